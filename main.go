@@ -1,8 +1,43 @@
 package main
 
-import "github.com/Lcmasdf/drs/pkg"
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg"
+)
 
 func main() {
-	srv := pkg.Server{}
-	srv.Run()
+	configPath := flag.String("c", "", "config file")
+	flag.Parse()
+
+	srv := pkg.Server{Config: &pkg.Config{}}
+	if *configPath != "" {
+		config, err := pkg.LoadConfig(*configPath)
+		if err != nil {
+			slog.Error("load config failed", "err", err)
+			return
+		}
+		srv.Config = config
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := srv.ListenAndServe(ctx); err != pkg.ErrServerClosed {
+		srv.Logger.Error("rtsp failed", "err", err)
+	}
+	// 再次收到信号时直接退出
+	stop()
+
+	srv.Logger.Info("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(srv.Config.ShutdownTimeout)*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		srv.Logger.Error("shutdown failed", "err", err)
+	}
 }
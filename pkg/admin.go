@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// StreamInfo 一个挂载路径的状态
+type StreamInfo struct {
+	Path string `json:"path"`
+	// publish、rtsp或文件扩展名
+	SourceType string      `json:"source_type"`
+	Tracks     []TrackInfo `json:"tracks"`
+	// 推流session的ID，没有推流时为空
+	Publisher string `json:"publisher"`
+	// PLAYING状态的session数量
+	Viewers int `json:"viewers"`
+	// 最近一秒收到的数据量(bit/s)
+	Bitrate uint64 `json:"bitrate"`
+}
+
+// TrackInfo sdp中的一个media
+type TrackInfo struct {
+	Media     string `json:"media"`
+	Codec     string `json:"codec"`
+	ClockRate int    `json:"clock_rate"`
+	Control   string `json:"control"`
+}
+
+// SessionInfo 一个rtsp连接的状态，还没有SETUP时ID为空
+type SessionInfo struct {
+	ID         string `json:"id"`
+	RemoteAddr string `json:"remote_addr"`
+	// INIT、READY、PLAYING或RECORDING
+	State     string `json:"state"`
+	Path      string `json:"path"`
+	UserAgent string `json:"user_agent"`
+	// 每个track的Transport
+	Transports []string `json:"transports"`
+	BytesSent  uint64   `json:"bytes_sent"`
+}
+
+// HealthInfo 服务状态
+type HealthInfo struct {
+	Status        string `json:"status"`
+	UptimeSeconds int64  `json:"uptime_seconds"`
+	Connections   int    `json:"connections"`
+	Streams       int    `json:"streams"`
+}
+
+// initAdmin 管理接口: /metrics及/api下的JSON接口
+func (s *Server) initAdmin() {
+	s.admin = http.NewServeMux()
+	s.admin.Handle("/metrics", s.metrics)
+	s.admin.HandleFunc("/api/health", s.handleHealth)
+	s.admin.HandleFunc("/api/streams", s.handleStreams)
+	s.admin.HandleFunc("/api/sessions", s.handleSessions)
+	s.admin.HandleFunc("/api/sessions/", s.handleSession)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.mu.RLock()
+	ret := HealthInfo{
+		Status:        "ok",
+		UptimeSeconds: int64(time.Since(s.startTime) / time.Second),
+		Connections:   len(s.sessions),
+		Streams:       len(s.streams),
+	}
+	s.mu.RUnlock()
+	writeJSON(w, http.StatusOK, ret)
+}
+
+func (s *Server) handleStreams(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Streams())
+}
+
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Sessions())
+}
+
+// handleSession GET返回一个session，DELETE踢出
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/sessions/")
+
+	switch r.Method {
+	case http.MethodGet:
+		for _, info := range s.Sessions() {
+			if id != "" && info.ID == id {
+				writeJSON(w, http.StatusOK, info)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "session "+id+" not found")
+	case http.MethodDelete:
+		if err := s.KickSession(id); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
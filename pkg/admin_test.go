@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/client"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAdmin(t *testing.T) {
+	Convey("test admin api", t, func() {
+		srv := &Server{Config: &Config{
+			Mounts: []*MountConfig{
+				{Path: "/live", Source: writeTestH264(t), FrameRate: 100},
+				{Path: "/ingest"},
+			},
+		}}
+		url := serveTest(srv)
+		ts := httptest.NewServer(srv.admin)
+		defer ts.Close()
+
+		get := func(path string, v interface{}) int {
+			resp, err := http.Get(ts.URL + path)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.Header.Get("Content-Type"), ShouldEqual, "application/json")
+			So(json.NewDecoder(resp.Body).Decode(v), ShouldBeNil)
+			return resp.StatusCode
+		}
+		kick := func(id string) int {
+			req, err := http.NewRequest(http.MethodDelete, ts.URL+"/api/sessions/"+id, nil)
+			So(err, ShouldBeNil)
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+
+		viewer, err := client.Dial(url+"/live", client.Options{Transport: client.TransportTCP, UserAgent: "admin-test"})
+		So(err, ShouldBeNil)
+		defer viewer.Close()
+		_, err = viewer.Describe()
+		So(err, ShouldBeNil)
+		So(viewer.SetupAll(), ShouldBeNil)
+		_, err = viewer.Play(nil)
+		So(err, ShouldBeNil)
+		<-viewer.Packets()
+
+		announced := sdp.NewSDP("test")
+		m := sdp.NewMedia("video", 96)
+		m.AddAttribute("rtpmap:96 H264/90000")
+		m.AddAttribute("control:trackID=0")
+		announced.AddMedia(m)
+		pub, err := client.Dial(url+"/ingest", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		defer pub.Close()
+		So(pub.Announce(announced), ShouldBeNil)
+		So(pub.SetupAll(), ShouldBeNil)
+		_, err = pub.Record()
+		So(err, ShouldBeNil)
+
+		health := HealthInfo{}
+		So(get("/api/health", &health), ShouldEqual, http.StatusOK)
+		So(health.Status, ShouldEqual, "ok")
+		So(health.Connections, ShouldEqual, 2)
+		So(health.Streams, ShouldEqual, 2)
+
+		sessions := make([]SessionInfo, 0)
+		So(get("/api/sessions", &sessions), ShouldEqual, http.StatusOK)
+		So(sessions, ShouldHaveLength, 2)
+		var playing, recording SessionInfo
+		for _, s := range sessions {
+			switch s.State {
+			case "PLAYING":
+				playing = s
+			case "RECORDING":
+				recording = s
+			}
+		}
+		So(playing.ID, ShouldNotBeEmpty)
+		So(playing.Path, ShouldEqual, "/live")
+		So(playing.UserAgent, ShouldEqual, "admin-test")
+		So(playing.Transports, ShouldHaveLength, 1)
+		So(playing.Transports[0], ShouldStartWith, "RTP/AVP/TCP;unicast;interleaved=0-1")
+		So(playing.BytesSent, ShouldBeGreaterThan, 0)
+		So(recording.Path, ShouldEqual, "/ingest")
+
+		one := SessionInfo{}
+		So(get("/api/sessions/"+playing.ID, &one), ShouldEqual, http.StatusOK)
+		So(one.RemoteAddr, ShouldEqual, playing.RemoteAddr)
+		So(get("/api/sessions/nope", &one), ShouldEqual, http.StatusNotFound)
+
+		// 码率按秒统计
+		var live, ingest StreamInfo
+		deadline := time.Now().Add(5 * time.Second)
+		for live.Bitrate == 0 && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+			streams := make([]StreamInfo, 0)
+			So(get("/api/streams", &streams), ShouldEqual, http.StatusOK)
+			So(streams, ShouldHaveLength, 2)
+			live, ingest = streams[1], streams[0]
+		}
+		So(live.Path, ShouldEqual, "/live")
+		So(live.SourceType, ShouldEqual, "h264")
+		So(live.Tracks, ShouldResemble, []TrackInfo{{Media: "video", Codec: "H264", ClockRate: 90000, Control: "trackID=0"}})
+		So(live.Viewers, ShouldEqual, 1)
+		So(live.Bitrate, ShouldBeGreaterThan, 0)
+		So(ingest.SourceType, ShouldEqual, "publish")
+		So(ingest.Publisher, ShouldEqual, recording.ID)
+
+		// 踢出后客户端连接断开，session释放
+		So(kick(playing.ID), ShouldEqual, http.StatusNoContent)
+		select {
+		case <-viewer.Done():
+		case <-time.After(5 * time.Second):
+			So("timeout", ShouldBeEmpty)
+		}
+		deadline = time.Now().Add(5 * time.Second)
+		for len(sessions) != 1 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			So(get("/api/sessions", &sessions), ShouldEqual, http.StatusOK)
+		}
+		So(sessions, ShouldHaveLength, 1)
+		So(sessions[0].ID, ShouldEqual, recording.ID)
+		So(kick(playing.ID), ShouldEqual, http.StatusNotFound)
+	})
+}
@@ -0,0 +1,163 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/rtsp"
+)
+
+// AuthRequest的Action
+const (
+	ActionRead    = "read"
+	ActionPublish = "publish"
+)
+
+// ErrUnauthorized Authorizer返回时响应401，要求客户端提供用户名和密码；其他错误响应403
+var ErrUnauthorized = errors.New("unauthorized")
+
+// AuthRequest DESCRIBE、SETUP和ANNOUNCE之前交给Authorizer判断
+type AuthRequest struct {
+	// read或publish
+	Action string `json:"action"`
+	// 挂载路径
+	Path  string `json:"path"`
+	Query string `json:"query"`
+	// 取自Basic认证，没有时为空
+	User     string `json:"user"`
+	Password string `json:"password"`
+	IP       string `json:"ip"`
+	// rtsp或rtsps
+	Protocol string `json:"protocol"`
+}
+
+// Authorizer 外部授权，允许时返回nil
+type Authorizer interface {
+	Authorize(ctx context.Context, req *AuthRequest) error
+}
+
+// httpAuthorizer 以POST JSON调用Config.Auth.URL，2xx允许，401要求认证，其他拒绝
+type httpAuthorizer struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPAuthorizer(url string) *httpAuthorizer {
+	return &httpAuthorizer{url: url, client: &http.Client{}}
+}
+
+func (a *httpAuthorizer) Authorize(ctx context.Context, req *AuthRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(r)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	default:
+		return fmt.Errorf("authorization denied: %s", resp.Status)
+	}
+}
+
+// authCache 缓存允许的结果，拒绝的结果每次都重新判断
+type authCache struct {
+	next Authorizer
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	allowed map[authKey]time.Time
+}
+
+// authKey 缓存的key，密码只保存SHA-256
+type authKey struct {
+	action   string
+	path     string
+	query    string
+	user     string
+	password [sha256.Size]byte
+	ip       string
+	protocol string
+}
+
+func newAuthKey(req *AuthRequest) authKey {
+	return authKey{
+		action:   req.Action,
+		path:     req.Path,
+		query:    req.Query,
+		user:     req.User,
+		password: sha256.Sum256([]byte(req.Password)),
+		ip:       req.IP,
+		protocol: req.Protocol,
+	}
+}
+
+func newAuthCache(next Authorizer, ttl time.Duration) *authCache {
+	return &authCache{
+		next:    next,
+		ttl:     ttl,
+		allowed: make(map[authKey]time.Time),
+	}
+}
+
+func (c *authCache) Authorize(ctx context.Context, req *AuthRequest) error {
+	now := time.Now()
+	key := newAuthKey(req)
+	c.mu.Lock()
+	expire, ok := c.allowed[key]
+	c.mu.Unlock()
+	if ok && now.Before(expire) {
+		return nil
+	}
+
+	if err := c.next.Authorize(ctx, req); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// 清理过期的结果
+	for k, expire := range c.allowed {
+		if !now.Before(expire) {
+			delete(c.allowed, k)
+		}
+	}
+	c.allowed[key] = now.Add(c.ttl)
+	return nil
+}
+
+// basicAuth 解析Authorization: Basic
+func basicAuth(r *rtsp.Request) (string, string) {
+	header, ok := r.GetMessage("Authorization")
+	if !ok || len(header) < 6 || !strings.EqualFold(header[:6], "Basic ") {
+		return "", ""
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(header[6:]))
+	if err != nil {
+		return "", ""
+	}
+	user, password, _ := strings.Cut(string(data), ":")
+	return user, password
+}
@@ -0,0 +1,202 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/client"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// authorizerFunc 测试用的Authorizer
+type authorizerFunc func(ctx context.Context, req *AuthRequest) error
+
+func (f authorizerFunc) Authorize(ctx context.Context, req *AuthRequest) error {
+	return f(ctx, req)
+}
+
+func TestAuth(t *testing.T) {
+	Convey("test http authorizer", t, func() {
+		var mu sync.Mutex
+		requests := make([]AuthRequest, 0)
+		policy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req := AuthRequest{}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			requests = append(requests, req)
+			mu.Unlock()
+
+			switch {
+			case req.User == "":
+				w.WriteHeader(http.StatusUnauthorized)
+			case req.Action == ActionRead && req.User == "alice" && req.Password == "secret":
+				w.WriteHeader(http.StatusOK)
+			case req.Action == ActionPublish && req.User == "bob":
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				w.WriteHeader(http.StatusForbidden)
+			}
+		}))
+		defer policy.Close()
+		calls := func() []AuthRequest {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]AuthRequest{}, requests...)
+		}
+
+		srv := &Server{Config: &Config{
+			Auth: AuthConfig{URL: policy.URL},
+			Mounts: []*MountConfig{
+				{Path: "/live", Source: writeTestH264(t), FrameRate: 100},
+				{Path: "/ingest"},
+			},
+		}}
+		url := serveTest(srv)
+		host := strings.TrimPrefix(url, "rtsp://")
+
+		// 没有用户名时401
+		c, err := client.Dial(url+"/live", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		defer c.Close()
+		_, err = c.Describe()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "401")
+
+		// 用户名或密码错误时403
+		c, err = client.Dial("rtsp://alice:nope@"+host+"/live", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		defer c.Close()
+		_, err = c.Describe()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "403")
+
+		viewer, err := client.Dial("rtsp://alice:secret@"+host+"/live?token=abc", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		defer viewer.Close()
+		_, err = viewer.Describe()
+		So(err, ShouldBeNil)
+		So(viewer.SetupAll(), ShouldBeNil)
+		_, err = viewer.Play(nil)
+		So(err, ShouldBeNil)
+		<-viewer.Packets()
+
+		// 客户端先收到401，再带上用户名和密码重发DESCRIBE，之后是SETUP
+		got := calls()
+		So(got[len(got)-1].Action, ShouldEqual, ActionRead)
+		So(got[len(got)-2], ShouldResemble, AuthRequest{
+			Action:   ActionRead,
+			Path:     "/live",
+			Query:    "token=abc",
+			User:     "alice",
+			Password: "secret",
+			IP:       "127.0.0.1",
+			Protocol: "rtsp",
+		})
+		So(got[len(got)-3].User, ShouldEqual, "")
+
+		announced := sdp.NewSDP("test")
+		m := sdp.NewMedia("video", 96)
+		m.AddAttribute("rtpmap:96 H264/90000")
+		m.AddAttribute("control:trackID=0")
+		announced.AddMedia(m)
+
+		// alice不能推流
+		pub, err := client.Dial("rtsp://alice:secret@"+host+"/ingest", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		defer pub.Close()
+		err = pub.Announce(announced)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "403")
+
+		pub, err = client.Dial("rtsp://bob:x@"+host+"/ingest", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		defer pub.Close()
+		So(pub.Announce(announced), ShouldBeNil)
+		So(pub.SetupAll(), ShouldBeNil)
+		_, err = pub.Record()
+		So(err, ShouldBeNil)
+		got = calls()
+		So(got[len(got)-1].Action, ShouldEqual, ActionPublish)
+		So(got[len(got)-1].User, ShouldEqual, "bob")
+	})
+
+	Convey("test authorizer interface and cache", t, func() {
+		var mu sync.Mutex
+		count := 0
+		allow := true
+		srv := &Server{
+			Config: &Config{Mounts: []*MountConfig{{Path: "/live", Source: writeTestH264(t), FrameRate: 100}}},
+			Authorizer: authorizerFunc(func(ctx context.Context, req *AuthRequest) error {
+				mu.Lock()
+				defer mu.Unlock()
+				count++
+				if !allow {
+					return errors.New("denied")
+				}
+				return nil
+			}),
+		}
+		url := serveTest(srv)
+		describe := func() error {
+			c, err := client.Dial(url+"/live", client.Options{Transport: client.TransportTCP})
+			So(err, ShouldBeNil)
+			defer c.Close()
+			_, err = c.Describe()
+			return err
+		}
+		calls := func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return count
+		}
+
+		So(describe(), ShouldBeNil)
+		So(describe(), ShouldBeNil)
+		So(calls(), ShouldEqual, 1)
+
+		// 拒绝的结果不缓存
+		cache := srv.auth.(*authCache)
+		cache.mu.Lock()
+		cache.allowed = make(map[authKey]time.Time)
+		cache.mu.Unlock()
+		mu.Lock()
+		allow = false
+		mu.Unlock()
+		err := describe()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "403")
+		So(describe(), ShouldNotBeNil)
+		So(calls(), ShouldEqual, 3)
+	})
+
+	Convey("test auth cache does not keep passwords", t, func() {
+		count := 0
+		cache := newAuthCache(authorizerFunc(func(ctx context.Context, req *AuthRequest) error {
+			count++
+			return nil
+		}), time.Minute)
+		req := &AuthRequest{Action: ActionRead, Path: "/live", User: "alice", Password: "secret"}
+		So(cache.Authorize(context.Background(), req), ShouldBeNil)
+		So(cache.Authorize(context.Background(), req), ShouldBeNil)
+		So(count, ShouldEqual, 1)
+
+		// 密码不同时重新判断
+		other := *req
+		other.Password = "other"
+		So(cache.Authorize(context.Background(), &other), ShouldBeNil)
+		So(count, ShouldEqual, 2)
+		So(fmt.Sprintf("%+v", cache.allowed), ShouldNotContainSubstring, "secret")
+	})
+}
@@ -0,0 +1,116 @@
+package pkg
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+)
+
+// 每个viewer track保留最近发送的RTP包数量，用于NACK重传，需能整除65536
+const retransmitBufferSize = 1024
+
+// retransmitBuffer 按序号保存最近发送的RTP包
+type retransmitBuffer struct {
+	pkts []*rtp.Packet
+}
+
+func newRetransmitBuffer() *retransmitBuffer {
+	return &retransmitBuffer{
+		pkts: make([]*rtp.Packet, retransmitBufferSize),
+	}
+}
+
+func (b *retransmitBuffer) push(p *rtp.Packet) {
+	b.pkts[p.SequenceNumber%retransmitBufferSize] = p
+}
+
+// get 已经被覆盖时返回nil
+func (b *retransmitBuffer) get(seq uint16) *rtp.Packet {
+	p := b.pkts[seq%retransmitBufferSize]
+	if p == nil || p.SequenceNumber != seq {
+		return nil
+	}
+	return p
+}
+
+// avpfTrack 支持RTP/AVPF的track的payload type
+type avpfTrack struct {
+	payloadType int
+	clockRate   int
+	rtx         int
+}
+
+// avpfTracks 视频track使用AVPF，RTX的payload type取sdp中没有使用的动态payload type
+func avpfTracks(s *sdp.SDPImpl) map[int]*avpfTrack {
+	used := make(map[int]bool)
+	for _, m := range s.Ms {
+		mm, err := m.GetM()
+		if err != nil {
+			continue
+		}
+		for _, f := range strings.Fields(mm.Fmt) {
+			if pt, err := strconv.Atoi(f); err == nil {
+				used[pt] = true
+			}
+		}
+	}
+
+	ret := make(map[int]*avpfTrack)
+	next := 96
+	for i, m := range s.Ms {
+		mm, err := m.GetM()
+		if err != nil || mm.Media != "video" {
+			continue
+		}
+		rtpmaps, err := m.GetRtpmaps()
+		if err != nil || len(rtpmaps) == 0 {
+			continue
+		}
+		for next < 128 && used[next] {
+			next++
+		}
+		if next >= 128 {
+			break
+		}
+		ret[i] = &avpfTrack{
+			payloadType: rtpmaps[0].PayloadType,
+			clockRate:   rtpmaps[0].ClockRate,
+			rtx:         next,
+		}
+		used[next] = true
+	}
+	return ret
+}
+
+// describeAVPF 视频改为RTP/AVPF(RTP/SAVPF)，加上a=rtcp-fb和RTX的payload type
+func describeAVPF(s *sdp.SDPImpl) (*sdp.SDPImpl, error) {
+	ret := &sdp.SDPImpl{}
+	if err := ret.Parse(s.Gen()); err != nil {
+		return nil, err
+	}
+
+	for i, t := range avpfTracks(ret) {
+		m := ret.Ms[i]
+		mm, err := m.GetM()
+		if err != nil {
+			return nil, err
+		}
+		// 推流端已经使用AVPF时保持不变
+		if !strings.HasSuffix(mm.Proto, "F") {
+			if err := m.SetProto(mm.Proto + "F"); err != nil {
+				return nil, err
+			}
+		}
+		if err := m.AddFormat(t.rtx); err != nil {
+			return nil, err
+		}
+		m.AddAttribute("rtcp-fb:%d nack", t.payloadType)
+		m.AddAttribute("rtcp-fb:%d nack pli", t.payloadType)
+		m.AddAttribute("rtcp-fb:%d ccm fir", t.payloadType)
+		m.AddAttribute("rtpmap:%d rtx/%d", t.rtx, t.clockRate)
+		m.AddAttribute("fmtp:%d apt=%d", t.rtx, t.payloadType)
+	}
+	return ret, nil
+}
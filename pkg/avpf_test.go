@@ -0,0 +1,169 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/client"
+	"github.com/Lcmasdf/drs/pkg/rtcp"
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRetransmitBuffer(t *testing.T) {
+	Convey("test retransmit buffer", t, func() {
+		b := newRetransmitBuffer()
+		b.push(&rtp.Packet{SequenceNumber: 1})
+		b.push(&rtp.Packet{SequenceNumber: 65535})
+		So(b.get(1), ShouldNotBeNil)
+		So(b.get(65535), ShouldNotBeNil)
+		So(b.get(2), ShouldBeNil)
+
+		// 被覆盖后不再重传
+		b.push(&rtp.Packet{SequenceNumber: 1 + retransmitBufferSize})
+		So(b.get(1), ShouldBeNil)
+		So(b.get(1+retransmitBufferSize), ShouldNotBeNil)
+	})
+}
+
+func TestDescribeAVPF(t *testing.T) {
+	Convey("test describe avpf", t, func() {
+		s := sdp.NewSDP("test")
+		v := sdp.NewMedia("video", 96)
+		v.AddAttribute("rtpmap:96 H264/90000")
+		s.AddMedia(v)
+		a := sdp.NewMedia("audio", 97)
+		a.AddAttribute("rtpmap:97 MPEG4-GENERIC/44100/2")
+		s.AddMedia(a)
+
+		ret, err := describeAVPF(s)
+		So(err, ShouldBeNil)
+		gen := string(ret.Gen())
+		// 97已被音频使用
+		So(gen, ShouldContainSubstring, "m=video 0 RTP/AVPF 96 98")
+		So(gen, ShouldContainSubstring, "a=rtcp-fb:96 nack\n")
+		So(gen, ShouldContainSubstring, "a=rtcp-fb:96 nack pli")
+		So(gen, ShouldContainSubstring, "a=rtcp-fb:96 ccm fir")
+		So(gen, ShouldContainSubstring, "a=rtpmap:98 rtx/90000")
+		So(gen, ShouldContainSubstring, "a=fmtp:98 apt=96")
+		So(gen, ShouldContainSubstring, "m=audio 0 RTP/AVP 97")
+		// 不修改原来的sdp
+		So(string(s.Gen()), ShouldNotContainSubstring, "AVPF")
+	})
+}
+
+func TestAVPF(t *testing.T) {
+	file := writeTestH264(t)
+
+	Convey("test nack is answered with rtx", t, func() {
+		srv := &Server{Config: &Config{
+			Mounts: []*MountConfig{{Path: "/live", Source: file, FrameRate: 100, AVPF: true}},
+		}}
+		url := serveTest(srv)
+
+		c, err := client.Dial(url+"/live", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		defer c.Close()
+		s, err := c.Describe()
+		So(err, ShouldBeNil)
+		So(string(s.Gen()), ShouldContainSubstring, "m=video 0 RTP/AVPF 96 97")
+		So(c.SetupAll(), ShouldBeNil)
+		_, err = c.Play(nil)
+		So(err, ShouldBeNil)
+
+		var lost uint16
+		select {
+		case p := <-c.Packets():
+			So(p.Packet.PayloadType, ShouldEqual, 96)
+			lost = p.Packet.SequenceNumber
+		case <-time.After(5 * time.Second):
+			So("timeout", ShouldBeEmpty)
+		}
+
+		nack := &rtcp.NACK{MediaSSRC: 1, Lost: []uint16{lost}}
+		So(c.WriteRTCP(0, nack.Marshal()), ShouldBeNil)
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case p := <-c.Packets():
+				if p.Packet.PayloadType != 97 {
+					continue
+				}
+				orig, err := rtp.DecodeRTX(p.Packet, 96, 1)
+				So(err, ShouldBeNil)
+				So(orig.SequenceNumber, ShouldEqual, lost)
+			case <-timeout:
+				So("timeout", ShouldBeEmpty)
+			}
+			break
+		}
+	})
+
+	Convey("test pli is forwarded to the publisher", t, func() {
+		srv := &Server{Config: &Config{
+			Mounts: []*MountConfig{{Path: "/ingest", AVPF: true}},
+		}}
+		url := serveTest(srv)
+
+		announced := sdp.NewSDP("test")
+		m := sdp.NewMedia("video", 96)
+		So(m.SetProto("RTP/AVPF"), ShouldBeNil)
+		m.AddAttribute("rtpmap:96 H264/90000")
+		m.AddAttribute("control:trackID=0")
+		announced.AddMedia(m)
+
+		feedback := make(chan rtcp.Packet, 8)
+		pub, err := client.Dial(url+"/ingest", client.Options{
+			Transport: client.TransportTCP,
+			OnRTCP: func(track int, data []byte) {
+				pkts, _ := rtcp.Unmarshal(data)
+				for _, p := range pkts {
+					feedback <- p
+				}
+			},
+		})
+		So(err, ShouldBeNil)
+		defer pub.Close()
+		So(pub.Announce(announced), ShouldBeNil)
+		So(pub.SetupAll(), ShouldBeNil)
+		_, err = pub.Record()
+		So(err, ShouldBeNil)
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for seq := uint16(0); ; seq++ {
+				select {
+				case <-done:
+					return
+				case <-time.After(10 * time.Millisecond):
+				}
+				pub.WritePacket(0, &rtp.Packet{PayloadType: 96, SequenceNumber: seq, SSRC: 1234, Payload: []byte{0x41, 0x9a, 0x01}})
+			}
+		}()
+
+		c, err := client.Dial(url+"/ingest", client.Options{Transport: client.TransportUDP})
+		So(err, ShouldBeNil)
+		defer c.Close()
+		_, err = c.Describe()
+		So(err, ShouldBeNil)
+		So(c.SetupAll(), ShouldBeNil)
+		_, err = c.Play(nil)
+		So(err, ShouldBeNil)
+		select {
+		case <-c.Packets():
+		case <-time.After(5 * time.Second):
+			So("timeout", ShouldBeEmpty)
+		}
+
+		So(c.RequestKeyFrame(0), ShouldBeNil)
+		select {
+		case p := <-feedback:
+			pli, ok := p.(*rtcp.PLI)
+			So(ok, ShouldBeTrue)
+			So(pli.MediaSSRC, ShouldEqual, 1234)
+		case <-time.After(5 * time.Second):
+			So("timeout", ShouldBeEmpty)
+		}
+	})
+}
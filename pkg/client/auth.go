@@ -0,0 +1,138 @@
+package client
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// authenticator 根据WWW-Authenticate生成Authorization，支持Basic和Digest(RFC2617)
+type authenticator struct {
+	user     string
+	password string
+
+	scheme    string
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+	nc        int
+}
+
+func newAuthenticator(user *url.Userinfo, challenge string) (*authenticator, error) {
+	password, _ := user.Password()
+	ret := &authenticator{
+		user:     user.Username(),
+		password: password,
+	}
+
+	index := strings.Index(challenge, " ")
+	if index == -1 {
+		return nil, fmt.Errorf("invalid challenge %s", challenge)
+	}
+	ret.scheme = challenge[:index]
+	params := parseAuthParams(challenge[index+1:])
+	ret.realm = params["realm"]
+
+	switch strings.ToLower(ret.scheme) {
+	case "basic":
+		ret.scheme = "Basic"
+	case "digest":
+		ret.scheme = "Digest"
+		ret.nonce = params["nonce"]
+		ret.opaque = params["opaque"]
+		ret.algorithm = params["algorithm"]
+		if ret.algorithm != "" && !strings.EqualFold(ret.algorithm, "MD5") {
+			return nil, fmt.Errorf("unsupported digest algorithm %s", ret.algorithm)
+		}
+		// 只支持qop=auth
+		for _, q := range strings.Split(params["qop"], ",") {
+			if strings.TrimSpace(q) == "auth" {
+				ret.qop = "auth"
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported auth scheme %s", ret.scheme)
+	}
+	return ret, nil
+}
+
+// header 生成请求的Authorization
+func (a *authenticator) header(method, uri string) string {
+	if a.scheme == "Basic" {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(a.user+":"+a.password))
+	}
+
+	a.nc++
+	cnonce := genCnonce()
+	ret := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+		a.user, a.realm, a.nonce, uri, a.response(method, uri, cnonce))
+	if a.opaque != "" {
+		ret += fmt.Sprintf(`, opaque="%s"`, a.opaque)
+	}
+	if a.algorithm != "" {
+		ret += fmt.Sprintf(`, algorithm=%s`, a.algorithm)
+	}
+	if a.qop != "" {
+		ret += fmt.Sprintf(`, qop=%s, nc=%08x, cnonce="%s"`, a.qop, a.nc, cnonce)
+	}
+	return ret
+}
+
+func (a *authenticator) response(method, uri, cnonce string) string {
+	ha1 := md5Hex(a.user + ":" + a.realm + ":" + a.password)
+	ha2 := md5Hex(method + ":" + uri)
+	if a.qop == "" {
+		return md5Hex(ha1 + ":" + a.nonce + ":" + ha2)
+	}
+	return md5Hex(fmt.Sprintf("%s:%s:%08x:%s:%s:%s", ha1, a.nonce, a.nc, cnonce, a.qop, ha2))
+}
+
+// parseAuthParams realm="x", nonce="y", qop="auth,auth-int"
+func parseAuthParams(s string) map[string]string {
+	ret := make(map[string]string)
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ,")
+		index := strings.Index(s, "=")
+		if index == -1 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:index]))
+		s = s[index+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.Index(s[1:], `"`)
+			if end == -1 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else {
+			end := strings.Index(s, ",")
+			if end == -1 {
+				value, s = s, ""
+			} else {
+				value, s = s[:end], s[end:]
+			}
+		}
+		ret[key] = strings.TrimSpace(value)
+	}
+	return ret
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func genCnonce() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Lcmasdf/drs/pkg/rtsp"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDigest(t *testing.T) {
+	Convey("test digest response (RFC2617 3.5)", t, func() {
+		a, err := newAuthenticator(url.UserPassword("Mufasa", "Circle Of Life"),
+			`Digest realm="testrealm@host.com", qop="auth,auth-int", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", opaque="5ccc069c403ebaf9f0171e9517f40e41"`)
+		So(err, ShouldBeNil)
+		So(a.qop, ShouldEqual, "auth")
+		So(a.opaque, ShouldEqual, "5ccc069c403ebaf9f0171e9517f40e41")
+
+		a.nc = 1
+		So(a.response("GET", "/dir/index.html", "0a4f113b"), ShouldEqual, "6629fae49393a05397450978507c4ef1")
+	})
+
+	Convey("test basic and unsupported schemes", t, func() {
+		a, err := newAuthenticator(url.UserPassword("Aladdin", "open sesame"), `Basic realm="drs"`)
+		So(err, ShouldBeNil)
+		So(a.header("DESCRIBE", "rtsp://host/live"), ShouldEqual, "Basic QWxhZGRpbjpvcGVuIHNlc2FtZQ==")
+
+		_, err = newAuthenticator(url.User("a"), `Bearer realm="drs"`)
+		So(err, ShouldNotBeNil)
+		_, err = newAuthenticator(url.User("a"), `Digest realm="drs", nonce="1", algorithm=SHA-256`)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestAuthChallenge(t *testing.T) {
+	Convey("test client retries with digest authorization", t, func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer listener.Close()
+
+		authorizations := make(chan string, 2)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			reader := textproto.NewReader(bufio.NewReader(conn))
+			for {
+				req := &rtsp.Request{}
+				if err := req.Parse(*reader); err != nil {
+					return
+				}
+				auth, ok := req.GetMessage("Authorization")
+				authorizations <- auth
+				if !ok {
+					fmt.Fprintf(conn, "RTSP/1.0 401 Unauthorized\r\nCSeq: %d\r\nWWW-Authenticate: Digest realm=\"drs\", nonce=\"abc\"\r\n\r\n", req.Seq)
+					continue
+				}
+				fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %d\r\nPublic: OPTIONS, DESCRIBE\r\n\r\n", req.Seq)
+			}
+		}()
+
+		c, err := Dial("rtsp://admin:secret@"+listener.Addr().String()+"/live", Options{})
+		So(err, ShouldBeNil)
+		defer c.Close()
+		So(c.url, ShouldNotContainSubstring, "secret")
+
+		_, err = c.Options()
+		So(err, ShouldBeNil)
+		So(<-authorizations, ShouldBeEmpty)
+
+		auth := <-authorizations
+		uri := "rtsp://" + listener.Addr().String() + "/live"
+		expected := md5Hex(md5Hex("admin:drs:secret") + ":abc:" + md5Hex("OPTIONS:"+uri))
+		So(auth, ShouldStartWith, "Digest ")
+		So(auth, ShouldContainSubstring, `username="admin"`)
+		So(auth, ShouldContainSubstring, fmt.Sprintf(`response="%s"`, expected))
+		So(strings.Contains(auth, "qop"), ShouldBeFalse)
+	})
+}
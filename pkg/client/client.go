@@ -0,0 +1,1012 @@
+package client
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/rtcp"
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/rtsp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+	"github.com/Lcmasdf/drs/pkg/srtp"
+)
+
+const (
+	TransportUDP = "udp"
+	TransportTCP = "tcp"
+	// 只用于播放，组播地址由服务端分配
+	TransportMulticast = "multicast"
+)
+
+const (
+	defaultPort      = 554
+	defaultTLSPort   = 322
+	defaultTimeout   = 10 * time.Second
+	defaultUserAgent = "drs"
+	// 服务端没有返回timeout时使用RFC2326的默认值
+	defaultSessionTimeout = 60
+)
+
+// Options 客户端配置，零值可用
+type Options struct {
+	// udp(默认)、tcp(interleaved)或multicast
+	Transport string
+	// 连接及等待response的超时时间
+	Timeout   time.Duration
+	UserAgent string
+	// rtsps使用，为nil时使用系统的根证书校验服务端
+	TLSConfig *tls.Config
+	// rtsp.Version10(默认)或rtsp.Version20
+	Version string
+
+	// 不为nil时通过回调交付RTP包，否则通过Packets()返回的channel
+	// UDP时每个track在单独的goroutine中回调
+	OnPacket func(track int, pkt *rtp.Packet)
+	// 收到的RTCP，SRTP时已解密
+	OnRTCP func(track int, data []byte)
+	// RTSP 2.0服务端发送的PLAY_NOTIFY，在读取goroutine中回调
+	OnPlayNotify func(req *rtsp.Request)
+	// 为nil时使用slog.Default()
+	Logger *slog.Logger
+}
+
+// Packet 收到的RTP包，Track为sdp中media的序号
+type Packet struct {
+	Track  int
+	Packet *rtp.Packet
+}
+
+type clientTrack struct {
+	transport *rtsp.TransportItem
+
+	rtpConn  *net.UDPConn
+	rtcpConn *net.UDPConn
+	// 推流时UDP的发送地址
+	serverAddr *net.UDPAddr
+	// 推流时TCP的发送channel
+	channel int
+	// media为RTP/SAVP时使用sdp中的密钥，播放时解密，推流时加密
+	srtp *srtp.Context
+	// 收到的RTP的SSRC，原子操作
+	remoteSSRC uint32
+}
+
+// Client rtsp 连接 C->S，同一时间只有一个请求
+type Client struct {
+	opts Options
+	// 不含userinfo
+	url  string
+	user *url.Userinfo
+	auth *authenticator
+	log  *slog.Logger
+
+	conn   net.Conn
+	reader *textproto.Reader
+
+	mu             sync.Mutex
+	seq            int64
+	session        string
+	sessionTimeout int
+	public         []string
+	contentBase    string
+	sdp            *sdp.SDPImpl
+	playing        bool
+	// ANNOUNCE之后SETUP使用mode=record
+	record bool
+
+	// 发送RTCP反馈使用的SSRC
+	ssrc uint32
+
+	// 收发数据时使用，不能与请求共用锁，修改时需同时持有mu
+	dataMu sync.RWMutex
+	tracks map[int]*clientTrack
+	// interleaved channel -> track
+	channels map[int]int
+
+	writeMu   sync.Mutex
+	responses chan *rtsp.Response
+	packets   chan Packet
+	// 连接断开
+	broken chan struct{}
+	err    error
+	done   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+// Dial 连接rtsp://或rtsps://[user:password@]host[:port]/path
+func Dial(rawurl string, opts Options) (*Client, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "rtsp" && u.Scheme != "rtsps" {
+		return nil, fmt.Errorf("unsupported scheme %s", u.Scheme)
+	}
+
+	if opts.Transport == "" {
+		opts.Transport = TransportUDP
+	}
+	if opts.Transport != TransportUDP && opts.Transport != TransportTCP && opts.Transport != TransportMulticast {
+		return nil, fmt.Errorf("unsupported transport %s", opts.Transport)
+	}
+	if opts.Version == "" {
+		opts.Version = rtsp.Version10
+	}
+	if opts.Version != rtsp.Version10 && opts.Version != rtsp.Version20 {
+		return nil, fmt.Errorf("unsupported version %s", opts.Version)
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultTimeout
+	}
+	if opts.UserAgent == "" {
+		opts.UserAgent = defaultUserAgent
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	host := u.Host
+	port := defaultPort
+	if u.Scheme == "rtsps" {
+		port = defaultTLSPort
+	}
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), strconv.Itoa(port))
+	}
+
+	var conn net.Conn
+	if u.Scheme == "rtsps" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: opts.Timeout}, "tcp", host, opts.TLSConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", host, opts.Timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user := u.User
+	u.User = nil
+	c := &Client{
+		opts:           opts,
+		url:            u.String(),
+		user:           user,
+		log:            opts.Logger.With("url", u.String()),
+		conn:           conn,
+		reader:         textproto.NewReader(bufio.NewReader(conn)),
+		sessionTimeout: defaultSessionTimeout,
+		ssrc:           rand.Uint32(),
+		tracks:         make(map[int]*clientTrack),
+		channels:       make(map[int]int),
+		responses:      make(chan *rtsp.Response, 8),
+		packets:        make(chan Packet, 1024),
+		broken:         make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	c.wg.Add(1)
+	go c.readLoop()
+	return c, nil
+}
+
+// Packets 没有设置OnPacket时使用，Close后关闭
+func (c *Client) Packets() <-chan Packet {
+	return c.packets
+}
+
+// SDP Describe之后可用
+func (c *Client) SDP() *sdp.SDPImpl {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.sdp
+}
+
+func (c *Client) Options() (*rtsp.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resp, err := c.do(rtsp.NewRequest("OPTIONS", c.url))
+	if err != nil {
+		return nil, err
+	}
+	if public, ok := resp.GetMessage("Public"); ok {
+		c.public = c.public[:0]
+		for _, m := range strings.Split(public, ",") {
+			c.public = append(c.public, strings.TrimSpace(m))
+		}
+	}
+	return resp, nil
+}
+
+func (c *Client) Describe() (*sdp.SDPImpl, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	req := rtsp.NewRequest("DESCRIBE", c.url)
+	req.AddMessage("Accept", "application/sdp")
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sdp.SDPImpl{}
+	if err := s.Parse(resp.Body()); err != nil {
+		return nil, err
+	}
+
+	// 相对的control以Content-Base为准
+	c.contentBase = c.url
+	if base, ok := resp.GetMessage("Content-Base"); ok {
+		c.contentBase = base
+	} else if base, ok := resp.GetMessage("Content-Location"); ok {
+		c.contentBase = base
+	}
+	c.sdp = s
+	return s, nil
+}
+
+// Announce 推流时代替Describe，发送本地的sdp
+func (c *Client) Announce(s *sdp.SDPImpl) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	req := rtsp.NewRequest("ANNOUNCE", c.url)
+	req.AddMessage("Content-Type", "application/sdp")
+	req.AddBody(s.Gen())
+	if _, err := c.do(req); err != nil {
+		return err
+	}
+
+	c.contentBase = c.url
+	c.sdp = s
+	c.record = true
+	return nil
+}
+
+// Setup 按配置的transport建立一个track，需先调用Describe或Announce
+func (c *Client) Setup(track int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sdp == nil {
+		return fmt.Errorf("setup before describe")
+	}
+	if track < 0 || track >= len(c.sdp.Ms) {
+		return fmt.Errorf("track %d not found", track)
+	}
+	if _, ok := c.tracks[track]; ok {
+		return fmt.Errorf("track %d already setup", track)
+	}
+	if c.record && c.opts.Transport == TransportMulticast {
+		return fmt.Errorf("record over multicast is not supported")
+	}
+	uri, err := c.trackURL(track)
+	if err != nil {
+		return err
+	}
+
+	t := &clientTrack{}
+	item := &rtsp.TransportItem{
+		Protocol:  "RTP",
+		Profile:   "AVP",
+		Cast:      "unicast",
+		Parameter: map[string][]byte{},
+	}
+	if c.record {
+		item.Parameter["mode"] = []byte("record")
+	}
+	if m := c.sdp.Ms[track]; srtp.IsSAVP(m) {
+		key, err := srtp.MediaKey(m)
+		if err != nil {
+			return err
+		}
+		if t.srtp, err = srtp.NewContext(key); err != nil {
+			return err
+		}
+		item.Profile = "SAVP"
+	}
+	// RTP/AVPF或RTP/SAVPF
+	if mm, err := c.sdp.Ms[track].GetM(); err == nil && strings.HasSuffix(mm.Proto, "F") {
+		item.Profile += "F"
+	}
+	if c.opts.Transport == TransportTCP {
+		item.LowerTransport = "TCP"
+		item.Interleaved = true
+		item.Interleaved1, item.Interleaved2 = 2*track, 2*track+1
+	} else if c.opts.Transport == TransportMulticast {
+		item.Cast = "multicast"
+	} else {
+		if t.rtpConn, t.rtcpConn, err = listenUDPPair(); err != nil {
+			return err
+		}
+		item.ClientPort1 = t.rtpConn.LocalAddr().(*net.UDPAddr).Port
+		item.ClientPort2 = item.ClientPort1 + 1
+		// RTSP 2.0 使用dest_addr，地址为空时服务端使用连接的地址
+		if c.opts.Version == rtsp.Version20 {
+			item.DestAddr = []string{
+				net.JoinHostPort("", strconv.Itoa(item.ClientPort1)),
+				net.JoinHostPort("", strconv.Itoa(item.ClientPort2)),
+			}
+			item.ClientPort1, item.ClientPort2 = 0, 0
+		}
+	}
+	transport, err := rtsp.GenTransportItem(item)
+	if err != nil {
+		t.close()
+		return err
+	}
+
+	req := rtsp.NewRequest("SETUP", uri)
+	req.AddMessage("Transport", string(transport))
+	resp, err := c.do(req)
+	if err != nil {
+		t.close()
+		return err
+	}
+
+	if session, ok := resp.GetMessage("Session"); ok && c.session == "" {
+		c.session, c.sessionTimeout = parseSessionHeader(session)
+	}
+
+	value, ok := resp.GetMessage("Transport")
+	if !ok {
+		t.close()
+		return fmt.Errorf("setup %s: no transport in response", uri)
+	}
+	trans, err := rtsp.ParseTransport([]byte(value))
+	if err != nil || len(trans.Items) == 0 {
+		t.close()
+		return fmt.Errorf("setup %s: invalid transport %s", uri, value)
+	}
+	t.transport = trans.Items[0]
+	if c.opts.Version == rtsp.Version20 {
+		if err := transport10(t.transport); err != nil {
+			t.close()
+			return fmt.Errorf("setup %s: %s", uri, err.Error())
+		}
+	}
+	if c.opts.Transport == TransportMulticast {
+		if t.rtpConn, t.rtcpConn, err = joinMulticast(t.transport); err != nil {
+			return fmt.Errorf("setup %s: %s", uri, err.Error())
+		}
+	}
+	if c.opts.Transport == TransportUDP {
+		// 推流总是发往连接的地址
+		host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+		t.serverAddr = &net.UDPAddr{IP: net.ParseIP(host), Port: t.transport.ServerPort1}
+	}
+
+	c.dataMu.Lock()
+	c.tracks[track] = t
+	if c.opts.Transport == TransportTCP {
+		// 服务端可能修改channel
+		t.channel = item.Interleaved1
+		if t.transport.Interleaved {
+			t.channel = t.transport.Interleaved1
+		}
+		c.channels[t.channel] = track
+	}
+	c.dataMu.Unlock()
+
+	if c.opts.Transport != TransportTCP {
+		c.wg.Add(2)
+		go c.readUDP(track, t.rtpConn, true)
+		go c.readUDP(track, t.rtcpConn, false)
+	}
+	return nil
+}
+
+// SetupAll 建立sdp中所有的track
+func (c *Client) SetupAll() error {
+	s := c.SDP()
+	if s == nil {
+		return fmt.Errorf("setup before describe")
+	}
+	for i := range s.Ms {
+		if err := c.Setup(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Play rng为nil时从头(直播为当前位置)开始
+func (c *Client) Play(rng *rtsp.Range) (*rtsp.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	req := rtsp.NewRequest("PLAY", c.aggregateURL())
+	if rng != nil {
+		if rng.Now {
+			req.AddMessage("Range", "npt=now-")
+		} else if rng.Clock {
+			req.AddMessage("Range", rtsp.GenClockRange(rng.StartTime, rng.EndTime))
+		} else {
+			req.AddMessage("Range", rtsp.GenRange(rng.Start, rng.End))
+		}
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.playing {
+		c.playing = true
+		c.wg.Add(1)
+		go c.keepalive()
+	}
+	return resp, nil
+}
+
+// Record 推流时代替Play，之后通过WritePacket发送数据
+func (c *Client) Record() (*rtsp.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resp, err := c.do(rtsp.NewRequest("RECORD", c.aggregateURL()))
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.playing {
+		c.playing = true
+		c.wg.Add(1)
+		go c.keepalive()
+	}
+	return resp, nil
+}
+
+// WritePacket 推流发送一个RTP包
+func (c *Client) WritePacket(track int, pkt *rtp.Packet) error {
+	c.dataMu.RLock()
+	t, ok := c.tracks[track]
+	c.dataMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("track %d not setup", track)
+	}
+
+	data := pkt.Marshal()
+	if t.srtp != nil {
+		var err error
+		if data, err = t.srtp.EncryptRTP(data); err != nil {
+			return err
+		}
+	}
+	if t.serverAddr != nil {
+		_, err := t.rtpConn.WriteToUDP(data, t.serverAddr)
+		return err
+	}
+
+	frame := make([]byte, 4, 4+len(data))
+	frame[0] = '$'
+	frame[1] = byte(t.channel)
+	binary.BigEndian.PutUint16(frame[2:], uint16(len(data)))
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.opts.Timeout))
+	_, err := c.conn.Write(append(frame, data...))
+	return err
+}
+
+// WriteRTCP 发送RTCP，组播时不支持
+func (c *Client) WriteRTCP(track int, data []byte) error {
+	c.dataMu.RLock()
+	t, ok := c.tracks[track]
+	c.dataMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("track %d not setup", track)
+	}
+
+	if t.srtp != nil {
+		var err error
+		if data, err = t.srtp.EncryptRTCP(data); err != nil {
+			return err
+		}
+	}
+	if t.serverAddr != nil {
+		addr := &net.UDPAddr{IP: t.serverAddr.IP, Port: t.transport.ServerPort2}
+		_, err := t.rtcpConn.WriteToUDP(data, addr)
+		return err
+	}
+	if c.opts.Transport != TransportTCP {
+		return fmt.Errorf("rtcp over %s not supported", c.opts.Transport)
+	}
+
+	frame := make([]byte, 4, 4+len(data))
+	frame[0] = '$'
+	frame[1] = byte(t.channel + 1)
+	binary.BigEndian.PutUint16(frame[2:], uint16(len(data)))
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.opts.Timeout))
+	_, err := c.conn.Write(append(frame, data...))
+	return err
+}
+
+// RequestKeyFrame 发送PLI
+func (c *Client) RequestKeyFrame(track int) error {
+	c.dataMu.RLock()
+	t, ok := c.tracks[track]
+	c.dataMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("track %d not setup", track)
+	}
+
+	pli := &rtcp.PLI{SenderSSRC: c.ssrc, MediaSSRC: atomic.LoadUint32(&t.remoteSSRC)}
+	return c.WriteRTCP(track, pli.Marshal())
+}
+
+func (c *Client) Pause() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := c.do(rtsp.NewRequest("PAUSE", c.aggregateURL()))
+	return err
+}
+
+func (c *Client) Teardown() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.teardown()
+}
+
+// teardown 需持有锁
+func (c *Client) teardown() error {
+	if c.session == "" {
+		return nil
+	}
+	_, err := c.do(rtsp.NewRequest("TEARDOWN", c.aggregateURL()))
+	c.session = ""
+	c.dataMu.Lock()
+	for i, t := range c.tracks {
+		t.close()
+		delete(c.tracks, i)
+	}
+	c.channels = make(map[int]int)
+	c.dataMu.Unlock()
+	return err
+}
+
+// Close 发送TEARDOWN并断开连接，等待所有goroutine退出后关闭Packets()
+func (c *Client) Close() error {
+	var err error
+	c.once.Do(func() {
+		// 先停止交付数据，避免readLoop阻塞在Packets()上读不到TEARDOWN的response
+		close(c.done)
+
+		c.mu.Lock()
+		select {
+		case <-c.broken:
+		default:
+			err = c.teardown()
+		}
+		c.conn.Close()
+		for _, t := range c.tracks {
+			t.close()
+		}
+		c.mu.Unlock()
+
+		c.wg.Wait()
+		close(c.packets)
+	})
+	return err
+}
+
+// Err 连接断开的原因
+func (c *Client) Err() error {
+	select {
+	case <-c.broken:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// Done 连接断开时关闭
+func (c *Client) Done() <-chan struct{} {
+	return c.broken
+}
+
+// do 需持有锁，发送请求并等待response，401时使用url中的用户名密码重试一次
+func (c *Client) do(req *rtsp.Request) (*rtsp.Response, error) {
+	authorized := false
+	for {
+		c.seq++
+		req.Version = c.opts.Version
+		req.AddMessage("CSeq", strconv.FormatInt(c.seq, 10))
+		req.AddMessage("User-Agent", c.opts.UserAgent)
+		if c.session != "" {
+			req.AddMessage("Session", c.session)
+		}
+		if c.auth != nil {
+			req.AddMessage("Authorization", c.auth.header(req.M, req.URI))
+		}
+
+		resp, err := c.roundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == "401" && c.user != nil && !authorized {
+			challenge, ok := resp.GetMessage("WWW-Authenticate")
+			if !ok {
+				return nil, fmt.Errorf("%s %s: 401 without challenge", req.M, req.URI)
+			}
+			if c.auth, err = newAuthenticator(c.user, challenge); err != nil {
+				return nil, err
+			}
+			authorized = true
+			continue
+		}
+
+		if resp.StatusCode[0] != '2' {
+			return resp, fmt.Errorf("%s %s: %s %s", req.M, req.URI, resp.StatusCode, resp.ReasonPhrase)
+		}
+		return resp, nil
+	}
+}
+
+func (c *Client) roundTrip(req *rtsp.Request) (*rtsp.Response, error) {
+	c.writeMu.Lock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.opts.Timeout))
+	_, err := c.conn.Write([]byte(req.Gen()))
+	c.writeMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := time.NewTimer(c.opts.Timeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case resp := <-c.responses:
+			// 忽略超时请求的response
+			if resp.Seq() != c.seq {
+				continue
+			}
+			return resp, nil
+		case <-c.broken:
+			return nil, c.err
+		case <-timeout.C:
+			return nil, fmt.Errorf("%s %s: timeout", req.M, req.URI)
+		}
+	}
+}
+
+// readLoop 读取response及interleaved数据
+func (c *Client) readLoop() {
+	defer c.wg.Done()
+
+	for {
+		b, err := c.reader.R.Peek(1)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		if b[0] == '$' {
+			header := make([]byte, 4)
+			if _, err := io.ReadFull(c.reader.R, header); err != nil {
+				c.fail(err)
+				return
+			}
+			data := make([]byte, binary.BigEndian.Uint16(header[2:]))
+			if _, err := io.ReadFull(c.reader.R, data); err != nil {
+				c.fail(err)
+				return
+			}
+
+			c.dataMu.RLock()
+			track, ok := c.channels[int(header[1])]
+			rtcpTrack, isRTCP := c.channels[int(header[1])-1]
+			c.dataMu.RUnlock()
+			if ok {
+				c.deliver(track, data)
+			} else if isRTCP {
+				c.deliverRTCP(rtcpTrack, data)
+			}
+			continue
+		}
+
+		// 服务端的请求，如PLAY_NOTIFY
+		if b, err := c.reader.R.Peek(5); err == nil && string(b) != "RTSP/" {
+			if err := c.handleRequest(); err != nil {
+				c.fail(err)
+				return
+			}
+			continue
+		}
+
+		resp := &rtsp.Response{}
+		if err := resp.Parse(*c.reader); err != nil {
+			c.fail(err)
+			return
+		}
+		select {
+		case c.responses <- resp:
+		default:
+			c.log.Warn("drop response", "cseq", resp.Seq())
+		}
+	}
+}
+
+// handleRequest 回复服务端的请求，只处理PLAY_NOTIFY
+func (c *Client) handleRequest() error {
+	req := &rtsp.Request{}
+	if err := req.Parse(*c.reader); err != nil {
+		return err
+	}
+
+	resp := &rtsp.Response{
+		StatusLine: rtsp.StatusLine{
+			RTSPVersion:  req.Version,
+			StatusCode:   "200",
+			ReasonPhrase: "OK",
+		},
+	}
+	if req.M != "PLAY_NOTIFY" {
+		resp.StatusCode, resp.ReasonPhrase = "501", "Not Implemented"
+	}
+	resp.AddMessage("CSeq", strconv.FormatInt(req.Seq, 10))
+	if session, ok := req.GetMessage("Session"); ok {
+		resp.AddMessage("Session", session)
+	}
+
+	c.writeMu.Lock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.opts.Timeout))
+	_, err := c.conn.Write([]byte(resp.Gen()))
+	c.writeMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if req.M == "PLAY_NOTIFY" && c.opts.OnPlayNotify != nil {
+		c.opts.OnPlayNotify(req)
+	}
+	return nil
+}
+
+func (c *Client) fail(err error) {
+	select {
+	case <-c.done:
+		err = fmt.Errorf("client closed")
+	default:
+	}
+	c.err = err
+	close(c.broken)
+}
+
+func (c *Client) readUDP(track int, conn *net.UDPConn, isRTP bool) {
+	defer c.wg.Done()
+
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		if isRTP {
+			c.deliver(track, data)
+		} else {
+			c.deliverRTCP(track, data)
+		}
+	}
+}
+
+func (c *Client) deliver(track int, data []byte) {
+	c.dataMu.RLock()
+	t, ok := c.tracks[track]
+	c.dataMu.RUnlock()
+	if ok && t.srtp != nil {
+		var err error
+		if data, err = t.srtp.DecryptRTP(data); err != nil {
+			c.log.Warn("drop srtp packet", "track", track, "err", err)
+			return
+		}
+	}
+
+	pkt := &rtp.Packet{}
+	if err := pkt.Unmarshal(data); err != nil {
+		c.log.Warn("invalid rtp packet", "track", track, "err", err)
+		return
+	}
+	if ok {
+		atomic.StoreUint32(&t.remoteSSRC, pkt.SSRC)
+	}
+
+	if c.opts.OnPacket != nil {
+		c.opts.OnPacket(track, pkt)
+		return
+	}
+	select {
+	case c.packets <- Packet{Track: track, Packet: pkt}:
+	case <-c.done:
+	}
+}
+
+func (c *Client) deliverRTCP(track int, data []byte) {
+	if c.opts.OnRTCP == nil {
+		return
+	}
+
+	c.dataMu.RLock()
+	t, ok := c.tracks[track]
+	c.dataMu.RUnlock()
+	if ok && t.srtp != nil {
+		var err error
+		if data, err = t.srtp.DecryptRTCP(data); err != nil {
+			c.log.Warn("drop srtcp packet", "track", track, "err", err)
+			return
+		}
+	}
+	c.opts.OnRTCP(track, data)
+}
+
+// keepalive 在session超时前发送GET_PARAMETER，服务端不支持时使用OPTIONS
+func (c *Client) keepalive() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(time.Duration(c.sessionTimeout) * time.Second / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			method := "OPTIONS"
+			for _, m := range c.public {
+				if m == "GET_PARAMETER" {
+					method = "GET_PARAMETER"
+				}
+			}
+			if c.session != "" {
+				if _, err := c.do(rtsp.NewRequest(method, c.aggregateURL())); err != nil {
+					c.log.Warn("keepalive failed", "err", err)
+				}
+			}
+			c.mu.Unlock()
+		case <-c.done:
+			return
+		case <-c.broken:
+			return
+		}
+	}
+}
+
+// trackURL 需持有锁，根据a=control生成SETUP的url
+func (c *Client) trackURL(track int) (string, error) {
+	controls, err := c.sdp.Ms[track].GetControl()
+	if err != nil {
+		return "", err
+	}
+	if len(controls) == 0 || controls[0].Value == "*" {
+		return c.aggregateURL(), nil
+	}
+
+	control := controls[0].Value
+	if strings.HasPrefix(strings.ToLower(control), "rtsp://") {
+		return control, nil
+	}
+	return strings.TrimSuffix(c.contentBase, "/") + "/" + control, nil
+}
+
+// aggregateURL 需持有锁，PLAY/PAUSE/TEARDOWN使用
+func (c *Client) aggregateURL() string {
+	if c.contentBase == "" {
+		return c.url
+	}
+	return strings.TrimSuffix(c.contentBase, "/")
+}
+
+func (t *clientTrack) close() {
+	if t.rtpConn != nil {
+		t.rtpConn.Close()
+		t.rtcpConn.Close()
+	}
+}
+
+// parseSessionHeader 12345678;timeout=60
+func parseSessionHeader(value string) (string, int) {
+	s, err := rtsp.ParseSession([]byte(value))
+	if err != nil {
+		return strings.TrimSpace(strings.Split(value, ";")[0]), defaultSessionTimeout
+	}
+	if s.Timeout == 0 {
+		return s.SessionId, defaultSessionTimeout
+	}
+	return s.SessionId, int(s.Timeout)
+}
+
+// transport10 将RTSP 2.0的src_addr/dest_addr转换为端口参数
+func transport10(item *rtsp.TransportItem) error {
+	port := func(addr string) (string, int, error) {
+		host, p, err := net.SplitHostPort(addr)
+		if err != nil {
+			return "", 0, err
+		}
+		n, err := strconv.Atoi(p)
+		return host, n, err
+	}
+
+	if item.Cast == "multicast" {
+		if len(item.DestAddr) == 0 {
+			return fmt.Errorf("no dest_addr")
+		}
+		host, p, err := port(item.DestAddr[0])
+		if err != nil {
+			return err
+		}
+		item.Destination, item.Port1, item.Port2 = host, p, p+1
+		return nil
+	}
+	if item.LowerTransport == "TCP" {
+		return nil
+	}
+	if len(item.SrcAddr) == 0 {
+		return fmt.Errorf("no src_addr")
+	}
+	_, p, err := port(item.SrcAddr[0])
+	if err != nil {
+		return err
+	}
+	item.ServerPort1, item.ServerPort2 = p, p+1
+	return nil
+}
+
+// joinMulticast 加入服务端分配的组播地址
+func joinMulticast(item *rtsp.TransportItem) (*net.UDPConn, *net.UDPConn, error) {
+	ip := net.ParseIP(item.Destination)
+	if item.Cast != "multicast" || ip == nil || !ip.IsMulticast() || item.Port1 == 0 {
+		return nil, nil, fmt.Errorf("invalid multicast transport")
+	}
+
+	rtpConn, err := net.ListenMulticastUDP("udp", nil, &net.UDPAddr{IP: ip, Port: item.Port1})
+	if err != nil {
+		return nil, nil, err
+	}
+	rtcpConn, err := net.ListenMulticastUDP("udp", nil, &net.UDPAddr{IP: ip, Port: item.Port1 + 1})
+	if err != nil {
+		rtpConn.Close()
+		return nil, nil, err
+	}
+	return rtpConn, rtcpConn, nil
+}
+
+// listenUDPPair RTP使用偶数端口，RTCP为RTP端口+1
+func listenUDPPair() (*net.UDPConn, *net.UDPConn, error) {
+	for i := 0; i < 100; i++ {
+		rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{})
+		if err != nil {
+			return nil, nil, err
+		}
+		port := rtpConn.LocalAddr().(*net.UDPAddr).Port
+		if port%2 != 0 {
+			rtpConn.Close()
+			continue
+		}
+		rtcpConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port + 1})
+		if err != nil {
+			rtpConn.Close()
+			continue
+		}
+		return rtpConn, rtcpConn, nil
+	}
+	return nil, nil, fmt.Errorf("no available udp port pair")
+}
@@ -0,0 +1,128 @@
+package client_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg"
+	"github.com/Lcmasdf/drs/pkg/client"
+	"github.com/Lcmasdf/drs/pkg/codec"
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// startServer 在随机端口启动drs，挂载一个H264文件
+func startServer(t *testing.T) string {
+	sps, _ := hex.DecodeString("6764002aac2c6a81e0089f966e0202020400")
+	pps, _ := hex.DecodeString("68ee3cb0")
+	nalus := [][]byte{
+		sps, pps,
+		append([]byte{0x65, 0x88}, bytes.Repeat([]byte{0x11}, 3000)...),
+		{0x41, 0x9a, 0x01},
+		{0x41, 0x9a, 0x02},
+	}
+	path := filepath.Join(t.TempDir(), "test.h264")
+	if err := ioutil.WriteFile(path, codec.JoinAnnexB(nalus), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &pkg.Server{Config: &pkg.Config{
+		Mounts: []*pkg.MountConfig{{Path: "/live", Source: path, FrameRate: 100}},
+	}}
+	go srv.Serve(listener)
+	return "rtsp://" + listener.Addr().String() + "/live"
+}
+
+func TestClient(t *testing.T) {
+	url := startServer(t)
+
+	for _, transport := range []string{client.TransportUDP, client.TransportTCP} {
+		Convey("test client play over "+transport, t, func() {
+			c, err := client.Dial(url, client.Options{Transport: transport, Timeout: 5 * time.Second})
+			So(err, ShouldBeNil)
+
+			resp, err := c.Options()
+			So(err, ShouldBeNil)
+			public, _ := resp.GetMessage("Public")
+			So(public, ShouldContainSubstring, "DESCRIBE")
+
+			s, err := c.Describe()
+			So(err, ShouldBeNil)
+			So(s.Ms, ShouldHaveLength, 1)
+			So(string(s.Gen()), ShouldContainSubstring, "H264/90000")
+
+			So(c.SetupAll(), ShouldBeNil)
+			_, err = c.Play(nil)
+			So(err, ShouldBeNil)
+
+			timeout := time.After(5 * time.Second)
+			for i := 0; i < 10; i++ {
+				select {
+				case p := <-c.Packets():
+					So(p.Track, ShouldEqual, 0)
+					So(p.Packet.PayloadType, ShouldEqual, 96)
+				case <-timeout:
+					So("timeout", ShouldBeEmpty)
+				}
+			}
+
+			So(c.Close(), ShouldBeNil)
+			for range c.Packets() {
+			}
+		})
+	}
+
+	Convey("test client callback", t, func() {
+		received := make(chan *rtp.Packet, 100)
+		c, err := client.Dial(url, client.Options{
+			Transport: client.TransportTCP,
+			OnPacket: func(track int, pkt *rtp.Packet) {
+				select {
+				case received <- pkt:
+				default:
+				}
+			},
+		})
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		_, err = c.Describe()
+		So(err, ShouldBeNil)
+		So(c.Setup(0), ShouldBeNil)
+		So(c.Setup(0), ShouldNotBeNil)
+		_, err = c.Play(nil)
+		So(err, ShouldBeNil)
+
+		select {
+		case pkt := <-received:
+			So(pkt.PayloadType, ShouldEqual, 96)
+		case <-time.After(5 * time.Second):
+			So("timeout", ShouldBeEmpty)
+		}
+		So(c.Teardown(), ShouldBeNil)
+	})
+
+	Convey("test client errors", t, func() {
+		c, err := client.Dial(strings.Replace(url, "/live", "/nope", 1), client.Options{})
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		_, err = c.Describe()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "404")
+		So(c.Setup(0), ShouldNotBeNil)
+
+		_, err = client.Dial("http://127.0.0.1/live", client.Options{})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,135 @@
+package pkg
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/rtcp"
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+)
+
+// 发送RTCP SR的间隔，测试时修改
+var senderReportInterval = 5 * time.Second
+
+// RTP时间与墙上时钟的偏差超过该值时重新对齐，用于推流端重新开始、倍速播放等
+const clockResyncThreshold = time.Second
+
+// clockTrack 一个track的RTP时间戳与墙上时钟的对应关系
+type clockTrack struct {
+	clockRate int64
+
+	synced  bool
+	rtpTime uint32
+	wall    time.Time
+	nextSeq uint16
+}
+
+// presentationClock 将stream中每个track的RTP时间戳映射到同一个墙上时钟
+// 每个track在经过stream的第一个包处对齐，source按媒体时间发送，各track之间保持同步
+type presentationClock struct {
+	mu sync.Mutex
+	// npt 0对应的绝对时间，直播为收到第一个包的时间
+	start  time.Time
+	tracks map[int]*clockTrack
+}
+
+// newPresentationClock 时钟频率取自sdp的rtpmap，start为零值时在收到第一个包时确定
+func newPresentationClock(s *sdp.SDPImpl, start time.Time) *presentationClock {
+	ret := &presentationClock{
+		start:  start,
+		tracks: make(map[int]*clockTrack),
+	}
+	for i, m := range s.Ms {
+		clockRate := 90000
+		if rtpmaps, err := m.GetRtpmaps(); err == nil && len(rtpmaps) > 0 && rtpmaps[0].ClockRate > 0 {
+			clockRate = rtpmaps[0].ClockRate
+		}
+		ret.tracks[i] = &clockTrack{clockRate: int64(clockRate)}
+	}
+	return ret
+}
+
+// update stream分发每个包之前调用
+func (c *presentationClock) update(track int, pkt *rtp.Packet, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, ok := c.tracks[track]
+	if !ok {
+		return
+	}
+	if c.start.IsZero() {
+		c.start = now
+	}
+	t.nextSeq = pkt.SequenceNumber + 1
+
+	if t.synced {
+		elapsed := time.Duration(int64(int32(pkt.Timestamp-t.rtpTime)) * int64(time.Second) / t.clockRate)
+		drift := now.Sub(t.wall.Add(elapsed))
+		if drift < clockResyncThreshold && drift > -clockResyncThreshold {
+			return
+		}
+	}
+	t.synced = true
+	t.rtpTime = pkt.Timestamp
+	t.wall = now
+}
+
+// reset seek、暂停等之后在下一个包处重新对齐
+func (c *presentationClock) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, t := range c.tracks {
+		t.synced = false
+	}
+}
+
+// rtpTime 墙上时钟at对应的track的RTP时间戳，还没有对齐时返回false
+func (c *presentationClock) rtpTime(track int, at time.Time) (uint32, bool) {
+	_, ret, ok := c.rtpInfo(track, at)
+	return ret, ok
+}
+
+// rtpInfo 下一个包的序号及at对应的RTP时间戳
+func (c *presentationClock) rtpInfo(track int, at time.Time) (uint16, uint32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, ok := c.tracks[track]
+	if !ok || !t.synced {
+		return 0, 0, false
+	}
+	return t.nextSeq, t.rtpTime + uint32(int64(at.Sub(t.wall))*t.clockRate/int64(time.Second)), true
+}
+
+// startTime npt 0对应的绝对时间，未知时为零值
+func (c *presentationClock) startTime() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.start
+}
+
+// senderStats 一个发送的track的统计，用于RTCP SR
+type senderStats struct {
+	packets uint32
+	octets  uint32
+}
+
+func (s *senderStats) sent(p *rtp.Packet) {
+	s.packets++
+	s.octets += uint32(len(p.Payload))
+}
+
+// report rtptime为now对应的RTP时间戳
+func (s *senderStats) report(ssrc, rtptime uint32, now time.Time) *rtcp.SenderReport {
+	return &rtcp.SenderReport{
+		SSRC:        ssrc,
+		NTPTime:     rtcp.NTPTime(now),
+		RTPTime:     rtptime,
+		PacketCount: s.packets,
+		OctetCount:  s.octets,
+	}
+}
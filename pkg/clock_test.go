@@ -0,0 +1,197 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/client"
+	"github.com/Lcmasdf/drs/pkg/rtcp"
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/rtsp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() {
+	// 在所有session启动之前修改
+	senderReportInterval = 100 * time.Millisecond
+}
+
+// writeTestFLV 0ms和1000ms各一个H264关键帧
+func writeTestFLV(t *testing.T) string {
+	tag := func(typ byte, timestamp uint32, data []byte) []byte {
+		b := []byte{typ, byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data)),
+			byte(timestamp >> 16), byte(timestamp >> 8), byte(timestamp), byte(timestamp >> 24), 0, 0, 0}
+		b = append(b, data...)
+		prev := make([]byte, 4)
+		binary.BigEndian.PutUint32(prev, uint32(len(b)))
+		return append(b, prev...)
+	}
+	sps := []byte{0x67, 0x42, 0xc0, 0x1e, 0xab}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	avcC := []byte{0x01, 0x42, 0xc0, 0x1e, 0xff, 0xe1, 0x00, byte(len(sps))}
+	avcC = append(avcC, sps...)
+	avcC = append(avcC, 0x01, 0x00, byte(len(pps)))
+	avcC = append(avcC, pps...)
+
+	data := []byte{'F', 'L', 'V', 1, 0x01, 0, 0, 0, 9, 0, 0, 0, 0}
+	data = append(data, tag(9, 0, append([]byte{0x17, 0, 0, 0, 0}, avcC...))...)
+	data = append(data, tag(9, 0, []byte{0x17, 1, 0, 0, 0, 0, 0, 0, 2, 0x65, 0x01})...)
+	data = append(data, tag(9, 1000, []byte{0x17, 1, 0, 0, 0, 0, 0, 0, 2, 0x65, 0x02})...)
+
+	path := filepath.Join(t.TempDir(), "test.flv")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPresentationClock(t *testing.T) {
+	Convey("test presentation clock", t, func() {
+		s := sdp.NewSDP("test")
+		v := sdp.NewMedia("video", 96)
+		v.AddAttribute("rtpmap:96 H264/90000")
+		s.AddMedia(v)
+		a := sdp.NewMedia("audio", 97)
+		a.AddAttribute("rtpmap:97 opus/48000/2")
+		s.AddMedia(a)
+
+		c := newPresentationClock(s, time.Time{})
+		start := time.Now()
+		_, ok := c.rtpTime(0, start)
+		So(ok, ShouldBeFalse)
+
+		c.update(0, &rtp.Packet{SequenceNumber: 10, Timestamp: 1000}, start)
+		c.update(1, &rtp.Packet{SequenceNumber: 20, Timestamp: 500}, start.Add(10*time.Millisecond))
+		So(c.startTime(), ShouldEqual, start)
+
+		// 同一时刻两个track按各自的时钟频率换算
+		at := start.Add(time.Second)
+		seq, rtptime, ok := c.rtpInfo(0, at)
+		So(ok, ShouldBeTrue)
+		So(seq, ShouldEqual, 11)
+		So(rtptime, ShouldEqual, 1000+90000)
+		rtptime, _ = c.rtpTime(1, at)
+		So(rtptime, ShouldEqual, 500+47520)
+
+		// 抖动不影响对齐
+		c.update(0, &rtp.Packet{SequenceNumber: 11, Timestamp: 1000 + 9000}, start.Add(150*time.Millisecond))
+		rtptime, _ = c.rtpTime(0, at)
+		So(rtptime, ShouldEqual, 1000+90000)
+
+		// 时间戳跳变后重新对齐
+		c.update(0, &rtp.Packet{SequenceNumber: 12, Timestamp: 1000 + 5*90000}, start.Add(200*time.Millisecond))
+		rtptime, _ = c.rtpTime(0, start.Add(1200*time.Millisecond))
+		So(rtptime, ShouldEqual, 1000+6*90000)
+
+		c.reset()
+		_, ok = c.rtpTime(1, at)
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func TestSync(t *testing.T) {
+	Convey("test rtp-info and sender reports on live mount", t, func() {
+		srv := &Server{Config: &Config{
+			Mounts: []*MountConfig{{Path: "/live", Source: writeTestH264(t), FrameRate: 100}},
+		}}
+		url := serveTest(srv)
+
+		first, err := client.Dial(url+"/live", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		defer first.Close()
+		_, err = first.Describe()
+		So(err, ShouldBeNil)
+		So(first.SetupAll(), ShouldBeNil)
+		_, err = first.Play(nil)
+		So(err, ShouldBeNil)
+		<-first.Packets()
+
+		reports := make(chan *rtcp.SenderReport, 16)
+		c, err := client.Dial(url+"/live", client.Options{
+			Transport: client.TransportTCP,
+			OnRTCP: func(track int, data []byte) {
+				pkts, _ := rtcp.Unmarshal(data)
+				for _, p := range pkts {
+					if sr, ok := p.(*rtcp.SenderReport); ok {
+						select {
+						case reports <- sr:
+						default:
+						}
+					}
+				}
+			},
+		})
+		So(err, ShouldBeNil)
+		defer c.Close()
+		_, err = c.Describe()
+		So(err, ShouldBeNil)
+		So(c.SetupAll(), ShouldBeNil)
+		resp, err := c.Play(&rtsp.Range{Clock: true, StartTime: time.Now()})
+		So(err, ShouldBeNil)
+		rng, _ := resp.GetMessage("Range")
+		So(rng, ShouldStartWith, "clock=")
+
+		v, ok := resp.GetMessage("RTP-Info")
+		So(ok, ShouldBeTrue)
+		infos, err := rtsp.ParseRTPInfo([]byte(v))
+		So(err, ShouldBeNil)
+		So(infos, ShouldHaveLength, 1)
+		So(infos[0].URL, ShouldEqual, url+"/live/trackID=0")
+
+		// 每帧10ms，RTP-Info之后到开始发送之间可能已经发出了几个包
+		p := <-c.Packets()
+		So(p.Packet.SequenceNumber-infos[0].Seq, ShouldBeLessThan, 5)
+		So(int32(p.Packet.Timestamp-infos[0].RTPTime), ShouldBeBetween, -9000, 9000)
+
+		select {
+		case sr := <-reports:
+			So(sr.SSRC, ShouldEqual, p.Packet.SSRC)
+			So(sr.PacketCount, ShouldBeGreaterThan, 0)
+			// SR中的NTP时间与RTP时间对应的是同一时刻
+			elapsed := rtcp.Time(sr.NTPTime).Sub(time.Now())
+			So(elapsed, ShouldBeBetween, -time.Second, time.Second)
+			So(int32(sr.RTPTime-p.Packet.Timestamp), ShouldBeBetween, 0, 90000)
+		case <-time.After(5 * time.Second):
+			So("timeout", ShouldBeEmpty)
+		}
+	})
+
+	Convey("test clock range on vod mount", t, func() {
+		file := writeTestFLV(t)
+		srv := &Server{Config: &Config{
+			Mounts: []*MountConfig{{Path: "/vod", Source: file}},
+		}}
+		url := serveTest(srv)
+
+		c, err := client.Dial(url+"/vod", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		defer c.Close()
+		_, err = c.Describe()
+		So(err, ShouldBeNil)
+		stream, _, _ := srv.findStream("/vod")
+		// 文件的结束时间为修改时间
+		end := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+		So(os.Chtimes(file, end, end), ShouldBeNil)
+		start := end.Add(-stream.Duration())
+
+		So(c.SetupAll(), ShouldBeNil)
+		resp, err := c.Play(&rtsp.Range{Clock: true, StartTime: start.Add(1200 * time.Millisecond)})
+		So(err, ShouldBeNil)
+		rng, _ := resp.GetMessage("Range")
+		So(rng, ShouldEqual, rtsp.GenClockRange(start.Add(time.Second), end))
+
+		v, _ := resp.GetMessage("RTP-Info")
+		infos, err := rtsp.ParseRTPInfo([]byte(v))
+		So(err, ShouldBeNil)
+		So(infos, ShouldHaveLength, 1)
+		p := <-c.Packets()
+		So(p.Packet.Payload, ShouldResemble, []byte{0x65, 0x02})
+		So(p.Packet.SequenceNumber, ShouldEqual, infos[0].Seq)
+		So(p.Packet.Timestamp, ShouldEqual, infos[0].RTPTime)
+	})
+}
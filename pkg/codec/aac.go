@@ -0,0 +1,57 @@
+package codec
+
+import "fmt"
+
+var aacSampleRates = []int{
+	96000, 88200, 64000, 48000, 44100, 32000,
+	24000, 22050, 16000, 12000, 11025, 8000, 7350,
+}
+
+// AudioSpecificConfig (ISO/IEC 14496-3)
+type AACConfig struct {
+	ObjectType int
+	SampleRate int
+	Channels   int
+}
+
+func ParseAACConfig(b []byte) (*AACConfig, error) {
+	r := &bitReader{data: b}
+	ret := &AACConfig{}
+
+	objectType, err := r.u(5)
+	if err != nil {
+		return nil, fmt.Errorf("invalid aac config")
+	}
+	if objectType == 31 {
+		ext, err := r.u(6)
+		if err != nil {
+			return nil, fmt.Errorf("invalid aac config")
+		}
+		objectType = 32 + ext
+	}
+	ret.ObjectType = int(objectType)
+
+	index, err := r.u(4)
+	if err != nil {
+		return nil, fmt.Errorf("invalid aac config")
+	}
+	if index == 15 {
+		rate, err := r.u(24)
+		if err != nil {
+			return nil, fmt.Errorf("invalid aac config")
+		}
+		ret.SampleRate = int(rate)
+	} else if int(index) < len(aacSampleRates) {
+		ret.SampleRate = aacSampleRates[index]
+	} else {
+		return nil, fmt.Errorf("invalid aac sample rate index %d", index)
+	}
+
+	channels, err := r.u(4)
+	if err != nil {
+		return nil, fmt.Errorf("invalid aac config")
+	}
+	ret.Channels = int(channels)
+
+	return ret, nil
+}
@@ -0,0 +1,49 @@
+package codec
+
+// SplitAnnexB 按 start code(00 00 01 / 00 00 00 01) 切分 Annex-B 字节流，返回不含 start code 的 NALU
+func SplitAnnexB(data []byte) [][]byte {
+	ret := make([][]byte, 0)
+
+	start := -1
+	zeros := 0
+	for i, b := range data {
+		if b == 0 {
+			zeros++
+			continue
+		}
+
+		if b == 1 && zeros >= 2 {
+			if start >= 0 {
+				end := i - zeros
+				if end > start {
+					ret = append(ret, data[start:end])
+				}
+			}
+			start = i + 1
+		}
+		zeros = 0
+	}
+
+	if start >= 0 && start < len(data) {
+		ret = append(ret, trimTrailingZeros(data[start:]))
+	}
+	return ret
+}
+
+// JoinAnnexB 给每个 NALU 加上4字节 start code 后拼接
+func JoinAnnexB(nalus [][]byte) []byte {
+	ret := make([]byte, 0)
+	for _, nalu := range nalus {
+		ret = append(ret, 0, 0, 0, 1)
+		ret = append(ret, nalu...)
+	}
+	return ret
+}
+
+func trimTrailingZeros(b []byte) []byte {
+	n := len(b)
+	for n > 0 && b[n-1] == 0 {
+		n--
+	}
+	return b[:n]
+}
@@ -0,0 +1,221 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// AVCDecoderConfigurationRecord (ISO/IEC 14496-15)，mp4/mkv/flv 中 H264 的 codec private
+type AVCDecoderConfig struct {
+	// NALU 长度字段的字节数
+	LengthSize int
+	SPS        [][]byte
+	PPS        [][]byte
+}
+
+func ParseAVCDecoderConfig(b []byte) (*AVCDecoderConfig, error) {
+	if len(b) < 7 || b[0] != 1 {
+		return nil, fmt.Errorf("invalid avcC")
+	}
+
+	ret := &AVCDecoderConfig{
+		LengthSize: int(b[4]&0x03) + 1,
+	}
+
+	pos := 6
+	readList := func(n int) ([][]byte, error) {
+		list := make([][]byte, 0, n)
+		for i := 0; i < n; i++ {
+			if pos+2 > len(b) {
+				return nil, fmt.Errorf("invalid avcC")
+			}
+			l := int(binary.BigEndian.Uint16(b[pos:]))
+			pos += 2
+			if pos+l > len(b) {
+				return nil, fmt.Errorf("invalid avcC")
+			}
+			list = append(list, b[pos:pos+l])
+			pos += l
+		}
+		return list, nil
+	}
+
+	var err error
+	if ret.SPS, err = readList(int(b[5] & 0x1f)); err != nil {
+		return nil, err
+	}
+	pos++
+	if pos > len(b) {
+		return nil, fmt.Errorf("invalid avcC")
+	}
+	if ret.PPS, err = readList(int(b[pos-1])); err != nil {
+		return nil, err
+	}
+
+	if len(ret.SPS) == 0 || len(ret.PPS) == 0 {
+		return nil, fmt.Errorf("avcC without sps/pps")
+	}
+	// profile-level-id 取自sps的第1~3字节
+	if len(ret.SPS[0]) < 4 {
+		return nil, fmt.Errorf("invalid avcC sps")
+	}
+	return ret, nil
+}
+
+// HEVCDecoderConfigurationRecord (ISO/IEC 14496-15)
+type HEVCDecoderConfig struct {
+	LengthSize int
+	VPS        [][]byte
+	SPS        [][]byte
+	PPS        [][]byte
+}
+
+func ParseHEVCDecoderConfig(b []byte) (*HEVCDecoderConfig, error) {
+	if len(b) < 23 {
+		return nil, fmt.Errorf("invalid hvcC")
+	}
+
+	ret := &HEVCDecoderConfig{
+		LengthSize: int(b[21]&0x03) + 1,
+	}
+
+	numArrays := int(b[22])
+	pos := 23
+	for i := 0; i < numArrays; i++ {
+		if pos+3 > len(b) {
+			return nil, fmt.Errorf("invalid hvcC")
+		}
+		naluType := int(b[pos] & 0x3f)
+		numNalus := int(binary.BigEndian.Uint16(b[pos+1:]))
+		pos += 3
+
+		for j := 0; j < numNalus; j++ {
+			if pos+2 > len(b) {
+				return nil, fmt.Errorf("invalid hvcC")
+			}
+			l := int(binary.BigEndian.Uint16(b[pos:]))
+			pos += 2
+			if pos+l > len(b) {
+				return nil, fmt.Errorf("invalid hvcC")
+			}
+			nalu := b[pos : pos+l]
+			pos += l
+
+			switch naluType {
+			case H265NaluVPS:
+				ret.VPS = append(ret.VPS, nalu)
+			case H265NaluSPS:
+				ret.SPS = append(ret.SPS, nalu)
+			case H265NaluPPS:
+				ret.PPS = append(ret.PPS, nalu)
+			}
+		}
+	}
+
+	if len(ret.VPS) == 0 || len(ret.SPS) == 0 || len(ret.PPS) == 0 {
+		return nil, fmt.Errorf("hvcC without vps/sps/pps")
+	}
+	return ret, nil
+}
+
+// SplitAVCC 按长度前缀切分NALU
+func SplitAVCC(data []byte, lengthSize int) ([][]byte, error) {
+	ret := make([][]byte, 0)
+	for len(data) > 0 {
+		if len(data) < lengthSize {
+			return nil, fmt.Errorf("invalid avcc nalu length")
+		}
+
+		l := 0
+		for i := 0; i < lengthSize; i++ {
+			l = l<<8 | int(data[i])
+		}
+		data = data[lengthSize:]
+		if l > len(data) {
+			return nil, fmt.Errorf("invalid avcc nalu length %d", l)
+		}
+		if l > 0 {
+			ret = append(ret, data[:l])
+		}
+		data = data[l:]
+	}
+	return ret, nil
+}
+
+// GenAVCDecoderConfig 由sps/pps生成avcC，NALU长度字段为4字节
+func GenAVCDecoderConfig(sps, pps [][]byte) ([]byte, error) {
+	if len(sps) == 0 || len(pps) == 0 || len(sps[0]) < 4 {
+		return nil, fmt.Errorf("invalid sps/pps")
+	}
+
+	ret := []byte{1, sps[0][1], sps[0][2], sps[0][3], 0xff, 0xe0 | byte(len(sps))}
+	for _, nalu := range sps {
+		ret = append(ret, byte(len(nalu)>>8), byte(len(nalu)))
+		ret = append(ret, nalu...)
+	}
+	ret = append(ret, byte(len(pps)))
+	for _, nalu := range pps {
+		ret = append(ret, byte(len(nalu)>>8), byte(len(nalu)))
+		ret = append(ret, nalu...)
+	}
+	return ret, nil
+}
+
+// GenHEVCDecoderConfig 由vps/sps/pps生成hvcC，NALU长度字段为4字节
+func GenHEVCDecoderConfig(vps, sps, pps [][]byte) ([]byte, error) {
+	if len(vps) == 0 || len(sps) == 0 || len(pps) == 0 {
+		return nil, fmt.Errorf("invalid vps/sps/pps")
+	}
+
+	// sps: nal header(2) + vps_id/max_sub_layers/nesting(1) + profile_tier_level(12)
+	rbsp := RBSP(sps[0])
+	if len(rbsp) < 15 {
+		return nil, fmt.Errorf("invalid h265 sps")
+	}
+
+	ret := make([]byte, 0, 64)
+	ret = append(ret, 1)
+	ret = append(ret, rbsp[3:15]...)
+	ret = append(ret,
+		0xf0, 0x00, // min_spatial_segmentation_idc
+		0xfc,       // parallelismType
+		0xfd,       // chromaFormat 4:2:0
+		0xf8,       // bitDepthLumaMinus8
+		0xf8,       // bitDepthChromaMinus8
+		0x00, 0x00, // avgFrameRate
+		0x0f, // lengthSizeMinusOne = 3
+		3,    // numOfArrays
+	)
+
+	for _, array := range []struct {
+		naluType int
+		nalus    [][]byte
+	}{
+		{H265NaluVPS, vps},
+		{H265NaluSPS, sps},
+		{H265NaluPPS, pps},
+	} {
+		ret = append(ret, 0x80|byte(array.naluType), byte(len(array.nalus)>>8), byte(len(array.nalus)))
+		for _, nalu := range array.nalus {
+			ret = append(ret, byte(len(nalu)>>8), byte(len(nalu)))
+			ret = append(ret, nalu...)
+		}
+	}
+	return ret, nil
+}
+
+// JoinAVCC 为每个NALU加上4字节长度前缀
+func JoinAVCC(nalus [][]byte) []byte {
+	size := 0
+	for _, nalu := range nalus {
+		size += 4 + len(nalu)
+	}
+
+	ret := make([]byte, 0, size)
+	for _, nalu := range nalus {
+		l := len(nalu)
+		ret = append(ret, byte(l>>24), byte(l>>16), byte(l>>8), byte(l))
+		ret = append(ret, nalu...)
+	}
+	return ret
+}
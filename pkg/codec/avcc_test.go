@@ -0,0 +1,53 @@
+package codec
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAVCDecoderConfig(t *testing.T) {
+	Convey("test avcC gen and parse", t, func() {
+		sps := []byte{0x67, 0x64, 0x00, 0x1f, 0xac}
+		pps := []byte{0x68, 0xee, 0x3c, 0x80}
+		b, err := GenAVCDecoderConfig([][]byte{sps}, [][]byte{pps})
+		So(err, ShouldBeNil)
+
+		conf, err := ParseAVCDecoderConfig(b)
+		So(err, ShouldBeNil)
+		So(conf.LengthSize, ShouldEqual, 4)
+		So(conf.SPS, ShouldResemble, [][]byte{sps})
+		So(conf.PPS, ShouldResemble, [][]byte{pps})
+
+		_, err = ParseAVCDecoderConfig(b[:7])
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("test hvcC gen and parse", t, func() {
+		vps := []byte{0x40, 0x01, 0x0c}
+		sps := []byte{0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x5d, 0xa0, 0x02, 0x80}
+		pps := []byte{0x44, 0x01, 0xc1}
+		b, err := GenHEVCDecoderConfig([][]byte{vps}, [][]byte{sps}, [][]byte{pps})
+		So(err, ShouldBeNil)
+		// general_profile_idc 及 general_level_idc
+		So(b[1], ShouldEqual, 0x01)
+		So(b[12], ShouldEqual, 0x5d)
+
+		conf, err := ParseHEVCDecoderConfig(b)
+		So(err, ShouldBeNil)
+		So(conf.LengthSize, ShouldEqual, 4)
+		So(conf.VPS, ShouldResemble, [][]byte{vps})
+		So(conf.SPS, ShouldResemble, [][]byte{sps})
+		So(conf.PPS, ShouldResemble, [][]byte{pps})
+	})
+
+	Convey("test avcc split and join", t, func() {
+		nalus := [][]byte{{0x65, 1, 2}, {0x06}}
+		units, err := SplitAVCC(JoinAVCC(nalus), 4)
+		So(err, ShouldBeNil)
+		So(units, ShouldResemble, nalus)
+
+		_, err = SplitAVCC([]byte{0, 0, 0, 9, 1}, 4)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,92 @@
+package codec
+
+import "fmt"
+
+var errBitsEOF = fmt.Errorf("bit reader eof")
+
+// bitReader 按位读取，用于解析 SPS/VPS 等参数集
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) u(n int) (uint32, error) {
+	var ret uint32
+	for i := 0; i < n; i++ {
+		if r.pos >= len(r.data)*8 {
+			return 0, errBitsEOF
+		}
+		bit := (r.data[r.pos/8] >> (7 - uint(r.pos%8))) & 1
+		ret = ret<<1 | uint32(bit)
+		r.pos++
+	}
+	return ret, nil
+}
+
+func (r *bitReader) flag() (bool, error) {
+	v, err := r.u(1)
+	return v == 1, err
+}
+
+func (r *bitReader) skip(n int) error {
+	if r.pos+n > len(r.data)*8 {
+		return errBitsEOF
+	}
+	r.pos += n
+	return nil
+}
+
+// ue Exp-Golomb 无符号
+func (r *bitReader) ue() (uint32, error) {
+	zeros := 0
+	for {
+		b, err := r.u(1)
+		if err != nil {
+			return 0, err
+		}
+		if b == 1 {
+			break
+		}
+		zeros++
+		if zeros > 31 {
+			return 0, fmt.Errorf("invalid exp-golomb code")
+		}
+	}
+
+	v, err := r.u(zeros)
+	if err != nil {
+		return 0, err
+	}
+	return (1<<uint(zeros) - 1) + v, nil
+}
+
+// se Exp-Golomb 有符号
+func (r *bitReader) se() (int32, error) {
+	v, err := r.ue()
+	if err != nil {
+		return 0, err
+	}
+	if v&1 == 1 {
+		return int32((v + 1) / 2), nil
+	}
+	return -int32(v / 2), nil
+}
+
+// RBSP 去除防竞争字节 0x000003
+func RBSP(nalu []byte) []byte {
+	ret := make([]byte, 0, len(nalu))
+	zeros := 0
+	for _, b := range nalu {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		ret = append(ret, b)
+	}
+	return ret
+}
@@ -0,0 +1,371 @@
+package codec
+
+import "fmt"
+
+// H264 NALU type
+const (
+	H264NaluNonIDR = 1
+	H264NaluIDR    = 5
+	H264NaluSEI    = 6
+	H264NaluSPS    = 7
+	H264NaluPPS    = 8
+	H264NaluAUD    = 9
+)
+
+func H264NaluType(nalu []byte) int {
+	return int(nalu[0] & 0x1f)
+}
+
+func h264IsVCL(t int) bool {
+	return t >= 1 && t <= 5
+}
+
+// H264IsKeyFrame 判断一个AU中是否包含IDR
+func H264IsKeyFrame(au [][]byte) bool {
+	for _, nalu := range au {
+		if len(nalu) > 0 && H264NaluType(nalu) == H264NaluIDR {
+			return true
+		}
+	}
+	return false
+}
+
+// H264GroupAccessUnits 将NALU序列按access unit分组
+func H264GroupAccessUnits(nalus [][]byte) [][][]byte {
+	ret := make([][][]byte, 0)
+	cur := make([][]byte, 0)
+	hasVCL := false
+
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+		t := H264NaluType(nalu)
+
+		newAU := false
+		if h264IsVCL(t) {
+			// first_mb_in_slice == 0 表示新的一帧
+			if hasVCL && len(nalu) > 1 && nalu[1]&0x80 != 0 {
+				newAU = true
+			}
+		} else if hasVCL && (t == H264NaluAUD || t == H264NaluSEI || t == H264NaluSPS || t == H264NaluPPS || (t >= 14 && t <= 18)) {
+			newAU = true
+		}
+
+		if newAU {
+			ret = append(ret, cur)
+			cur = make([][]byte, 0)
+			hasVCL = false
+		}
+
+		cur = append(cur, nalu)
+		if h264IsVCL(t) {
+			hasVCL = true
+		}
+	}
+
+	if len(cur) > 0 {
+		ret = append(ret, cur)
+	}
+	return ret
+}
+
+type H264SPS struct {
+	ProfileIdc    uint8
+	ConstraintSet uint8
+	LevelIdc      uint8
+
+	Width  int
+	Height int
+
+	// VUI timing，没有则为0
+	NumUnitsInTick uint32
+	TimeScale      uint32
+}
+
+// FrameRate 由VUI timing计算帧率，没有timing信息返回0
+func (s *H264SPS) FrameRate() float64 {
+	if s.NumUnitsInTick == 0 || s.TimeScale == 0 {
+		return 0
+	}
+	return float64(s.TimeScale) / float64(2*s.NumUnitsInTick)
+}
+
+// ProfileLevelID sdp fmtp profile-level-id
+func (s *H264SPS) ProfileLevelID() string {
+	return fmt.Sprintf("%02X%02X%02X", s.ProfileIdc, s.ConstraintSet, s.LevelIdc)
+}
+
+func ParseH264SPS(nalu []byte) (*H264SPS, error) {
+	if len(nalu) < 4 || H264NaluType(nalu) != H264NaluSPS {
+		return nil, fmt.Errorf("invalid h264 sps")
+	}
+
+	ret := &H264SPS{
+		ProfileIdc:    nalu[1],
+		ConstraintSet: nalu[2],
+		LevelIdc:      nalu[3],
+	}
+
+	if err := ret.parse(&bitReader{data: RBSP(nalu[4:])}); err != nil {
+		return nil, fmt.Errorf("invalid h264 sps: %s", err.Error())
+	}
+	return ret, nil
+}
+
+func (s *H264SPS) parse(r *bitReader) error {
+	// seq_parameter_set_id
+	if _, err := r.ue(); err != nil {
+		return err
+	}
+
+	chromaFormatIdc := uint32(1)
+	switch s.ProfileIdc {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		var err error
+		if chromaFormatIdc, err = r.ue(); err != nil {
+			return err
+		}
+		if chromaFormatIdc == 3 {
+			// separate_colour_plane_flag
+			if err := r.skip(1); err != nil {
+				return err
+			}
+		}
+		// bit_depth_luma_minus8 bit_depth_chroma_minus8
+		for i := 0; i < 2; i++ {
+			if _, err := r.ue(); err != nil {
+				return err
+			}
+		}
+		// qpprime_y_zero_transform_bypass_flag
+		if err := r.skip(1); err != nil {
+			return err
+		}
+		present, err := r.flag()
+		if err != nil {
+			return err
+		}
+		if present {
+			n := 8
+			if chromaFormatIdc == 3 {
+				n = 12
+			}
+			for i := 0; i < n; i++ {
+				listPresent, err := r.flag()
+				if err != nil {
+					return err
+				}
+				if !listPresent {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				if err := h264SkipScalingList(r, size); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// log2_max_frame_num_minus4
+	if _, err := r.ue(); err != nil {
+		return err
+	}
+
+	pocType, err := r.ue()
+	if err != nil {
+		return err
+	}
+	switch pocType {
+	case 0:
+		// log2_max_pic_order_cnt_lsb_minus4
+		if _, err := r.ue(); err != nil {
+			return err
+		}
+	case 1:
+		// delta_pic_order_always_zero_flag
+		if err := r.skip(1); err != nil {
+			return err
+		}
+		// offset_for_non_ref_pic offset_for_top_to_bottom_field
+		for i := 0; i < 2; i++ {
+			if _, err := r.se(); err != nil {
+				return err
+			}
+		}
+		n, err := r.ue()
+		if err != nil {
+			return err
+		}
+		for i := uint32(0); i < n; i++ {
+			if _, err := r.se(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// max_num_ref_frames
+	if _, err := r.ue(); err != nil {
+		return err
+	}
+	// gaps_in_frame_num_value_allowed_flag
+	if err := r.skip(1); err != nil {
+		return err
+	}
+
+	widthMbs, err := r.ue()
+	if err != nil {
+		return err
+	}
+	heightMapUnits, err := r.ue()
+	if err != nil {
+		return err
+	}
+	frameMbsOnly, err := r.flag()
+	if err != nil {
+		return err
+	}
+	if !frameMbsOnly {
+		// mb_adaptive_frame_field_flag
+		if err := r.skip(1); err != nil {
+			return err
+		}
+	}
+	// direct_8x8_inference_flag
+	if err := r.skip(1); err != nil {
+		return err
+	}
+
+	frameHeightFactor := 2
+	if frameMbsOnly {
+		frameHeightFactor = 1
+	}
+	s.Width = int(widthMbs+1) * 16
+	s.Height = int(heightMapUnits+1) * 16 * frameHeightFactor
+
+	cropping, err := r.flag()
+	if err != nil {
+		return err
+	}
+	if cropping {
+		var crop [4]uint32
+		for i := range crop {
+			if crop[i], err = r.ue(); err != nil {
+				return err
+			}
+		}
+		cropUnitX, cropUnitY := 1, frameHeightFactor
+		if chromaFormatIdc == 1 || chromaFormatIdc == 2 {
+			cropUnitX = 2
+		}
+		if chromaFormatIdc == 1 {
+			cropUnitY *= 2
+		}
+		s.Width -= int(crop[0]+crop[1]) * cropUnitX
+		s.Height -= int(crop[2]+crop[3]) * cropUnitY
+	}
+
+	vui, err := r.flag()
+	if err != nil {
+		return err
+	}
+	if !vui {
+		return nil
+	}
+	return s.parseVUI(r)
+}
+
+func (s *H264SPS) parseVUI(r *bitReader) error {
+	aspectRatio, err := r.flag()
+	if err != nil {
+		return err
+	}
+	if aspectRatio {
+		idc, err := r.u(8)
+		if err != nil {
+			return err
+		}
+		// Extended_SAR
+		if idc == 255 {
+			if err := r.skip(32); err != nil {
+				return err
+			}
+		}
+	}
+
+	overscan, err := r.flag()
+	if err != nil {
+		return err
+	}
+	if overscan {
+		if err := r.skip(1); err != nil {
+			return err
+		}
+	}
+
+	videoSignal, err := r.flag()
+	if err != nil {
+		return err
+	}
+	if videoSignal {
+		// video_format video_full_range_flag
+		if err := r.skip(4); err != nil {
+			return err
+		}
+		colour, err := r.flag()
+		if err != nil {
+			return err
+		}
+		if colour {
+			if err := r.skip(24); err != nil {
+				return err
+			}
+		}
+	}
+
+	chromaLoc, err := r.flag()
+	if err != nil {
+		return err
+	}
+	if chromaLoc {
+		for i := 0; i < 2; i++ {
+			if _, err := r.ue(); err != nil {
+				return err
+			}
+		}
+	}
+
+	timing, err := r.flag()
+	if err != nil {
+		return err
+	}
+	if timing {
+		if s.NumUnitsInTick, err = r.u(32); err != nil {
+			return err
+		}
+		if s.TimeScale, err = r.u(32); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func h264SkipScalingList(r *bitReader, size int) error {
+	lastScale, nextScale := int32(8), int32(8)
+	for j := 0; j < size; j++ {
+		if nextScale != 0 {
+			delta, err := r.se()
+			if err != nil {
+				return err
+			}
+			nextScale = (lastScale + delta + 256) % 256
+		}
+		if nextScale != 0 {
+			lastScale = nextScale
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package codec
+
+import (
+	"encoding/hex"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// bitWriter 用于构造测试用的参数集
+type bitWriter struct {
+	data []byte
+	n    int
+}
+
+func (w *bitWriter) u(n int, v uint32) {
+	for i := n - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if (v>>uint(i))&1 == 1 {
+			w.data[len(w.data)-1] |= 1 << uint(7-w.n%8)
+		}
+		w.n++
+	}
+}
+
+func (w *bitWriter) ue(v uint32) {
+	v++
+	bits := 0
+	for t := v; t > 0; t >>= 1 {
+		bits++
+	}
+	w.u(bits-1, 0)
+	w.u(bits, v)
+}
+
+func (w *bitWriter) bytes() []byte {
+	// rbsp_stop_one_bit
+	w.u(1, 1)
+	return w.data
+}
+
+func TestSplitAnnexB(t *testing.T) {
+	Convey("test annexb split", t, func() {
+		data := []byte{0, 0, 0, 1, 0x67, 1, 2, 0, 0, 1, 0x68, 3, 0, 0, 0, 1, 0x65, 4, 5, 0}
+		nalus := SplitAnnexB(data)
+		So(len(nalus), ShouldEqual, 3)
+		So(nalus[0], ShouldResemble, []byte{0x67, 1, 2})
+		So(nalus[1], ShouldResemble, []byte{0x68, 3})
+		So(nalus[2], ShouldResemble, []byte{0x65, 4, 5})
+
+		So(SplitAnnexB(JoinAnnexB(nalus)), ShouldResemble, nalus)
+	})
+}
+
+func TestH264GroupAccessUnits(t *testing.T) {
+	Convey("test h264 access unit grouping", t, func() {
+		nalus := [][]byte{
+			{0x67, 0x42}, {0x68, 0xce},
+			{0x65, 0x88}, {0x65, 0x10},
+			{0x41, 0x9a}, {0x41, 0x02},
+			{0x06, 0x05}, {0x41, 0x9a},
+		}
+		aus := H264GroupAccessUnits(nalus)
+		So(len(aus), ShouldEqual, 3)
+		So(len(aus[0]), ShouldEqual, 4)
+		So(H264IsKeyFrame(aus[0]), ShouldBeTrue)
+		So(len(aus[1]), ShouldEqual, 2)
+		So(H264IsKeyFrame(aus[1]), ShouldBeFalse)
+		So(len(aus[2]), ShouldEqual, 2)
+	})
+}
+
+func TestParseH264SPS(t *testing.T) {
+	Convey("test h264 sps parse", t, func() {
+		Convey("high profile without timing", func() {
+			b, _ := hex.DecodeString("6764002aac2c6a81e0089f966e0202020400")
+			sps, err := ParseH264SPS(b)
+			So(err, ShouldBeNil)
+			So(sps.Width, ShouldEqual, 1920)
+			So(sps.Height, ShouldEqual, 1080)
+			So(sps.ProfileLevelID(), ShouldEqual, "64002A")
+			So(sps.FrameRate(), ShouldEqual, 0)
+		})
+
+		Convey("baseline profile with vui timing", func() {
+			w := &bitWriter{}
+			w.ue(0) // seq_parameter_set_id
+			w.ue(0) // log2_max_frame_num_minus4
+			w.ue(2) // pic_order_cnt_type
+			w.ue(1) // max_num_ref_frames
+			w.u(1, 0)
+			w.ue(19) // pic_width_in_mbs_minus1
+			w.ue(14) // pic_height_in_map_units_minus1
+			w.u(1, 1)
+			w.u(1, 1)
+			w.u(1, 0)
+			w.u(1, 1) // vui_parameters_present_flag
+			w.u(4, 0)
+			w.u(1, 1) // timing_info_present_flag
+			w.u(32, 1)
+			w.u(32, 60)
+			w.u(1, 1)
+			w.u(5, 0)
+
+			sps, err := ParseH264SPS(append([]byte{0x67, 66, 0xc0, 30}, w.bytes()...))
+			So(err, ShouldBeNil)
+			So(sps.Width, ShouldEqual, 320)
+			So(sps.Height, ShouldEqual, 240)
+			So(sps.FrameRate(), ShouldEqual, 30)
+		})
+	})
+}
@@ -0,0 +1,512 @@
+package codec
+
+import "fmt"
+
+// H265 NALU type
+const (
+	H265NaluBLAWLP     = 16
+	H265NaluCRANUT     = 21
+	H265NaluVPS        = 32
+	H265NaluSPS        = 33
+	H265NaluPPS        = 34
+	H265NaluAUD        = 35
+	H265NaluPrefixSEI  = 39
+	H265NaluSuffixSEI  = 40
+	h265NaluMaxVCLType = 31
+)
+
+func H265NaluType(nalu []byte) int {
+	return int(nalu[0]>>1) & 0x3f
+}
+
+func h265IsVCL(t int) bool {
+	return t <= h265NaluMaxVCLType
+}
+
+// H265IsKeyFrame 判断一个AU中是否包含IRAP
+func H265IsKeyFrame(au [][]byte) bool {
+	for _, nalu := range au {
+		if len(nalu) < 2 {
+			continue
+		}
+		t := H265NaluType(nalu)
+		if t >= H265NaluBLAWLP && t <= H265NaluCRANUT {
+			return true
+		}
+	}
+	return false
+}
+
+// H265GroupAccessUnits 将NALU序列按access unit分组
+func H265GroupAccessUnits(nalus [][]byte) [][][]byte {
+	ret := make([][][]byte, 0)
+	cur := make([][]byte, 0)
+	hasVCL := false
+
+	for _, nalu := range nalus {
+		if len(nalu) < 2 {
+			continue
+		}
+		t := H265NaluType(nalu)
+
+		newAU := false
+		if h265IsVCL(t) {
+			// first_slice_segment_in_pic_flag
+			if hasVCL && len(nalu) > 2 && nalu[2]&0x80 != 0 {
+				newAU = true
+			}
+		} else if hasVCL && ((t >= H265NaluVPS && t <= H265NaluPrefixSEI) || (t >= 41 && t <= 44) || (t >= 48 && t <= 55)) {
+			newAU = true
+		}
+
+		if newAU {
+			ret = append(ret, cur)
+			cur = make([][]byte, 0)
+			hasVCL = false
+		}
+
+		cur = append(cur, nalu)
+		if h265IsVCL(t) {
+			hasVCL = true
+		}
+	}
+
+	if len(cur) > 0 {
+		ret = append(ret, cur)
+	}
+	return ret
+}
+
+type H265SPS struct {
+	Width  int
+	Height int
+
+	// VUI timing，没有则为0
+	NumUnitsInTick uint32
+	TimeScale      uint32
+}
+
+// FrameRate 由VUI timing计算帧率，没有timing信息返回0
+func (s *H265SPS) FrameRate() float64 {
+	if s.NumUnitsInTick == 0 || s.TimeScale == 0 {
+		return 0
+	}
+	return float64(s.TimeScale) / float64(s.NumUnitsInTick)
+}
+
+func ParseH265SPS(nalu []byte) (*H265SPS, error) {
+	if len(nalu) < 3 || H265NaluType(nalu) != H265NaluSPS {
+		return nil, fmt.Errorf("invalid h265 sps")
+	}
+
+	ret := &H265SPS{}
+	if err := ret.parse(&bitReader{data: RBSP(nalu[2:])}); err != nil {
+		return nil, fmt.Errorf("invalid h265 sps: %s", err.Error())
+	}
+	return ret, nil
+}
+
+func (s *H265SPS) parse(r *bitReader) error {
+	// sps_video_parameter_set_id
+	if err := r.skip(4); err != nil {
+		return err
+	}
+	maxSubLayersMinus1, err := r.u(3)
+	if err != nil {
+		return err
+	}
+	// sps_temporal_id_nesting_flag
+	if err := r.skip(1); err != nil {
+		return err
+	}
+	if err := h265SkipProfileTierLevel(r, int(maxSubLayersMinus1)); err != nil {
+		return err
+	}
+
+	// sps_seq_parameter_set_id
+	if _, err := r.ue(); err != nil {
+		return err
+	}
+	chromaFormatIdc, err := r.ue()
+	if err != nil {
+		return err
+	}
+	if chromaFormatIdc == 3 {
+		if err := r.skip(1); err != nil {
+			return err
+		}
+	}
+
+	width, err := r.ue()
+	if err != nil {
+		return err
+	}
+	height, err := r.ue()
+	if err != nil {
+		return err
+	}
+	s.Width, s.Height = int(width), int(height)
+
+	conformance, err := r.flag()
+	if err != nil {
+		return err
+	}
+	if conformance {
+		var win [4]uint32
+		for i := range win {
+			if win[i], err = r.ue(); err != nil {
+				return err
+			}
+		}
+		subWidth, subHeight := 1, 1
+		if chromaFormatIdc == 1 || chromaFormatIdc == 2 {
+			subWidth = 2
+		}
+		if chromaFormatIdc == 1 {
+			subHeight = 2
+		}
+		s.Width -= int(win[0]+win[1]) * subWidth
+		s.Height -= int(win[2]+win[3]) * subHeight
+	}
+
+	// bit_depth_luma_minus8 bit_depth_chroma_minus8
+	for i := 0; i < 2; i++ {
+		if _, err := r.ue(); err != nil {
+			return err
+		}
+	}
+	log2MaxPocLsbMinus4, err := r.ue()
+	if err != nil {
+		return err
+	}
+
+	orderingInfo, err := r.flag()
+	if err != nil {
+		return err
+	}
+	first := maxSubLayersMinus1
+	if orderingInfo {
+		first = 0
+	}
+	for i := first; i <= maxSubLayersMinus1; i++ {
+		for j := 0; j < 3; j++ {
+			if _, err := r.ue(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// log2_min_luma_coding_block_size_minus3 ... max_transform_hierarchy_depth_intra
+	for i := 0; i < 6; i++ {
+		if _, err := r.ue(); err != nil {
+			return err
+		}
+	}
+
+	scalingList, err := r.flag()
+	if err != nil {
+		return err
+	}
+	if scalingList {
+		present, err := r.flag()
+		if err != nil {
+			return err
+		}
+		if present {
+			if err := h265SkipScalingListData(r); err != nil {
+				return err
+			}
+		}
+	}
+
+	// amp_enabled_flag sample_adaptive_offset_enabled_flag
+	if err := r.skip(2); err != nil {
+		return err
+	}
+
+	pcm, err := r.flag()
+	if err != nil {
+		return err
+	}
+	if pcm {
+		if err := r.skip(8); err != nil {
+			return err
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := r.ue(); err != nil {
+				return err
+			}
+		}
+		if err := r.skip(1); err != nil {
+			return err
+		}
+	}
+
+	numStRps, err := r.ue()
+	if err != nil {
+		return err
+	}
+	numDeltaPocs := make([]int, numStRps)
+	for i := 0; i < int(numStRps); i++ {
+		if numDeltaPocs[i], err = h265SkipStRefPicSet(r, i, numDeltaPocs); err != nil {
+			return err
+		}
+	}
+
+	longTerm, err := r.flag()
+	if err != nil {
+		return err
+	}
+	if longTerm {
+		n, err := r.ue()
+		if err != nil {
+			return err
+		}
+		for i := uint32(0); i < n; i++ {
+			// lt_ref_pic_poc_lsb_sps used_by_curr_pic_lt_sps_flag
+			if err := r.skip(int(log2MaxPocLsbMinus4) + 4 + 1); err != nil {
+				return err
+			}
+		}
+	}
+
+	// sps_temporal_mvp_enabled_flag strong_intra_smoothing_enabled_flag
+	if err := r.skip(2); err != nil {
+		return err
+	}
+
+	vui, err := r.flag()
+	if err != nil {
+		return err
+	}
+	if !vui {
+		return nil
+	}
+	return s.parseVUI(r)
+}
+
+func (s *H265SPS) parseVUI(r *bitReader) error {
+	aspectRatio, err := r.flag()
+	if err != nil {
+		return err
+	}
+	if aspectRatio {
+		idc, err := r.u(8)
+		if err != nil {
+			return err
+		}
+		if idc == 255 {
+			if err := r.skip(32); err != nil {
+				return err
+			}
+		}
+	}
+
+	overscan, err := r.flag()
+	if err != nil {
+		return err
+	}
+	if overscan {
+		if err := r.skip(1); err != nil {
+			return err
+		}
+	}
+
+	videoSignal, err := r.flag()
+	if err != nil {
+		return err
+	}
+	if videoSignal {
+		if err := r.skip(4); err != nil {
+			return err
+		}
+		colour, err := r.flag()
+		if err != nil {
+			return err
+		}
+		if colour {
+			if err := r.skip(24); err != nil {
+				return err
+			}
+		}
+	}
+
+	chromaLoc, err := r.flag()
+	if err != nil {
+		return err
+	}
+	if chromaLoc {
+		for i := 0; i < 2; i++ {
+			if _, err := r.ue(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// neutral_chroma_indication_flag field_seq_flag frame_field_info_present_flag
+	if err := r.skip(3); err != nil {
+		return err
+	}
+
+	displayWindow, err := r.flag()
+	if err != nil {
+		return err
+	}
+	if displayWindow {
+		for i := 0; i < 4; i++ {
+			if _, err := r.ue(); err != nil {
+				return err
+			}
+		}
+	}
+
+	timing, err := r.flag()
+	if err != nil {
+		return err
+	}
+	if timing {
+		if s.NumUnitsInTick, err = r.u(32); err != nil {
+			return err
+		}
+		if s.TimeScale, err = r.u(32); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func h265SkipProfileTierLevel(r *bitReader, maxSubLayersMinus1 int) error {
+	// general profile/tier/flags + general_level_idc
+	if err := r.skip(96); err != nil {
+		return err
+	}
+
+	profilePresent := make([]bool, maxSubLayersMinus1)
+	levelPresent := make([]bool, maxSubLayersMinus1)
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		var err error
+		if profilePresent[i], err = r.flag(); err != nil {
+			return err
+		}
+		if levelPresent[i], err = r.flag(); err != nil {
+			return err
+		}
+	}
+	if maxSubLayersMinus1 > 0 {
+		if err := r.skip(2 * (8 - maxSubLayersMinus1)); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		if profilePresent[i] {
+			if err := r.skip(88); err != nil {
+				return err
+			}
+		}
+		if levelPresent[i] {
+			if err := r.skip(8); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func h265SkipScalingListData(r *bitReader) error {
+	for sizeID := 0; sizeID < 4; sizeID++ {
+		step := 1
+		if sizeID == 3 {
+			step = 3
+		}
+		for matrixID := 0; matrixID < 6; matrixID += step {
+			predMode, err := r.flag()
+			if err != nil {
+				return err
+			}
+			if !predMode {
+				// scaling_list_pred_matrix_id_delta
+				if _, err := r.ue(); err != nil {
+					return err
+				}
+				continue
+			}
+
+			coefNum := 1 << uint(4+(sizeID<<1))
+			if coefNum > 64 {
+				coefNum = 64
+			}
+			if sizeID > 1 {
+				// scaling_list_dc_coef_minus8
+				if _, err := r.se(); err != nil {
+					return err
+				}
+			}
+			for i := 0; i < coefNum; i++ {
+				if _, err := r.se(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// h265SkipStRefPicSet 跳过 st_ref_pic_set，返回该集合的 NumDeltaPocs
+func h265SkipStRefPicSet(r *bitReader, idx int, numDeltaPocs []int) (int, error) {
+	interPrediction := false
+	if idx != 0 {
+		var err error
+		if interPrediction, err = r.flag(); err != nil {
+			return 0, err
+		}
+	}
+
+	if interPrediction {
+		// delta_rps_sign
+		if err := r.skip(1); err != nil {
+			return 0, err
+		}
+		// abs_delta_rps_minus1
+		if _, err := r.ue(); err != nil {
+			return 0, err
+		}
+
+		ret := 0
+		for j := 0; j <= numDeltaPocs[idx-1]; j++ {
+			used, err := r.flag()
+			if err != nil {
+				return 0, err
+			}
+			useDelta := true
+			if !used {
+				if useDelta, err = r.flag(); err != nil {
+					return 0, err
+				}
+			}
+			if used || useDelta {
+				ret++
+			}
+		}
+		return ret, nil
+	}
+
+	negative, err := r.ue()
+	if err != nil {
+		return 0, err
+	}
+	positive, err := r.ue()
+	if err != nil {
+		return 0, err
+	}
+	for i := uint32(0); i < negative+positive; i++ {
+		// delta_poc_minus1 used_by_curr_pic_flag
+		if _, err := r.ue(); err != nil {
+			return 0, err
+		}
+		if err := r.skip(1); err != nil {
+			return 0, err
+		}
+	}
+	return int(negative + positive), nil
+}
@@ -0,0 +1,79 @@
+package codec
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseH265SPS(t *testing.T) {
+	Convey("test h265 sps parse with vui timing", t, func() {
+		w := &bitWriter{}
+		w.u(4, 0) // sps_video_parameter_set_id
+		w.u(3, 0) // sps_max_sub_layers_minus1
+		w.u(1, 1)
+		w.u(32, 0x60000000) // profile_tier_level
+		w.u(32, 0)
+		w.u(32, 0x5d)
+		w.ue(0)   // sps_seq_parameter_set_id
+		w.ue(1)   // chroma_format_idc
+		w.ue(640) // pic_width_in_luma_samples
+		w.ue(360)
+		w.u(1, 0)
+		w.ue(0)
+		w.ue(0)
+		w.ue(4) // log2_max_pic_order_cnt_lsb_minus4
+		w.u(1, 1)
+		w.ue(0)
+		w.ue(0)
+		w.ue(0)
+		for i := 0; i < 6; i++ {
+			w.ue(0)
+		}
+		w.u(1, 0) // scaling_list_enabled_flag
+		w.u(2, 0)
+		w.u(1, 0) // pcm_enabled_flag
+		w.ue(2)   // num_short_term_ref_pic_sets
+		w.ue(1)   // st_ref_pic_set(0)
+		w.ue(0)
+		w.ue(0)
+		w.u(1, 1)
+		w.u(1, 1) // st_ref_pic_set(1) inter_ref_pic_set_prediction_flag
+		w.u(1, 0)
+		w.ue(0)
+		w.u(1, 1)
+		w.u(1, 1)
+		w.u(1, 0) // long_term_ref_pics_present_flag
+		w.u(2, 0)
+		w.u(1, 1) // vui_parameters_present_flag
+		w.u(4, 0)
+		w.u(3, 0)
+		w.u(1, 0)
+		w.u(1, 1) // vui_timing_info_present_flag
+		w.u(32, 1001)
+		w.u(32, 30000)
+
+		sps, err := ParseH265SPS(append([]byte{0x42, 0x01}, w.bytes()...))
+		So(err, ShouldBeNil)
+		So(sps.Width, ShouldEqual, 640)
+		So(sps.Height, ShouldEqual, 360)
+		So(sps.FrameRate(), ShouldAlmostEqual, 29.97, 0.01)
+	})
+}
+
+func TestH265GroupAccessUnits(t *testing.T) {
+	Convey("test h265 access unit grouping", t, func() {
+		nalus := [][]byte{
+			{0x40, 0x01}, {0x42, 0x01}, {0x44, 0x01},
+			{0x26, 0x01, 0xaf}, {0x26, 0x01, 0x20},
+			{0x02, 0x01, 0xd0}, {0x50, 0x01},
+			{0x02, 0x01, 0xd0},
+		}
+		aus := H265GroupAccessUnits(nalus)
+		So(len(aus), ShouldEqual, 3)
+		So(len(aus[0]), ShouldEqual, 5)
+		So(H265IsKeyFrame(aus[0]), ShouldBeTrue)
+		So(len(aus[1]), ShouldEqual, 2)
+		So(H265IsKeyFrame(aus[1]), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,326 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/Lcmasdf/drs/pkg/srtp"
+)
+
+const (
+	defaultListen     = ":8554"
+	defaultTLSListen  = ":322"
+	defaultRtpPortMin = 30000
+	defaultRtpPortMax = 40000
+
+	defaultHLSSegmentDuration = 2
+	defaultHLSWindowSize      = 6
+	defaultHLSIdleTimeout     = 30
+
+	defaultMulticastRange = "239.255.42.0/24"
+	defaultMulticastPort  = 5004
+	defaultMulticastTTL   = 16
+
+	defaultRecordPath            = "recordings/{path}/{start}.mp4"
+	defaultRecordSegmentDuration = 3600
+
+	defaultShutdownTimeout = 10
+
+	defaultAuthTimeout  = 5
+	defaultAuthCacheTTL = 5
+
+	defaultSessionTimeout = 60
+	defaultHookRetries    = 3
+	defaultHookTimeout    = 5
+)
+
+type Config struct {
+	Listen string `json:"listen"`
+
+	// rtsps监听，没有配置证书时不启用
+	TLS TLSConfig `json:"tls"`
+
+	// server_port 分配范围
+	RtpPortMin int `json:"rtp_port_min"`
+	RtpPortMax int `json:"rtp_port_max"`
+
+	// HLS/DASH等HTTP服务的监听地址，为空时不启用
+	HTTPListen string    `json:"http_listen"`
+	HLS        HLSConfig `json:"hls"`
+
+	// 管理接口(/metrics、/api)的监听地址，为空时不启用
+	AdminListen string `json:"admin_listen"`
+
+	Record RecordConfig `json:"record"`
+
+	Multicast MulticastConfig `json:"multicast"`
+
+	Log LogConfig `json:"log"`
+
+	Limits LimitConfig `json:"limits"`
+
+	Auth AuthConfig `json:"auth"`
+
+	// 建立session后超过该时间(s)没有收到请求或数据时断开
+	SessionTimeout int `json:"session_timeout"`
+
+	// 事件通知
+	Hooks []*HookConfig `json:"hooks"`
+
+	// 收到SIGINT/SIGTERM后等待session退出的时间(s)，超时后强制关闭
+	ShutdownTimeout int `json:"shutdown_timeout"`
+
+	Mounts []*MountConfig `json:"mounts"`
+}
+
+// LimitConfig 连接限制，超过限制的连接直接关闭，请求返回503；数量为0表示不限制
+type LimitConfig struct {
+	// 总连接数
+	MaxConnections int `json:"max_connections"`
+	// 每个IP的连接数
+	MaxConnectionsPerIP int `json:"max_connections_per_ip"`
+	// 每个IP的session数，SETUP创建session时检查
+	MaxSessionsPerIP int `json:"max_sessions_per_ip"`
+	// 每个连接每秒的请求数及允许的突发数，突发数为0时取每秒请求数
+	RequestRate  float64 `json:"request_rate"`
+	RequestBurst int     `json:"request_burst"`
+	// CIDR或单个地址，deny优先；allow不为空时只允许其中的地址
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// AuthConfig 外部授权，DESCRIBE、SETUP和ANNOUNCE之前以POST JSON(AuthRequest)调用URL，
+// 2xx允许，401要求客户端提供用户名和密码，其他拒绝(403)；URL为空时不检查
+type AuthConfig struct {
+	URL string `json:"url"`
+	// 请求超时(s)
+	Timeout int `json:"timeout"`
+	// 允许的结果缓存时间(s)，小于0时不缓存
+	CacheTTL int `json:"cache_ttl"`
+}
+
+// HookConfig 一个事件通知目标，URL和Command二选一
+type HookConfig struct {
+	// 为空时通知所有事件
+	Events []string `json:"events"`
+	// POST JSON(Event)，非2xx时重试
+	URL string `json:"url"`
+	// 命令及参数以空格分隔，事件通过DRS_EVENT、DRS_PATH等环境变量传递
+	Command string `json:"command"`
+	// 失败后的重试次数，小于0时不重试
+	Retries int `json:"retries"`
+	// 每次调用的超时(s)
+	Timeout int `json:"timeout"`
+}
+
+// LogConfig 日志输出到标准错误
+type LogConfig struct {
+	// debug、info(默认)、warn或error，debug时输出RTSP请求和响应
+	Level string `json:"level"`
+	// text(默认)或json
+	Format string `json:"format"`
+}
+
+// TLSConfig rtsps的证书，文件修改后自动重新加载
+type TLSConfig struct {
+	Listen string `json:"listen"`
+	// PEM格式的证书和私钥
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+	// 不为空时要求客户端证书，并用该CA校验
+	ClientCA string `json:"client_ca"`
+	// rtsps上的session只允许RTP/SAVP或TCP interleaved，媒体数据不以明文发送
+	RequireSecureTransport bool `json:"require_secure_transport"`
+}
+
+// HLSConfig HLS和DASH共用的分片配置
+type HLSConfig struct {
+	// 分片目标时长(秒)，实际在关键帧处切分
+	SegmentDuration int `json:"segment_duration"`
+	// playlist中的分片数量
+	WindowSize int `json:"window_size"`
+	// 超过该时间(秒)没有请求playlist/manifest时停止转换
+	IdleTimeout int `json:"idle_timeout"`
+}
+
+// RecordConfig 录制为fMP4文件，满足任一条件时在下一个关键帧切分文件
+type RecordConfig struct {
+	// 文件名模板，{path}为挂载路径，{start}为文件开始时间
+	Path string `json:"path"`
+	// 单个文件的最大时长(秒)
+	SegmentDuration int `json:"segment_duration"`
+	// 单个文件的最大字节数，0表示不限制
+	SegmentSize int64 `json:"segment_size"`
+}
+
+// MulticastConfig 组播地址池，每个开启组播的挂载路径分配一个组播地址
+type MulticastConfig struct {
+	// 组播地址范围，如239.255.42.0/24
+	Range string `json:"range"`
+	// 第一个track的RTP端口，第n个track使用Port+2n
+	Port int `json:"port"`
+	TTL  int `json:"ttl"`
+}
+
+// MountConfig 将一个source挂载到rtsp路径上
+type MountConfig struct {
+	// rtsp://host:port/<path>
+	Path string `json:"path"`
+	// 文件路径，类型由扩展名决定；rtsp://或rtsps://开头时从远端拉流；为空时由客户端ANNOUNCE/RECORD推流
+	Source string `json:"source"`
+
+	// 裸流文件的播放帧率，0表示使用SPS中的帧率
+	FrameRate float64 `json:"frame_rate"`
+	// 音频文件每个RTP包的时长(ms)，0表示20ms
+	PTime int `json:"ptime"`
+
+	// 启动时开始录制
+	Record bool `json:"record"`
+
+	// 拉流的传输方式，udp(默认)或tcp
+	PullTransport string `json:"pull_transport"`
+	// 启动时就连接远端并保持连接，否则在第一个客户端请求时连接、最后一个离开时断开
+	PullAlways bool `json:"pull_always"`
+
+	// 转推到其他rtsp服务
+	Push []*PushConfig `json:"push"`
+
+	// 允许客户端使用组播，所有组播客户端共用一份数据，点播不支持
+	Multicast bool `json:"multicast"`
+
+	// 只允许RTP/SAVP，播放的密钥通过DESCRIBE的a=crypto下发，推流的密钥取自ANNOUNCE；不支持组播
+	SRTP bool `json:"srtp"`
+	// AES_CM_128_HMAC_SHA1_80(默认)或AES_CM_128_HMAC_SHA1_32
+	SRTPSuite string `json:"srtp_suite"`
+
+	// 视频使用RTP/AVPF，viewer可以通过NACK请求RTX重传、通过PLI/FIR向推流端请求关键帧；不支持组播
+	AVPF bool `json:"avpf"`
+
+	// 推流和拉流的jitter buffer延迟(ms)，按序号重排，超时未到的包认为丢失；0表示不使用
+	JitterBuffer int `json:"jitter_buffer"`
+
+	// 在全局的限制之外，只允许这些地址访问该挂载路径，格式同LimitConfig
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// PushConfig 作为推流客户端(ANNOUNCE/RECORD)将挂载路径转推到远端，失败后重试
+type PushConfig struct {
+	// rtsp://[user:password@]host[:port]/path
+	URL string `json:"url"`
+	// tcp(默认)或udp
+	Transport string `json:"transport"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &Config{}
+	if err := json.Unmarshal(data, ret); err != nil {
+		return nil, err
+	}
+	ret.setDefault()
+	if err := ret.validate(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// validate 检查时间参数、地址列表和hook，在setDefault之后调用
+func (c *Config) validate() error {
+	if c.SessionTimeout < 0 {
+		return fmt.Errorf("invalid session_timeout %d", c.SessionTimeout)
+	}
+	if c.HLS.SegmentDuration < 0 {
+		return fmt.Errorf("invalid hls.segment_duration %d", c.HLS.SegmentDuration)
+	}
+	if c.HLS.WindowSize < 0 {
+		return fmt.Errorf("invalid hls.window_size %d", c.HLS.WindowSize)
+	}
+	if c.HLS.IdleTimeout < 0 {
+		return fmt.Errorf("invalid hls.idle_timeout %d", c.HLS.IdleTimeout)
+	}
+	for _, h := range c.Hooks {
+		if _, err := newHookSink(h); err != nil {
+			return err
+		}
+	}
+	if _, err := newIPFilter(c.Limits.Allow, c.Limits.Deny); err != nil {
+		return err
+	}
+	for _, m := range c.Mounts {
+		if _, err := newIPFilter(m.Allow, m.Deny); err != nil {
+			return fmt.Errorf("mount %s: %v", m.Path, err)
+		}
+	}
+	return nil
+}
+
+func (c *Config) setDefault() {
+	if c.Listen == "" {
+		c.Listen = defaultListen
+	}
+	if c.TLS.Listen == "" {
+		c.TLS.Listen = defaultTLSListen
+	}
+	if c.RtpPortMin == 0 {
+		c.RtpPortMin = defaultRtpPortMin
+	}
+	if c.RtpPortMax == 0 {
+		c.RtpPortMax = defaultRtpPortMax
+	}
+	if c.HLS.SegmentDuration == 0 {
+		c.HLS.SegmentDuration = defaultHLSSegmentDuration
+	}
+	if c.HLS.WindowSize == 0 {
+		c.HLS.WindowSize = defaultHLSWindowSize
+	}
+	if c.HLS.IdleTimeout == 0 {
+		c.HLS.IdleTimeout = defaultHLSIdleTimeout
+	}
+	if c.Record.Path == "" {
+		c.Record.Path = defaultRecordPath
+	}
+	if c.Record.SegmentDuration == 0 {
+		c.Record.SegmentDuration = defaultRecordSegmentDuration
+	}
+	if c.Multicast.Range == "" {
+		c.Multicast.Range = defaultMulticastRange
+	}
+	if c.Multicast.Port == 0 {
+		c.Multicast.Port = defaultMulticastPort
+	}
+	if c.Multicast.TTL == 0 {
+		c.Multicast.TTL = defaultMulticastTTL
+	}
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = defaultShutdownTimeout
+	}
+	if c.Auth.Timeout == 0 {
+		c.Auth.Timeout = defaultAuthTimeout
+	}
+	if c.Auth.CacheTTL == 0 {
+		c.Auth.CacheTTL = defaultAuthCacheTTL
+	}
+	if c.SessionTimeout == 0 {
+		c.SessionTimeout = defaultSessionTimeout
+	}
+	for _, h := range c.Hooks {
+		if h.Retries == 0 {
+			h.Retries = defaultHookRetries
+		}
+		if h.Timeout == 0 {
+			h.Timeout = defaultHookTimeout
+		}
+	}
+	for _, m := range c.Mounts {
+		m.Path = normalizePath(m.Path)
+		if m.SRTP && m.SRTPSuite == "" {
+			m.SRTPSuite = srtp.SuiteSHA1_80
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+const dashManifestName = "manifest.mpd"
+
+// dashDuration xs:duration
+func dashDuration(d time.Duration) string {
+	return fmt.Sprintf("PT%.3fS", d.Seconds())
+}
+
+// genMPD 需持有锁，每个track一个AdaptationSet，使用SegmentTimeline记录GOP对齐的分片时长
+func (c *segmentCache) genMPD() []byte {
+	segments := c.window()
+	segmentDuration := time.Duration(c.conf.SegmentDuration) * time.Second
+
+	var total time.Duration
+	for _, s := range segments {
+		total += s.duration
+	}
+
+	b := &bytes.Buffer{}
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(b, `<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" profiles="urn:mpeg:dash:profile:isoff-live:2011,urn:mpeg:dash:profile:cmaf:2019" type="dynamic" availabilityStartTime="%s" publishTime="%s" minimumUpdatePeriod="%s" minBufferTime="%s" timeShiftBufferDepth="%s" suggestedPresentationDelay="%s">`+"\n",
+		c.startTime.UTC().Format(time.RFC3339),
+		time.Now().UTC().Format(time.RFC3339),
+		dashDuration(segmentDuration),
+		dashDuration(segmentDuration),
+		dashDuration(total),
+		dashDuration(2*segmentDuration))
+	b.WriteString(`  <Period id="0" start="PT0S">` + "\n")
+
+	for _, t := range c.muxer.fmp4.Tracks() {
+		contentType := "audio"
+		if t.IsVideo() {
+			contentType = "video"
+		}
+
+		var size int
+		var duration uint64
+		for _, s := range segments {
+			ts := s.track(t.ID)
+			size += len(ts.data)
+			duration += ts.duration
+		}
+		bandwidth := 1
+		if duration > 0 {
+			bandwidth = int(uint64(size) * 8 * uint64(t.TimeScale) / duration)
+		}
+
+		fmt.Fprintf(b, `    <AdaptationSet id="%d" contentType="%s" mimeType="%s/mp4" segmentAlignment="true" startWithSAP="1">`+"\n",
+			t.ID, contentType, contentType)
+		if t.IsVideo() {
+			fmt.Fprintf(b, `      <Representation id="%d" codecs="%s" bandwidth="%d" width="%d" height="%d">`+"\n",
+				t.ID, t.CodecString(), bandwidth, t.Width, t.Height)
+		} else {
+			fmt.Fprintf(b, `      <Representation id="%d" codecs="%s" bandwidth="%d" audioSamplingRate="%d">`+"\n",
+				t.ID, t.CodecString(), bandwidth, t.SampleRate)
+			fmt.Fprintf(b, `        <AudioChannelConfiguration schemeIdUri="urn:mpeg:dash:23003:3:audio_channel_configuration:2011" value="%d"/>`+"\n",
+				t.Channels)
+		}
+		fmt.Fprintf(b, `        <SegmentTemplate timescale="%d" initialization="init-$RepresentationID$.mp4" media="seg-$RepresentationID$-$Number$.m4s" startNumber="%d">`+"\n",
+			t.TimeScale, segments[0].seq)
+		b.WriteString("          <SegmentTimeline>\n")
+		for _, s := range segments {
+			ts := s.track(t.ID)
+			fmt.Fprintf(b, `            <S t="%d" d="%d"/>`+"\n", ts.baseTime, ts.duration)
+		}
+		b.WriteString("          </SegmentTimeline>\n")
+		b.WriteString("        </SegmentTemplate>\n")
+		b.WriteString("      </Representation>\n")
+		b.WriteString("    </AdaptationSet>\n")
+	}
+
+	b.WriteString("  </Period>\n")
+	b.WriteString("</MPD>\n")
+	return b.Bytes()
+}
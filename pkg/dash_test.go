@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDASH(t *testing.T) {
+	Convey("test dash manifest and segments", t, func() {
+		srv := &Server{Config: &Config{
+			HLS:    HLSConfig{SegmentDuration: 1, WindowSize: 3},
+			Mounts: []*MountConfig{{Path: "/live"}},
+		}}
+		srv.init()
+		stream, _, _ := srv.findStream("/live")
+		src := newFakeSource()
+		stream.source = src
+		stream.sdp = src.SDP()
+
+		ts := httptest.NewServer(srv.http)
+		defer ts.Close()
+
+		get := func(path string) (int, string) {
+			resp, err := http.Get(ts.URL + path)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			return resp.StatusCode, string(body)
+		}
+
+		code, body := get("/live/manifest.mpd")
+		So(code, ShouldEqual, http.StatusOK)
+		So(body, ShouldContainSubstring, `type="dynamic"`)
+		So(body, ShouldContainSubstring, `availabilityStartTime="`)
+		So(body, ShouldContainSubstring, `timeShiftBufferDepth="PT`)
+		So(body, ShouldContainSubstring, `media="seg-$RepresentationID$-$Number$.m4s"`)
+		So(body, ShouldContainSubstring, `<Representation id="1" codecs="avc1.`)
+		So(body, ShouldContainSubstring, `startNumber="0"`)
+		So(body, ShouldContainSubstring, `<S t="0" d="`)
+
+		code, body = get("/live/init-1.mp4")
+		So(code, ShouldEqual, http.StatusOK)
+		So(body[4:8], ShouldEqual, "ftyp")
+		code, _ = get("/live/init-9.mp4")
+		So(code, ShouldEqual, http.StatusNotFound)
+
+		code, body = get("/live/seg-1-0.m4s")
+		So(code, ShouldEqual, http.StatusOK)
+		So(body[4:8], ShouldEqual, "styp")
+		So(strings.Contains(body, "moof"), ShouldBeTrue)
+		code, _ = get("/live/seg-1-99.m4s")
+		So(code, ShouldEqual, http.StatusNotFound)
+
+		// HLS和DASH共用一个cache
+		code, _ = get("/live/index.m3u8")
+		So(code, ShouldEqual, http.StatusOK)
+		srv.segments.mu.Lock()
+		So(len(srv.segments.caches), ShouldEqual, 1)
+		srv.segments.mu.Unlock()
+		stream.mu.Lock()
+		So(len(stream.readers), ShouldEqual, 1)
+		stream.mu.Unlock()
+	})
+}
@@ -0,0 +1,70 @@
+package fmp4
+
+import "encoding/binary"
+
+// ISO/IEC 14496-12 box
+
+// boxWriter 按大端序写入box内容
+type boxWriter struct {
+	buf []byte
+}
+
+func (w *boxWriter) u8(v uint8) {
+	w.buf = append(w.buf, v)
+}
+
+func (w *boxWriter) u16(v uint16) {
+	w.buf = append(w.buf, byte(v>>8), byte(v))
+}
+
+func (w *boxWriter) u24(v uint32) {
+	w.buf = append(w.buf, byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *boxWriter) u32(v uint32) {
+	w.buf = append(w.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *boxWriter) u64(v uint64) {
+	w.u32(uint32(v >> 32))
+	w.u32(uint32(v))
+}
+
+func (w *boxWriter) zeros(n int) {
+	w.buf = append(w.buf, make([]byte, n)...)
+}
+
+func (w *boxWriter) write(b ...[]byte) {
+	for _, v := range b {
+		w.buf = append(w.buf, v...)
+	}
+}
+
+// genBox size(4) + type(4) + payload
+func genBox(typ string, payloads ...[]byte) []byte {
+	size := 8
+	for _, p := range payloads {
+		size += len(p)
+	}
+
+	ret := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(ret, uint32(size))
+	copy(ret[4:], typ)
+	for _, p := range payloads {
+		ret = append(ret, p...)
+	}
+	return ret
+}
+
+// genFullBox 带 version(1) + flags(3) 的box
+func genFullBox(typ string, version uint8, flags uint32, payloads ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return genBox(typ, append([][]byte{header}, payloads...)...)
+}
+
+// 单位矩阵
+func writeMatrix(w *boxWriter) {
+	for _, v := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		w.u32(v)
+	}
+}
@@ -0,0 +1,83 @@
+package fmp4
+
+// sample_flags
+const (
+	sampleFlagsKeyFrame    = 0x02000000
+	sampleFlagsNonKeyFrame = 0x01010000
+)
+
+// trun flags
+const (
+	trunDataOffset        = 0x000001
+	trunSampleDuration    = 0x000100
+	trunSampleSize        = 0x000200
+	trunSampleFlags       = 0x000400
+	trunCompositionOffset = 0x000800
+)
+
+type Sample struct {
+	// 单位为track的timescale
+	Duration          uint32
+	CompositionOffset int32
+	KeyFrame          bool
+	Data              []byte
+}
+
+type TrackFragment struct {
+	TrackID        int
+	BaseDecodeTime uint64
+	Samples        []*Sample
+}
+
+// GenFragment 生成 moof + mdat，seq从1开始
+func GenFragment(seq uint32, trafs []*TrackFragment) []byte {
+	// moof 的大小与data_offset的值无关，先计算moof大小
+	moof := genMoof(seq, trafs, 0)
+	moof = genMoof(seq, trafs, len(moof)+8)
+
+	mdat := make([][]byte, 0)
+	for _, traf := range trafs {
+		for _, s := range traf.Samples {
+			mdat = append(mdat, s.Data)
+		}
+	}
+	return append(moof, genBox("mdat", mdat...)...)
+}
+
+// genMoof dataOffset为第一个sample相对moof起始位置的偏移
+func genMoof(seq uint32, trafs []*TrackFragment, dataOffset int) []byte {
+	w := &boxWriter{}
+	w.u32(seq)
+	boxes := [][]byte{genFullBox("mfhd", 0, 0, w.buf)}
+
+	for _, traf := range trafs {
+		// tfhd default-base-is-moof
+		w := &boxWriter{}
+		w.u32(uint32(traf.TrackID))
+		tfhd := genFullBox("tfhd", 0, 0x020000, w.buf)
+
+		w = &boxWriter{}
+		w.u64(traf.BaseDecodeTime)
+		tfdt := genFullBox("tfdt", 1, 0, w.buf)
+
+		w = &boxWriter{}
+		w.u32(uint32(len(traf.Samples)))
+		w.u32(uint32(dataOffset))
+		for _, s := range traf.Samples {
+			w.u32(s.Duration)
+			w.u32(uint32(len(s.Data)))
+			if s.KeyFrame {
+				w.u32(sampleFlagsKeyFrame)
+			} else {
+				w.u32(sampleFlagsNonKeyFrame)
+			}
+			w.u32(uint32(s.CompositionOffset))
+			dataOffset += len(s.Data)
+		}
+		// version 1 的composition offset为有符号数
+		trun := genFullBox("trun", 1, trunDataOffset|trunSampleDuration|trunSampleSize|trunSampleFlags|trunCompositionOffset, w.buf)
+
+		boxes = append(boxes, genBox("traf", tfhd, tfdt, trun))
+	}
+	return genBox("moof", boxes...)
+}
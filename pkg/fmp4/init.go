@@ -0,0 +1,299 @@
+package fmp4
+
+import "fmt"
+
+const (
+	CodecH264 = "H264"
+	CodecH265 = "H265"
+	CodecAAC  = "AAC"
+	CodecOpus = "Opus"
+)
+
+// mvhd 的timescale
+const movieTimeScale = 1000
+
+type Track struct {
+	// 从1开始
+	ID        int
+	TimeScale uint32
+	Codec     string
+
+	// 视频
+	Width  int
+	Height int
+
+	// 音频
+	SampleRate int
+	Channels   int
+
+	// H264: avcC, H265: hvcC, AAC: AudioSpecificConfig
+	Config []byte
+}
+
+func (t *Track) IsVideo() bool {
+	return t.Codec == CodecH264 || t.Codec == CodecH265
+}
+
+// GenInit 生成初始化段 ftyp + moov
+func GenInit(tracks []*Track) ([]byte, error) {
+	w := &boxWriter{}
+	w.write([]byte("iso5"))
+	w.u32(512)
+	w.write([]byte("iso5"), []byte("iso6"), []byte("mp41"), []byte("cmfc"))
+	ftyp := genBox("ftyp", w.buf)
+
+	moov := [][]byte{genMvhd(len(tracks) + 1)}
+	trexs := make([][]byte, 0, len(tracks))
+	for _, t := range tracks {
+		trak, err := genTrak(t)
+		if err != nil {
+			return nil, err
+		}
+		moov = append(moov, trak)
+
+		w := &boxWriter{}
+		w.u32(uint32(t.ID))
+		// default_sample_description_index
+		w.u32(1)
+		w.zeros(12)
+		trexs = append(trexs, genFullBox("trex", 0, 0, w.buf))
+	}
+	moov = append(moov, genBox("mvex", trexs...))
+
+	return append(ftyp, genBox("moov", moov...)...), nil
+}
+
+// GenStyp CMAF分片开头的styp
+func GenStyp() []byte {
+	w := &boxWriter{}
+	w.write([]byte("msdh"))
+	w.u32(0)
+	w.write([]byte("msdh"), []byte("msix"), []byte("cmfs"))
+	return genBox("styp", w.buf)
+}
+
+// CodecString RFC6381 codecs参数，用于DASH/HLS
+func (t *Track) CodecString() string {
+	switch t.Codec {
+	case CodecH264:
+		if len(t.Config) >= 4 {
+			return fmt.Sprintf("avc1.%02x%02x%02x", t.Config[1], t.Config[2], t.Config[3])
+		}
+		return "avc1"
+	case CodecH265:
+		return hevcCodecString(t.Config)
+	case CodecAAC:
+		if len(t.Config) >= 1 {
+			return fmt.Sprintf("mp4a.40.%d", t.Config[0]>>3)
+		}
+		return "mp4a.40.2"
+	case CodecOpus:
+		return "opus"
+	}
+	return ""
+}
+
+// hevcCodecString ISO/IEC 14496-15 E.3
+func hevcCodecString(hvcC []byte) string {
+	if len(hvcC) < 13 {
+		return "hvc1"
+	}
+
+	profileSpace := []string{"", "A", "B", "C"}[hvcC[1]>>6]
+	tier := "L"
+	if hvcC[1]&0x20 != 0 {
+		tier = "H"
+	}
+	profile := hvcC[1] & 0x1f
+
+	// compatibility flags 按bit反转
+	flags := uint32(hvcC[2])<<24 | uint32(hvcC[3])<<16 | uint32(hvcC[4])<<8 | uint32(hvcC[5])
+	var reversed uint32
+	for i := 0; i < 32; i++ {
+		reversed = reversed<<1 | flags>>uint(i)&1
+	}
+
+	ret := fmt.Sprintf("hvc1.%s%d.%X.%s%d", profileSpace, profile, reversed, tier, hvcC[12])
+	// constraint flags 去掉结尾的0
+	constraints := hvcC[6:12]
+	n := len(constraints)
+	for n > 0 && constraints[n-1] == 0 {
+		n--
+	}
+	for _, b := range constraints[:n] {
+		ret += fmt.Sprintf(".%X", b)
+	}
+	return ret
+}
+
+func genMvhd(nextTrackID int) []byte {
+	w := &boxWriter{}
+	// creation_time, modification_time
+	w.zeros(8)
+	w.u32(movieTimeScale)
+	// duration 由fragment决定
+	w.u32(0)
+	w.u32(0x00010000)
+	w.u16(0x0100)
+	w.zeros(10)
+	writeMatrix(w)
+	w.zeros(24)
+	w.u32(uint32(nextTrackID))
+	return genFullBox("mvhd", 0, 0, w.buf)
+}
+
+func genTrak(t *Track) ([]byte, error) {
+	stsd, err := genStsd(t)
+	if err != nil {
+		return nil, err
+	}
+
+	// tkhd flags: track_enabled | track_in_movie
+	w := &boxWriter{}
+	w.zeros(8)
+	w.u32(uint32(t.ID))
+	w.zeros(4)
+	w.u32(0)
+	w.zeros(8)
+	// layer, alternate_group
+	w.zeros(4)
+	if t.IsVideo() {
+		w.u16(0)
+	} else {
+		w.u16(0x0100)
+	}
+	w.zeros(2)
+	writeMatrix(w)
+	w.u32(uint32(t.Width) << 16)
+	w.u32(uint32(t.Height) << 16)
+	tkhd := genFullBox("tkhd", 0, 3, w.buf)
+
+	w = &boxWriter{}
+	w.zeros(8)
+	w.u32(t.TimeScale)
+	w.u32(0)
+	// language und
+	w.u16(0x55c4)
+	w.u16(0)
+	mdhd := genFullBox("mdhd", 0, 0, w.buf)
+
+	handler, name, mhd := "soun", "SoundHandler", genFullBox("smhd", 0, 0, make([]byte, 4))
+	if t.IsVideo() {
+		handler, name, mhd = "vide", "VideoHandler", genFullBox("vmhd", 0, 1, make([]byte, 8))
+	}
+	w = &boxWriter{}
+	w.u32(0)
+	w.write([]byte(handler))
+	w.zeros(12)
+	w.write([]byte(name), []byte{0})
+	hdlr := genBox("hdlr", []byte{0, 0, 0, 0}, w.buf)
+
+	dinf := genBox("dinf", genFullBox("dref", 0, 0, []byte{0, 0, 0, 1}, genFullBox("url ", 0, 1)))
+
+	// fragment 中的sample不写入stbl
+	empty := []byte{0, 0, 0, 0}
+	stbl := genBox("stbl",
+		stsd,
+		genFullBox("stts", 0, 0, empty),
+		genFullBox("stsc", 0, 0, empty),
+		genFullBox("stsz", 0, 0, empty, empty),
+		genFullBox("stco", 0, 0, empty))
+
+	minf := genBox("minf", mhd, dinf, stbl)
+	mdia := genBox("mdia", mdhd, hdlr, minf)
+	return genBox("trak", tkhd, mdia), nil
+}
+
+func genStsd(t *Track) ([]byte, error) {
+	var entry []byte
+	switch t.Codec {
+	case CodecH264:
+		entry = genVisualSampleEntry("avc1", t, genBox("avcC", t.Config))
+	case CodecH265:
+		entry = genVisualSampleEntry("hvc1", t, genBox("hvcC", t.Config))
+	case CodecAAC:
+		entry = genAudioSampleEntry("mp4a", t, genEsds(t))
+	case CodecOpus:
+		entry = genAudioSampleEntry("Opus", t, genDOps(t))
+	default:
+		return nil, fmt.Errorf("unsupported codec %s", t.Codec)
+	}
+	return genFullBox("stsd", 0, 0, []byte{0, 0, 0, 1}, entry), nil
+}
+
+func genVisualSampleEntry(typ string, t *Track, config []byte) []byte {
+	w := &boxWriter{}
+	w.zeros(6)
+	// data_reference_index
+	w.u16(1)
+	w.zeros(16)
+	w.u16(uint16(t.Width))
+	w.u16(uint16(t.Height))
+	// 72 dpi
+	w.u32(0x00480000)
+	w.u32(0x00480000)
+	w.zeros(4)
+	// frame_count
+	w.u16(1)
+	// compressorname
+	w.zeros(32)
+	w.u16(0x0018)
+	w.u16(0xffff)
+	return genBox(typ, w.buf, config)
+}
+
+func genAudioSampleEntry(typ string, t *Track, config []byte) []byte {
+	w := &boxWriter{}
+	w.zeros(6)
+	w.u16(1)
+	w.zeros(8)
+	w.u16(uint16(t.Channels))
+	w.u16(16)
+	w.zeros(4)
+	w.u32(uint32(t.SampleRate) << 16)
+	return genBox(typ, w.buf, config)
+}
+
+// genEsds ISO/IEC 14496-1 ES_Descriptor
+func genEsds(t *Track) []byte {
+	descriptor := func(tag byte, payloads ...[]byte) []byte {
+		size := 0
+		for _, p := range payloads {
+			size += len(p)
+		}
+		// 长度固定使用4字节编码
+		ret := []byte{tag, 0x80 | byte(size>>21), 0x80 | byte(size>>14), 0x80 | byte(size>>7), byte(size & 0x7f)}
+		for _, p := range payloads {
+			ret = append(ret, p...)
+		}
+		return ret
+	}
+
+	w := &boxWriter{}
+	// objectTypeIndication: Audio ISO/IEC 14496-3
+	w.u8(0x40)
+	// streamType audio
+	w.u8(0x15)
+	w.u24(0)
+	w.u32(0)
+	w.u32(0)
+	decoderConfig := descriptor(0x04, w.buf, descriptor(0x05, t.Config))
+
+	es := descriptor(0x03, []byte{0, byte(t.ID), 0}, decoderConfig, descriptor(0x06, []byte{0x02}))
+	return genFullBox("esds", 0, 0, es)
+}
+
+// genDOps Encapsulation of Opus in ISO Base Media File Format
+func genDOps(t *Track) []byte {
+	w := &boxWriter{}
+	w.u8(0)
+	w.u8(uint8(t.Channels))
+	// PreSkip
+	w.u16(0)
+	w.u32(uint32(t.SampleRate))
+	// OutputGain
+	w.u16(0)
+	// ChannelMappingFamily
+	w.u8(0)
+	return genBox("dOps", w.buf)
+}
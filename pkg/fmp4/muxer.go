@@ -0,0 +1,351 @@
+package fmp4
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/codec"
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+)
+
+// 没有视频时每个fragment的时长
+const audioFragmentDuration = time.Second
+
+// Fragment 一个 moof + mdat
+type Fragment struct {
+	Data []byte
+	// 相对muxer开始的时间
+	Start    time.Duration
+	Duration time.Duration
+	// 以视频关键帧开始，可以作为文件或分片的开始
+	KeyFrame bool
+
+	// 生成Data的参数，用于按track单独输出(DASH)
+	Seq    uint32
+	Tracks []*TrackFragment
+}
+
+type muxTrack struct {
+	*Track
+	depacketizer rtp.Depacketizer
+
+	started      bool
+	lastTS       uint32
+	lastDuration uint32
+	// 等待下一帧确定时长的sample
+	pending *Sample
+	// samples 中第一个sample的decode time
+	baseTime uint64
+	samples  []*Sample
+}
+
+func (t *muxTrack) samplesDuration() uint64 {
+	var ret uint64
+	for _, s := range t.samples {
+		ret += uint64(s.Duration)
+	}
+	return ret
+}
+
+// Muxer 将RTP包转换为fMP4，视频每个GOP生成一个fragment
+// 时间戳直接使用RTP timestamp，不支持B帧
+type Muxer struct {
+	// stream track index -> track
+	tracks map[int]*muxTrack
+	order  []*muxTrack
+	// 决定fragment边界的track，有视频时为视频
+	primary *muxTrack
+	init    []byte
+
+	started bool
+	start   time.Time
+	seq     uint32
+}
+
+// NewMuxer 根据sdp创建muxer，不支持的track会被忽略
+func NewMuxer(s *sdp.SDPImpl) (*Muxer, error) {
+	ret := &Muxer{
+		tracks: make(map[int]*muxTrack),
+	}
+
+	for i, m := range s.Ms {
+		t, err := newMuxTrack(len(ret.order)+1, m)
+		if err != nil {
+			return nil, fmt.Errorf("track %d: %s", i, err.Error())
+		}
+		if t == nil {
+			continue
+		}
+		ret.tracks[i] = t
+		ret.order = append(ret.order, t)
+		if ret.primary == nil || (t.IsVideo() && !ret.primary.IsVideo()) {
+			ret.primary = t
+		}
+	}
+	if len(ret.order) == 0 {
+		return nil, fmt.Errorf("no supported track")
+	}
+
+	tracks := make([]*Track, 0, len(ret.order))
+	for _, t := range ret.order {
+		tracks = append(tracks, t.Track)
+	}
+	init, err := GenInit(tracks)
+	if err != nil {
+		return nil, err
+	}
+	ret.init = init
+	return ret, nil
+}
+
+func newMuxTrack(id int, m *sdp.Media) (*muxTrack, error) {
+	rtpmaps, err := m.GetRtpmaps()
+	if err != nil || len(rtpmaps) == 0 {
+		return nil, nil
+	}
+	rtpmap := rtpmaps[0]
+
+	params := make(map[string]string)
+	fmtps, err := m.GetFmtps()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fmtps {
+		if f.PayloadType == rtpmap.PayloadType {
+			params = f.Params
+		}
+	}
+
+	ret := &muxTrack{
+		Track: &Track{
+			ID:        id,
+			TimeScale: uint32(rtpmap.ClockRate),
+		},
+	}
+
+	switch strings.ToUpper(rtpmap.EncodingName) {
+	case "H264":
+		sets, err := decodeBase64List(params["sprop-parameter-sets"])
+		if err != nil || len(sets) < 2 {
+			return nil, fmt.Errorf("invalid sprop-parameter-sets")
+		}
+		ret.Codec = CodecH264
+		if ret.Config, err = codec.GenAVCDecoderConfig(sets[:1], sets[1:]); err != nil {
+			return nil, err
+		}
+		if sps, err := codec.ParseH264SPS(sets[0]); err == nil {
+			ret.Width, ret.Height = sps.Width, sps.Height
+		}
+		ret.depacketizer = &rtp.H264Depacketizer{}
+	case "H265":
+		vps, err1 := decodeBase64List(params["sprop-vps"])
+		sps, err2 := decodeBase64List(params["sprop-sps"])
+		pps, err3 := decodeBase64List(params["sprop-pps"])
+		if err1 != nil || err2 != nil || err3 != nil {
+			return nil, fmt.Errorf("invalid sprop-vps/sps/pps")
+		}
+		ret.Codec = CodecH265
+		if ret.Config, err = codec.GenHEVCDecoderConfig(vps, sps, pps); err != nil {
+			return nil, err
+		}
+		if s, err := codec.ParseH265SPS(sps[0]); err == nil {
+			ret.Width, ret.Height = s.Width, s.Height
+		}
+		ret.depacketizer = &rtp.H265Depacketizer{}
+	case "MPEG4-GENERIC":
+		if !strings.EqualFold(params["mode"], "AAC-hbr") {
+			return nil, fmt.Errorf("unsupported aac mode %s", params["mode"])
+		}
+		config, err := hex.DecodeString(params["config"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid aac config")
+		}
+		conf, err := codec.ParseAACConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		ret.Codec = CodecAAC
+		ret.Config = config
+		ret.SampleRate = conf.SampleRate
+		ret.Channels = conf.Channels
+		ret.depacketizer = &rtp.AACDepacketizer{}
+	case "OPUS":
+		ret.Codec = CodecOpus
+		ret.SampleRate = rtpmap.ClockRate
+		ret.Channels = 2
+		if params["sprop-stereo"] != "1" {
+			ret.Channels = 1
+		}
+		ret.depacketizer = &rtp.RawDepacketizer{}
+	default:
+		return nil, nil
+	}
+	return ret, nil
+}
+
+func decodeBase64List(s string) ([][]byte, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty value")
+	}
+
+	ret := make([][]byte, 0)
+	for _, part := range strings.Split(s, ",") {
+		b, err := base64.StdEncoding.DecodeString(part)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, b)
+	}
+	return ret, nil
+}
+
+// Init 初始化段 ftyp + moov
+func (m *Muxer) Init() []byte {
+	return m.init
+}
+
+func (m *Muxer) Tracks() []*Track {
+	ret := make([]*Track, 0, len(m.order))
+	for _, t := range m.order {
+		ret = append(ret, t.Track)
+	}
+	return ret
+}
+
+// WritePacket 写入stream中track的RTP包，返回完成的fragment
+func (m *Muxer) WritePacket(track int, pkt *rtp.Packet) ([]*Fragment, error) {
+	t, ok := m.tracks[track]
+	if !ok {
+		return nil, nil
+	}
+
+	frames, err := t.depacketizer.Depacketize(pkt)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*Fragment
+	for _, f := range frames {
+		if frag := m.writeFrame(t, f); frag != nil {
+			ret = append(ret, frag)
+		}
+	}
+	return ret, nil
+}
+
+func (m *Muxer) writeFrame(t *muxTrack, f *rtp.Frame) *Fragment {
+	sample := &Sample{KeyFrame: true}
+	switch t.Codec {
+	case CodecH264:
+		sample.KeyFrame = codec.H264IsKeyFrame(f.Units)
+		sample.Data = codec.JoinAVCC(f.Units)
+	case CodecH265:
+		sample.KeyFrame = codec.H265IsKeyFrame(f.Units)
+		sample.Data = codec.JoinAVCC(f.Units)
+	default:
+		if len(f.Units) == 0 {
+			return nil
+		}
+		sample.Data = f.Units[0]
+	}
+
+	// 从视频关键帧开始
+	if !m.started {
+		if t != m.primary || !sample.KeyFrame {
+			return nil
+		}
+		m.started = true
+		m.start = time.Now()
+	}
+
+	if !t.started {
+		// 各track按到达时间对齐
+		t.started = true
+		t.baseTime = uint64(time.Since(m.start)) * uint64(t.TimeScale) / uint64(time.Second)
+		t.lastTS = f.Timestamp
+		t.pending = sample
+		return nil
+	}
+
+	delta := int32(f.Timestamp - t.lastTS)
+	if delta <= 0 {
+		delta = int32(t.lastDuration)
+		if delta == 0 {
+			delta = 1
+		}
+	}
+	t.pending.Duration = uint32(delta)
+	t.samples = append(t.samples, t.pending)
+	t.lastDuration = uint32(delta)
+	t.lastTS = f.Timestamp
+	t.pending = sample
+
+	var ret *Fragment
+	if t == m.primary {
+		if t.IsVideo() && sample.KeyFrame {
+			ret = m.fragment()
+		} else if !t.IsVideo() && t.samplesDuration() >= uint64(audioFragmentDuration)*uint64(t.TimeScale)/uint64(time.Second) {
+			ret = m.fragment()
+		}
+	}
+	return ret
+}
+
+// Flush 输出剩余的sample，最后一个sample使用上一个sample的时长
+func (m *Muxer) Flush() *Fragment {
+	for _, t := range m.order {
+		if t.pending == nil {
+			continue
+		}
+		t.pending.Duration = t.lastDuration
+		if t.pending.Duration == 0 {
+			t.pending.Duration = 1
+		}
+		t.samples = append(t.samples, t.pending)
+		t.pending = nil
+	}
+	return m.fragment()
+}
+
+func (m *Muxer) fragment() *Fragment {
+	p := m.primary
+	if len(p.samples) == 0 {
+		return nil
+	}
+
+	ret := &Fragment{
+		Start:    toDuration(p.baseTime, p.TimeScale),
+		Duration: toDuration(p.samplesDuration(), p.TimeScale),
+		KeyFrame: p.samples[0].KeyFrame,
+	}
+
+	trafs := make([]*TrackFragment, 0, len(m.order))
+	for _, t := range m.order {
+		if len(t.samples) == 0 {
+			continue
+		}
+		trafs = append(trafs, &TrackFragment{
+			TrackID:        t.ID,
+			BaseDecodeTime: t.baseTime,
+			Samples:        t.samples,
+		})
+		t.baseTime += t.samplesDuration()
+		t.samples = nil
+	}
+
+	m.seq++
+	ret.Seq = m.seq
+	ret.Tracks = trafs
+	ret.Data = GenFragment(m.seq, trafs)
+	return ret
+}
+
+// toDuration 避免长时间运行后乘法溢出
+func toDuration(v uint64, timeScale uint32) time.Duration {
+	scale := uint64(timeScale)
+	return time.Duration(v/scale)*time.Second + time.Duration(v%scale*uint64(time.Second)/scale)
+}
@@ -0,0 +1,126 @@
+package fmp4
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// readBoxes 返回 type -> 第一个同类型box的payload
+func readBoxes(b []byte) ([]string, map[string][]byte) {
+	types := make([]string, 0)
+	ret := make(map[string][]byte)
+	for len(b) >= 8 {
+		size := int(binary.BigEndian.Uint32(b))
+		if size < 8 || size > len(b) {
+			break
+		}
+		typ := string(b[4:8])
+		types = append(types, typ)
+		if _, ok := ret[typ]; !ok {
+			ret[typ] = b[8:size]
+		}
+		b = b[size:]
+	}
+	return types, ret
+}
+
+func testSDP() *sdp.SDPImpl {
+	s := sdp.NewSDP("test")
+	v := sdp.NewMedia("video", 96)
+	v.AddAttribute("control:trackID=0")
+	v.AddAttribute("rtpmap:96 H264/90000")
+	v.AddAttribute("fmtp:96 packetization-mode=1;sprop-parameter-sets=Z2QAH6w=,aO48gA==")
+	s.AddMedia(v)
+	a := sdp.NewMedia("audio", 97)
+	a.AddAttribute("control:trackID=1")
+	a.AddAttribute("rtpmap:97 MPEG4-GENERIC/44100/2")
+	a.AddAttribute("fmtp:97 streamtype=5;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=1210")
+	s.AddMedia(a)
+	m := sdp.NewMedia("application", 98)
+	m.AddAttribute("rtpmap:98 x-unknown/1000")
+	s.AddMedia(m)
+	return s
+}
+
+func TestMuxer(t *testing.T) {
+	Convey("test fmp4 init segment", t, func() {
+		m, err := NewMuxer(testSDP())
+		So(err, ShouldBeNil)
+		So(len(m.Tracks()), ShouldEqual, 2)
+		So(m.Tracks()[0].Codec, ShouldEqual, CodecH264)
+		So(m.Tracks()[1].Codec, ShouldEqual, CodecAAC)
+		So(m.Tracks()[1].SampleRate, ShouldEqual, 44100)
+
+		types, boxes := readBoxes(m.Init())
+		So(types, ShouldResemble, []string{"ftyp", "moov"})
+		types, moov := readBoxes(boxes["moov"])
+		So(types, ShouldResemble, []string{"mvhd", "trak", "trak", "mvex"})
+		_, trak := readBoxes(moov["trak"])
+		_, mdia := readBoxes(trak["mdia"])
+		So(string(mdia["hdlr"][8:12]), ShouldEqual, "vide")
+		// mdhd timescale
+		So(binary.BigEndian.Uint32(mdia["mdhd"][12:]), ShouldEqual, 90000)
+	})
+
+	Convey("test fmp4 fragment per gop", t, func() {
+		m, err := NewMuxer(testSDP())
+		So(err, ShouldBeNil)
+
+		video := rtp.NewPacketizer(96, 90000, &rtp.H264Payloader{})
+		audio := rtp.NewPacketizer(97, 44100, &rtp.AACPayloader{})
+
+		var frags []*Fragment
+		write := func(track int, pkts []*rtp.Packet) {
+			for _, pkt := range pkts {
+				f, err := m.WritePacket(track, pkt)
+				So(err, ShouldBeNil)
+				frags = append(frags, f...)
+			}
+		}
+
+		// 关键帧之前的数据被丢弃
+		write(0, video.Packetize([][]byte{{0x41, 0x00}}, 0))
+		write(1, audio.Packetize([][]byte{{0x21}}, 0))
+		write(0, video.Packetize([][]byte{{0x65, 0x01, 0x02}}, 3000))
+		write(1, audio.Packetize([][]byte{{0x21, 0x22}}, 1024))
+		write(1, audio.Packetize([][]byte{{0x21, 0x23}}, 2048))
+		write(0, video.Packetize([][]byte{{0x41, 0x03}}, 6000))
+		write(0, video.Packetize([][]byte{{0x41, 0x04}}, 9000))
+		So(frags, ShouldBeEmpty)
+
+		write(0, video.Packetize([][]byte{{0x65, 0x05}}, 12000))
+		So(len(frags), ShouldEqual, 1)
+		So(frags[0].KeyFrame, ShouldBeTrue)
+		So(frags[0].Duration.Milliseconds(), ShouldEqual, 100)
+
+		types, boxes := readBoxes(frags[0].Data)
+		So(types, ShouldResemble, []string{"moof", "mdat"})
+		types, moof := readBoxes(boxes["moof"])
+		So(types, ShouldResemble, []string{"mfhd", "traf", "traf"})
+		_, traf := readBoxes(moof["traf"])
+		trun := traf["trun"]
+		So(binary.BigEndian.Uint32(trun[4:]), ShouldEqual, 3)
+		// data_offset 指向mdat中的第一个sample
+		offset := binary.BigEndian.Uint32(trun[8:])
+		So(frags[0].Data[offset:offset+7], ShouldResemble, []byte{0, 0, 0, 3, 0x65, 0x01, 0x02})
+		// 第一个sample duration
+		So(binary.BigEndian.Uint32(trun[12:]), ShouldEqual, 3000)
+
+		last := m.Flush()
+		So(last, ShouldNotBeNil)
+		So(last.Start.Milliseconds(), ShouldEqual, 100)
+	})
+
+	Convey("test fmp4 muxer without supported track", t, func() {
+		s := sdp.NewSDP("test")
+		m := sdp.NewMedia("audio", 0)
+		m.AddAttribute("rtpmap:0 PCMU/8000")
+		s.AddMedia(m)
+		_, err := NewMuxer(s)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,35 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+)
+
+const hlsPlaylistName = "index.m3u8"
+
+// genHLSPlaylist 需持有锁
+func (c *segmentCache) genHLSPlaylist() []byte {
+	segments := c.window()
+
+	// 分片时长四舍五入后不能超过TARGETDURATION
+	target := c.conf.SegmentDuration
+	for _, s := range segments {
+		if d := int(math.Round(s.duration.Seconds())); d > target {
+			target = d
+		}
+	}
+
+	b := &bytes.Buffer{}
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:7\n")
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	fmt.Fprintf(b, "#EXT-X-TARGETDURATION:%d\n", target)
+	fmt.Fprintf(b, "#EXT-X-MEDIA-SEQUENCE:%d\n", segments[0].seq)
+	fmt.Fprintf(b, "#EXT-X-MAP:URI=\"%s\"\n", segmentInitName)
+	for _, s := range segments {
+		fmt.Fprintf(b, "#EXTINF:%.3f,\n", s.duration.Seconds())
+		fmt.Fprintf(b, "seg%d.m4s\n", s.seq)
+	}
+	return b.Bytes()
+}
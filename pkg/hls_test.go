@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHLS(t *testing.T) {
+	Convey("test hls playlist and segments", t, func() {
+		srv := &Server{Config: &Config{
+			HLS:    HLSConfig{SegmentDuration: 1},
+			Mounts: []*MountConfig{{Path: "/live"}},
+		}}
+		srv.init()
+		stream, _, _ := srv.findStream("/live")
+		src := newFakeSource()
+		stream.source = src
+		stream.sdp = src.SDP()
+
+		ts := httptest.NewServer(srv.http)
+		defer ts.Close()
+
+		get := func(path string) (int, string) {
+			resp, err := http.Get(ts.URL + path)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			return resp.StatusCode, string(body)
+		}
+
+		// 没有请求playlist前不转换
+		code, _ := get("/live/init.mp4")
+		So(code, ShouldEqual, http.StatusNotFound)
+
+		code, body := get("/live/index.m3u8")
+		So(code, ShouldEqual, http.StatusOK)
+		So(body, ShouldStartWith, "#EXTM3U\n")
+		So(body, ShouldContainSubstring, "#EXT-X-TARGETDURATION:1\n")
+		So(body, ShouldContainSubstring, "#EXT-X-MEDIA-SEQUENCE:0\n")
+		So(body, ShouldContainSubstring, `#EXT-X-MAP:URI="init.mp4"`)
+		So(body, ShouldContainSubstring, "\nseg0.m4s\n")
+
+		code, body = get("/live/init.mp4")
+		So(code, ShouldEqual, http.StatusOK)
+		So(body[4:8], ShouldEqual, "ftyp")
+
+		code, body = get("/live/seg0.m4s")
+		So(code, ShouldEqual, http.StatusOK)
+		So(body[4:8], ShouldEqual, "styp")
+		So(strings.Count(body, "moof"), ShouldBeGreaterThan, 1)
+
+		code, _ = get("/live/seg99.m4s")
+		So(code, ShouldEqual, http.StatusNotFound)
+		code, _ = get("/nope/index.m3u8")
+		So(code, ShouldEqual, http.StatusNotFound)
+
+		// 长时间没有请求playlist
+		m, err := srv.segments.getCache("/live", false)
+		So(err, ShouldBeNil)
+		So(m.idle(), ShouldBeFalse)
+		m.mu.Lock()
+		m.lastAccess = time.Now().Add(-time.Minute)
+		m.mu.Unlock()
+		So(m.idle(), ShouldBeTrue)
+	})
+
+	Convey("test negative hls config is rejected", t, func() {
+		for _, hls := range []HLSConfig{{SegmentDuration: -1}, {WindowSize: -1}, {IdleTimeout: -1}} {
+			c := &Config{HLS: hls}
+			c.setDefault()
+			So(c.validate(), ShouldNotBeNil)
+		}
+		c := &Config{}
+		c.setDefault()
+		So(c.validate(), ShouldBeNil)
+
+		// 不经过LoadConfig的配置在启动时检查
+		for _, conf := range []*Config{
+			{HLS: HLSConfig{WindowSize: -1}},
+			{SessionTimeout: -1},
+			{Hooks: []*HookConfig{{Command: " "}}},
+		} {
+			srv := &Server{Config: conf}
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+			served := make(chan error, 1)
+			go func() {
+				served <- srv.Serve(listener)
+			}()
+			select {
+			case err := <-served:
+				So(err, ShouldNotBeNil)
+			case <-time.After(time.Second):
+				So("server started", ShouldBeEmpty)
+				srv.Shutdown(context.Background())
+			}
+			listener.Close()
+		}
+	})
+}
@@ -0,0 +1,282 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event的Type
+const (
+	EventConnect       = "connect"
+	EventDisconnect    = "disconnect"
+	EventSessionCreate = "session_create"
+	EventPlay          = "play"
+	EventPause         = "pause"
+	EventTeardown      = "teardown"
+	EventTimeout       = "timeout"
+	EventPublishStart  = "publish_start"
+	EventPublishStop   = "publish_stop"
+	EventSourceReady   = "source_ready"
+	EventSourceFailed  = "source_failed"
+)
+
+var eventTypes = map[string]bool{
+	EventConnect:       true,
+	EventDisconnect:    true,
+	EventSessionCreate: true,
+	EventPlay:          true,
+	EventPause:         true,
+	EventTeardown:      true,
+	EventTimeout:       true,
+	EventPublishStart:  true,
+	EventPublishStop:   true,
+	EventSourceReady:   true,
+	EventSourceFailed:  true,
+}
+
+// hook每次重试的等待时间，之后加倍
+var hookRetryDelay = time.Second
+
+// hookQueueSize 每个通知目标最多缓存的事件，超过时丢弃
+const hookQueueSize = 1024
+
+// Event 通过Server.OnEvent、webhook(POST JSON)或外部命令(DRS_*环境变量)通知
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// 挂载路径，还没有请求挂载路径的连接为空
+	Path string `json:"path,omitempty"`
+	// rtsp连接的事件才有
+	SessionID  string `json:"session_id,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	// source_failed的原因
+	Error string `json:"error,omitempty"`
+}
+
+// env 外部命令的环境变量
+func (e *Event) env() []string {
+	return []string{
+		"DRS_EVENT=" + e.Type,
+		"DRS_TIME=" + e.Time.Format(time.RFC3339Nano),
+		"DRS_PATH=" + e.Path,
+		"DRS_SESSION_ID=" + e.SessionID,
+		"DRS_REMOTE_ADDR=" + e.RemoteAddr,
+		"DRS_ERROR=" + e.Error,
+	}
+}
+
+// hookSink 一个通知目标，事件按顺序在单独的goroutine中发送
+type hookSink struct {
+	name string
+	// 为nil时通知所有事件
+	events  map[string]bool
+	retries int
+	timeout time.Duration
+	deliver func(ctx context.Context, e *Event) error
+	queue   chan *Event
+}
+
+// hooks 事件不阻塞session和stream，发送失败只记录日志
+type hooks struct {
+	log   *slog.Logger
+	sinks []*hookSink
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// newHooks 配置错误的hook不启用
+func newHooks(conf []*HookConfig, onEvent func(Event), log *slog.Logger) *hooks {
+	h := &hooks{log: log}
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+
+	if onEvent != nil {
+		h.sinks = append(h.sinks, &hookSink{
+			name: "callback",
+			deliver: func(ctx context.Context, e *Event) error {
+				onEvent(*e)
+				return nil
+			},
+		})
+	}
+	for _, c := range conf {
+		sink, err := newHookSink(c)
+		if err != nil {
+			log.Error("invalid hook", "err", err)
+			continue
+		}
+		h.sinks = append(h.sinks, sink)
+	}
+
+	for _, sink := range h.sinks {
+		sink.queue = make(chan *Event, hookQueueSize)
+		h.wg.Add(1)
+		go h.run(sink)
+	}
+	return h
+}
+
+func newHookSink(c *HookConfig) (*hookSink, error) {
+	ret := &hookSink{
+		retries: c.Retries,
+		timeout: time.Duration(c.Timeout) * time.Second,
+	}
+	if ret.retries < 0 {
+		ret.retries = 0
+	}
+	if len(c.Events) > 0 {
+		ret.events = make(map[string]bool)
+		for _, typ := range c.Events {
+			if !eventTypes[typ] {
+				return nil, fmt.Errorf("unknown event %s", typ)
+			}
+			ret.events[typ] = true
+		}
+	}
+
+	switch {
+	case c.URL != "" && c.Command != "":
+		return nil, fmt.Errorf("hook with both url and command")
+	case c.URL != "":
+		ret.name = c.URL
+		client := &http.Client{}
+		ret.deliver = func(ctx context.Context, e *Event) error {
+			return postEvent(ctx, client, c.URL, e)
+		}
+	case c.Command != "":
+		args := strings.Fields(c.Command)
+		if len(args) == 0 {
+			return nil, fmt.Errorf("hook command is empty")
+		}
+		ret.name = args[0]
+		ret.deliver = func(ctx context.Context, e *Event) error {
+			cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+			cmd.Env = append(os.Environ(), e.env()...)
+			return cmd.Run()
+		}
+	default:
+		return nil, fmt.Errorf("hook without url or command")
+	}
+	return ret, nil
+}
+
+func (s *hookSink) send(ctx context.Context, e *Event) error {
+	if s.timeout <= 0 {
+		return s.deliver(ctx, e)
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.deliver(ctx, e)
+}
+
+func postEvent(ctx context.Context, client *http.Client, url string, e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// fire 可以在nil上调用，不阻塞，队列满时丢弃
+func (h *hooks) fire(e *Event) {
+	if h == nil {
+		return
+	}
+	e.Time = time.Now()
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.closed {
+		return
+	}
+	for _, sink := range h.sinks {
+		if sink.events != nil && !sink.events[e.Type] {
+			continue
+		}
+		select {
+		case sink.queue <- e:
+		default:
+			h.log.Warn("drop event", "hook", sink.name, "event", e.Type)
+		}
+	}
+}
+
+func (h *hooks) run(sink *hookSink) {
+	defer h.wg.Done()
+
+	for e := range sink.queue {
+		delay := hookRetryDelay
+		for attempt := 0; h.ctx.Err() == nil; attempt++ {
+			err := sink.send(h.ctx, e)
+			if err == nil {
+				break
+			}
+			if attempt >= sink.retries {
+				h.log.Warn("hook failed", "hook", sink.name, "event", e.Type, "path", e.Path, "err", err)
+				break
+			}
+
+			select {
+			case <-time.After(delay):
+			case <-h.ctx.Done():
+			}
+			delay *= 2
+		}
+	}
+}
+
+// close 发送完已有的事件后返回，ctx结束时放弃剩余的事件
+func (h *hooks) close(ctx context.Context) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	if !h.closed {
+		h.closed = true
+		for _, sink := range h.sinks {
+			close(sink.queue)
+		}
+	}
+	h.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		h.cancel()
+		<-done
+	}
+	h.cancel()
+}
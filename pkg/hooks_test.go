@@ -0,0 +1,323 @@
+package pkg
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/client"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// eventRecorder 保存OnEvent收到的事件
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) add(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+// types 等待满足条件，返回过滤后的事件类型
+func (r *eventRecorder) types(filter func(Event) bool, want int) []string {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r.mu.Lock()
+		ret := make([]string, 0)
+		for _, e := range r.events {
+			if filter(e) {
+				ret = append(ret, e.Type)
+			}
+		}
+		r.mu.Unlock()
+		if len(ret) >= want || time.Now().After(deadline) {
+			return ret
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	hookRetryDelay = 10 * time.Millisecond
+
+	Convey("test event callback", t, func() {
+		rec := &eventRecorder{}
+		srv := &Server{
+			Config: &Config{Mounts: []*MountConfig{
+				{Path: "/live", Source: writeTestH264(t), FrameRate: 100},
+				{Path: "/ingest"},
+			}},
+			OnEvent: rec.add,
+		}
+		url := serveTest(srv)
+
+		viewer, err := client.Dial(url+"/live", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		_, err = viewer.Describe()
+		So(err, ShouldBeNil)
+		So(viewer.SetupAll(), ShouldBeNil)
+		_, err = viewer.Play(nil)
+		So(err, ShouldBeNil)
+		<-viewer.Packets()
+		So(viewer.Pause(), ShouldBeNil)
+		So(viewer.Teardown(), ShouldBeNil)
+		viewer.Close()
+
+		// 目前只有这一个连接
+		So(rec.types(func(e Event) bool { return e.RemoteAddr != "" }, 6), ShouldResemble,
+			[]string{EventConnect, EventSessionCreate, EventPlay, EventPause, EventTeardown, EventDisconnect})
+		So(rec.types(func(e Event) bool { return e.Type == EventSourceReady }, 1), ShouldResemble, []string{EventSourceReady})
+
+		announced := sdp.NewSDP("test")
+		m := sdp.NewMedia("video", 96)
+		m.AddAttribute("rtpmap:96 H264/90000")
+		m.AddAttribute("control:trackID=0")
+		announced.AddMedia(m)
+		pub, err := client.Dial(url+"/ingest", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		So(pub.Announce(announced), ShouldBeNil)
+		So(pub.SetupAll(), ShouldBeNil)
+		_, err = pub.Record()
+		So(err, ShouldBeNil)
+		pub.Close()
+
+		// Close发送TEARDOWN，之后的disconnect没有挂载路径
+		ingest := func(e Event) bool { return e.Path == "/ingest" }
+		So(rec.types(ingest, 5), ShouldResemble,
+			[]string{EventSessionCreate, EventSourceReady, EventPublishStart, EventPublishStop, EventTeardown})
+	})
+
+	Convey("test failed tls handshake fires no connect event", t, func() {
+		dir := t.TempDir()
+		certFile, keyFile, _ := writeTestCert(dir, "server", 1)
+		rec := &eventRecorder{}
+		srv := &Server{
+			Config: &Config{
+				TLS:    TLSConfig{Cert: certFile, Key: keyFile},
+				Mounts: []*MountConfig{{Path: "/live", Source: writeTestH264(t), FrameRate: 100}},
+			},
+			OnEvent: rec.add,
+		}
+		url := serveTLSTest(srv)
+		host := strings.TrimPrefix(url, "rtsps://")
+
+		// 不信任服务端证书的客户端和明文客户端
+		_, err := client.Dial(url+"/live", client.Options{TLSConfig: &tls.Config{}})
+		So(err, ShouldNotBeNil)
+		conn, err := net.Dial("tcp", host)
+		So(err, ShouldBeNil)
+		fmt.Fprintf(conn, "OPTIONS %s/live RTSP/1.0\r\nCSeq: 1\r\n\r\n", url)
+		ioutil.ReadAll(conn)
+		conn.Close()
+
+		c, err := client.Dial(url+"/live", client.Options{TLSConfig: &tls.Config{RootCAs: certPool(certFile)}})
+		So(err, ShouldBeNil)
+		_, err = c.Options()
+		So(err, ShouldBeNil)
+		c.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		So(srv.Shutdown(ctx), ShouldBeNil)
+		So(rec.types(func(e Event) bool { return true }, 2), ShouldResemble, []string{EventConnect, EventDisconnect})
+	})
+
+	Convey("test vod describe does not fire source_ready", t, func() {
+		rec := &eventRecorder{}
+		srv := &Server{
+			Config:  &Config{Mounts: []*MountConfig{{Path: "/vod", Source: writeTestFLV(t)}}},
+			OnEvent: rec.add,
+		}
+		url := serveTest(srv)
+
+		for i := 0; i < 2; i++ {
+			c, err := client.Dial(url+"/vod", client.Options{Transport: client.TransportTCP})
+			So(err, ShouldBeNil)
+			_, err = c.Describe()
+			So(err, ShouldBeNil)
+			c.Close()
+		}
+
+		// Shutdown之后所有事件已经通知
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		So(srv.Shutdown(ctx), ShouldBeNil)
+		So(rec.types(func(e Event) bool { return e.Path == "/vod" && e.RemoteAddr == "" }, 0), ShouldBeEmpty)
+		So(rec.types(func(e Event) bool { return e.Type == EventConnect }, 2), ShouldHaveLength, 2)
+	})
+
+	Convey("test webhook retries and event filter", t, func() {
+		var mu sync.Mutex
+		received := make([]Event, 0)
+		attempts := 0
+		webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			// 第一次失败
+			if attempts++; attempts == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			e := Event{}
+			if json.NewDecoder(r.Body).Decode(&e) == nil {
+				received = append(received, e)
+			}
+		}))
+		defer webhook.Close()
+
+		srv := &Server{Config: &Config{
+			Mounts: []*MountConfig{{Path: "/live", Source: writeTestH264(t), FrameRate: 100}},
+			Hooks:  []*HookConfig{{URL: webhook.URL, Events: []string{EventPlay}}},
+		}}
+		url := serveTest(srv)
+		viewer, err := client.Dial(url+"/live", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		defer viewer.Close()
+		_, err = viewer.Describe()
+		So(err, ShouldBeNil)
+		So(viewer.SetupAll(), ShouldBeNil)
+		_, err = viewer.Play(nil)
+		So(err, ShouldBeNil)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		So(srv.Shutdown(ctx), ShouldBeNil)
+
+		mu.Lock()
+		defer mu.Unlock()
+		So(attempts, ShouldEqual, 2)
+		So(received, ShouldHaveLength, 1)
+		So(received[0].Type, ShouldEqual, EventPlay)
+		So(received[0].Path, ShouldEqual, "/live")
+		So(received[0].SessionID, ShouldNotBeEmpty)
+		So(received[0].Time.IsZero(), ShouldBeFalse)
+
+		So((&Config{Hooks: []*HookConfig{{URL: webhook.URL, Events: []string{"nope"}}}}).validate(), ShouldNotBeNil)
+		So((&Config{Hooks: []*HookConfig{{}}}).validate(), ShouldNotBeNil)
+		So((&Config{Hooks: []*HookConfig{{Command: "  "}}}).validate(), ShouldNotBeNil)
+		So((&Config{Hooks: []*HookConfig{{URL: webhook.URL, Command: "true"}}}).validate(), ShouldNotBeNil)
+		So((&Config{SessionTimeout: -1}).validate(), ShouldNotBeNil)
+	})
+
+	Convey("test command hook environment", t, func() {
+		dir := t.TempDir()
+		script := filepath.Join(dir, "hook.sh")
+		out := filepath.Join(dir, "out")
+		So(ioutil.WriteFile(script, []byte("echo \"$DRS_EVENT $DRS_PATH $DRS_SESSION_ID\" >> \"$1\"\n"), 0755), ShouldBeNil)
+
+		srv := &Server{Config: &Config{
+			Mounts: []*MountConfig{{Path: "/live", Source: writeTestH264(t), FrameRate: 100}},
+			Hooks:  []*HookConfig{{Command: "/bin/sh " + script + " " + out, Events: []string{EventSessionCreate}}},
+		}}
+		url := serveTest(srv)
+		viewer, err := client.Dial(url+"/live", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		defer viewer.Close()
+		_, err = viewer.Describe()
+		So(err, ShouldBeNil)
+		So(viewer.SetupAll(), ShouldBeNil)
+		id := srv.Sessions()[0].ID
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		So(srv.Shutdown(ctx), ShouldBeNil)
+		data, err := ioutil.ReadFile(out)
+		So(err, ShouldBeNil)
+		So(strings.TrimSpace(string(data)), ShouldEqual, "session_create /live "+id)
+	})
+
+	Convey("test idle session timeout", t, func() {
+		rec := &eventRecorder{}
+		srv := &Server{
+			Config:  &Config{SessionTimeout: 1, Mounts: []*MountConfig{{Path: "/live", Source: writeTestH264(t), FrameRate: 100}}},
+			OnEvent: rec.add,
+		}
+		url := serveTest(srv)
+
+		// 播放的客户端定时发送OPTIONS保持session
+		viewer, err := client.Dial(url+"/live", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		defer viewer.Close()
+		_, err = viewer.Describe()
+		So(err, ShouldBeNil)
+		So(viewer.SetupAll(), ShouldBeNil)
+		_, err = viewer.Play(nil)
+		So(err, ShouldBeNil)
+
+		idle, err := client.Dial(url+"/live", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		defer idle.Close()
+		_, err = idle.Describe()
+		So(err, ShouldBeNil)
+		So(idle.SetupAll(), ShouldBeNil)
+
+		// UDP的客户端只发送RTCP RR，不发送RTSP请求
+		reporter, err := client.Dial(url+"/live", client.Options{Transport: client.TransportUDP})
+		So(err, ShouldBeNil)
+		defer reporter.Close()
+		_, err = reporter.Describe()
+		So(err, ShouldBeNil)
+		So(reporter.SetupAll(), ShouldBeNil)
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			// 不带report block的RR
+			rr := []byte{0x80, 201, 0, 1, 0, 0, 0, 1}
+			ticker := time.NewTicker(200 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					reporter.WriteRTCP(0, rr)
+				case <-stop:
+					return
+				}
+			}
+		}()
+
+		select {
+		case <-idle.Done():
+		case <-time.After(5 * time.Second):
+			So("timeout", ShouldBeEmpty)
+		}
+		timeouts := rec.types(func(e Event) bool { return e.Type == EventTimeout }, 1)
+		So(timeouts, ShouldHaveLength, 1)
+		select {
+		case <-viewer.Done():
+			So("viewer disconnected", ShouldBeEmpty)
+		case <-reporter.Done():
+			So("reporter disconnected", ShouldBeEmpty)
+		default:
+		}
+		So(srv.Sessions(), ShouldHaveLength, 2)
+	})
+
+	Convey("test session header carries timeout", t, func() {
+		srv := &Server{Config: &Config{SessionTimeout: 30, Mounts: []*MountConfig{{Path: "/live", Source: writeTestH264(t), FrameRate: 100}}}}
+		url := serveTest(srv)
+		viewer, err := client.Dial(url+"/live", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		defer viewer.Close()
+		_, err = viewer.Describe()
+		So(err, ShouldBeNil)
+		So(viewer.SetupAll(), ShouldBeNil)
+		resp, err := viewer.Play(nil)
+		So(err, ShouldBeNil)
+		session, _ := resp.GetMessage("Session")
+		So(session, ShouldEqual, srv.Sessions()[0].ID+";timeout=30")
+	})
+}
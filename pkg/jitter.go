@@ -0,0 +1,81 @@
+package pkg
+
+import (
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/rtp"
+)
+
+type sourcePacket struct {
+	track int
+	pkt   *rtp.Packet
+	err   error
+}
+
+// jitterStage 推流和拉流的每个track经过jitter buffer排序后再交给stream
+// 在单独的goroutine中读取source，source关闭后退出
+type jitterStage struct {
+	buffers  map[int]*rtp.JitterBuffer
+	latency  time.Duration
+	incoming chan sourcePacket
+}
+
+func newJitterStage(src Source, latency time.Duration) *jitterStage {
+	ret := &jitterStage{
+		buffers:  make(map[int]*rtp.JitterBuffer),
+		latency:  latency,
+		incoming: make(chan sourcePacket, 64),
+	}
+	go ret.read(src)
+	return ret
+}
+
+func (j *jitterStage) read(src Source) {
+	for {
+		track, pkt, err := src.ReadPacket()
+		j.incoming <- sourcePacket{track: track, pkt: pkt, err: err}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ReadPacket 返回下一个按序的包及其之前丢失的包数量
+func (j *jitterStage) ReadPacket() (int, *rtp.Packet, int, error) {
+	for {
+		now := time.Now()
+		var deadline time.Time
+		for track, b := range j.buffers {
+			if pkt, lost := b.Pop(now); pkt != nil {
+				return track, pkt, lost, nil
+			}
+			if d, ok := b.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+				deadline = d
+			}
+		}
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			timer = time.NewTimer(time.Until(deadline))
+			timeout = timer.C
+		}
+
+		select {
+		case p := <-j.incoming:
+			if timer != nil {
+				timer.Stop()
+			}
+			if p.err != nil {
+				return 0, nil, 0, p.err
+			}
+			b, ok := j.buffers[p.track]
+			if !ok {
+				b = rtp.NewJitterBuffer(j.latency)
+				j.buffers[p.track] = b
+			}
+			b.Push(p.pkt, time.Now())
+		case <-timeout:
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/client"
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJitterBuffer(t *testing.T) {
+	Convey("test published packets are reordered", t, func() {
+		srv := &Server{Config: &Config{
+			Mounts: []*MountConfig{{Path: "/ingest", JitterBuffer: 100}},
+		}}
+		url := serveTest(srv)
+
+		announced := sdp.NewSDP("test")
+		m := sdp.NewMedia("video", 96)
+		m.AddAttribute("rtpmap:96 H264/90000")
+		m.AddAttribute("control:trackID=0")
+		announced.AddMedia(m)
+
+		pub, err := client.Dial(url+"/ingest", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		defer pub.Close()
+		So(pub.Announce(announced), ShouldBeNil)
+		So(pub.SetupAll(), ShouldBeNil)
+		_, err = pub.Record()
+		So(err, ShouldBeNil)
+
+		c, err := client.Dial(url+"/ingest", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		defer c.Close()
+		_, err = c.Describe()
+		So(err, ShouldBeNil)
+		So(c.SetupAll(), ShouldBeNil)
+		_, err = c.Play(nil)
+		So(err, ShouldBeNil)
+
+		// 4丢失
+		for _, seq := range []uint16{0, 2, 1, 3, 6, 5} {
+			So(pub.WritePacket(0, &rtp.Packet{PayloadType: 96, SequenceNumber: seq, Payload: []byte{0x41, 0x9a}}), ShouldBeNil)
+		}
+
+		seqs := make([]uint16, 0)
+		for len(seqs) < 6 {
+			select {
+			case p := <-c.Packets():
+				seqs = append(seqs, p.Packet.SequenceNumber)
+			case <-time.After(5 * time.Second):
+				So("timeout", ShouldBeEmpty)
+			}
+		}
+		So(seqs, ShouldResemble, []uint16{0, 1, 2, 3, 5, 6})
+
+		stream, _, _ := srv.findStream("/ingest")
+		So(stream.PacketsLost(0), ShouldEqual, 1)
+	})
+}
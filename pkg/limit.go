@@ -0,0 +1,141 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"time"
+)
+
+// 拒绝的原因，用于统计
+const (
+	rejectDeny                = "deny"
+	rejectMaxConnections      = "max_connections"
+	rejectMaxConnectionsPerIP = "max_connections_per_ip"
+	rejectMaxSessionsPerIP    = "max_sessions_per_ip"
+	rejectMountDeny           = "mount_deny"
+	rejectRateLimit           = "rate_limit"
+)
+
+// rejectError 超过连接数限制
+type rejectError struct {
+	reason string
+}
+
+func (e *rejectError) Error() string {
+	return "connection rejected: " + e.reason
+}
+
+// ipFilter deny优先，allow为空时允许所有不在deny中的地址
+type ipFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func newIPFilter(allow, deny []string) (*ipFilter, error) {
+	ret := &ipFilter{}
+	var err error
+	if ret.allow, err = parseCIDRs(allow); err != nil {
+		return nil, err
+	}
+	if ret.deny, err = parseCIDRs(deny); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// parseCIDRs 不带掩码的地址视为单个地址
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	ret := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %s", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			ret = append(ret, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, n)
+	}
+	return ret, nil
+}
+
+// allowed 可以在nil上调用
+func (f *ipFilter) allowed(ip net.IP) bool {
+	if f == nil {
+		return true
+	}
+	for _, n := range f.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, n := range f.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenBucket 每个连接的请求速率限制，只在session goroutine中使用
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket rate<=0时返回nil，不限制；burst为0时取max(1, rate)
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	b := float64(burst)
+	if burst <= 0 {
+		b = math.Max(1, math.Ceil(rate))
+	}
+	return &tokenBucket{rate: rate, burst: b, tokens: b}
+}
+
+// allow 可以在nil上调用
+func (b *tokenBucket) allow(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// remoteIP 连接的远端地址
+func remoteIP(conn net.Conn) net.IP {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
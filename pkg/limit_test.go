@@ -0,0 +1,171 @@
+package pkg
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/client"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func rejected(srv *Server) string {
+	w := &metricsWriter{}
+	srv.metrics.rejected.write(w, "drs_rejected_total")
+	return w.buf.String()
+}
+
+func TestLimit(t *testing.T) {
+	Convey("test ip filter", t, func() {
+		f, err := newIPFilter(nil, []string{"10.0.0.0/8", "192.168.1.1"})
+		So(err, ShouldBeNil)
+		So(f.allowed(net.ParseIP("10.1.2.3")), ShouldBeFalse)
+		So(f.allowed(net.ParseIP("192.168.1.1")), ShouldBeFalse)
+		So(f.allowed(net.ParseIP("192.168.1.2")), ShouldBeTrue)
+
+		f, err = newIPFilter([]string{"127.0.0.0/8", "::1"}, []string{"127.0.0.2"})
+		So(err, ShouldBeNil)
+		So(f.allowed(net.ParseIP("127.0.0.1")), ShouldBeTrue)
+		So(f.allowed(net.ParseIP("::1")), ShouldBeTrue)
+		So(f.allowed(net.ParseIP("127.0.0.2")), ShouldBeFalse)
+		So(f.allowed(net.ParseIP("8.8.8.8")), ShouldBeFalse)
+
+		var none *ipFilter
+		So(none.allowed(net.ParseIP("8.8.8.8")), ShouldBeTrue)
+
+		_, err = newIPFilter([]string{"nope"}, nil)
+		So(err, ShouldNotBeNil)
+		_, err = newIPFilter(nil, []string{"10.0.0.0/33"})
+		So(err, ShouldNotBeNil)
+		So((&Config{Mounts: []*MountConfig{{Path: "/live", Deny: []string{"nope"}}}}).validate(), ShouldNotBeNil)
+	})
+
+	Convey("test token bucket", t, func() {
+		now := time.Now()
+		b := newTokenBucket(2, 3)
+		So(b.allow(now), ShouldBeTrue)
+		So(b.allow(now), ShouldBeTrue)
+		So(b.allow(now), ShouldBeTrue)
+		So(b.allow(now), ShouldBeFalse)
+		So(b.allow(now.Add(500*time.Millisecond)), ShouldBeTrue)
+		So(b.allow(now.Add(500*time.Millisecond)), ShouldBeFalse)
+		// 最多累积burst个
+		So(b.allow(now.Add(time.Hour)), ShouldBeTrue)
+		So(b.allow(now.Add(time.Hour)), ShouldBeTrue)
+		So(b.allow(now.Add(time.Hour)), ShouldBeTrue)
+		So(b.allow(now.Add(time.Hour)), ShouldBeFalse)
+
+		So(newTokenBucket(0, 10).allow(now), ShouldBeTrue)
+		So(newTokenBucket(0.5, 0).burst, ShouldEqual, 1)
+	})
+
+	Convey("test invalid address lists refuse to start", t, func() {
+		for _, conf := range []*Config{
+			{Limits: LimitConfig{Deny: []string{"10.0.0.0/8", "nope"}}},
+			{Mounts: []*MountConfig{{Path: "/live", Source: writeTestH264(t), Deny: []string{"nope"}}}},
+		} {
+			srv := &Server{Config: conf}
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+			So(srv.Serve(listener), ShouldNotBeNil)
+			listener.Close()
+			So(srv.ListenAndServe(context.Background()), ShouldNotBeNil)
+		}
+	})
+
+	Convey("test connection limits and global deny list close the connection", t, func() {
+		srv := &Server{Config: &Config{
+			Limits: LimitConfig{MaxConnectionsPerIP: 1},
+			Mounts: []*MountConfig{{Path: "/live", Source: writeTestH264(t), FrameRate: 100}},
+		}}
+		url := serveTest(srv)
+
+		first, err := client.Dial(url+"/live", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		defer first.Close()
+		_, err = first.Options()
+		So(err, ShouldBeNil)
+
+		second, err := client.Dial(url+"/live", client.Options{Transport: client.TransportTCP})
+		if err == nil {
+			defer second.Close()
+			_, err = second.Options()
+		}
+		So(err, ShouldNotBeNil)
+		So(rejected(srv), ShouldContainSubstring, `drs_rejected_total{reason="max_connections_per_ip"} 1`)
+
+		// 断开后可以再次连接
+		first.Close()
+		deadline := time.Now().Add(5 * time.Second)
+		for len(srv.Sessions()) != 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		third, err := client.Dial(url+"/live", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		defer third.Close()
+		_, err = third.Options()
+		So(err, ShouldBeNil)
+
+		denied := &Server{Config: &Config{Limits: LimitConfig{Deny: []string{"127.0.0.0/8"}}}}
+		url = serveTest(denied)
+		c, err := client.Dial(url+"/live", client.Options{Transport: client.TransportTCP})
+		if err == nil {
+			defer c.Close()
+			_, err = c.Options()
+		}
+		So(err, ShouldNotBeNil)
+		So(rejected(denied), ShouldContainSubstring, `drs_rejected_total{reason="deny"} 1`)
+	})
+
+	Convey("test mount deny list, sessions per ip and request rate return 503", t, func() {
+		source := writeTestH264(t)
+		srv := &Server{Config: &Config{
+			Limits: LimitConfig{MaxSessionsPerIP: 1, RequestRate: 0.1, RequestBurst: 5},
+			Mounts: []*MountConfig{
+				{Path: "/live", Source: source, FrameRate: 100},
+				{Path: "/private", Source: source, FrameRate: 100, Allow: []string{"10.0.0.0/8"}},
+			},
+		}}
+		url := serveTest(srv)
+
+		c, err := client.Dial(url+"/private", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		defer c.Close()
+		_, err = c.Describe()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "503")
+		So(rejected(srv), ShouldContainSubstring, `drs_rejected_total{reason="mount_deny"} 1`)
+
+		setup := func() (*client.Client, error) {
+			c, err := client.Dial(url+"/live", client.Options{Transport: client.TransportTCP})
+			So(err, ShouldBeNil)
+			if _, err := c.Describe(); err != nil {
+				return c, err
+			}
+			return c, c.SetupAll()
+		}
+		first, err := setup()
+		So(err, ShouldBeNil)
+		defer first.Close()
+		second, err := setup()
+		defer second.Close()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "503")
+		So(rejected(srv), ShouldContainSubstring, `drs_rejected_total{reason="max_sessions_per_ip"} 1`)
+
+		// TEARDOWN之后释放
+		So(first.Teardown(), ShouldBeNil)
+		So(second.SetupAll(), ShouldBeNil)
+
+		// 第二个连接已经发送了3个请求
+		_, err = second.Options()
+		So(err, ShouldBeNil)
+		_, err = second.Options()
+		So(err, ShouldBeNil)
+		_, err = second.Options()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "503")
+		So(rejected(srv), ShouldContainSubstring, `drs_rejected_total{reason="rate_limit"} 1`)
+	})
+}
@@ -0,0 +1,21 @@
+package pkg
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// newLogger 无法识别的级别使用info，格式使用text
+func newLogger(c *LogConfig, w io.Writer) *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+		level = slog.LevelInfo
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	if strings.ToLower(c.Format) == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
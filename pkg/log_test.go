@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Lcmasdf/drs/pkg/client"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// logBuffer 测试时读取日志，handler在其他goroutine中写入
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records 解析JSON格式的日志
+func (b *logBuffer) records() []map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ret := make([]map[string]interface{}, 0)
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		record := make(map[string]interface{})
+		if json.Unmarshal([]byte(line), &record) == nil {
+			ret = append(ret, record)
+		}
+	}
+	return ret
+}
+
+func TestLogger(t *testing.T) {
+	Convey("test logger config", t, func() {
+		buf := &logBuffer{}
+		log := newLogger(&LogConfig{Level: "debug", Format: "json"}, buf)
+		log.Debug("hello", "path", "/live")
+		records := buf.records()
+		So(records, ShouldHaveLength, 1)
+		So(records[0]["msg"], ShouldEqual, "hello")
+		So(records[0]["level"], ShouldEqual, "DEBUG")
+		So(records[0]["path"], ShouldEqual, "/live")
+
+		buf = &logBuffer{}
+		log = newLogger(&LogConfig{Level: "nope"}, buf)
+		log.Debug("hidden")
+		log.Info("shown")
+		So(buf.buf.String(), ShouldNotContainSubstring, "hidden")
+		So(buf.buf.String(), ShouldContainSubstring, "level=INFO msg=shown")
+	})
+
+	Convey("test session records carry session id, remote address and cseq", t, func() {
+		buf := &logBuffer{}
+		srv := &Server{
+			Config: &Config{Mounts: []*MountConfig{{Path: "/live", Source: writeTestH264(t), FrameRate: 100}}},
+			Logger: newLogger(&LogConfig{Level: "debug", Format: "json"}, buf),
+		}
+		url := serveTest(srv)
+
+		c, err := client.Dial(url+"/live", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		_, err = c.Describe()
+		So(err, ShouldBeNil)
+		So(c.SetupAll(), ShouldBeNil)
+		_, err = c.Play(nil)
+		So(err, ShouldBeNil)
+		c.Close()
+
+		var setup, setupResp, play map[string]interface{}
+		for _, r := range buf.records() {
+			switch {
+			case r["msg"] == "request" && r["method"] == "SETUP":
+				setup = r
+			case r["msg"] == "response" && setup != nil && setupResp == nil:
+				setupResp = r
+			case r["msg"] == "request" && r["method"] == "PLAY":
+				play = r
+			}
+		}
+		So(setup, ShouldNotBeNil)
+		So(setup["cseq"], ShouldEqual, 2)
+		So(setup["remote"], ShouldNotBeEmpty)
+		So(setup["message"], ShouldStartWith, "SETUP ")
+		// SETUP的请求还没有session ID，响应中已经分配
+		So(setup["session"], ShouldEqual, "")
+		So(setupResp["session"], ShouldNotBeEmpty)
+		So(setupResp["message"], ShouldStartWith, "RTSP/1.0 200 OK")
+		So(play["session"], ShouldEqual, setupResp["session"])
+		So(play["cseq"], ShouldEqual, 3)
+	})
+}
@@ -0,0 +1,242 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// counterVec 按label值分组的计数器
+type counterVec struct {
+	labels []string
+
+	mu     sync.RWMutex
+	values map[string]*uint64
+}
+
+func newCounterVec(labels ...string) *counterVec {
+	return &counterVec{
+		labels: labels,
+		values: make(map[string]*uint64),
+	}
+}
+
+// add label值的顺序与创建时的label名相同
+func (c *counterVec) add(n uint64, values ...string) {
+	key := strings.Join(values, "\xff")
+
+	c.mu.RLock()
+	v, ok := c.values[key]
+	c.mu.RUnlock()
+	if !ok {
+		c.mu.Lock()
+		if v, ok = c.values[key]; !ok {
+			v = new(uint64)
+			c.values[key] = v
+		}
+		c.mu.Unlock()
+	}
+	atomic.AddUint64(v, n)
+}
+
+func (c *counterVec) write(w *metricsWriter, name string) {
+	c.mu.RLock()
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	c.mu.RUnlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		c.mu.RLock()
+		v := atomic.LoadUint64(c.values[key])
+		c.mu.RUnlock()
+		w.sample(name, c.labels, strings.Split(key, "\xff"), v)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsWriter Prometheus text exposition format
+type metricsWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *metricsWriter) header(name, typ, help string) {
+	fmt.Fprintf(&w.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (w *metricsWriter) sample(name string, labels, values []string, v uint64) {
+	w.buf.WriteString(name)
+	if len(labels) > 0 {
+		pairs := make([]string, len(labels))
+		for i, label := range labels {
+			pairs[i] = label + `="` + labelEscaper.Replace(values[i]) + `"`
+		}
+		w.buf.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	fmt.Fprintf(&w.buf, " %d\n", v)
+}
+
+// metrics 在管理端口的/metrics上输出，方法可以在nil上调用
+type metrics struct {
+	// 原子操作，放在第一个保证64位对齐
+	authFailures uint64
+
+	// gauge在请求时从server的session和端口状态计算
+	srv         *Server
+	requests    *counterVec
+	sentBytes   *counterVec
+	sentPackets *counterVec
+	rejected    *counterVec
+}
+
+func newMetrics(srv *Server) *metrics {
+	return &metrics{
+		srv:         srv,
+		requests:    newCounterVec("method", "code"),
+		sentBytes:   newCounterVec("path"),
+		sentPackets: newCounterVec("path"),
+		rejected:    newCounterVec("reason"),
+	}
+}
+
+// request 不支持的方法统一记为OTHER，避免label数量不受控制
+func (m *metrics) request(method, code string) {
+	if m == nil {
+		return
+	}
+	if _, ok := Method2method[method]; !ok {
+		method = "OTHER"
+	}
+	m.requests.add(1, method, code)
+	if code == "401" {
+		m.authFailure()
+	}
+}
+
+// reject 超过限制或地址不允许而拒绝的连接和请求
+func (m *metrics) reject(reason string) {
+	if m == nil {
+		return
+	}
+	m.rejected.add(1, reason)
+}
+
+func (m *metrics) authFailure() {
+	if m == nil {
+		return
+	}
+	atomic.AddUint64(&m.authFailures, 1)
+}
+
+// sent 发送了一个RTP包
+func (m *metrics) sent(path string, n int) {
+	if m == nil {
+		return
+	}
+	m.sentBytes.add(uint64(n), path)
+	m.sentPackets.add(1, path)
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mw := &metricsWriter{}
+
+	sessions := m.srv.Sessions()
+	connections := uint64(len(sessions))
+	states := make(map[string]uint64)
+	viewers := make(map[string]uint64)
+	publishers := uint64(0)
+	for _, info := range sessions {
+		states[info.State]++
+		switch info.State {
+		case State2String[PLAYING]:
+			viewers[info.Path]++
+		case State2String[RECORDING]:
+			publishers++
+		}
+	}
+
+	mw.header("drs_connections", "gauge", "Active RTSP TCP connections.")
+	mw.sample("drs_connections", nil, nil, connections)
+
+	mw.header("drs_sessions", "gauge", "RTSP sessions by state.")
+	for _, st := range []state{INIT, READY, PLAYING, RECORDING} {
+		mw.sample("drs_sessions", []string{"state"}, []string{State2String[st]}, states[State2String[st]])
+	}
+
+	mw.header("drs_requests_total", "counter", "RTSP requests by method and status code.")
+	m.requests.write(mw, "drs_requests_total")
+
+	mw.header("drs_sent_bytes_total", "counter", "RTP bytes sent by path.")
+	m.sentBytes.write(mw, "drs_sent_bytes_total")
+	mw.header("drs_sent_packets_total", "counter", "RTP packets sent by path.")
+	m.sentPackets.write(mw, "drs_sent_packets_total")
+
+	mw.header("drs_publishers", "gauge", "Active publishers.")
+	mw.sample("drs_publishers", nil, nil, publishers)
+
+	mw.header("drs_viewers", "gauge", "Playing sessions by path.")
+	paths := make([]string, 0, len(viewers))
+	for path := range viewers {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		mw.sample("drs_viewers", []string{"path"}, []string{path}, viewers[path])
+	}
+
+	mw.header("drs_rtp_ports_used", "gauge", "Allocated RTP/RTCP port pairs.")
+	mw.sample("drs_rtp_ports_used", nil, nil, uint64(m.srv.ports.Used()))
+	mw.header("drs_rtp_ports_total", "gauge", "Available RTP/RTCP port pairs.")
+	mw.sample("drs_rtp_ports_total", nil, nil, uint64(m.srv.ports.Size()))
+
+	mw.header("drs_auth_failures_total", "counter", "Authentication failures.")
+	mw.sample("drs_auth_failures_total", nil, nil, atomic.LoadUint64(&m.authFailures))
+
+	mw.header("drs_rejected_total", "counter", "Connections and requests rejected by limits and address lists.")
+	m.rejected.write(mw, "drs_rejected_total")
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(mw.buf.Bytes())
+}
+
+// bitrateMeter 按秒统计stream收到的数据量，点播Fork的stream与挂载路径共用
+type bitrateMeter struct {
+	mu      sync.Mutex
+	start   time.Time
+	bytes   uint64
+	bitrate uint64
+}
+
+func (m *bitrateMeter) add(n int, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.start.IsZero() {
+		m.start = now
+	}
+	m.bytes += uint64(n)
+	if elapsed := now.Sub(m.start); elapsed >= time.Second {
+		m.bitrate = m.bytes * 8 * uint64(time.Second) / uint64(elapsed)
+		m.start = now
+		m.bytes = 0
+	}
+}
+
+// rate bit/s，超过两秒没有数据时为0
+func (m *bitrateMeter) rate(now time.Time) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.start.IsZero() || now.Sub(m.start) > 2*time.Second {
+		return 0
+	}
+	return m.bitrate
+}
@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/client"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetrics(t *testing.T) {
+	Convey("test counter labels", t, func() {
+		c := newCounterVec("path")
+		c.add(2, `/a"b`)
+		c.add(3, `/a"b`)
+		c.add(1, "/c")
+		w := &metricsWriter{}
+		c.write(w, "test_total")
+		So(w.buf.String(), ShouldEqual, "test_total{path=\"/a\\\"b\"} 5\ntest_total{path=\"/c\"} 1\n")
+	})
+
+	Convey("test metrics endpoint", t, func() {
+		srv := &Server{Config: &Config{
+			RtpPortMin: 41000,
+			RtpPortMax: 41099,
+			Mounts: []*MountConfig{
+				{Path: "/live", Source: writeTestH264(t), FrameRate: 100},
+				{Path: "/ingest"},
+			},
+		}}
+		url := serveTest(srv)
+		ts := httptest.NewServer(srv.admin)
+		defer ts.Close()
+
+		scrape := func() string {
+			resp, err := http.Get(ts.URL + "/metrics")
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.Header.Get("Content-Type"), ShouldStartWith, "text/plain")
+			body, err := ioutil.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			return string(body)
+		}
+
+		viewer, err := client.Dial(url+"/live", client.Options{})
+		So(err, ShouldBeNil)
+		defer viewer.Close()
+		_, err = viewer.Describe()
+		So(err, ShouldBeNil)
+		So(viewer.SetupAll(), ShouldBeNil)
+		_, err = viewer.Play(nil)
+		So(err, ShouldBeNil)
+		<-viewer.Packets()
+
+		announced := sdp.NewSDP("test")
+		m := sdp.NewMedia("video", 96)
+		m.AddAttribute("rtpmap:96 H264/90000")
+		m.AddAttribute("control:trackID=0")
+		announced.AddMedia(m)
+		pub, err := client.Dial(url+"/ingest", client.Options{Transport: client.TransportTCP})
+		So(err, ShouldBeNil)
+		defer pub.Close()
+		So(pub.Announce(announced), ShouldBeNil)
+		So(pub.SetupAll(), ShouldBeNil)
+		_, err = pub.Record()
+		So(err, ShouldBeNil)
+
+		body := scrape()
+		So(body, ShouldContainSubstring, "# TYPE drs_connections gauge\ndrs_connections 2\n")
+		So(body, ShouldContainSubstring, "drs_sessions{state=\"PLAYING\"} 1\n")
+		So(body, ShouldContainSubstring, "drs_sessions{state=\"RECORDING\"} 1\n")
+		So(body, ShouldContainSubstring, "drs_requests_total{method=\"PLAY\",code=\"200\"} 1\n")
+		So(body, ShouldContainSubstring, "drs_requests_total{method=\"SETUP\",code=\"200\"} 2\n")
+		So(body, ShouldContainSubstring, "drs_sent_packets_total{path=\"/live\"}")
+		So(body, ShouldContainSubstring, "drs_sent_bytes_total{path=\"/live\"}")
+		So(body, ShouldContainSubstring, "drs_publishers 1\n")
+		So(body, ShouldContainSubstring, "drs_viewers{path=\"/live\"} 1\n")
+		// viewer使用UDP，占用一对端口
+		So(body, ShouldContainSubstring, "drs_rtp_ports_used 1\n")
+		So(body, ShouldContainSubstring, "drs_rtp_ports_total 50\n")
+		So(body, ShouldContainSubstring, "drs_auth_failures_total 0\n")
+
+		// 断开后连接和端口释放
+		viewer.Close()
+		pub.Close()
+		deadline := time.Now().Add(5 * time.Second)
+		for !strings.Contains(body, "drs_connections 0\n") && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			body = scrape()
+		}
+		So(body, ShouldContainSubstring, "drs_connections 0\n")
+		So(body, ShouldContainSubstring, "drs_rtp_ports_used 0\n")
+		So(body, ShouldNotContainSubstring, "drs_viewers{")
+	})
+}
@@ -0,0 +1,272 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/rtcp"
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/rtsp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+)
+
+// MulticastAllocator 从地址池中分配组播地址
+type MulticastAllocator struct {
+	base  uint32
+	total uint32
+	next  uint32
+
+	mu   sync.Mutex
+	used map[uint32]bool
+}
+
+// NewMulticastAllocator cidr需在224.0.0.0/4内
+func NewMulticastAllocator(cidr string) (*MulticastAllocator, error) {
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	if ip.To4() == nil || !ip.IsMulticast() {
+		return nil, fmt.Errorf("%s is not an ipv4 multicast range", cidr)
+	}
+
+	ones, bits := network.Mask.Size()
+	return &MulticastAllocator{
+		base:  binary.BigEndian.Uint32(network.IP.To4()),
+		total: 1 << uint(bits-ones),
+		used:  make(map[uint32]bool),
+	}, nil
+}
+
+func (a *MulticastAllocator) Alloc() (net.IP, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for i := uint32(0); i < a.total; i++ {
+		offset := a.next
+		a.next = (a.next + 1) % a.total
+		if a.used[offset] {
+			continue
+		}
+
+		a.used[offset] = true
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, a.base+offset)
+		return ip, nil
+	}
+	return nil, fmt.Errorf("no available multicast address")
+}
+
+func (a *MulticastAllocator) Release(ip net.IP) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if ip4 := ip.To4(); ip4 != nil {
+		delete(a.used, binary.BigEndian.Uint32(ip4)-a.base)
+	}
+}
+
+// multicastGroup 一个stream的组播发送，第一个组播客户端PLAY时开始发送，最后一个离开时停止
+type multicastGroup struct {
+	stream *Stream
+	ip     net.IP
+	port   int
+	ttl    int
+	conn   *net.UDPConn
+	// 由server设置，统计发送的数据
+	metrics *metrics
+
+	mu    sync.Mutex
+	ssrcs map[int]uint32
+	stats map[int]*senderStats
+
+	viewersMu sync.Mutex
+	viewers   map[*RtspServerSession]struct{}
+	// 有客户端时定时发送RTCP SR，最后一个客户端离开时关闭
+	stopReports chan struct{}
+}
+
+func newMulticastGroup(stream *Stream, ip net.IP, port, ttl int) (*multicastGroup, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := setMulticastTTL(conn, ttl); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &multicastGroup{
+		stream:  stream,
+		ip:      ip,
+		port:    port,
+		ttl:     ttl,
+		conn:    conn,
+		ssrcs:   make(map[int]uint32),
+		stats:   make(map[int]*senderStats),
+		viewers: make(map[*RtspServerSession]struct{}),
+	}, nil
+}
+
+// addr track的RTP地址，RTCP为端口+1
+func (g *multicastGroup) addr(track int) *net.UDPAddr {
+	return &net.UDPAddr{IP: g.ip, Port: g.port + 2*track}
+}
+
+// ssrc 所有组播客户端看到相同的ssrc
+func (g *multicastGroup) ssrc(track int) uint32 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ssrc, ok := g.ssrcs[track]
+	if !ok {
+		ssrc = rand.Uint32()
+		g.ssrcs[track] = ssrc
+	}
+	return ssrc
+}
+
+// setup 填充SETUP response的transport
+func (g *multicastGroup) setup(track int, item *rtsp.TransportItem) {
+	addr := g.addr(track)
+	item.Destination = g.ip.String()
+	item.Port1, item.Port2 = addr.Port, addr.Port+1
+	item.Ttl = g.ttl
+	item.ClientPort1, item.ClientPort2 = 0, 0
+	item.ServerPort1, item.ServerPort2 = 0, 0
+	item.Ssrc = fmt.Sprintf("%08x", g.ssrc(track))
+}
+
+// describe 复制sdp，连接地址改为组播地址，media端口改为组播端口
+func (g *multicastGroup) describe(s *sdp.SDPImpl) *sdp.SDPImpl {
+	ret := &sdp.SDPImpl{}
+	if err := ret.Parse(s.Gen()); err != nil {
+		return s
+	}
+
+	ret.S.Item['c'] = [][]byte{[]byte(fmt.Sprintf("IN IP4 %s/%d", g.ip, g.ttl))}
+	for i, m := range ret.Ms {
+		delete(m.Item, 'c')
+		// m=<media> <port> <proto> <fmt>
+		if v, ok := m.Item['m']; ok {
+			parts := strings.Split(string(v[0]), " ")
+			if len(parts) >= 4 {
+				parts[1] = strconv.Itoa(g.addr(i).Port)
+				m.Item['m'] = [][]byte{[]byte(strings.Join(parts, " "))}
+			}
+		}
+	}
+	return ret
+}
+
+// addViewer 第一个客户端加入时开始从stream读取
+func (g *multicastGroup) addViewer(rss *RtspServerSession) error {
+	g.viewersMu.Lock()
+	defer g.viewersMu.Unlock()
+
+	if _, ok := g.viewers[rss]; ok {
+		return nil
+	}
+	if len(g.viewers) == 0 {
+		if err := g.stream.AddReader(g); err != nil {
+			return err
+		}
+		g.stopReports = make(chan struct{})
+		go g.sendReports(g.stopReports)
+	}
+	g.viewers[rss] = struct{}{}
+	return nil
+}
+
+// removeViewer 最后一个客户端离开时停止发送
+func (g *multicastGroup) removeViewer(rss *RtspServerSession) {
+	g.viewersMu.Lock()
+	defer g.viewersMu.Unlock()
+
+	if _, ok := g.viewers[rss]; !ok {
+		return
+	}
+	delete(g.viewers, rss)
+	if len(g.viewers) == 0 {
+		g.stream.RemoveReader(g)
+		close(g.stopReports)
+	}
+}
+
+func (g *multicastGroup) writePacket(track int, pkt *rtp.Packet) {
+	p := pkt.Clone()
+	p.SSRC = g.ssrc(track)
+
+	g.mu.Lock()
+	stats, ok := g.stats[track]
+	if !ok {
+		stats = &senderStats{}
+		g.stats[track] = stats
+	}
+	stats.sent(p)
+	g.mu.Unlock()
+
+	data := p.Marshal()
+	g.conn.WriteToUDP(data, g.addr(track))
+	g.metrics.sent(g.stream.Path, len(data))
+}
+
+// sendReports 定时向每个track的RTCP端口发送SR
+func (g *multicastGroup) sendReports(stop chan struct{}) {
+	ticker := time.NewTicker(senderReportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+
+		g.mu.Lock()
+		tracks := make([]int, 0, len(g.stats))
+		for track := range g.stats {
+			tracks = append(tracks, track)
+		}
+		g.mu.Unlock()
+
+		// 不能在持有锁时访问stream，stream分发数据时会获取group的锁
+		now := time.Now()
+		for _, track := range tracks {
+			rtptime, ok := g.stream.RTPTime(track, now)
+			if !ok {
+				continue
+			}
+			g.mu.Lock()
+			sr := g.stats[track].report(g.ssrcs[track], rtptime, now)
+			g.mu.Unlock()
+
+			addr := g.addr(track)
+			addr.Port++
+			g.conn.WriteToUDP(sr.Marshal(), addr)
+		}
+	}
+}
+
+// goodbye 服务关闭时向每个track的RTCP端口发送BYE
+func (g *multicastGroup) goodbye() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for track, ssrc := range g.ssrcs {
+		addr := g.addr(track)
+		addr.Port++
+		g.conn.WriteToUDP((&rtcp.Goodbye{Sources: []uint32{ssrc}, Reason: "server shutdown"}).Marshal(), addr)
+	}
+}
+
+// close 所有客户端离开后调用
+func (g *multicastGroup) close() {
+	g.conn.Close()
+}
@@ -0,0 +1,94 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/client"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMulticastAllocator(t *testing.T) {
+	Convey("test multicast allocator", t, func() {
+		_, err := NewMulticastAllocator("10.0.0.0/8")
+		So(err, ShouldNotBeNil)
+
+		a, err := NewMulticastAllocator("239.1.1.0/31")
+		So(err, ShouldBeNil)
+		ip1, err := a.Alloc()
+		So(err, ShouldBeNil)
+		So(ip1.String(), ShouldEqual, "239.1.1.0")
+		ip2, err := a.Alloc()
+		So(err, ShouldBeNil)
+		So(ip2.String(), ShouldEqual, "239.1.1.1")
+		_, err = a.Alloc()
+		So(err, ShouldNotBeNil)
+
+		a.Release(ip1)
+		ip3, err := a.Alloc()
+		So(err, ShouldBeNil)
+		So(ip3.String(), ShouldEqual, "239.1.1.0")
+	})
+}
+
+func TestMulticast(t *testing.T) {
+	file := writeTestH264(t)
+
+	Convey("test multicast viewers share one group", t, func() {
+		srv := &Server{Config: &Config{
+			Multicast: MulticastConfig{Range: "239.255.43.0/24", Port: 45004},
+			Mounts:    []*MountConfig{{Path: "/live", Source: file, FrameRate: 100, Multicast: true}},
+		}}
+		url := serveTest(srv)
+
+		play := func(transport string) *client.Client {
+			c, err := client.Dial(url+"/live", client.Options{Transport: transport})
+			So(err, ShouldBeNil)
+			s, err := c.Describe()
+			So(err, ShouldBeNil)
+			So(string(s.Gen()), ShouldContainSubstring, "c=IN IP4 239.255.43.0/16")
+			So(string(s.Gen()), ShouldContainSubstring, "m=video 45004 RTP/AVP 96")
+			So(c.SetupAll(), ShouldBeNil)
+			_, err = c.Play(nil)
+			So(err, ShouldBeNil)
+
+			select {
+			case p := <-c.Packets():
+				So(p.Packet.PayloadType, ShouldEqual, 96)
+			case <-time.After(5 * time.Second):
+				So("timeout", ShouldBeEmpty)
+			}
+			return c
+		}
+
+		c1 := play(client.TransportMulticast)
+		c2 := play(client.TransportMulticast)
+		// 组播只占用一个reader
+		So(upstreamReaders(srv), ShouldEqual, 1)
+
+		c3 := play(client.TransportUDP)
+		So(upstreamReaders(srv), ShouldEqual, 2)
+		c3.Close()
+
+		c1.Close()
+		So(upstreamReaders(srv), ShouldEqual, 1)
+		c2.Close()
+		So(upstreamReaders(srv), ShouldEqual, 0)
+	})
+
+	Convey("test multicast is rejected when not enabled", t, func() {
+		srv := &Server{Config: &Config{
+			Mounts: []*MountConfig{{Path: "/live", Source: file, FrameRate: 100}},
+		}}
+		url := serveTest(srv)
+
+		c, err := client.Dial(url+"/live", client.Options{Transport: client.TransportMulticast})
+		So(err, ShouldBeNil)
+		defer c.Close()
+		_, err = c.Describe()
+		So(err, ShouldBeNil)
+		err = c.SetupAll()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "461")
+	})
+}
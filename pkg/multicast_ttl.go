@@ -0,0 +1,26 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package pkg
+
+import (
+	"net"
+	"syscall"
+)
+
+// setMulticastTTL 设置IP_MULTICAST_TTL
+func setMulticastTTL(conn *net.UDPConn, ttl int) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, ttl)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,11 @@
+//go:build windows || plan9 || js
+// +build windows plan9 js
+
+package pkg
+
+import "net"
+
+// setMulticastTTL 不支持的平台使用系统默认的TTL
+func setMulticastTTL(conn *net.UDPConn, ttl int) error {
+	return nil
+}
@@ -0,0 +1,104 @@
+package pkg
+
+import (
+	"sync"
+
+	"github.com/Lcmasdf/drs/pkg/fmp4"
+	"github.com/Lcmasdf/drs/pkg/rtp"
+)
+
+type muxPacket struct {
+	track int
+	pkt   *rtp.Packet
+}
+
+// muxer 作为stream的reader将RTP包转换为fMP4 fragment
+// handler在单独的goroutine中调用，stop后handler收到剩余的fragment及nil
+type muxer struct {
+	stream  *Stream
+	fmp4    *fmp4.Muxer
+	handler func(f *fmp4.Fragment) error
+
+	packets   chan muxPacket
+	done      chan struct{}
+	closeOnce sync.Once
+	finished  chan struct{}
+}
+
+func newMuxer(stream *Stream, handler func(f *fmp4.Fragment) error) *muxer {
+	return &muxer{
+		stream:   stream,
+		handler:  handler,
+		packets:  make(chan muxPacket, 1024),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+}
+
+func (m *muxer) start() error {
+	s, err := m.stream.Describe()
+	if err != nil {
+		return err
+	}
+	if m.fmp4, err = fmp4.NewMuxer(s); err != nil {
+		return err
+	}
+
+	if err := m.stream.AddReader(m); err != nil {
+		return err
+	}
+	go m.run()
+	return nil
+}
+
+// stop 停止接收数据并等待handler处理完成
+func (m *muxer) stop() {
+	m.closeOnce.Do(func() {
+		m.stream.RemoveReader(m)
+		close(m.done)
+	})
+	<-m.finished
+}
+
+// Init 初始化段，start成功后可用
+func (m *muxer) Init() []byte {
+	return m.fmp4.Init()
+}
+
+func (m *muxer) writePacket(track int, pkt *rtp.Packet) {
+	select {
+	case m.packets <- muxPacket{track: track, pkt: pkt}:
+	case <-m.done:
+	default:
+		m.stream.log.Warn("mux drop packet", "track", track)
+	}
+}
+
+func (m *muxer) run() {
+	defer close(m.finished)
+
+	for {
+		select {
+		case p := <-m.packets:
+			frags, err := m.fmp4.WritePacket(p.track, p.pkt)
+			if err != nil {
+				m.stream.log.Warn("mux failed", "track", p.track, "err", err)
+				continue
+			}
+			for _, f := range frags {
+				if err := m.handler(f); err != nil {
+					m.stream.log.Error("handle fragment failed", "err", err)
+					m.stream.RemoveReader(m)
+					m.handler(nil)
+					return
+				}
+			}
+		case <-m.done:
+			if f := m.fmp4.Flush(); f != nil {
+				m.handler(f)
+			}
+			m.handler(nil)
+			return
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// PortAllocator 分配RTP/RTCP端口对，RTP为偶数端口，RTCP为RTP+1
+type PortAllocator struct {
+	min  int
+	max  int
+	next int
+
+	mu   sync.Mutex
+	used map[int]bool
+}
+
+func NewPortAllocator(min, max int) *PortAllocator {
+	if min%2 != 0 {
+		min++
+	}
+	return &PortAllocator{
+		min:  min,
+		max:  max,
+		next: min,
+		used: make(map[int]bool),
+	}
+}
+
+// Alloc 分配一对端口并监听，返回RTP端口
+func (p *PortAllocator) Alloc() (int, *net.UDPConn, *net.UDPConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	total := p.Size()
+	for i := 0; i < total; i++ {
+		port := p.next
+		p.next += 2
+		if p.next+1 > p.max {
+			p.next = p.min
+		}
+
+		if p.used[port] {
+			continue
+		}
+
+		rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+		if err != nil {
+			continue
+		}
+		rtcpConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port + 1})
+		if err != nil {
+			rtpConn.Close()
+			continue
+		}
+
+		p.used[port] = true
+		return port, rtpConn, rtcpConn, nil
+	}
+
+	return 0, nil, nil, fmt.Errorf("no available port in %d-%d", p.min, p.max)
+}
+
+func (p *PortAllocator) Release(port int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.used, port)
+}
+
+// Used 已分配的端口对数量
+func (p *PortAllocator) Used() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.used)
+}
+
+// Size 可分配的端口对数量
+func (p *PortAllocator) Size() int {
+	return (p.max - p.min + 1) / 2
+}
@@ -0,0 +1,206 @@
+package pkg
+
+import (
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/client"
+	"github.com/Lcmasdf/drs/pkg/rtp"
+)
+
+const (
+	pushRetryMin = time.Second
+	pushRetryMax = 30 * time.Second
+)
+
+const (
+	PushStateConnecting = "connecting"
+	PushStatePushing    = "pushing"
+	PushStateRetrying   = "retrying"
+	PushStateStopped    = "stopped"
+)
+
+// PushStatus 一个推流目标的状态
+type PushStatus struct {
+	Path string `json:"path"`
+	// 不含密码
+	URL   string `json:"url"`
+	State string `json:"state"`
+	// 最近一次失败的原因
+	Error string `json:"error,omitempty"`
+	// 进入当前状态的时间
+	Since   time.Time `json:"since"`
+	Retries int       `json:"retries"`
+	Packets uint64    `json:"packets"`
+	Bytes   uint64    `json:"bytes"`
+}
+
+// pusher 作为stream的reader转推到远端，不影响本地的reader
+type pusher struct {
+	stream *Stream
+	conf   *PushConfig
+
+	packets  chan muxPacket
+	done     chan struct{}
+	finished chan struct{}
+
+	mu     sync.Mutex
+	status PushStatus
+}
+
+func newPusher(stream *Stream, conf *PushConfig) *pusher {
+	redacted := conf.URL
+	if u, err := url.Parse(conf.URL); err == nil {
+		redacted = u.Redacted()
+	}
+
+	return &pusher{
+		stream:   stream,
+		conf:     conf,
+		packets:  make(chan muxPacket, 1024),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+		status: PushStatus{
+			Path:  stream.Path,
+			URL:   redacted,
+			State: PushStateConnecting,
+			Since: time.Now(),
+		},
+	}
+}
+
+func (p *pusher) start() {
+	go p.run()
+}
+
+// stop 断开连接并等待退出
+func (p *pusher) stop() {
+	close(p.done)
+	<-p.finished
+}
+
+func (p *pusher) Status() PushStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.status
+}
+
+func (p *pusher) setState(state string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.status.State != state {
+		p.status.State = state
+		p.status.Since = time.Now()
+	}
+	if err != nil {
+		p.status.Error = err.Error()
+	}
+}
+
+func (p *pusher) writePacket(track int, pkt *rtp.Packet) {
+	select {
+	case p.packets <- muxPacket{track: track, pkt: pkt}:
+	default:
+	}
+}
+
+func (p *pusher) run() {
+	defer close(p.finished)
+
+	retry := pushRetryMin
+	for {
+		pushed, err := p.push()
+		select {
+		case <-p.done:
+			p.setState(PushStateStopped, nil)
+			return
+		default:
+		}
+
+		p.stream.log.Warn("push failed", "url", p.status.URL, "err", err)
+		p.setState(PushStateRetrying, err)
+		p.mu.Lock()
+		p.status.Retries++
+		p.mu.Unlock()
+
+		// 推流成功过则重新开始退避
+		if pushed {
+			retry = pushRetryMin
+		}
+		select {
+		case <-time.After(retry):
+		case <-p.done:
+			p.setState(PushStateStopped, nil)
+			return
+		}
+		if retry *= 2; retry > pushRetryMax {
+			retry = pushRetryMax
+		}
+	}
+}
+
+// push 连接远端并转发数据，返回是否进入过推流状态
+func (p *pusher) push() (bool, error) {
+	p.setState(PushStateConnecting, nil)
+
+	// 点播的stream单独读取
+	stream := p.stream
+	s, err := stream.Describe()
+	if err != nil {
+		return false, err
+	}
+	if stream.VOD() {
+		stream = stream.Fork()
+		defer stream.Close()
+	}
+
+	transport := p.conf.Transport
+	if transport == "" {
+		transport = client.TransportTCP
+	}
+	c, err := client.Dial(p.conf.URL, client.Options{Transport: transport})
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+
+	if err := c.Announce(s); err != nil {
+		return false, err
+	}
+	if err := c.SetupAll(); err != nil {
+		return false, err
+	}
+	if _, err := c.Record(); err != nil {
+		return false, err
+	}
+
+	// 丢弃上一次连接剩余的数据
+	for len(p.packets) > 0 {
+		<-p.packets
+	}
+	if err := stream.AddReader(p); err != nil {
+		return false, err
+	}
+	defer stream.RemoveReader(p)
+
+	p.setState(PushStatePushing, nil)
+	for {
+		select {
+		case mp := <-p.packets:
+			if err := c.WritePacket(mp.track, mp.pkt); err != nil {
+				return true, err
+			}
+			p.mu.Lock()
+			p.status.Packets++
+			p.status.Bytes += uint64(len(mp.pkt.Payload))
+			p.mu.Unlock()
+		case <-c.Done():
+			return true, c.Err()
+		case <-p.done:
+			return true, nil
+		}
+	}
+}
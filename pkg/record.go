@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/fmp4"
+)
+
+// 文件名中{start}的格式
+const recordTimeLayout = "20060102-150405"
+
+// recorder 将stream写入fMP4文件
+type recorder struct {
+	stream *Stream
+	conf   *RecordConfig
+	muxer  *muxer
+
+	file      *os.File
+	fileStart time.Duration
+	fileSize  int64
+}
+
+func newRecorder(stream *Stream, conf *RecordConfig) *recorder {
+	ret := &recorder{
+		stream: stream,
+		conf:   conf,
+	}
+	ret.muxer = newMuxer(stream, ret.writeFragment)
+	return ret
+}
+
+func (r *recorder) start() error {
+	return r.muxer.start()
+}
+
+// stop 停止录制并等待文件写完
+func (r *recorder) stop() {
+	r.muxer.stop()
+}
+
+// writeFragment f为nil表示录制结束
+func (r *recorder) writeFragment(f *fmp4.Fragment) error {
+	if f == nil {
+		r.closeFile()
+		return nil
+	}
+
+	if f.KeyFrame && r.needRotate(f) {
+		r.closeFile()
+		if err := r.openFile(f); err != nil {
+			return err
+		}
+	}
+	if r.file == nil {
+		return nil
+	}
+
+	n, err := r.file.Write(f.Data)
+	r.fileSize += int64(n)
+	return err
+}
+
+func (r *recorder) needRotate(f *fmp4.Fragment) bool {
+	if r.file == nil {
+		return true
+	}
+	if r.conf.SegmentDuration > 0 && f.Start-r.fileStart >= time.Duration(r.conf.SegmentDuration)*time.Second {
+		return true
+	}
+	return r.conf.SegmentSize > 0 && r.fileSize >= r.conf.SegmentSize
+}
+
+func (r *recorder) openFile(f *fmp4.Fragment) error {
+	name := genRecordPath(r.conf.Path, r.stream.Path, time.Now())
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	// 同一秒内切分时文件名相同
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	n, err := file.Write(r.muxer.Init())
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.stream.log.Info("record", "file", name)
+	r.file = file
+	r.fileStart = f.Start
+	r.fileSize = int64(n)
+	return nil
+}
+
+func (r *recorder) closeFile() {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
+
+// genRecordPath 替换模板中的{path}和{start}
+func genRecordPath(template, path string, start time.Time) string {
+	return strings.NewReplacer(
+		"{path}", strings.Trim(path, "/"),
+		"{start}", start.Format(recordTimeLayout),
+	).Replace(template)
+}
@@ -0,0 +1,113 @@
+package pkg
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeSource 每隔10ms产生一帧H264，每5帧一个关键帧
+type fakeSource struct {
+	packetizer *rtp.Packetizer
+	frames     int
+	closed     chan struct{}
+}
+
+func newFakeSource() *fakeSource {
+	return &fakeSource{
+		packetizer: rtp.NewPacketizer(96, 90000, &rtp.H264Payloader{}),
+		closed:     make(chan struct{}),
+	}
+}
+
+func (s *fakeSource) SDP() *sdp.SDPImpl {
+	ret := sdp.NewSDP("test")
+	m := sdp.NewMedia("video", 96)
+	m.AddAttribute("control:trackID=0")
+	m.AddAttribute("rtpmap:96 H264/90000")
+	m.AddAttribute("fmtp:96 packetization-mode=1;sprop-parameter-sets=Z2QAH6w=,aO48gA==")
+	ret.AddMedia(m)
+	return ret
+}
+
+func (s *fakeSource) ReadPacket() (int, *rtp.Packet, error) {
+	select {
+	case <-s.closed:
+		return 0, nil, io.EOF
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	nalu := []byte{0x41, byte(s.frames)}
+	if s.frames%5 == 0 {
+		nalu = []byte{0x65, byte(s.frames)}
+	}
+	pkts := s.packetizer.Packetize([][]byte{nalu}, uint32(s.frames*3000))
+	s.frames++
+	return 0, pkts[0], nil
+}
+
+func (s *fakeSource) Close() error {
+	select {
+	case <-s.closed:
+	default:
+		close(s.closed)
+	}
+	return nil
+}
+
+func TestRecord(t *testing.T) {
+	Convey("test record path template", t, func() {
+		start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		So(genRecordPath("rec/{path}/{start}.mp4", "/live/cam1", start), ShouldEqual, "rec/live/cam1/20200102-030405.mp4")
+	})
+
+	Convey("test record stream to rotated fmp4 files", t, func() {
+		dir := t.TempDir()
+		srv := &Server{Config: &Config{
+			Record: RecordConfig{
+				Path:        filepath.Join(dir, "{path}", "{start}.mp4"),
+				SegmentSize: 1,
+			},
+			Mounts: []*MountConfig{{Path: "/live"}},
+		}}
+		srv.init()
+
+		stream, _, ok := srv.findStream("/live")
+		So(ok, ShouldBeTrue)
+		src := newFakeSource()
+		stream.source = src
+		stream.sdp = src.SDP()
+
+		So(srv.StartRecording("/live"), ShouldBeNil)
+		So(srv.IsRecording("/live"), ShouldBeTrue)
+		So(srv.StartRecording("/live"), ShouldNotBeNil)
+
+		time.Sleep(200 * time.Millisecond)
+		So(srv.StopRecording("/live"), ShouldBeNil)
+		So(srv.IsRecording("/live"), ShouldBeFalse)
+		So(srv.StopRecording("/live"), ShouldNotBeNil)
+
+		// 每个GOP一个文件
+		files, err := filepath.Glob(filepath.Join(dir, "live", "*.mp4"))
+		So(err, ShouldBeNil)
+		So(len(files), ShouldBeGreaterThanOrEqualTo, 2)
+
+		data, err := ioutil.ReadFile(files[0])
+		So(err, ShouldBeNil)
+		So(string(data[4:8]), ShouldEqual, "ftyp")
+		So(bytes.Contains(data, []byte("moof")), ShouldBeTrue)
+	})
+
+	Convey("test record unknown stream", t, func() {
+		srv := &Server{}
+		srv.init()
+		So(srv.StartRecording("/nope"), ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,289 @@
+package rtcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+const (
+	headerLength = 4
+	version      = 2
+)
+
+// RFC3550 / RFC4585 packet type
+const (
+	TypeSR    = 200
+	TypeRR    = 201
+	TypeSDES  = 202
+	TypeBYE   = 203
+	TypeAPP   = 204
+	TypeRTPFB = 205
+	TypePSFB  = 206
+)
+
+// 反馈包的FMT
+const (
+	FormatNACK = 1
+	FormatPLI  = 1
+	FormatFIR  = 4
+)
+
+// Packet compound RTCP中的一个包
+type Packet interface {
+	Marshal() []byte
+}
+
+// SenderReport RFC3550 6.4.1，不包含reception report
+type SenderReport struct {
+	SSRC uint32
+	// NTP时间戳，高32位为1900年起的秒数
+	NTPTime     uint64
+	RTPTime     uint32
+	PacketCount uint32
+	OctetCount  uint32
+}
+
+// NTP时间从1900年开始
+const ntpEpochOffset = 2208988800
+
+// NTPTime 墙上时钟转换为64位NTP时间戳
+func NTPTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+// Time NTP时间戳转换为墙上时钟
+func Time(ntp uint64) time.Time {
+	seconds := int64(ntp>>32) - ntpEpochOffset
+	nanos := int64((ntp & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(seconds, nanos)
+}
+
+// Goodbye RFC3550 6.6 BYE，发送端停止发送
+type Goodbye struct {
+	Sources []uint32
+	// 可选，最长255字节
+	Reason string
+}
+
+// NACK RFC4585 6.2.1 Generic NACK
+type NACK struct {
+	SenderSSRC uint32
+	MediaSSRC  uint32
+	Lost       []uint16
+}
+
+// PLI RFC4585 6.3.1 Picture Loss Indication
+type PLI struct {
+	SenderSSRC uint32
+	MediaSSRC  uint32
+}
+
+// FIR RFC5104 4.3.1 Full Intra Request
+type FIR struct {
+	SenderSSRC uint32
+	Entries    []FIREntry
+}
+
+type FIREntry struct {
+	SSRC uint32
+	Seq  uint8
+}
+
+// Unmarshal 解析compound RTCP，不支持的包跳过
+func Unmarshal(b []byte) ([]Packet, error) {
+	ret := make([]Packet, 0)
+	for len(b) > 0 {
+		if len(b) < headerLength {
+			return nil, fmt.Errorf("rtcp packet too short: %d", len(b))
+		}
+		if b[0]>>6 != version {
+			return nil, fmt.Errorf("invalid rtcp version: %d", b[0]>>6)
+		}
+
+		size := 4 * (int(binary.BigEndian.Uint16(b[2:])) + 1)
+		if len(b) < size {
+			return nil, fmt.Errorf("rtcp packet too short: %d < %d", len(b), size)
+		}
+		body := b[headerLength:size]
+		if b[0]&0x20 != 0 {
+			padding := int(b[size-1])
+			if padding == 0 || padding > len(body) {
+				return nil, fmt.Errorf("invalid rtcp padding %d", padding)
+			}
+			body = body[:len(body)-padding]
+		}
+
+		p, err := unmarshal(b[0]&0x1f, b[1], body)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			ret = append(ret, p)
+		}
+		b = b[size:]
+	}
+	return ret, nil
+}
+
+func unmarshal(format, typ byte, body []byte) (Packet, error) {
+	switch {
+	case typ == TypeSR:
+		if len(body) < 24 {
+			return nil, fmt.Errorf("invalid sender report")
+		}
+		return &SenderReport{
+			SSRC:        binary.BigEndian.Uint32(body),
+			NTPTime:     binary.BigEndian.Uint64(body[4:]),
+			RTPTime:     binary.BigEndian.Uint32(body[12:]),
+			PacketCount: binary.BigEndian.Uint32(body[16:]),
+			OctetCount:  binary.BigEndian.Uint32(body[20:]),
+		}, nil
+	case typ == TypeBYE:
+		count := int(format)
+		if len(body) < 4*count {
+			return nil, fmt.Errorf("invalid bye")
+		}
+		ret := &Goodbye{Sources: make([]uint32, count)}
+		for i := range ret.Sources {
+			ret.Sources[i] = binary.BigEndian.Uint32(body[4*i:])
+		}
+		if reason := body[4*count:]; len(reason) > 0 {
+			if int(reason[0]) >= len(reason) {
+				return nil, fmt.Errorf("invalid bye reason")
+			}
+			ret.Reason = string(reason[1 : 1+reason[0]])
+		}
+		return ret, nil
+	case typ == TypeRTPFB && format == FormatNACK:
+		if len(body) < 8 {
+			return nil, fmt.Errorf("invalid nack")
+		}
+		ret := &NACK{
+			SenderSSRC: binary.BigEndian.Uint32(body),
+			MediaSSRC:  binary.BigEndian.Uint32(body[4:]),
+		}
+		// PID + BLP，BLP的第i位表示PID+i+1丢失
+		for fci := body[8:]; len(fci) >= 4; fci = fci[4:] {
+			pid := binary.BigEndian.Uint16(fci)
+			blp := binary.BigEndian.Uint16(fci[2:])
+			ret.Lost = append(ret.Lost, pid)
+			for i := uint16(0); i < 16; i++ {
+				if blp&(1<<i) != 0 {
+					ret.Lost = append(ret.Lost, pid+i+1)
+				}
+			}
+		}
+		return ret, nil
+	case typ == TypePSFB && format == FormatPLI:
+		if len(body) < 8 {
+			return nil, fmt.Errorf("invalid pli")
+		}
+		return &PLI{
+			SenderSSRC: binary.BigEndian.Uint32(body),
+			MediaSSRC:  binary.BigEndian.Uint32(body[4:]),
+		}, nil
+	case typ == TypePSFB && format == FormatFIR:
+		if len(body) < 8 {
+			return nil, fmt.Errorf("invalid fir")
+		}
+		// media source SSRC不使用，每个entry为SSRC + seq + 3字节保留
+		ret := &FIR{SenderSSRC: binary.BigEndian.Uint32(body)}
+		for fci := body[8:]; len(fci) >= 8; fci = fci[8:] {
+			ret.Entries = append(ret.Entries, FIREntry{
+				SSRC: binary.BigEndian.Uint32(fci),
+				Seq:  fci[4],
+			})
+		}
+		return ret, nil
+	default:
+		return nil, nil
+	}
+}
+
+// marshalFeedback RFC4585 6.1 通用的反馈包格式
+func marshalFeedback(format, typ byte, sender, media uint32, fci []byte) []byte {
+	ret := make([]byte, 12, 12+len(fci))
+	ret[0] = version<<6 | format
+	ret[1] = typ
+	binary.BigEndian.PutUint16(ret[2:], uint16((12+len(fci))/4-1))
+	binary.BigEndian.PutUint32(ret[4:], sender)
+	binary.BigEndian.PutUint32(ret[8:], media)
+	return append(ret, fci...)
+}
+
+func (p *SenderReport) Marshal() []byte {
+	ret := make([]byte, 28)
+	ret[0] = version << 6
+	ret[1] = TypeSR
+	binary.BigEndian.PutUint16(ret[2:], 28/4-1)
+	binary.BigEndian.PutUint32(ret[4:], p.SSRC)
+	binary.BigEndian.PutUint64(ret[8:], p.NTPTime)
+	binary.BigEndian.PutUint32(ret[16:], p.RTPTime)
+	binary.BigEndian.PutUint32(ret[20:], p.PacketCount)
+	binary.BigEndian.PutUint32(ret[24:], p.OctetCount)
+	return ret
+}
+
+// Marshal 最多31个SSRC，reason按4字节补齐
+func (p *Goodbye) Marshal() []byte {
+	sources := p.Sources
+	if len(sources) > 31 {
+		sources = sources[:31]
+	}
+	reason := p.Reason
+	if len(reason) > 255 {
+		reason = reason[:255]
+	}
+
+	size := 4 + 4*len(sources)
+	if reason != "" {
+		size += (1 + len(reason) + 3) / 4 * 4
+	}
+	ret := make([]byte, size)
+	ret[0] = version<<6 | byte(len(sources))
+	ret[1] = TypeBYE
+	binary.BigEndian.PutUint16(ret[2:], uint16(size/4-1))
+	for i, ssrc := range sources {
+		binary.BigEndian.PutUint32(ret[4+4*i:], ssrc)
+	}
+	if reason != "" {
+		ret[4+4*len(sources)] = byte(len(reason))
+		copy(ret[5+4*len(sources):], reason)
+	}
+	return ret
+}
+
+// Marshal 连续的序号合并到同一个PID/BLP
+func (p *NACK) Marshal() []byte {
+	fci := make([]byte, 0)
+	for i := 0; i < len(p.Lost); {
+		pid := p.Lost[i]
+		var blp uint16
+		i++
+		for ; i < len(p.Lost); i++ {
+			diff := p.Lost[i] - pid
+			if diff == 0 || diff > 16 {
+				break
+			}
+			blp |= 1 << (diff - 1)
+		}
+		fci = append(fci, byte(pid>>8), byte(pid), byte(blp>>8), byte(blp))
+	}
+	return marshalFeedback(FormatNACK, TypeRTPFB, p.SenderSSRC, p.MediaSSRC, fci)
+}
+
+func (p *PLI) Marshal() []byte {
+	return marshalFeedback(FormatPLI, TypePSFB, p.SenderSSRC, p.MediaSSRC, nil)
+}
+
+func (p *FIR) Marshal() []byte {
+	fci := make([]byte, 8*len(p.Entries))
+	for i, e := range p.Entries {
+		binary.BigEndian.PutUint32(fci[8*i:], e.SSRC)
+		fci[8*i+4] = e.Seq
+	}
+	return marshalFeedback(FormatFIR, TypePSFB, p.SenderSSRC, 0, fci)
+}
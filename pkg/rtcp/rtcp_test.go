@@ -0,0 +1,69 @@
+package rtcp
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFeedback(t *testing.T) {
+	Convey("test generic nack", t, func() {
+		nack := &NACK{SenderSSRC: 1, MediaSSRC: 0x703342ee, Lost: []uint16{100, 101, 116, 117, 65535, 0}}
+		b := nack.Marshal()
+		// 100 + BLP(101, 116)，117 + BLP(无)，65535 + BLP(0)
+		So(len(b), ShouldEqual, 12+3*4)
+		So(b[0], ShouldEqual, 0x81)
+		So(b[1], ShouldEqual, TypeRTPFB)
+		So(b[12:16], ShouldResemble, []byte{0, 100, 0x80, 0x01})
+
+		pkts, err := Unmarshal(b)
+		So(err, ShouldBeNil)
+		So(pkts, ShouldHaveLength, 1)
+		So(pkts[0], ShouldResemble, nack)
+	})
+
+	Convey("test compound pli and fir", t, func() {
+		pli := &PLI{SenderSSRC: 1, MediaSSRC: 2}
+		fir := &FIR{SenderSSRC: 1, Entries: []FIREntry{{SSRC: 2, Seq: 7}}}
+		// 前面是一个receiver report，不支持的包跳过
+		rr := []byte{0x80, TypeRR, 0, 1, 0, 0, 0, 1}
+		b := append(append(rr, pli.Marshal()...), fir.Marshal()...)
+
+		pkts, err := Unmarshal(b)
+		So(err, ShouldBeNil)
+		So(pkts, ShouldResemble, []Packet{pli, fir})
+	})
+
+	Convey("test sender report", t, func() {
+		now := time.Date(2026, 10, 19, 7, 0, 0, 500000000, time.UTC)
+		ntp := NTPTime(now)
+		So(ntp>>32, ShouldEqual, now.Unix()+2208988800)
+		So(uint32(ntp), ShouldEqual, 1<<31)
+		So(Time(ntp).Equal(now), ShouldBeTrue)
+
+		sr := &SenderReport{SSRC: 1, NTPTime: ntp, RTPTime: 90000, PacketCount: 10, OctetCount: 1000}
+		b := sr.Marshal()
+		So(len(b), ShouldEqual, 28)
+		pkts, err := Unmarshal(b)
+		So(err, ShouldBeNil)
+		So(pkts, ShouldResemble, []Packet{sr})
+	})
+
+	Convey("test goodbye", t, func() {
+		bye := &Goodbye{Sources: []uint32{1, 2}, Reason: "shutdown"}
+		b := bye.Marshal()
+		// 4 + 8 + (1+8)补齐到12
+		So(len(b), ShouldEqual, 24)
+		pkts, err := Unmarshal(append(b, (&Goodbye{Sources: []uint32{3}}).Marshal()...))
+		So(err, ShouldBeNil)
+		So(pkts, ShouldResemble, []Packet{bye, &Goodbye{Sources: []uint32{3}}})
+	})
+
+	Convey("test invalid rtcp", t, func() {
+		_, err := Unmarshal([]byte{0x80, TypeRR, 0, 4, 0, 0, 0, 1})
+		So(err, ShouldNotBeNil)
+		_, err = Unmarshal([]byte{0x00, TypeRR, 0, 0})
+		So(err, ShouldNotBeNil)
+	})
+}
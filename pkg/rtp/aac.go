@@ -0,0 +1,62 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// RFC3640 mpeg4-generic, mode=AAC-hbr
+// sizelength=13;indexlength=3;indexdeltalength=3
+type AACPayloader struct{}
+
+func (p *AACPayloader) Payload(mtu int, au []byte) [][]byte {
+	if len(au) == 0 || len(au) >= 1<<13 {
+		return nil
+	}
+
+	// AU-headers-length(16bit) + 一个AU-header(16bit)
+	payload := make([]byte, 4+len(au))
+	payload[0] = 0
+	payload[1] = 16
+	payload[2] = byte(len(au) >> 5)
+	payload[3] = byte(len(au)<<3) & 0xf8
+	copy(payload[4:], au)
+
+	return [][]byte{payload}
+}
+
+// 每个AAC帧的采样数
+const aacFrameSamples = 1024
+
+// AACDepacketizer mode=AAC-hbr，每个AU返回一帧，不支持分片的AU
+type AACDepacketizer struct{}
+
+func (d *AACDepacketizer) Depacketize(pkt *Packet) ([]*Frame, error) {
+	p := pkt.Payload
+	if len(p) < 2 {
+		return nil, fmt.Errorf("invalid aac payload")
+	}
+
+	// AU-headers-length 单位为bit，每个AU-header 16bit
+	headersLen := (int(binary.BigEndian.Uint16(p)) + 7) / 8
+	p = p[2:]
+	if headersLen > len(p) || headersLen%2 != 0 {
+		return nil, fmt.Errorf("invalid aac AU headers")
+	}
+	headers := p[:headersLen]
+	data := p[headersLen:]
+
+	ret := make([]*Frame, 0, headersLen/2)
+	for i := 0; i < headersLen/2; i++ {
+		size := int(binary.BigEndian.Uint16(headers[2*i:]) >> 3)
+		if size > len(data) {
+			return nil, fmt.Errorf("fragmented aac AU is not supported")
+		}
+		ret = append(ret, &Frame{
+			Timestamp: pkt.Timestamp + uint32(i*aacFrameSamples),
+			Units:     [][]byte{data[:size]},
+		})
+		data = data[size:]
+	}
+	return ret, nil
+}
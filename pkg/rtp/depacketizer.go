@@ -0,0 +1,66 @@
+package rtp
+
+// Frame 一帧数据，Units为该帧的编码单元(NALU, AU...)
+type Frame struct {
+	Timestamp uint32
+	Units     [][]byte
+}
+
+// Depacketizer 将RTP包还原为帧，返回已经完整的帧，帧不完整时返回nil
+type Depacketizer interface {
+	Depacketize(pkt *Packet) ([]*Frame, error)
+}
+
+// frameAssembler 按timestamp和marker将units组合为帧
+type frameAssembler struct {
+	frame *Frame
+}
+
+func (a *frameAssembler) push(pkt *Packet, units [][]byte) []*Frame {
+	var ret []*Frame
+	// marker丢失时通过timestamp变化判断帧结束
+	if a.frame != nil && a.frame.Timestamp != pkt.Timestamp {
+		ret = append(ret, a.frame)
+		a.frame = nil
+	}
+
+	if len(units) > 0 {
+		if a.frame == nil {
+			a.frame = &Frame{Timestamp: pkt.Timestamp}
+		}
+		a.frame.Units = append(a.frame.Units, units...)
+	}
+
+	if pkt.Marker && a.frame != nil {
+		ret = append(ret, a.frame)
+		a.frame = nil
+	}
+	return ret
+}
+
+// seqTracker 检测丢包
+type seqTracker struct {
+	seq     uint16
+	started bool
+}
+
+// lost 返回pkt之前是否有丢包
+func (t *seqTracker) lost(pkt *Packet) bool {
+	ret := t.started && pkt.SequenceNumber != t.seq+1
+	t.seq = pkt.SequenceNumber
+	t.started = true
+	return ret
+}
+
+// RawDepacketizer 一个包对应一帧(PCM, opus等)
+type RawDepacketizer struct{}
+
+func (d *RawDepacketizer) Depacketize(pkt *Packet) ([]*Frame, error) {
+	if len(pkt.Payload) == 0 {
+		return nil, nil
+	}
+	return []*Frame{{
+		Timestamp: pkt.Timestamp,
+		Units:     [][]byte{pkt.Payload},
+	}}, nil
+}
@@ -0,0 +1,81 @@
+package rtp
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDepacketizer(t *testing.T) {
+	Convey("test h264 packetize and depacketize", t, func() {
+		big := append([]byte{0x65}, bytes.Repeat([]byte{1, 2, 3}, 1000)...)
+		units := [][]byte{{0x67, 0x42}, {0x68, 0xce}, big}
+
+		p := NewPacketizer(96, 90000, &H264Payloader{})
+		pkts := p.Packetize(units, 3000)
+		So(len(pkts), ShouldBeGreaterThan, 3)
+
+		d := &H264Depacketizer{}
+		var frames []*Frame
+		for _, pkt := range pkts {
+			f, err := d.Depacketize(pkt)
+			So(err, ShouldBeNil)
+			frames = append(frames, f...)
+		}
+		So(len(frames), ShouldEqual, 1)
+		So(frames[0].Timestamp, ShouldEqual, 3000)
+		So(frames[0].Units, ShouldResemble, units)
+	})
+
+	Convey("test h264 STAP-A and lost FU", t, func() {
+		d := &H264Depacketizer{}
+		frames, err := d.Depacketize(&Packet{SequenceNumber: 1, Timestamp: 1,
+			Payload: []byte{0x18, 0, 2, 0x67, 0x42, 0, 2, 0x68, 0xce}})
+		So(err, ShouldBeNil)
+		So(frames, ShouldBeEmpty)
+
+		// FU-A 开始后丢包
+		frames, err = d.Depacketize(&Packet{SequenceNumber: 2, Timestamp: 1, Payload: []byte{0x7c, 0x85, 1}})
+		So(err, ShouldBeNil)
+		So(frames, ShouldBeEmpty)
+		frames, err = d.Depacketize(&Packet{SequenceNumber: 4, Timestamp: 1, Marker: true, Payload: []byte{0x7c, 0x45, 3}})
+		So(err, ShouldBeNil)
+		So(len(frames), ShouldEqual, 1)
+		So(frames[0].Units, ShouldResemble, [][]byte{{0x67, 0x42}, {0x68, 0xce}})
+	})
+
+	Convey("test h265 packetize and depacketize", t, func() {
+		big := append([]byte{0x26, 0x01}, bytes.Repeat([]byte{4, 5, 6}, 1000)...)
+		p := NewPacketizer(96, 90000, &H265Payloader{})
+		d := &H265Depacketizer{}
+
+		var frames []*Frame
+		for _, pkt := range p.Packetize([][]byte{{0x40, 0x01, 0x0c}, big}, 9000) {
+			f, err := d.Depacketize(pkt)
+			So(err, ShouldBeNil)
+			frames = append(frames, f...)
+		}
+		So(len(frames), ShouldEqual, 1)
+		So(frames[0].Units, ShouldResemble, [][]byte{{0x40, 0x01, 0x0c}, big})
+	})
+
+	Convey("test aac depacketize", t, func() {
+		p := NewPacketizer(97, 44100, &AACPayloader{})
+		pkts := p.Packetize([][]byte{{0x21, 0x22, 0x23}}, 1000)
+		So(len(pkts), ShouldEqual, 1)
+
+		d := &AACDepacketizer{}
+		frames, err := d.Depacketize(pkts[0])
+		So(err, ShouldBeNil)
+		So(len(frames), ShouldEqual, 1)
+		So(frames[0].Units[0], ShouldResemble, []byte{0x21, 0x22, 0x23})
+
+		// 两个AU
+		frames, err = d.Depacketize(&Packet{Timestamp: 1000, Payload: []byte{0, 32, 0, 8, 0, 16, 1, 2, 3}})
+		So(err, ShouldBeNil)
+		So(len(frames), ShouldEqual, 2)
+		So(frames[1].Timestamp, ShouldEqual, 2024)
+		So(frames[1].Units[0], ShouldResemble, []byte{2, 3})
+	})
+}
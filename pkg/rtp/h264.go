@@ -0,0 +1,117 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// RFC6184
+const (
+	h264NaluSTAPA = 24
+	h264NaluFUA   = 28
+)
+
+type H264Payloader struct{}
+
+func (p *H264Payloader) Payload(mtu int, nalu []byte) [][]byte {
+	if len(nalu) == 0 {
+		return nil
+	}
+
+	// Single NAL Unit Packet
+	if len(nalu) <= mtu {
+		return [][]byte{nalu}
+	}
+
+	// FU-A
+	// +---------------+---------------+
+	// |F|NRI|  Type   |S|E|R|  Type   |
+	// +---------------+---------------+
+	ret := make([][]byte, 0)
+	indicator := nalu[0]&0xe0 | h264NaluFUA
+	naluType := nalu[0] & 0x1f
+
+	data := nalu[1:]
+	maxFragment := mtu - 2
+	for start := true; len(data) > 0; start = false {
+		n := len(data)
+		if n > maxFragment {
+			n = maxFragment
+		}
+
+		header := naluType
+		if start {
+			header |= 0x80
+		}
+		if n == len(data) {
+			header |= 0x40
+		}
+
+		payload := make([]byte, 2+n)
+		payload[0] = indicator
+		payload[1] = header
+		copy(payload[2:], data[:n])
+		ret = append(ret, payload)
+
+		data = data[n:]
+	}
+	return ret
+}
+
+// H264Depacketizer 支持 Single NAL Unit, STAP-A, FU-A
+type H264Depacketizer struct {
+	assembler frameAssembler
+	seq       seqTracker
+	fu        []byte
+}
+
+func (d *H264Depacketizer) Depacketize(pkt *Packet) ([]*Frame, error) {
+	// 丢包后丢弃未完成的FU
+	if d.seq.lost(pkt) {
+		d.fu = nil
+	}
+
+	p := pkt.Payload
+	if len(p) < 1 {
+		return nil, fmt.Errorf("empty h264 payload")
+	}
+
+	var units [][]byte
+	switch p[0] & 0x1f {
+	case h264NaluSTAPA:
+		p = p[1:]
+		for len(p) > 0 {
+			if len(p) < 2 {
+				return nil, fmt.Errorf("invalid h264 STAP-A")
+			}
+			l := int(binary.BigEndian.Uint16(p))
+			p = p[2:]
+			if l > len(p) {
+				return nil, fmt.Errorf("invalid h264 STAP-A")
+			}
+			if l > 0 {
+				units = append(units, p[:l])
+			}
+			p = p[l:]
+		}
+	case h264NaluFUA:
+		if len(p) < 2 {
+			return nil, fmt.Errorf("invalid h264 FU-A")
+		}
+		if p[1]&0x80 != 0 {
+			d.fu = append([]byte{p[0]&0xe0 | p[1]&0x1f}, p[2:]...)
+		} else if d.fu != nil {
+			d.fu = append(d.fu, p[2:]...)
+		}
+		if p[1]&0x40 != 0 && d.fu != nil {
+			units = append(units, d.fu)
+			d.fu = nil
+		}
+	case 25, 26, 27, 29:
+		return nil, fmt.Errorf("unsupported h264 packetization type %d", p[0]&0x1f)
+	default:
+		units = append(units, p)
+	}
+
+	return d.assembler.push(pkt, units), nil
+}
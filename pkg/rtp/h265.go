@@ -0,0 +1,119 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// RFC7798
+const (
+	h265NaluAP   = 48
+	h265NaluFU   = 49
+	h265NaluPACI = 50
+)
+
+type H265Payloader struct{}
+
+func (p *H265Payloader) Payload(mtu int, nalu []byte) [][]byte {
+	if len(nalu) < 2 {
+		return nil
+	}
+
+	// Single NAL Unit Packet
+	if len(nalu) <= mtu {
+		return [][]byte{nalu}
+	}
+
+	// FU
+	// +---------------+---------------+---------------+
+	// |F|   Type    |  LayerId  | TID |S|E|  FuType   |
+	// +---------------+---------------+---------------+
+	ret := make([][]byte, 0)
+	naluType := (nalu[0] >> 1) & 0x3f
+	header0 := nalu[0]&0x81 | h265NaluFU<<1
+	header1 := nalu[1]
+
+	data := nalu[2:]
+	maxFragment := mtu - 3
+	for start := true; len(data) > 0; start = false {
+		n := len(data)
+		if n > maxFragment {
+			n = maxFragment
+		}
+
+		fuHeader := naluType
+		if start {
+			fuHeader |= 0x80
+		}
+		if n == len(data) {
+			fuHeader |= 0x40
+		}
+
+		payload := make([]byte, 3+n)
+		payload[0] = header0
+		payload[1] = header1
+		payload[2] = fuHeader
+		copy(payload[3:], data[:n])
+		ret = append(ret, payload)
+
+		data = data[n:]
+	}
+	return ret
+}
+
+// H265Depacketizer 支持 Single NAL Unit, AP, FU
+type H265Depacketizer struct {
+	assembler frameAssembler
+	seq       seqTracker
+	fu        []byte
+}
+
+func (d *H265Depacketizer) Depacketize(pkt *Packet) ([]*Frame, error) {
+	if d.seq.lost(pkt) {
+		d.fu = nil
+	}
+
+	p := pkt.Payload
+	if len(p) < 2 {
+		return nil, fmt.Errorf("invalid h265 payload")
+	}
+
+	var units [][]byte
+	switch (p[0] >> 1) & 0x3f {
+	case h265NaluAP:
+		p = p[2:]
+		for len(p) > 0 {
+			if len(p) < 2 {
+				return nil, fmt.Errorf("invalid h265 AP")
+			}
+			l := int(binary.BigEndian.Uint16(p))
+			p = p[2:]
+			if l > len(p) {
+				return nil, fmt.Errorf("invalid h265 AP")
+			}
+			if l > 0 {
+				units = append(units, p[:l])
+			}
+			p = p[l:]
+		}
+	case h265NaluFU:
+		if len(p) < 3 {
+			return nil, fmt.Errorf("invalid h265 FU")
+		}
+		if p[2]&0x80 != 0 {
+			d.fu = append([]byte{p[0]&0x81 | (p[2]&0x3f)<<1, p[1]}, p[3:]...)
+		} else if d.fu != nil {
+			d.fu = append(d.fu, p[3:]...)
+		}
+		if p[2]&0x40 != 0 && d.fu != nil {
+			units = append(units, d.fu)
+			d.fu = nil
+		}
+	case h265NaluPACI:
+		return nil, fmt.Errorf("unsupported h265 PACI")
+	default:
+		units = append(units, p)
+	}
+
+	return d.assembler.push(pkt, units), nil
+}
@@ -0,0 +1,107 @@
+package rtp
+
+import (
+	"sort"
+	"time"
+)
+
+// RFC3550 A.1 序号跳变超过该范围时认为源重新开始
+const (
+	maxDropout  = 3000
+	maxMisorder = 100
+)
+
+// 缓存的包超过该数量时不再等待丢失的包
+const maxJitterPackets = 1024
+
+type jitterEntry struct {
+	// 扩展序号，包含序号回绕的次数
+	seq     uint64
+	pkt     *Packet
+	arrival time.Time
+}
+
+// JitterBuffer 按扩展序号重排RTP包，丢失的包最多等待latency
+// 从丢失位置之后第一个包到达开始计时，超时后认为丢失
+type JitterBuffer struct {
+	latency time.Duration
+
+	started bool
+	// 下一个要输出的扩展序号
+	next    uint64
+	entries []*jitterEntry
+	// 源重新开始时缓存中的包直接输出，不计丢包
+	ready []*Packet
+}
+
+func NewJitterBuffer(latency time.Duration) *JitterBuffer {
+	return &JitterBuffer{latency: latency}
+}
+
+// Push 迟到(已经输出或认为丢失)和重复的包丢弃
+func (b *JitterBuffer) Push(pkt *Packet, now time.Time) {
+	if !b.started {
+		b.started = true
+		// 留出空间，序号回退时扩展序号不会小于0
+		b.next = 1<<32 + uint64(pkt.SequenceNumber)
+	}
+
+	diff := int64(int16(pkt.SequenceNumber - uint16(b.next)))
+	if diff >= maxDropout || diff < -maxMisorder {
+		b.restart(pkt.SequenceNumber)
+		diff = 0
+	} else if diff < 0 {
+		return
+	}
+
+	seq := b.next + uint64(diff)
+	i := sort.Search(len(b.entries), func(i int) bool {
+		return b.entries[i].seq >= seq
+	})
+	if i < len(b.entries) && b.entries[i].seq == seq {
+		return
+	}
+	b.entries = append(b.entries, nil)
+	copy(b.entries[i+1:], b.entries[i:])
+	b.entries[i] = &jitterEntry{seq: seq, pkt: pkt, arrival: now}
+}
+
+// restart 源重新开始，缓存中的包按顺序输出，之后从seq开始
+func (b *JitterBuffer) restart(seq uint16) {
+	for _, e := range b.entries {
+		b.ready = append(b.ready, e.pkt)
+	}
+	b.entries = b.entries[:0]
+	b.next = b.next&^0xffff | uint64(seq)
+}
+
+// Pop 返回下一个按序的包及其之前丢失的包数量，需要继续等待时返回nil
+func (b *JitterBuffer) Pop(now time.Time) (*Packet, int) {
+	if len(b.ready) > 0 {
+		pkt := b.ready[0]
+		b.ready = b.ready[1:]
+		return pkt, 0
+	}
+	if len(b.entries) == 0 {
+		return nil, 0
+	}
+
+	head := b.entries[0]
+	if head.seq != b.next && now.Before(head.arrival.Add(b.latency)) && len(b.entries) <= maxJitterPackets {
+		return nil, 0
+	}
+
+	lost := int(head.seq - b.next)
+	b.next = head.seq + 1
+	b.entries[0] = nil
+	b.entries = b.entries[1:]
+	return head.pkt, lost
+}
+
+// Deadline 等待丢失的包的截止时间，没有在等待时返回false
+func (b *JitterBuffer) Deadline() (time.Time, bool) {
+	if len(b.ready) > 0 || len(b.entries) == 0 || b.entries[0].seq == b.next {
+		return time.Time{}, false
+	}
+	return b.entries[0].arrival.Add(b.latency), true
+}
@@ -0,0 +1,86 @@
+package rtp
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJitterBuffer(t *testing.T) {
+	start := time.Now()
+	latency := 100 * time.Millisecond
+
+	push := func(b *JitterBuffer, now time.Time, seqs ...uint16) {
+		for _, seq := range seqs {
+			b.Push(&Packet{SequenceNumber: seq}, now)
+		}
+	}
+	// popAll 返回当前可以输出的序号及丢包总数
+	popAll := func(b *JitterBuffer, now time.Time) ([]uint16, int) {
+		seqs := make([]uint16, 0)
+		lost := 0
+		for {
+			pkt, n := b.Pop(now)
+			if pkt == nil {
+				return seqs, lost
+			}
+			seqs = append(seqs, pkt.SequenceNumber)
+			lost += n
+		}
+	}
+
+	Convey("test reorder across wrap around", t, func() {
+		b := NewJitterBuffer(latency)
+		push(b, start, 65534, 0, 65535, 1, 1, 65533)
+		seqs, lost := popAll(b, start)
+		// 65533已经迟到，重复的1丢弃
+		So(seqs, ShouldResemble, []uint16{65534, 65535, 0, 1})
+		So(lost, ShouldEqual, 0)
+		_, ok := b.Deadline()
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("test wait for missing packets until deadline", t, func() {
+		b := NewJitterBuffer(latency)
+		push(b, start, 10, 13, 14)
+		seqs, _ := popAll(b, start)
+		So(seqs, ShouldResemble, []uint16{10})
+
+		deadline, ok := b.Deadline()
+		So(ok, ShouldBeTrue)
+		So(deadline, ShouldEqual, start.Add(latency))
+
+		// 截止时间之前到达
+		push(b, start.Add(50*time.Millisecond), 11)
+		seqs, _ = popAll(b, start.Add(50*time.Millisecond))
+		So(seqs, ShouldResemble, []uint16{11})
+
+		// 12超时认为丢失，之后到达的12丢弃
+		seqs, lost := popAll(b, start.Add(latency))
+		So(seqs, ShouldResemble, []uint16{13, 14})
+		So(lost, ShouldEqual, 1)
+		push(b, start.Add(latency), 12)
+		seqs, _ = popAll(b, start.Add(latency))
+		So(seqs, ShouldBeEmpty)
+	})
+
+	Convey("test source restart", t, func() {
+		b := NewJitterBuffer(latency)
+		push(b, start, 100, 102, 20000, 20001)
+		seqs, lost := popAll(b, start)
+		So(seqs, ShouldResemble, []uint16{100, 102, 20000, 20001})
+		So(lost, ShouldEqual, 0)
+	})
+
+	Convey("test buffer limit", t, func() {
+		b := NewJitterBuffer(time.Hour)
+		push(b, start, 0)
+		for i := 0; i <= maxJitterPackets; i++ {
+			push(b, start, uint16(2+i))
+		}
+		seqs, lost := popAll(b, start)
+		So(seqs[:2], ShouldResemble, []uint16{0, 2})
+		So(lost, ShouldEqual, 1)
+	})
+}
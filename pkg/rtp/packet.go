@@ -0,0 +1,106 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	headerLength = 12
+	version      = 2
+)
+
+// RFC3550 RTP fixed header
+type Packet struct {
+	Marker         bool
+	PayloadType    uint8
+	SequenceNumber uint16
+	Timestamp      uint32
+	SSRC           uint32
+	CSRC           []uint32
+
+	Payload []byte
+}
+
+func (p *Packet) Marshal() []byte {
+	ret := make([]byte, headerLength+4*len(p.CSRC)+len(p.Payload))
+
+	ret[0] = version<<6 | byte(len(p.CSRC))
+	ret[1] = p.PayloadType & 0x7f
+	if p.Marker {
+		ret[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(ret[2:], p.SequenceNumber)
+	binary.BigEndian.PutUint32(ret[4:], p.Timestamp)
+	binary.BigEndian.PutUint32(ret[8:], p.SSRC)
+
+	n := headerLength
+	for _, csrc := range p.CSRC {
+		binary.BigEndian.PutUint32(ret[n:], csrc)
+		n += 4
+	}
+	copy(ret[n:], p.Payload)
+
+	return ret
+}
+
+func (p *Packet) Unmarshal(b []byte) error {
+	if len(b) < headerLength {
+		return fmt.Errorf("rtp packet too short: %d", len(b))
+	}
+
+	if b[0]>>6 != version {
+		return fmt.Errorf("invalid rtp version: %d", b[0]>>6)
+	}
+
+	padding := b[0]&0x20 != 0
+	extension := b[0]&0x10 != 0
+	cc := int(b[0] & 0x0f)
+
+	p.Marker = b[1]&0x80 != 0
+	p.PayloadType = b[1] & 0x7f
+	p.SequenceNumber = binary.BigEndian.Uint16(b[2:])
+	p.Timestamp = binary.BigEndian.Uint32(b[4:])
+	p.SSRC = binary.BigEndian.Uint32(b[8:])
+
+	n := headerLength
+	if len(b) < n+4*cc {
+		return fmt.Errorf("rtp packet too short: %d", len(b))
+	}
+	p.CSRC = nil
+	for i := 0; i < cc; i++ {
+		p.CSRC = append(p.CSRC, binary.BigEndian.Uint32(b[n:]))
+		n += 4
+	}
+
+	// header extension 不做解析，直接跳过
+	if extension {
+		if len(b) < n+4 {
+			return fmt.Errorf("rtp packet too short: %d", len(b))
+		}
+		n += 4 + 4*int(binary.BigEndian.Uint16(b[n+2:]))
+		if len(b) < n {
+			return fmt.Errorf("rtp packet too short: %d", len(b))
+		}
+	}
+
+	end := len(b)
+	if padding {
+		if end == n {
+			return fmt.Errorf("invalid rtp padding")
+		}
+		end -= int(b[end-1])
+		if end < n {
+			return fmt.Errorf("invalid rtp padding")
+		}
+	}
+
+	p.Payload = b[n:end]
+	return nil
+}
+
+// Clone 复制header，payload共享
+func (p *Packet) Clone() *Packet {
+	ret := *p
+	return &ret
+}
@@ -0,0 +1,67 @@
+package rtp
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPacket(t *testing.T) {
+	Convey("test rtp packet marshal and unmarshal", t, func() {
+		p := &Packet{
+			Marker:         true,
+			PayloadType:    96,
+			SequenceNumber: 65535,
+			Timestamp:      3000,
+			SSRC:           0x703342ee,
+			Payload:        []byte{1, 2, 3},
+		}
+		b := p.Marshal()
+		So(len(b), ShouldEqual, 15)
+		So(b[1], ShouldEqual, 0x80|96)
+
+		p2 := &Packet{}
+		So(p2.Unmarshal(b), ShouldBeNil)
+		So(p2, ShouldResemble, p)
+
+		So(p2.Unmarshal(b[:8]), ShouldNotBeNil)
+	})
+}
+
+func TestH264Payloader(t *testing.T) {
+	Convey("test h264 FU-A fragmentation", t, func() {
+		nalu := append([]byte{0x65}, bytes.Repeat([]byte{0xaa}, 2500)...)
+		p := NewPacketizer(96, 90000, &H264Payloader{})
+		pkts := p.Packetize([][]byte{{0x67, 1}, nalu}, 1000)
+
+		So(len(pkts), ShouldEqual, 3)
+		So(pkts[0].Payload, ShouldResemble, []byte{0x67, 1})
+		So(pkts[1].Payload[0], ShouldEqual, 0x60|28)
+		So(pkts[1].Payload[1], ShouldEqual, 0x80|5)
+		So(pkts[2].Payload[1], ShouldEqual, 0x40|5)
+		So(pkts[1].Marker, ShouldBeFalse)
+		So(pkts[2].Marker, ShouldBeTrue)
+		So(pkts[2].SequenceNumber, ShouldEqual, pkts[0].SequenceNumber+2)
+		So(len(pkts[1].Payload)+len(pkts[2].Payload)-4, ShouldEqual, len(nalu)-1)
+	})
+}
+
+func TestRTX(t *testing.T) {
+	Convey("test rtx encapsulation", t, func() {
+		p := &Packet{Marker: true, PayloadType: 96, SequenceNumber: 1000, Timestamp: 3000, SSRC: 1, Payload: []byte{1, 2}}
+		rtx := NewRTX(p, 97, 2, 5)
+		So(rtx.PayloadType, ShouldEqual, 97)
+		So(rtx.SSRC, ShouldEqual, 2)
+		So(rtx.SequenceNumber, ShouldEqual, 5)
+		So(rtx.Timestamp, ShouldEqual, 3000)
+		So(rtx.Payload, ShouldResemble, []byte{0x03, 0xe8, 1, 2})
+
+		orig, err := DecodeRTX(rtx, 96, 1)
+		So(err, ShouldBeNil)
+		So(orig, ShouldResemble, p)
+
+		_, err = DecodeRTX(&Packet{Payload: []byte{1}}, 96, 1)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,73 @@
+package rtp
+
+import (
+	"math/rand"
+	"time"
+)
+
+// 默认MTU，预留了RTP header及TCP interleaved的空间
+const DefaultMTU = 1400
+
+// Payloader 将一个编码单元(NALU, 音频帧...)切分为若干RTP payload
+type Payloader interface {
+	Payload(mtu int, data []byte) [][]byte
+}
+
+type Packetizer struct {
+	PayloadType uint8
+	SSRC        uint32
+	ClockRate   int
+	MTU         int
+
+	payloader Payloader
+	seq       uint16
+}
+
+func NewPacketizer(pt uint8, clockRate int, payloader Payloader) *Packetizer {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return &Packetizer{
+		PayloadType: pt,
+		SSRC:        r.Uint32(),
+		ClockRate:   clockRate,
+		MTU:         DefaultMTU,
+		payloader:   payloader,
+		seq:         uint16(r.Uint32()),
+	}
+}
+
+// Seq 下一个包的序号
+func (p *Packetizer) Seq() uint16 {
+	return p.seq
+}
+
+// Packetize 打包一帧数据，units为该帧的所有编码单元，帧的最后一个包设置marker
+func (p *Packetizer) Packetize(units [][]byte, ts uint32) []*Packet {
+	ret := make([]*Packet, 0)
+	for _, unit := range units {
+		for _, payload := range p.payloader.Payload(p.MTU, unit) {
+			ret = append(ret, &Packet{
+				PayloadType:    p.PayloadType,
+				SequenceNumber: p.seq,
+				Timestamp:      ts,
+				SSRC:           p.SSRC,
+				Payload:        payload,
+			})
+			p.seq++
+		}
+	}
+
+	if len(ret) > 0 {
+		ret[len(ret)-1].Marker = true
+	}
+	return ret
+}
+
+// RawPayloader 不做切分，一个编码单元对应一个payload (PCM等音频)
+type RawPayloader struct{}
+
+func (p *RawPayloader) Payload(mtu int, data []byte) [][]byte {
+	if len(data) == 0 {
+		return nil
+	}
+	return [][]byte{data}
+}
@@ -0,0 +1,37 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// NewRTX RFC4588 重传包，payload前加上2字节的原始序号
+func NewRTX(p *Packet, payloadType uint8, ssrc uint32, seq uint16) *Packet {
+	payload := make([]byte, 2+len(p.Payload))
+	binary.BigEndian.PutUint16(payload, p.SequenceNumber)
+	copy(payload[2:], p.Payload)
+
+	return &Packet{
+		Marker:         p.Marker,
+		PayloadType:    payloadType,
+		SequenceNumber: seq,
+		Timestamp:      p.Timestamp,
+		SSRC:           ssrc,
+		CSRC:           p.CSRC,
+		Payload:        payload,
+	}
+}
+
+// DecodeRTX 还原原始包，payloadType和ssrc为原始流的值
+func DecodeRTX(p *Packet, payloadType uint8, ssrc uint32) (*Packet, error) {
+	if len(p.Payload) < 2 {
+		return nil, fmt.Errorf("rtx packet too short: %d", len(p.Payload))
+	}
+
+	ret := p.Clone()
+	ret.PayloadType = payloadType
+	ret.SSRC = ssrc
+	ret.SequenceNumber = binary.BigEndian.Uint16(p.Payload)
+	ret.Payload = p.Payload[2:]
+	return ret, nil
+}
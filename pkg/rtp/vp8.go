@@ -0,0 +1,31 @@
+package rtp
+
+// RFC7741
+type VP8Payloader struct{}
+
+func (p *VP8Payloader) Payload(mtu int, frame []byte) [][]byte {
+	if len(frame) == 0 {
+		return nil
+	}
+
+	// payload descriptor 只使用1字节
+	// |X|R|N|S|R| PID |
+	ret := make([][]byte, 0)
+	maxFragment := mtu - 1
+	for start := true; len(frame) > 0; start = false {
+		n := len(frame)
+		if n > maxFragment {
+			n = maxFragment
+		}
+
+		payload := make([]byte, 1+n)
+		if start {
+			payload[0] = 0x10
+		}
+		copy(payload[1:], frame[:n])
+		ret = append(ret, payload)
+
+		frame = frame[n:]
+	}
+	return ret
+}
@@ -0,0 +1,68 @@
+package rtp
+
+// RFC9628
+type VP9Payloader struct{}
+
+func (p *VP9Payloader) Payload(mtu int, frame []byte) [][]byte {
+	if len(frame) == 0 {
+		return nil
+	}
+
+	// payload descriptor 只使用1字节，non-flexible模式且不带picture id
+	// |I|P|L|F|B|E|V|Z|
+	// frame tag 中的 frame_type 为1时为帧间预测
+	var inter byte
+	if vp9IsInterFrame(frame) {
+		inter = 0x40
+	}
+
+	ret := make([][]byte, 0)
+	maxFragment := mtu - 1
+	for start := true; len(frame) > 0; start = false {
+		n := len(frame)
+		if n > maxFragment {
+			n = maxFragment
+		}
+
+		payload := make([]byte, 1+n)
+		payload[0] = inter
+		if start {
+			payload[0] |= 0x08
+		}
+		if n == len(frame) {
+			payload[0] |= 0x04
+		}
+		copy(payload[1:], frame[:n])
+		ret = append(ret, payload)
+
+		frame = frame[n:]
+	}
+	return ret
+}
+
+// vp9IsInterFrame 解析 uncompressed header 中的 frame_type
+func vp9IsInterFrame(frame []byte) bool {
+	// frame_marker(2) profile_low_bit(1) profile_high_bit(1)
+	b := frame[0]
+	profile := (b>>5)&1 | (b>>4)&1<<1
+	pos := uint(4)
+	if profile == 3 {
+		// reserved_zero
+		pos++
+	}
+
+	bit := func() byte {
+		if pos >= 8 {
+			return 0
+		}
+		v := (b >> (7 - pos)) & 1
+		pos++
+		return v
+	}
+
+	// show_existing_frame
+	if bit() == 1 {
+		return true
+	}
+	return bit() == 1
+}
@@ -0,0 +1,696 @@
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// for RequestLine
+type Method struct {
+	M string
+}
+
+func (m *Method) parse(content string) error {
+	m.M = content
+	return nil
+}
+
+type RequestURI struct {
+	URI string
+}
+
+func (m *RequestURI) parse(content string) error {
+	m.URI = content
+	return nil
+}
+
+const (
+	Version10 = "RTSP/1.0"
+	Version20 = "RTSP/2.0"
+)
+
+type RTSPVersion struct {
+	Version string
+}
+
+func (m *RTSPVersion) parse(content string) error {
+	rtspVersionReg := regexp.MustCompile("RTSP/[0-9].[0-9]")
+	if ok := rtspVersionReg.Match([]byte(content)); !ok {
+		return fmt.Errorf("rtsp version parse failed: %s", content)
+	}
+	m.Version = content
+	return nil
+}
+
+//for request
+type RequestLine struct {
+	Method
+	RequestURI
+	RTSPVersion
+}
+
+func (m *RequestLine) parse(content string) error {
+	//RFC2326
+	//Request-Line = Method SP Request-URI SP RTSP-Version CRLF
+
+	parts := strings.Split(content, " ")
+	if len(parts) != 3 {
+		return fmt.Errorf("request-line parse failed: %s", content)
+	}
+
+	if err := m.Method.parse(parts[0]); err != nil {
+		return err
+	}
+
+	if err := m.RequestURI.parse(parts[1]); err != nil {
+		return err
+	}
+
+	if err := m.RTSPVersion.parse(parts[2]); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type RequestMessages struct {
+	messages map[string]string
+	// 保持header的添加顺序，用于生成请求
+	headers []string
+}
+
+// parseInc 为增量的parse，每次输入一行
+func (m *RequestMessages) parseInc(content string) error {
+	if m.messages == nil {
+		m.messages = make(map[string]string)
+	}
+
+	reg := regexp.MustCompile("(.*): (.*)")
+	rets := reg.FindStringSubmatch(content)
+	if len(rets) != 3 {
+		return fmt.Errorf("request message parse failed: %s||", content)
+	}
+	m.messages[rets[1]] = rets[2]
+	return nil
+}
+
+func (m *RequestMessages) reset() {
+	m.messages = nil
+	m.headers = nil
+}
+
+func (m *RequestMessages) AddMessage(header, content string) {
+	if m.messages == nil {
+		m.messages = make(map[string]string)
+	}
+
+	if _, ok := m.messages[header]; !ok {
+		m.headers = append(m.headers, header)
+	}
+	m.messages[header] = content
+}
+
+func (m *RequestMessages) gen() string {
+	ret := ""
+	for _, k := range m.headers {
+		ret += fmt.Sprintf("%s: %s\r\n", k, m.messages[k])
+	}
+	return ret
+}
+
+func (m *RequestMessages) GetMessage(msgType string) (string, bool) {
+	msg, ok := m.messages[msgType]
+	return msg, ok
+}
+
+type Request struct {
+	RequestLine
+	RequestMessages
+
+	Seq int64
+
+	// request body
+	body []byte
+}
+
+// NewRequest 生成客户端请求，CSeq由调用方添加
+func NewRequest(method, uri string) *Request {
+	return &Request{
+		RequestLine: RequestLine{
+			Method:      Method{M: method},
+			RequestURI:  RequestURI{URI: uri},
+			RTSPVersion: RTSPVersion{Version: Version10},
+		},
+	}
+}
+
+// Gen 客户端请求使用CRLF换行
+func (m *Request) Gen() string {
+	ret := fmt.Sprintf("%s %s %s\r\n", m.M, m.URI, m.Version)
+	ret += m.RequestMessages.gen()
+	ret += "\r\n"
+	ret += string(m.body)
+	return ret
+}
+
+func (m *Request) AddBody(data []byte) {
+	m.RequestMessages.AddMessage("Content-Length", fmt.Sprintf("%d", len(data)))
+	m.body = data
+}
+
+func (m *Request) GenRequest(conn net.Conn) error {
+	return m.Parse(*textproto.NewReader(bufio.NewReader(conn)))
+}
+
+func (m *Request) Parse(trd textproto.Reader) error {
+	requestLine, err := trd.ReadLine()
+	if err != nil {
+		return err
+	}
+
+	if err := m.RequestLine.parse(requestLine); err != nil {
+		return err
+	}
+
+	for {
+		data, err := trd.ReadLine()
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		// header 以EOF结束
+		if err == io.EOF && data == "" {
+			break
+		}
+
+		if err == io.EOF {
+			return err
+		}
+
+		if data == "" {
+			break
+		}
+
+		if err := m.parseInc(data); err != nil {
+			return err
+		}
+	}
+	seq, ok := m.messages["CSeq"]
+	if !ok {
+		return fmt.Errorf("no cseq")
+	}
+
+	m.Seq, err = strconv.ParseInt(seq, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	// ANNOUNCE 等请求带有body
+	if length, ok := m.messages["Content-Length"]; ok {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid content-length %s", length)
+		}
+		m.body = make([]byte, n)
+		if _, err := io.ReadFull(trd.R, m.body); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Request) Body() []byte {
+	return m.body
+}
+
+type Transport struct {
+	Items []*TransportItem
+}
+
+func ParseTransport(b []byte) (*Transport, error) {
+	ret := &Transport{
+		Items: make([]*TransportItem, 0),
+	}
+	items := bytes.Split(b, []byte(","))
+	for _, item := range items {
+		t, err := ParseTransportItem(item)
+		if err != nil {
+			return nil, err
+		}
+		ret.Items = append(ret.Items, t)
+	}
+	return ret, nil
+}
+
+func GenTransport(trans *Transport) ([]byte, error) {
+	items := make([][]byte, 0, len(trans.Items))
+	for _, v := range trans.Items {
+		itemB, err := GenTransportItem(v)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, itemB)
+	}
+	return bytes.Join(items, []byte(",")), nil
+}
+
+type TransportItem struct {
+	Protocol       string
+	Profile        string
+	LowerTransport string
+	Cast           string
+	Parameter      map[string][]byte
+
+	//RTP
+	//for multicast
+	Destination string
+	Port1       int
+	Port2       int
+	Ttl         int
+	ClientPort1 int
+	ClientPort2 int
+	ServerPort1 int
+	ServerPort2 int
+	Ssrc        string
+
+	//RTP/AVP/TCP
+	Interleaved  bool
+	Interleaved1 int
+	Interleaved2 int
+
+	//RTSP 2.0，RTP和RTCP的地址，host:port，host可以为空
+	DestAddr []string
+	SrcAddr  []string
+}
+
+func ParseTransportItem(b []byte) (*TransportItem, error) {
+	ret := &TransportItem{
+		Parameter: make(map[string][]byte),
+	}
+
+	// RTP/AVP;unicast;client_port=3456-3457;mode="PLAY"
+	parts := bytes.Split(b, []byte(";"))
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid transportitem %s", string(b))
+	}
+
+	specs := bytes.Split(parts[0], []byte("/"))
+	ret.Protocol = string(specs[0])
+	ret.Profile = string(specs[1])
+	if len(specs) == 2 {
+		ret.LowerTransport = "UDP"
+	} else {
+		ret.LowerTransport = string(specs[2])
+	}
+
+	ret.Cast = string(parts[1])
+
+	for i := 2; i < len(parts); i++ {
+		index := bytes.Index(parts[i], []byte("="))
+		if index == -1 {
+			return nil, fmt.Errorf("invalid transportitem %s", string(b))
+		}
+
+		var err error
+		switch string(parts[i][:index]) {
+		case "destination":
+			ret.Destination = string(parts[i][index+1:])
+		case "ttl":
+			ttl, err := strconv.Atoi(string(parts[i][index+1:]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid transportitem %s", string(b))
+			}
+			ret.Ttl = ttl
+		case "port":
+			ret.Port1, ret.Port2, err = transportRtpPortConv(parts[i][index+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid transportitem %s", string(b))
+			}
+		case "client_port":
+			ret.ClientPort1, ret.ClientPort2, err = transportRtpPortConv(parts[i][index+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid transportitem %s", string(b))
+			}
+		case "server_port":
+			ret.ServerPort1, ret.ServerPort2, err = transportRtpPortConv(parts[i][index+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid transportitem %s", string(b))
+			}
+		case "interleaved":
+			ret.Interleaved = true
+			ret.Interleaved1, ret.Interleaved2, err = transportRtpPortConv(parts[i][index+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid transportitem %s", string(b))
+			}
+		case "ssrc":
+			ret.Ssrc = string(parts[i][index+1:])
+		case "dest_addr":
+			ret.DestAddr = parseTransportAddr(parts[i][index+1:])
+		case "src_addr":
+			ret.SrcAddr = parseTransportAddr(parts[i][index+1:])
+		default:
+			ret.Parameter[string(parts[i][:index])] = parts[i][index+1:]
+		}
+	}
+
+	return ret, nil
+}
+
+// parseTransportAddr "192.0.2.5:4588"/"192.0.2.5:4589"
+func parseTransportAddr(b []byte) []string {
+	ret := make([]string, 0, 2)
+	for _, addr := range bytes.Split(b, []byte("/")) {
+		ret = append(ret, strings.Trim(string(addr), `"`))
+	}
+	return ret
+}
+
+func genTransportAddr(addrs []string) string {
+	quoted := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		quoted = append(quoted, `"`+addr+`"`)
+	}
+	return strings.Join(quoted, "/")
+}
+
+func transportRtpPortConv(p []byte) (int, int, error) {
+	index := bytes.Index(p, []byte("-"))
+	if index == -1 {
+		return -1, -1, fmt.Errorf("invalid rtp port: %s", p)
+	}
+
+	p1, err := strconv.ParseInt(string(p[:index]), 10, 64)
+	if err != nil {
+		return -1, -1, fmt.Errorf("invalid rtp port: %s", p)
+	}
+
+	p2, err := strconv.ParseInt(string(p[index+1:]), 10, 64)
+	if err != nil {
+		return -1, -1, fmt.Errorf("invalid rtp port: %s", p)
+	}
+
+	return int(p1), int(p2), nil
+}
+
+func GenTransportItem(t *TransportItem) ([]byte, error) {
+	// bytes.Join()
+	parts := make([][]byte, 0)
+
+	//RTP/AVP
+	p1 := fmt.Sprintf("%s/%s", t.Protocol, t.Profile)
+	if t.LowerTransport != "" {
+		p1 = fmt.Sprintf("%s/%s", p1, t.LowerTransport)
+	}
+	parts = append(parts, []byte(p1))
+	// unicast/multicast
+	parts = append(parts, []byte(t.Cast))
+	//destination=239.255.42.1
+	if t.Destination != "" {
+		parts = append(parts, []byte("destination="+t.Destination))
+	}
+	//port=3456-3457
+	if t.Port1 != 0 {
+		portStr := fmt.Sprintf("port=%d-%d", t.Port1, t.Port2)
+		parts = append(parts, []byte(portStr))
+	}
+	//ttl=16
+	if t.Ttl != 0 {
+		parts = append(parts, []byte(fmt.Sprintf("ttl=%d", t.Ttl)))
+	}
+	//client_port=18276-18277
+	if t.ClientPort1 != 0 {
+		clientPortStr := fmt.Sprintf("client_port=%d-%d", t.ClientPort1, t.ClientPort2)
+		parts = append(parts, []byte(clientPortStr))
+	}
+	//server_port=40658-40659
+	if t.ServerPort1 != 0 {
+		serverPortStr := fmt.Sprintf("server_port=%d-%d", t.ServerPort1, t.ServerPort2)
+		parts = append(parts, []byte(serverPortStr))
+	}
+	//interleaved=0-1
+	if t.Interleaved {
+		interleavedStr := fmt.Sprintf("interleaved=%d-%d", t.Interleaved1, t.Interleaved2)
+		parts = append(parts, []byte(interleavedStr))
+	}
+	//dest_addr=":4588"/":4589"
+	if len(t.DestAddr) > 0 {
+		parts = append(parts, []byte("dest_addr="+genTransportAddr(t.DestAddr)))
+	}
+	//src_addr="192.0.2.1:6256"/"192.0.2.1:6257"
+	if len(t.SrcAddr) > 0 {
+		parts = append(parts, []byte("src_addr="+genTransportAddr(t.SrcAddr)))
+	}
+	//ssrc
+	if t.Ssrc != "" {
+		ssrcStr := fmt.Sprintf("ssrc=%s", t.Ssrc)
+		parts = append(parts, []byte(ssrcStr))
+	}
+	for k, v := range t.Parameter {
+		paraStr := fmt.Sprintf("%s=%s", k, v)
+		parts = append(parts, []byte(paraStr))
+	}
+	return bytes.Join(parts, []byte(";")), nil
+}
+
+type Session struct {
+	SessionId string
+	Timeout   uint64
+}
+
+func ParseSession(b []byte) (*Session, error) {
+	index := bytes.Index(b, []byte(";"))
+	if index == -1 {
+		return &Session{
+			SessionId: string(b),
+		}, nil
+	}
+
+	//12345678;timeout=60
+	timeout, err := strconv.ParseUint(strings.TrimPrefix(string(b[index+1:]), "timeout="), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		SessionId: string(b[:index]),
+		Timeout:   timeout,
+	}, nil
+}
+
+func GenSession(s *Session) ([]byte, error) {
+	if s.Timeout == 0 {
+		return []byte(s.SessionId), nil
+	}
+
+	return []byte(fmt.Sprintf("%s;timeout=%d", s.SessionId, s.Timeout)), nil
+}
+
+// Range RFC2326 12.29，支持npt和clock
+type Range struct {
+	Start time.Duration
+	// 0 表示没有指定结束时间
+	End time.Duration
+	// npt=now-
+	Now bool
+
+	// clock=，使用StartTime/EndTime
+	Clock     bool
+	StartTime time.Time
+	// 零值表示没有指定结束时间
+	EndTime time.Time
+}
+
+// clock=的时间格式，解析时秒后面可以带小数
+const clockLayout = "20060102T150405Z"
+
+func ParseRange(b []byte) (*Range, error) {
+	if bytes.HasPrefix(b, []byte("clock=")) {
+		return parseClockRange(b)
+	}
+
+	//npt=10.5-20
+	if !bytes.HasPrefix(b, []byte("npt=")) {
+		return nil, fmt.Errorf("unsupported range %s", b)
+	}
+
+	// 可能带有 ;time=...
+	value := bytes.Split(b[4:], []byte(";"))[0]
+	index := bytes.Index(value, []byte("-"))
+	if index == -1 {
+		return nil, fmt.Errorf("invalid range %s", b)
+	}
+
+	ret := &Range{}
+	start := strings.TrimSpace(string(value[:index]))
+	end := strings.TrimSpace(string(value[index+1:]))
+
+	var err error
+	if start == "now" {
+		ret.Now = true
+	} else if start != "" {
+		if ret.Start, err = parseNptTime(start); err != nil {
+			return nil, fmt.Errorf("invalid range %s", b)
+		}
+	}
+
+	if end != "" {
+		if ret.End, err = parseNptTime(end); err != nil {
+			return nil, fmt.Errorf("invalid range %s", b)
+		}
+	}
+
+	return ret, nil
+}
+
+// parseClockRange clock=19961108T142300Z-19961108T143520Z
+func parseClockRange(b []byte) (*Range, error) {
+	value := bytes.Split(b[6:], []byte(";"))[0]
+	index := bytes.Index(value, []byte("-"))
+	if index == -1 {
+		return nil, fmt.Errorf("invalid range %s", b)
+	}
+
+	ret := &Range{Clock: true}
+	start := strings.TrimSpace(string(value[:index]))
+	end := strings.TrimSpace(string(value[index+1:]))
+
+	var err error
+	if ret.StartTime, err = time.Parse(clockLayout, start); err != nil {
+		return nil, fmt.Errorf("invalid range %s", b)
+	}
+	if end != "" {
+		if ret.EndTime, err = time.Parse(clockLayout, end); err != nil {
+			return nil, fmt.Errorf("invalid range %s", b)
+		}
+	}
+	return ret, nil
+}
+
+// parseNptTime npt-sec(12.5) 或 npt-hhmmss(0:01:02.5)
+func parseNptTime(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 1 && len(parts) != 3 {
+		return 0, fmt.Errorf("invalid npt time %s", s)
+	}
+
+	var seconds float64
+	for _, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid npt time %s", s)
+		}
+		seconds = seconds*60 + v
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func GenRange(start, end time.Duration) string {
+	if end <= 0 {
+		return fmt.Sprintf("npt=%.3f-", start.Seconds())
+	}
+	return fmt.Sprintf("npt=%.3f-%.3f", start.Seconds(), end.Seconds())
+}
+
+// GenClockRange end为零值时不指定结束时间
+func GenClockRange(start, end time.Time) string {
+	layout := "20060102T150405.000Z"
+	if end.IsZero() {
+		return fmt.Sprintf("clock=%s-", start.UTC().Format(layout))
+	}
+	return fmt.Sprintf("clock=%s-%s", start.UTC().Format(layout), end.UTC().Format(layout))
+}
+
+// RTPInfo PLAY response中一个track的RTP-Info
+type RTPInfo struct {
+	URL string
+	// RTSP 2.0 使用，16进制
+	Ssrc string
+	// 第一个包的序号，rtptime为Range开始时间对应的RTP时间戳
+	Seq     uint16
+	RTPTime uint32
+}
+
+// GenRTPInfo RFC2326 12.33 或 RFC7826 18.45
+func GenRTPInfo(infos []*RTPInfo, version string) string {
+	items := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if version == Version20 {
+			items = append(items, fmt.Sprintf("url=\"%s\" ssrc=%s:seq=%d;rtptime=%d", info.URL, info.Ssrc, info.Seq, info.RTPTime))
+		} else {
+			items = append(items, fmt.Sprintf("url=%s;seq=%d;rtptime=%d", info.URL, info.Seq, info.RTPTime))
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// ParseRTPInfo 支持RTSP 1.0和2.0的格式
+func ParseRTPInfo(b []byte) ([]*RTPInfo, error) {
+	ret := make([]*RTPInfo, 0)
+	for _, item := range strings.Split(string(b), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		info := &RTPInfo{}
+		var params string
+		if strings.HasPrefix(item, "url=\"") {
+			// url="rtsp://..." ssrc=0A13C760:seq=45102;rtptime=12345678
+			end := strings.Index(item[5:], "\"")
+			if end == -1 {
+				return nil, fmt.Errorf("invalid rtp-info %s", item)
+			}
+			info.URL = item[5 : 5+end]
+			rest := strings.TrimSpace(item[5+end+1:])
+			if strings.HasPrefix(rest, "ssrc=") {
+				index := strings.Index(rest, ":")
+				if index == -1 {
+					index = len(rest)
+				}
+				info.Ssrc = rest[5:index]
+				rest = strings.TrimPrefix(rest[index:], ":")
+			}
+			params = rest
+		} else {
+			params = item
+		}
+
+		for _, param := range strings.Split(params, ";") {
+			index := strings.Index(param, "=")
+			if index == -1 {
+				continue
+			}
+			key, value := strings.TrimSpace(param[:index]), strings.TrimSpace(param[index+1:])
+			switch key {
+			case "url":
+				info.URL = value
+			case "seq":
+				v, err := strconv.ParseUint(value, 10, 16)
+				if err != nil {
+					return nil, fmt.Errorf("invalid rtp-info %s", item)
+				}
+				info.Seq = uint16(v)
+			case "rtptime":
+				v, err := strconv.ParseUint(value, 10, 32)
+				if err != nil {
+					return nil, fmt.Errorf("invalid rtp-info %s", item)
+				}
+				info.RTPTime = uint32(v)
+			}
+		}
+		if info.URL == "" {
+			return nil, fmt.Errorf("invalid rtp-info %s", item)
+		}
+		ret = append(ret, info)
+	}
+	return ret, nil
+}
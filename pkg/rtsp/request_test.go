@@ -0,0 +1,197 @@
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRequestParse(t *testing.T) {
+	Convey("test request parse", t, func() {
+		rLine := "OPTIONS rtsp://127.0.0.1:7776 RTSP/1.0\nCSeq: 1\nUser-Agent: Lavf57.83.100\n"
+		r := Request{}
+		err := r.Parse(*textproto.NewReader(bufio.NewReader(bytes.NewReader([]byte(rLine)))))
+		So(err, ShouldBeNil)
+		So(r.M, ShouldEqual, "OPTIONS")
+		So(r.Version, ShouldEqual, "RTSP/1.0")
+		So(r.Seq, ShouldEqual, 1)
+		agent, ok := r.GetMessage("User-Agent")
+		So(ok, ShouldBeTrue)
+		So(agent, ShouldEqual, "Lavf57.83.100")
+	})
+}
+
+func TestTransportItem(t *testing.T) {
+	Convey("test transport item parse ans gen", t, func() {
+		t := "RTP/AVP/UDP;unicast;client_port=18276-18277;server_port=40658-40659;ssrc=703342EE"
+		item, err := ParseTransportItem([]byte(t))
+		So(err, ShouldBeNil)
+		So(item.ClientPort1, ShouldEqual, 18276)
+		tNew, err := GenTransportItem(item)
+		So(err, ShouldBeNil)
+		So(t, ShouldEqual, string(tNew))
+	})
+}
+
+func TestRange(t *testing.T) {
+	Convey("test range parse and gen", t, func() {
+		r, err := ParseRange([]byte("npt=10.5-20"))
+		So(err, ShouldBeNil)
+		So(r.Start, ShouldEqual, 10500*time.Millisecond)
+		So(r.End, ShouldEqual, 20*time.Second)
+
+		r, err = ParseRange([]byte("npt=0:01:02.5-;time=19970123T143720Z"))
+		So(err, ShouldBeNil)
+		So(r.Start, ShouldEqual, 62500*time.Millisecond)
+		So(r.End, ShouldEqual, 0)
+
+		r, err = ParseRange([]byte("npt=now-"))
+		So(err, ShouldBeNil)
+		So(r.Now, ShouldBeTrue)
+
+		_, err = ParseRange([]byte("smpte=10:07:00-"))
+		So(err, ShouldNotBeNil)
+		_, err = ParseRange([]byte("npt=abc-"))
+		So(err, ShouldNotBeNil)
+
+		So(GenRange(1500*time.Millisecond, 0), ShouldEqual, "npt=1.500-")
+		So(GenRange(0, 60*time.Second), ShouldEqual, "npt=0.000-60.000")
+	})
+
+	Convey("test clock range", t, func() {
+		r, err := ParseRange([]byte("clock=19961108T142300Z-19961108T143520.5Z"))
+		So(err, ShouldBeNil)
+		So(r.Clock, ShouldBeTrue)
+		So(r.StartTime.Equal(time.Date(1996, 11, 8, 14, 23, 0, 0, time.UTC)), ShouldBeTrue)
+		So(r.EndTime.Equal(time.Date(1996, 11, 8, 14, 35, 20, 500000000, time.UTC)), ShouldBeTrue)
+
+		r, err = ParseRange([]byte("clock=19961108T142300Z-"))
+		So(err, ShouldBeNil)
+		So(r.EndTime.IsZero(), ShouldBeTrue)
+
+		_, err = ParseRange([]byte("clock=-19961108T142300Z"))
+		So(err, ShouldNotBeNil)
+
+		start := time.Date(2026, 10, 19, 7, 0, 1, 250000000, time.Local)
+		So(GenClockRange(start, time.Time{}), ShouldEqual, "clock="+start.UTC().Format("20060102T150405")+".250Z-")
+	})
+}
+
+func TestRTPInfo(t *testing.T) {
+	Convey("test rtp-info 1.0", t, func() {
+		infos := []*RTPInfo{
+			{URL: "rtsp://127.0.0.1/live/trackID=0", Seq: 100, RTPTime: 4000000000},
+			{URL: "rtsp://127.0.0.1/live/trackID=1", Seq: 65535, RTPTime: 0},
+		}
+		v := GenRTPInfo(infos, Version10)
+		So(v, ShouldEqual, "url=rtsp://127.0.0.1/live/trackID=0;seq=100;rtptime=4000000000,url=rtsp://127.0.0.1/live/trackID=1;seq=65535;rtptime=0")
+
+		parsed, err := ParseRTPInfo([]byte(v))
+		So(err, ShouldBeNil)
+		So(parsed, ShouldResemble, infos)
+	})
+
+	Convey("test rtp-info 2.0", t, func() {
+		infos := []*RTPInfo{{URL: "rtsp://127.0.0.1/live/trackID=0", Ssrc: "0A13C760", Seq: 45102, RTPTime: 12345678}}
+		v := GenRTPInfo(infos, Version20)
+		So(v, ShouldEqual, `url="rtsp://127.0.0.1/live/trackID=0" ssrc=0A13C760:seq=45102;rtptime=12345678`)
+
+		parsed, err := ParseRTPInfo([]byte(v))
+		So(err, ShouldBeNil)
+		So(parsed, ShouldResemble, infos)
+
+		_, err = ParseRTPInfo([]byte("seq=1;rtptime=2"))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestRequestGen(t *testing.T) {
+	Convey("test request gen", t, func() {
+		r := NewRequest("SETUP", "rtsp://127.0.0.1/live/trackID=0")
+		r.AddMessage("CSeq", "3")
+		r.AddMessage("Transport", "RTP/AVP/TCP;unicast;interleaved=0-1")
+		r.AddMessage("CSeq", "4")
+		So(r.Gen(), ShouldEqual, "SETUP rtsp://127.0.0.1/live/trackID=0 RTSP/1.0\r\nCSeq: 4\r\nTransport: RTP/AVP/TCP;unicast;interleaved=0-1\r\n\r\n")
+
+		parsed := Request{}
+		err := parsed.Parse(*textproto.NewReader(bufio.NewReader(bytes.NewReader([]byte(r.Gen())))))
+		So(err, ShouldBeNil)
+		So(parsed.M, ShouldEqual, "SETUP")
+		So(parsed.Seq, ShouldEqual, 4)
+	})
+}
+
+func TestTransportInterleaved(t *testing.T) {
+	Convey("test interleaved transport parse and gen", t, func() {
+		trans, err := ParseTransport([]byte("RTP/AVP/TCP;unicast;interleaved=2-3,RTP/AVP;unicast;client_port=4000-4001"))
+		So(err, ShouldBeNil)
+		So(trans.Items, ShouldHaveLength, 2)
+		So(trans.Items[0].LowerTransport, ShouldEqual, "TCP")
+		So(trans.Items[0].Interleaved, ShouldBeTrue)
+		So(trans.Items[0].Interleaved1, ShouldEqual, 2)
+		So(trans.Items[0].Interleaved2, ShouldEqual, 3)
+		So(trans.Items[1].Interleaved, ShouldBeFalse)
+
+		b, err := GenTransport(trans)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, "RTP/AVP/TCP;unicast;interleaved=2-3,RTP/AVP/UDP;unicast;client_port=4000-4001")
+	})
+}
+
+func TestTransportMulticast(t *testing.T) {
+	Convey("test multicast transport parse and gen", t, func() {
+		item, err := ParseTransportItem([]byte("RTP/AVP;multicast;destination=239.255.42.1;port=5004-5005;ttl=16"))
+		So(err, ShouldBeNil)
+		So(item.Cast, ShouldEqual, "multicast")
+		So(item.Destination, ShouldEqual, "239.255.42.1")
+		So(item.Port1, ShouldEqual, 5004)
+		So(item.Port2, ShouldEqual, 5005)
+		So(item.Ttl, ShouldEqual, 16)
+
+		b, err := GenTransportItem(item)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, "RTP/AVP/UDP;multicast;destination=239.255.42.1;port=5004-5005;ttl=16")
+
+		_, err = ParseTransportItem([]byte("RTP/AVP;multicast;ttl=x"))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestTransport20(t *testing.T) {
+	Convey("test rtsp 2.0 transport parse and gen", t, func() {
+		item, err := ParseTransportItem([]byte(`RTP/AVP/UDP;unicast;dest_addr=":4588"/":4589";src_addr="192.0.2.1:6256"/"192.0.2.1:6257"`))
+		So(err, ShouldBeNil)
+		So(item.DestAddr, ShouldResemble, []string{":4588", ":4589"})
+		So(item.SrcAddr, ShouldResemble, []string{"192.0.2.1:6256", "192.0.2.1:6257"})
+
+		b, err := GenTransportItem(item)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, `RTP/AVP/UDP;unicast;dest_addr=":4588"/":4589";src_addr="192.0.2.1:6256"/"192.0.2.1:6257"`)
+	})
+}
+
+func TestRequestBody(t *testing.T) {
+	Convey("test request with body", t, func() {
+		r := NewRequest("ANNOUNCE", "rtsp://127.0.0.1/live")
+		r.AddMessage("CSeq", "2")
+		r.AddMessage("Content-Type", "application/sdp")
+		r.AddBody([]byte("v=0\r\n"))
+		content := r.Gen() + "OPTIONS rtsp://127.0.0.1/live RTSP/1.0\r\nCSeq: 3\r\n\r\n"
+		reader := textproto.NewReader(bufio.NewReader(strings.NewReader(content)))
+
+		parsed := Request{}
+		So(parsed.Parse(*reader), ShouldBeNil)
+		So(parsed.M, ShouldEqual, "ANNOUNCE")
+		So(string(parsed.Body()), ShouldEqual, "v=0\r\n")
+
+		parsed = Request{}
+		So(parsed.Parse(*reader), ShouldBeNil)
+		So(parsed.M, ShouldEqual, "OPTIONS")
+		So(parsed.Body(), ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,161 @@
+package rtsp
+
+import (
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+type StatusLine struct {
+	RTSPVersion  string
+	StatusCode   string
+	ReasonPhrase string
+}
+
+//TODO: str -> []byte
+func (m *StatusLine) gen() string {
+	//Status-Line = RTSP-Version SP Status-Code SP Reason-Phrase CRLF
+	return fmt.Sprintf("%s %s %s\n", m.RTSPVersion, m.StatusCode, m.ReasonPhrase)
+}
+
+func (m *StatusLine) parse(content string) error {
+	parts := strings.SplitN(content, " ", 3)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "RTSP/") {
+		return fmt.Errorf("status-line parse failed: %s", content)
+	}
+	if _, err := strconv.Atoi(parts[1]); err != nil || len(parts[1]) != 3 {
+		return fmt.Errorf("status-line parse failed: %s", content)
+	}
+
+	m.RTSPVersion = parts[0]
+	m.StatusCode = parts[1]
+	if len(parts) == 3 {
+		m.ReasonPhrase = parts[2]
+	}
+	return nil
+}
+
+type ResponseMessages struct {
+	messages map[string]string
+	// 保持header的添加顺序
+	headers []string
+}
+
+func (m *ResponseMessages) AddMessage(header, content string) {
+	if m.messages == nil {
+		m.messages = make(map[string]string)
+	}
+
+	if _, ok := m.messages[header]; !ok {
+		m.headers = append(m.headers, header)
+	}
+	m.messages[header] = content
+}
+
+// GetMessage header名称不区分大小写
+func (m *ResponseMessages) GetMessage(msgType string) (string, bool) {
+	if msg, ok := m.messages[msgType]; ok {
+		return msg, true
+	}
+	for _, k := range m.headers {
+		if strings.EqualFold(k, msgType) {
+			return m.messages[k], true
+		}
+	}
+	return "", false
+}
+
+func (m *ResponseMessages) gen() string {
+	ret := ""
+	for _, k := range m.headers {
+		ret += fmt.Sprintf("%s: %s\n", k, m.messages[k])
+	}
+	return ret
+}
+
+func (m *ResponseMessages) parseInc(content string) error {
+	index := strings.Index(content, ":")
+	if index <= 0 {
+		return fmt.Errorf("response message parse failed: %s", content)
+	}
+	m.AddMessage(strings.TrimSpace(content[:index]), strings.TrimSpace(content[index+1:]))
+	return nil
+}
+
+type Response struct {
+	StatusLine
+	ResponseMessages
+
+	// response body
+	body []byte
+}
+
+// TODO: string 与 []byte 有什么区别
+func (m *Response) Gen() string {
+	ret := ""
+	ret += m.StatusLine.gen()
+	ret += m.ResponseMessages.gen()
+	ret += "\n"
+	ret += string(m.body)
+	return ret
+}
+
+func (m *Response) AddBody(data []byte) {
+	m.ResponseMessages.AddMessage("Content-Length", fmt.Sprintf("%d", len(data)))
+	m.body = data
+}
+
+func (m *Response) Body() []byte {
+	return m.body
+}
+
+// Parse 客户端读取response，body长度由Content-Length决定
+func (m *Response) Parse(trd textproto.Reader) error {
+	statusLine, err := trd.ReadLine()
+	if err != nil {
+		return err
+	}
+	if err := m.StatusLine.parse(statusLine); err != nil {
+		return err
+	}
+
+	for {
+		data, err := trd.ReadLine()
+		if err != nil {
+			return err
+		}
+		if data == "" {
+			break
+		}
+		if err := m.parseInc(data); err != nil {
+			return err
+		}
+	}
+
+	length, ok := m.GetMessage("Content-Length")
+	if !ok {
+		return nil
+	}
+	n, err := strconv.Atoi(length)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid content-length %s", length)
+	}
+	m.body = make([]byte, n)
+	_, err = io.ReadFull(trd.R, m.body)
+	return err
+}
+
+// Seq 返回CSeq，不存在时返回-1
+func (m *Response) Seq() int64 {
+	seq, ok := m.GetMessage("CSeq")
+	if !ok {
+		return -1
+	}
+	ret, err := strconv.ParseInt(seq, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return ret
+}
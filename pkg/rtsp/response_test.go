@@ -1,8 +1,11 @@
-package pkg
+package rtsp
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
+	"net/textproto"
+	"strings"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -14,6 +17,7 @@ func TestResponse(t *testing.T) {
 		resp.StatusCode = "200"
 		resp.ReasonPhrase = "OK"
 		resp.RTSPVersion = "RTSP/1.0"
+		resp.AddMessage("CSeq", "100")
 		resp.AddMessage("Public", "DESCRIBE, SETUP, TEARDOWN, PLAY, PAUSE")
 		respContent := "RTSP/1.0 200 OK\nCSeq: 100\nPublic: DESCRIBE, SETUP, TEARDOWN, PLAY, PAUSE\n\n"
 		So(respContent, ShouldEqual, resp.Gen())
@@ -77,3 +81,30 @@ func TestResponseMessages(t *testing.T) {
 	})
 
 }
+
+func TestResponseParse(t *testing.T) {
+	Convey("test response parse", t, func() {
+		content := "RTSP/1.0 200 OK\r\nCSeq: 2\r\ncontent-base: rtsp://127.0.0.1/live/\r\nContent-Length: 5\r\n\r\nv=0\r\nRTSP/1.0 404 Not Found\nCSeq: 3\n\n"
+		reader := textproto.NewReader(bufio.NewReader(strings.NewReader(content)))
+
+		resp := Response{}
+		So(resp.Parse(*reader), ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, "200")
+		So(resp.ReasonPhrase, ShouldEqual, "OK")
+		So(resp.Seq(), ShouldEqual, 2)
+		base, ok := resp.GetMessage("Content-Base")
+		So(ok, ShouldBeTrue)
+		So(base, ShouldEqual, "rtsp://127.0.0.1/live/")
+		So(string(resp.Body()), ShouldEqual, "v=0\r\n")
+
+		resp = Response{}
+		So(resp.Parse(*reader), ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, "404")
+		So(resp.ReasonPhrase, ShouldEqual, "Not Found")
+		So(resp.Seq(), ShouldEqual, 3)
+
+		resp = Response{}
+		err := resp.Parse(*textproto.NewReader(bufio.NewReader(strings.NewReader("HTTP/1.1 200 OK\r\n\r\n"))))
+		So(err, ShouldNotBeNil)
+	})
+}
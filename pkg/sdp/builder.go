@@ -0,0 +1,43 @@
+package sdp
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// NewSDP 生成只包含session描述的sdp，media通过AddMedia添加
+func NewSDP(name string) *SDPImpl {
+	id := rand.New(rand.NewSource(time.Now().UnixNano())).Uint32()
+
+	s := &Session{
+		Item: make(map[byte][][]byte),
+	}
+	s.SetItem('v', []byte("0"))
+	s.SetItem('o', []byte(fmt.Sprintf("- %d %d IN IP4 0.0.0.0", id, id)))
+	s.SetItem('s', []byte(name))
+	s.SetItem('c', []byte("IN IP4 0.0.0.0"))
+	s.SetItem('t', []byte("0 0"))
+	s.SetItem('a', []byte("control:*"))
+
+	return &SDPImpl{
+		S: s,
+	}
+}
+
+func (s *SDPImpl) AddMedia(m *Media) {
+	s.Ms = append(s.Ms, m)
+}
+
+// NewMedia m=<media> 0 RTP/AVP <payloadType>
+func NewMedia(media string, payloadType int) *Media {
+	m := &Media{
+		Item: make(map[byte][][]byte),
+	}
+	m.SetItem('m', []byte(fmt.Sprintf("%s 0 RTP/AVP %d", media, payloadType)))
+	return m
+}
+
+func (m *Media) AddAttribute(format string, args ...interface{}) {
+	m.SetItem('a', []byte(fmt.Sprintf(format, args...)))
+}
@@ -10,7 +10,7 @@ import (
 )
 
 var (
-	sessionKeySequence = []byte{'v', 'o', 's', 'i', 'u', 'e', 'p', 'c', 'b'}
+	sessionKeySequence = []byte{'v', 'o', 's', 'i', 'u', 'e', 'p', 'c', 'b', 't', 'r', 'z', 'k', 'a'}
 	mediaKeySequence   = []byte{'m', 'i', 'c', 'b', 'k', 'a'}
 )
 
@@ -67,6 +67,9 @@ func (s *SDPImpl) Parse(data []byte) error {
 			}
 			s.Ms = append(s.Ms, instance.(*Media))
 		}
+		if instance == nil {
+			return fmt.Errorf("sdp must start with v= or m=")
+		}
 
 		err = instance.SetItem(k, v)
 		if err != nil {
@@ -211,6 +214,24 @@ func (m *Media) GetRtpmaps() ([]*Rtpmap, error) {
 	return ret, nil
 }
 
+func (m *Media) GetFmtps() ([]*Fmtp, error) {
+	//a=fmtp:96 packetization-mode=1;profile-level-id=64002A
+	ret := make([]*Fmtp, 0)
+
+	attrs := m.Item['a']
+	for _, attr := range attrs {
+		if bytes.HasPrefix(attr, []byte("fmtp:")) {
+			r, err := parseFmtp(attr)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, r)
+		}
+	}
+
+	return ret, nil
+}
+
 func (m *Media) GetControl() ([]*Control, error) {
 	//a=control:trackID=2
 	ret := make([]*Control, 0)
@@ -229,6 +250,50 @@ func (m *Media) GetControl() ([]*Control, error) {
 	return ret, nil
 }
 
+func (m *Media) GetCryptos() ([]*Crypto, error) {
+	//a=crypto:1 AES_CM_128_HMAC_SHA1_80 inline:PS1uQCVeeCFCanVmcjkpPywjNWhcYD0mXXtxaVBR|2^20|1:32
+	ret := make([]*Crypto, 0)
+
+	attrs := m.Item['a']
+	for _, attr := range attrs {
+		if bytes.HasPrefix(attr, []byte("crypto:")) {
+			r, err := parseCrypto(attr)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, r)
+		}
+	}
+	return ret, nil
+}
+
+// SetProto 修改m行的传输协议，如RTP/AVP -> RTP/SAVP
+func (m *Media) SetProto(proto string) error {
+	ms, ok := m.Item['m']
+	if !ok {
+		return fmt.Errorf("not found")
+	}
+
+	parts := bytes.Split(ms[0], []byte(" "))
+	if len(parts) < 4 {
+		return fmt.Errorf("invalid M %s", ms[0])
+	}
+	parts[2] = []byte(proto)
+	m.Item['m'] = [][]byte{bytes.Join(parts, []byte(" "))}
+	return nil
+}
+
+// AddFormat m行增加一个payload type，如RTX
+func (m *Media) AddFormat(payloadType int) error {
+	ms, ok := m.Item['m']
+	if !ok {
+		return fmt.Errorf("not found")
+	}
+
+	m.Item['m'] = [][]byte{append(append([]byte{}, ms[0]...), []byte(fmt.Sprintf(" %d", payloadType))...)}
+	return nil
+}
+
 //============================item impl=========================
 
 type M struct {
@@ -245,7 +310,7 @@ func parseM(b []byte) (*M, error) {
 
 	// s := strings.Split(str, " ")
 	parts := bytes.Split(b, []byte(" "))
-	if len(parts) != 4 {
+	if len(parts) < 4 {
 		return nil, fmt.Errorf("invalid M %s", b)
 	}
 	ret.Media = string(parts[0])
@@ -272,17 +337,25 @@ func parseM(b []byte) (*M, error) {
 	}
 
 	ret.Proto = string(parts[2])
-	ret.Fmt = string(parts[3])
+	// 多个payload type以空格分隔
+	ret.Fmt = string(bytes.Join(parts[3:], []byte(" ")))
 
 	return ret, nil
 }
 
 type Control struct {
-	value string
+	Value string
 }
 
 func parseControl(b []byte) (*Control, error) {
-	return nil, nil
+	//control:trackID=2
+	index := bytes.Index(b, []byte(":"))
+	if index == -1 {
+		return nil, fmt.Errorf("invalid control %s", b)
+	}
+	return &Control{
+		Value: string(b[index+1:]),
+	}, nil
 }
 
 type Rtpmap struct {
@@ -295,7 +368,10 @@ type Rtpmap struct {
 func parseRtpmap(b []byte) (*Rtpmap, error) {
 	var err error
 	//rtpmap:96 L8/8000
-	rtpmap := b[6:]
+	if len(b) < 7 {
+		return nil, fmt.Errorf("invalid ARtp %s", b)
+	}
+	rtpmap := b[7:]
 	//rtmap-value = payload-type SP encoding-name/clock-rate[/encoding-params]
 	parts := bytes.Split(rtpmap, []byte(" "))
 	if len(parts) != 2 {
@@ -330,3 +406,75 @@ func parseRtpmap(b []byte) (*Rtpmap, error) {
 
 	return ret, nil
 }
+
+type Fmtp struct {
+	PayloadType int
+	// 参数名统一为小写
+	Params map[string]string
+}
+
+func parseFmtp(b []byte) (*Fmtp, error) {
+	//fmtp:97 streamtype=5;config=1408
+	fmtp := b[5:]
+	index := bytes.Index(fmtp, []byte(" "))
+	if index == -1 {
+		return nil, fmt.Errorf("invalid fmtp %s", fmtp)
+	}
+
+	ret := &Fmtp{
+		Params: make(map[string]string),
+	}
+	var err error
+	ret.PayloadType, err = strconv.Atoi(string(fmtp[:index]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid fmtp %s", fmtp)
+	}
+
+	for _, param := range bytes.Split(fmtp[index+1:], []byte(";")) {
+		param = bytes.TrimSpace(param)
+		if len(param) == 0 {
+			continue
+		}
+		// base64的值中可能有'='，只按第一个'='切分
+		kv := bytes.SplitN(param, []byte("="), 2)
+		key := string(bytes.ToLower(kv[0]))
+		if len(kv) == 2 {
+			ret.Params[key] = string(kv[1])
+		} else {
+			ret.Params[key] = ""
+		}
+	}
+	return ret, nil
+}
+
+// Crypto RFC4568 SDES
+type Crypto struct {
+	Tag   int
+	Suite string
+	// inline:<key||salt>[|lifetime][|MKI:length]
+	KeyParams string
+	// 可选的session参数
+	SessionParams []string
+}
+
+func parseCrypto(b []byte) (*Crypto, error) {
+	//crypto:<tag> <crypto-suite> <key-params> [<session-params>]
+	parts := bytes.Fields(b[7:])
+	if len(parts) < 3 {
+		return nil, fmt.Errorf("invalid crypto %s", b)
+	}
+
+	ret := &Crypto{
+		Suite:     string(parts[1]),
+		KeyParams: string(parts[2]),
+	}
+	var err error
+	ret.Tag, err = strconv.Atoi(string(parts[0]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid crypto %s", b)
+	}
+	for _, p := range parts[3:] {
+		ret.SessionParams = append(ret.SessionParams, string(p))
+	}
+	return ret, nil
+}
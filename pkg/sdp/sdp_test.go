@@ -20,6 +20,60 @@ func TestSDPGen(t *testing.T) {
 			if mSession.Media == "video" {
 				So(mSession.Proto, ShouldEqual, "RTP/AVP")
 			}
+
+			rtpmaps, err := m.GetRtpmaps()
+			So(err, ShouldBeNil)
+			So(len(rtpmaps), ShouldEqual, 1)
+			fmtps, err := m.GetFmtps()
+			So(err, ShouldBeNil)
+			So(len(fmtps), ShouldEqual, 1)
+			So(fmtps[0].PayloadType, ShouldEqual, rtpmaps[0].PayloadType)
+			if mSession.Media == "video" {
+				So(rtpmaps[0].EncodingName, ShouldEqual, "H264")
+				So(rtpmaps[0].ClockRate, ShouldEqual, 90000)
+				So(fmtps[0].Params["sprop-parameter-sets"], ShouldEqual, "Z2QAKqwsaoHgCJ+WbgICAgQA,aO48sAA=")
+			} else {
+				So(fmtps[0].Params["config"], ShouldEqual, "1408")
+			}
 		}
 	})
 }
+
+func TestSDPCrypto(t *testing.T) {
+	Convey("test sdp crypto attribute", t, func() {
+		m := NewMedia("video", 96)
+		m.AddAttribute("crypto:1 AES_CM_128_HMAC_SHA1_80 inline:PS1uQCVeeCFCanVmcjkpPywjNWhcYD0mXXtxaVBR|2^20|1:32 UNENCRYPTED_SRTCP")
+		m.AddAttribute("crypto:x AES_CM_128_HMAC_SHA1_32")
+
+		_, err := m.GetCryptos()
+		So(err, ShouldNotBeNil)
+
+		m.Item['a'] = m.Item['a'][:1]
+		cryptos, err := m.GetCryptos()
+		So(err, ShouldBeNil)
+		So(cryptos, ShouldHaveLength, 1)
+		So(cryptos[0].Tag, ShouldEqual, 1)
+		So(cryptos[0].Suite, ShouldEqual, "AES_CM_128_HMAC_SHA1_80")
+		So(cryptos[0].KeyParams, ShouldEqual, "inline:PS1uQCVeeCFCanVmcjkpPywjNWhcYD0mXXtxaVBR|2^20|1:32")
+		So(cryptos[0].SessionParams, ShouldResemble, []string{"UNENCRYPTED_SRTCP"})
+
+		So(m.SetProto("RTP/SAVP"), ShouldBeNil)
+		mm, err := m.GetM()
+		So(err, ShouldBeNil)
+		So(mm.Proto, ShouldEqual, "RTP/SAVP")
+	})
+}
+
+func TestSDPFormats(t *testing.T) {
+	Convey("test m line with multiple payload types", t, func() {
+		m := NewMedia("video", 96)
+		So(m.AddFormat(97), ShouldBeNil)
+		So(m.SetProto("RTP/AVPF"), ShouldBeNil)
+		So(string(m.Gen()), ShouldEqual, "m=video 0 RTP/AVPF 96 97\n")
+
+		mm, err := m.GetM()
+		So(err, ShouldBeNil)
+		So(mm.Proto, ShouldEqual, "RTP/AVPF")
+		So(mm.Fmt, ShouldEqual, "96 97")
+	})
+}
@@ -0,0 +1,396 @@
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/fmp4"
+)
+
+const (
+	// HLS使用包含所有track的分片
+	segmentInitName = "init.mp4"
+
+	// 等待第一个分片的最长时间
+	segmentWaitTimeout = 10 * time.Second
+)
+
+// segment 一个或多个GOP
+type segment struct {
+	seq      int
+	start    time.Duration
+	duration time.Duration
+	// styp + 所有fragment
+	data      []byte
+	fragments []*fmp4.Fragment
+
+	// 按track拆分的数据(DASH)，第一次请求时生成
+	tracks map[int]*trackSegment
+}
+
+type trackSegment struct {
+	// 单位为track的timescale
+	baseTime uint64
+	duration uint64
+	data     []byte
+}
+
+// track 生成只包含一个track的分片，需持有segmentCache的锁
+func (s *segment) track(id int) *trackSegment {
+	if t, ok := s.tracks[id]; ok {
+		return t
+	}
+
+	ret := &trackSegment{data: fmp4.GenStyp()}
+	first := true
+	for _, f := range s.fragments {
+		for _, traf := range f.Tracks {
+			if traf.TrackID != id {
+				continue
+			}
+			if first {
+				ret.baseTime = traf.BaseDecodeTime
+				first = false
+			}
+			for _, sample := range traf.Samples {
+				ret.duration += uint64(sample.Duration)
+			}
+			ret.data = append(ret.data, fmp4.GenFragment(f.Seq, []*fmp4.TrackFragment{traf})...)
+		}
+	}
+
+	if s.tracks == nil {
+		s.tracks = make(map[int]*trackSegment)
+	}
+	s.tracks[id] = ret
+	return ret
+}
+
+// segmentCache 将一个stream转换为fMP4分片并保存最近的分片，HLS和DASH共用
+type segmentCache struct {
+	path  string
+	conf  *HLSConfig
+	muxer *muxer
+
+	mu       sync.Mutex
+	segments []*segment
+	// 正在生成的分片
+	current *segment
+	nextSeq int
+	// 第一个分片开始的时间，DASH的availabilityStartTime
+	startTime  time.Time
+	changed    chan struct{}
+	closed     bool
+	lastAccess time.Time
+}
+
+func newSegmentCache(stream *Stream, conf *HLSConfig) *segmentCache {
+	ret := &segmentCache{
+		path:       stream.Path,
+		conf:       conf,
+		changed:    make(chan struct{}),
+		lastAccess: time.Now(),
+	}
+	ret.muxer = newMuxer(stream, ret.writeFragment)
+	return ret
+}
+
+func (c *segmentCache) writeFragment(f *fmp4.Fragment) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if f == nil {
+		c.closed = true
+		c.notify()
+		return nil
+	}
+
+	// 在关键帧处切分
+	target := time.Duration(c.conf.SegmentDuration) * time.Second
+	if c.current != nil && f.KeyFrame && c.current.duration >= target {
+		c.segments = append(c.segments, c.current)
+		c.current = nil
+
+		// 多保留几个分片给落后的客户端
+		if len(c.segments) > c.conf.WindowSize+2 {
+			c.segments = c.segments[len(c.segments)-c.conf.WindowSize-2:]
+		}
+		c.notify()
+	}
+
+	if c.current == nil {
+		if !f.KeyFrame {
+			return nil
+		}
+		if c.startTime.IsZero() {
+			c.startTime = time.Now().Add(-f.Start)
+		}
+		c.current = &segment{
+			seq:   c.nextSeq,
+			start: f.Start,
+			data:  fmp4.GenStyp(),
+		}
+		c.nextSeq++
+	}
+	c.current.duration += f.Duration
+	c.current.data = append(c.current.data, f.Data...)
+	c.current.fragments = append(c.current.fragments, f)
+	return nil
+}
+
+// notify 需持有锁
+func (c *segmentCache) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// idle 超过IdleTimeout没有请求playlist/manifest
+func (c *segmentCache) idle() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed || time.Since(c.lastAccess) > time.Duration(c.conf.IdleTimeout)*time.Second
+}
+
+// window 需持有锁，返回playlist/manifest中的分片
+func (c *segmentCache) window() []*segment {
+	segments := c.segments
+	if len(segments) > c.conf.WindowSize {
+		segments = segments[len(segments)-c.conf.WindowSize:]
+	}
+	return segments
+}
+
+// manifest 调用gen生成playlist/manifest，没有分片时等待第一个分片生成
+func (c *segmentCache) manifest(r *http.Request, gen func() []byte) ([]byte, error) {
+	timeout := time.NewTimer(segmentWaitTimeout)
+	defer timeout.Stop()
+
+	for {
+		c.mu.Lock()
+		c.lastAccess = time.Now()
+		if len(c.segments) > 0 {
+			ret := gen()
+			c.mu.Unlock()
+			return ret, nil
+		}
+		if c.closed {
+			c.mu.Unlock()
+			return nil, fmt.Errorf("stream %s closed", c.path)
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-timeout.C:
+			return nil, fmt.Errorf("stream %s has no segment", c.path)
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+	}
+}
+
+func (c *segmentCache) segment(seq int) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, s := range c.segments {
+		if s.seq == seq {
+			return s.data, true
+		}
+	}
+	return nil, false
+}
+
+func (c *segmentCache) trackSegment(id, seq int) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, s := range c.segments {
+		if s.seq == seq {
+			return s.track(id).data, true
+		}
+	}
+	return nil, false
+}
+
+func (c *segmentCache) trackInit(id int) ([]byte, bool) {
+	for _, t := range c.muxer.fmp4.Tracks() {
+		if t.ID == id {
+			data, err := fmp4.GenInit([]*fmp4.Track{t})
+			return data, err == nil
+		}
+	}
+	return nil, false
+}
+
+// segmentServer 处理HLS和DASH请求，同一路径的客户端共用一个segmentCache
+// HLS:  /<path>/index.m3u8, /<path>/init.mp4, /<path>/seg<N>.m4s
+// DASH: /<path>/manifest.mpd, /<path>/init-<track>.mp4, /<path>/seg-<track>-<N>.m4s
+type segmentServer struct {
+	srv *Server
+
+	mu     sync.Mutex
+	caches map[string]*segmentCache
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func newSegmentServer(srv *Server) *segmentServer {
+	ret := &segmentServer{
+		srv:    srv,
+		caches: make(map[string]*segmentCache),
+		done:   make(chan struct{}),
+	}
+	go ret.checkIdle()
+	return ret
+}
+
+// getCache 请求playlist/manifest时创建
+func (h *segmentServer) getCache(path string, create bool) (*segmentCache, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if c, ok := h.caches[path]; ok {
+		return c, nil
+	}
+	if !create {
+		return nil, fmt.Errorf("stream %s is not muxing", path)
+	}
+
+	stream, rest, ok := h.srv.findStream(path)
+	if !ok || rest != "" {
+		return nil, fmt.Errorf("stream %s not found", path)
+	}
+
+	c := newSegmentCache(stream, &h.srv.Config.HLS)
+	if err := c.muxer.start(); err != nil {
+		return nil, err
+	}
+	h.caches[path] = c
+	stream.log.Info("segment start")
+	return c, nil
+}
+
+func (h *segmentServer) checkIdle() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-h.done:
+			return
+		}
+
+		h.mu.Lock()
+		idle := make([]*segmentCache, 0)
+		for path, c := range h.caches {
+			if c.idle() {
+				idle = append(idle, c)
+				delete(h.caches, path)
+			}
+		}
+		h.mu.Unlock()
+
+		for _, c := range idle {
+			h.srv.Logger.Info("segment stop", "path", c.path)
+			c.muxer.stop()
+		}
+	}
+}
+
+// close 停止所有分片，服务关闭时调用
+func (h *segmentServer) close() {
+	h.closeOnce.Do(func() {
+		close(h.done)
+	})
+
+	h.mu.Lock()
+	caches := h.caches
+	h.caches = make(map[string]*segmentCache)
+	h.mu.Unlock()
+
+	for _, c := range caches {
+		c.muxer.stop()
+	}
+}
+
+func (h *segmentServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	index := strings.LastIndex(r.URL.Path, "/")
+	path, name := normalizePath(r.URL.Path[:index]), r.URL.Path[index+1:]
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	c, err := h.getCache(path, name == hlsPlaylistName || name == dashManifestName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var data []byte
+	ok := true
+	switch {
+	case name == hlsPlaylistName:
+		data, err = c.manifest(r, c.genHLSPlaylist)
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+	case name == dashManifestName:
+		data, err = c.manifest(r, c.genMPD)
+		w.Header().Set("Content-Type", "application/dash+xml")
+		w.Header().Set("Cache-Control", "no-cache")
+	case name == segmentInitName:
+		data = c.muxer.Init()
+		w.Header().Set("Content-Type", "video/mp4")
+	case matchName(name, "init-", ".mp4"):
+		var id int
+		if id, err = strconv.Atoi(trimName(name, "init-", ".mp4")); err == nil {
+			data, ok = c.trackInit(id)
+		}
+		w.Header().Set("Content-Type", "video/mp4")
+	case matchName(name, "seg-", ".m4s"):
+		parts := strings.Split(trimName(name, "seg-", ".m4s"), "-")
+		ok = false
+		if len(parts) == 2 {
+			id, err1 := strconv.Atoi(parts[0])
+			seq, err2 := strconv.Atoi(parts[1])
+			if err1 == nil && err2 == nil {
+				data, ok = c.trackSegment(id, seq)
+			}
+		}
+		w.Header().Set("Content-Type", "video/iso.segment")
+	case matchName(name, "seg", ".m4s"):
+		var seq int
+		if seq, err = strconv.Atoi(trimName(name, "seg", ".m4s")); err == nil {
+			data, ok = c.segment(seq)
+		}
+		w.Header().Set("Content-Type", "video/iso.segment")
+	default:
+		ok = false
+	}
+
+	if err != nil || !ok {
+		w.Header().Del("Content-Type")
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(data)
+}
+
+func matchName(name, prefix, suffix string) bool {
+	return strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix)
+}
+
+func trimName(name, prefix, suffix string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix)
+}
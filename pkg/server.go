@@ -1,29 +1,698 @@
 package pkg
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
+const (
+	preloadRetryMin = time.Second
+	preloadRetryMax = 30 * time.Second
+)
+
+// ErrServerClosed Shutdown之后Serve、ServeTLS和ListenAndServe返回
+var ErrServerClosed = errors.New("rtsp: server closed")
+
 type Server struct {
+	Config *Config
+	// 为nil时根据Config.Log创建，输出到标准错误
+	Logger *slog.Logger
+	// 为nil且配置了Config.Auth.URL时调用该地址，允许的结果按Config.Auth.CacheTTL缓存
+	Authorizer Authorizer
+	// 事件回调，与Config.Hooks一样在单独的goroutine中按顺序调用
+	OnEvent func(Event)
+
+	mu        sync.RWMutex
+	streams   map[string]*Stream
+	recorders map[string]*recorder
+	pushers   map[string][]*pusher
+	ports     *PortAllocator
+	// 开启组播的挂载路径 -> 组播发送
+	groups   map[string]*multicastGroup
+	segments *segmentServer
+	http     *http.ServeMux
+	metrics  *metrics
+	admin    *http.ServeMux
+	// 所有rtsp连接
+	sessions  map[*RtspServerSession]struct{}
+	startTime time.Time
+
+	// Config.Limits及挂载路径的地址列表，init之后不变
+	filter       *ipFilter
+	mountFilters map[string]*ipFilter
+	// 每个IP的连接数和session数
+	connsPerIP    map[string]int
+	sessionsPerIP map[string]int
+	// 带缓存的Authorizer，没有配置时为nil
+	auth  Authorizer
+	hooks *hooks
+
+	// Serve和ServeTLS共用，只启动一次
+	startOnce sync.Once
+
+	// init的结果，配置错误时不启动
+	initErr error
+
+	// 关闭后不再接受连接，Shutdown等待所有session的goroutine退出
+	closing     bool
+	listeners   map[net.Listener]struct{}
+	httpServers []*http.Server
+	wg          sync.WaitGroup
+	// closing时关闭，中断预连接的重试等待
+	stopped chan struct{}
+}
+
+// init 可以重复调用，只初始化一次；配置错误时返回错误，Serve、ServeTLS和ListenAndServe不启动
+func (s *Server) init() error {
+	if s.streams != nil {
+		return s.initErr
+	}
+	if s.Config == nil {
+		s.Config = &Config{}
+	}
+	s.Config.setDefault()
+	if s.Logger == nil {
+		s.Logger = newLogger(&s.Config.Log, os.Stderr)
+	}
+	// 不经过LoadConfig的配置同样检查
+	s.initErr = s.Config.validate()
+
+	s.streams = make(map[string]*Stream)
+	s.recorders = make(map[string]*recorder)
+	s.pushers = make(map[string][]*pusher)
+	s.sessions = make(map[*RtspServerSession]struct{})
+	s.listeners = make(map[net.Listener]struct{})
+	s.stopped = make(chan struct{})
+	s.startTime = time.Now()
+	s.hooks = newHooks(s.Config.Hooks, s.OnEvent, s.Logger)
+	for _, mc := range s.Config.Mounts {
+		st := newStream(mc, s.Logger)
+		st.hooks = s.hooks
+		s.streams[mc.Path] = st
+	}
+	if err := s.initLimits(); err != nil && s.initErr == nil {
+		s.initErr = err
+	}
+	s.initAuth()
+	s.ports = NewPortAllocator(s.Config.RtpPortMin, s.Config.RtpPortMax)
+	s.metrics = newMetrics(s)
+	s.initMulticast()
+
+	s.segments = newSegmentServer(s)
+	s.http = http.NewServeMux()
+	s.http.Handle("/", s.segments)
+	s.initAdmin()
+	return s.initErr
+}
+
+// initLimits 地址列表错误时返回错误，不能以不限制的方式启动
+func (s *Server) initLimits() error {
+	s.connsPerIP = make(map[string]int)
+	s.sessionsPerIP = make(map[string]int)
+	s.mountFilters = make(map[string]*ipFilter)
+
+	var err error
+	if s.filter, err = newIPFilter(s.Config.Limits.Allow, s.Config.Limits.Deny); err != nil {
+		return fmt.Errorf("invalid limits: %v", err)
+	}
+	for _, mc := range s.Config.Mounts {
+		f, err := newIPFilter(mc.Allow, mc.Deny)
+		if err != nil {
+			return fmt.Errorf("invalid limits of mount %s: %v", mc.Path, err)
+		}
+		s.mountFilters[mc.Path] = f
+	}
+	return nil
+}
+
+func (s *Server) initAuth() {
+	s.auth = s.Authorizer
+	if s.auth == nil && s.Config.Auth.URL != "" {
+		s.auth = newHTTPAuthorizer(s.Config.Auth.URL)
+	}
+	if s.auth != nil && s.Config.Auth.CacheTTL > 0 {
+		s.auth = newAuthCache(s.auth, time.Duration(s.Config.Auth.CacheTTL)*time.Second)
+	}
 }
 
+// initMulticast 为开启组播的挂载路径分配组播地址，失败时该路径只支持单播
+func (s *Server) initMulticast() {
+	s.groups = make(map[string]*multicastGroup)
+
+	var allocator *MulticastAllocator
+	for _, mc := range s.Config.Mounts {
+		if !mc.Multicast {
+			continue
+		}
+		if allocator == nil {
+			var err error
+			if allocator, err = NewMulticastAllocator(s.Config.Multicast.Range); err != nil {
+				s.Logger.Error("multicast disabled", "err", err)
+				return
+			}
+		}
+
+		ip, err := allocator.Alloc()
+		if err != nil {
+			s.Logger.Error("multicast failed", "path", mc.Path, "err", err)
+			continue
+		}
+		group, err := newMulticastGroup(s.streams[mc.Path], ip, s.Config.Multicast.Port, s.Config.Multicast.TTL)
+		if err != nil {
+			allocator.Release(ip)
+			s.Logger.Error("multicast failed", "path", mc.Path, "err", err)
+			continue
+		}
+		group.metrics = s.metrics
+		s.groups[mc.Path] = group
+	}
+}
+
+// multicastGroup 挂载路径没有开启组播时返回nil
+func (s *Server) multicastGroup(path string) *multicastGroup {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.groups[path]
+}
+
+// Run 一直运行直到出错，需要停止时使用ListenAndServe和Shutdown
 func (s *Server) Run() {
-	listener, err := net.Listen("tcp", ":8554")
+	if err := s.ListenAndServe(context.Background()); err != nil && err != ErrServerClosed {
+		s.Logger.Error("rtsp failed", "err", err)
+	}
+}
+
+// ListenAndServe 监听Config.Listen，配置了证书时同时监听Config.TLS.Listen
+// ctx结束后停止接受连接并返回ErrServerClosed，之后由Shutdown关闭已有的session
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", s.Config.Listen)
 	if err != nil {
-		fmt.Println(err.Error())
-		return
+		return err
+	}
+
+	if s.Config.TLS.Cert != "" {
+		listener, err := net.Listen("tcp", s.Config.TLS.Listen)
+		if err != nil {
+			s.Logger.Error("rtsps listen failed", "err", err)
+		} else {
+			go func() {
+				if err := s.ServeTLS(listener); err != nil && err != ErrServerClosed {
+					s.Logger.Error("rtsps failed", "err", err)
+				}
+			}()
+		}
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.Serve(listener)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		s.stopAccepting()
+		return ErrServerClosed
+	}
+}
+
+// Serve 在listener上接受rtsp连接，Shutdown之后返回ErrServerClosed
+func (s *Server) Serve(listener net.Listener) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	s.startOnce.Do(s.start)
+	return s.accept(listener)
+}
+
+// ServeTLS 在listener上接受rtsps连接，证书取自Config.TLS，配置错误或Shutdown之后返回
+func (s *Server) ServeTLS(listener net.Listener) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	config, err := newTLSConfig(&s.Config.TLS, s.Logger)
+	if err != nil {
+		return err
+	}
+	s.startOnce.Do(s.start)
+	return s.accept(tls.NewListener(listener, config))
+}
+
+// Shutdown 停止接受连接，向所有session发送RTCP BYE后断开连接，等待session释放资源，
+// 之后停止录制(写完当前文件)、转推、source和事件通知。ctx结束时不再等待，返回ctx.Err()
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.init()
+	s.stopAccepting()
+
+	s.mu.RLock()
+	servers := s.httpServers
+	groups := make([]*multicastGroup, 0, len(s.groups))
+	for _, g := range s.groups {
+		groups = append(groups, g)
+	}
+	s.mu.RUnlock()
+
+	httpDone := make(chan struct{})
+	go func() {
+		for _, hs := range servers {
+			if hs.Shutdown(ctx) != nil {
+				hs.Close()
+			}
+		}
+		close(httpDone)
+	}()
+
+	for _, g := range groups {
+		g.goodbye()
+	}
+	for _, rss := range s.sessionList() {
+		rss.shutdown()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		<-httpDone
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.Logger.Warn("shutdown timeout", "sessions", len(s.sessionList()))
+	}
+
+	s.mu.Lock()
+	recorders := s.recorders
+	s.recorders = make(map[string]*recorder)
+	pushers := s.pushers
+	s.pushers = make(map[string][]*pusher)
+	streams := make([]*Stream, 0, len(s.streams))
+	for _, st := range s.streams {
+		streams = append(streams, st)
+	}
+	s.mu.Unlock()
+
+	for _, r := range recorders {
+		r.stop()
+	}
+	for _, list := range pushers {
+		for _, p := range list {
+			p.stop()
+		}
+	}
+	s.segments.close()
+	for _, g := range groups {
+		g.close()
+	}
+	for _, st := range streams {
+		st.Close()
+	}
+	s.hooks.close(ctx)
+	return err
+}
+
+// stopAccepting 关闭所有rtsp listener，可以重复调用
+func (s *Server) stopAccepting() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closing {
+		s.closing = true
+		close(s.stopped)
+	}
+	for l := range s.listeners {
+		l.Close()
+		delete(s.listeners, l)
+	}
+}
+
+func (s *Server) closed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.closing
+}
+
+// start 启动HTTP服务、管理接口、预连接、转推和录制
+func (s *Server) start() {
+	if s.Config.HTTPListen != "" {
+		go s.serveHTTP("http", s.Config.HTTPListen, s.http)
+	}
+	if s.Config.AdminListen != "" {
+		go s.serveHTTP("admin", s.Config.AdminListen, s.admin)
+	}
+
+	for _, mc := range s.Config.Mounts {
+		if mc.PullAlways {
+			go s.preload(mc.Path)
+		}
+	}
+
+	for _, mc := range s.Config.Mounts {
+		stream, _, _ := s.findStream(mc.Path)
+		for _, pc := range mc.Push {
+			p := newPusher(stream, pc)
+			s.mu.Lock()
+			s.pushers[mc.Path] = append(s.pushers[mc.Path], p)
+			s.mu.Unlock()
+			p.start()
+		}
 	}
 
+	for _, mc := range s.Config.Mounts {
+		if !mc.Record {
+			continue
+		}
+		if err := s.StartRecording(mc.Path); err != nil {
+			s.Logger.Error("record failed", "path", mc.Path, "err", err)
+		}
+	}
+}
+
+func (s *Server) accept(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.listeners[listener] = struct{}{}
+	s.mu.Unlock()
+
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			fmt.Println(err.Error())
+			if s.closed() {
+				return ErrServerClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			s.Logger.Error("accept failed", "err", err)
 			continue
 		}
 
-		session := NewRtspServerSession(conn)
+		session := NewRtspServerSession(s, conn)
 		session.Init()
-		go session.Run()
+		if err := s.addSession(session); err != nil {
+			conn.Close()
+			if err == ErrServerClosed {
+				return err
+			}
+			s.metrics.reject(err.(*rejectError).reason)
+			session.log().Debug("connection rejected", "err", err)
+			continue
+		}
+		go func() {
+			defer s.wg.Done()
+			session.Run()
+		}()
+	}
+}
+
+// serveHTTP 记录http.Server，Shutdown时关闭
+func (s *Server) serveHTTP(name, addr string, handler http.Handler) {
+	hs := &http.Server{Addr: addr, Handler: handler}
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return
+	}
+	s.httpServers = append(s.httpServers, hs)
+	s.mu.Unlock()
+
+	if err := hs.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		s.Logger.Error(name+" failed", "err", err)
+	}
+}
+
+// preload 连接远端直到成功或服务关闭，之后的断线由source重连
+func (s *Server) preload(path string) {
+	stream, _, _ := s.findStream(path)
+	retry := preloadRetryMin
+	for !s.closed() {
+		err := stream.Preload()
+		if err == nil {
+			return
+		}
+		s.Logger.Warn("preload failed", "path", path, "err", err)
+
+		timer := time.NewTimer(retry)
+		select {
+		case <-timer.C:
+		case <-s.stopped:
+			timer.Stop()
+			return
+		}
+		if retry *= 2; retry > preloadRetryMax {
+			retry = preloadRetryMax
+		}
+	}
+}
+
+// StartRecording 开始录制挂载路径上的stream
+func (s *Server) StartRecording(path string) error {
+	path = normalizePath(path)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream, ok := s.streams[path]
+	if !ok {
+		return fmt.Errorf("stream %s not found", path)
+	}
+	if _, ok := s.recorders[path]; ok {
+		return fmt.Errorf("stream %s is already recording", path)
+	}
+
+	r := newRecorder(stream, &s.Config.Record)
+	if err := r.start(); err != nil {
+		return err
+	}
+	s.recorders[path] = r
+	return nil
+}
+
+// StopRecording 停止录制，当前文件写完后返回
+func (s *Server) StopRecording(path string) error {
+	path = normalizePath(path)
+
+	s.mu.Lock()
+	r, ok := s.recorders[path]
+	delete(s.recorders, path)
+	s.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("stream %s is not recording", path)
+	}
+	r.stop()
+	return nil
+}
+
+func (s *Server) IsRecording(path string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.recorders[normalizePath(path)]
+	return ok
+}
+
+// PushStatus 挂载路径上所有转推目标的状态
+func (s *Server) PushStatus(path string) []PushStatus {
+	s.mu.RLock()
+	pushers := s.pushers[normalizePath(path)]
+	s.mu.RUnlock()
+
+	ret := make([]PushStatus, 0, len(pushers))
+	for _, p := range pushers {
+		ret = append(ret, p.Status())
+	}
+	return ret
+}
+
+// addSession 开始关闭后返回ErrServerClosed，超过限制或地址不允许时返回*rejectError
+func (s *Server) addSession(rss *RtspServerSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return ErrServerClosed
+	}
+	limits := &s.Config.Limits
+	ip := rss.ip.String()
+	switch {
+	case !s.filter.allowed(rss.ip):
+		return &rejectError{reason: rejectDeny}
+	case limits.MaxConnections > 0 && len(s.sessions) >= limits.MaxConnections:
+		return &rejectError{reason: rejectMaxConnections}
+	case limits.MaxConnectionsPerIP > 0 && s.connsPerIP[ip] >= limits.MaxConnectionsPerIP:
+		return &rejectError{reason: rejectMaxConnectionsPerIP}
 	}
+	s.sessions[rss] = struct{}{}
+	s.connsPerIP[ip]++
+	s.wg.Add(1)
+	return nil
+}
+
+func (s *Server) removeSession(rss *RtspServerSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[rss]; !ok {
+		return
+	}
+	delete(s.sessions, rss)
+	ip := rss.ip.String()
+	if s.connsPerIP[ip]--; s.connsPerIP[ip] <= 0 {
+		delete(s.connsPerIP, ip)
+	}
+}
+
+// acquireSession SETUP创建session时调用，超过每个IP的session数时返回false
+func (s *Server) acquireSession(ip net.IP) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	max := s.Config.Limits.MaxSessionsPerIP
+	if max > 0 && s.sessionsPerIP[ip.String()] >= max {
+		return false
+	}
+	s.sessionsPerIP[ip.String()]++
+	return true
+}
+
+// releaseSession TEARDOWN或连接断开时调用
+func (s *Server) releaseSession(ip net.IP) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sessionsPerIP[ip.String()]--; s.sessionsPerIP[ip.String()] <= 0 {
+		delete(s.sessionsPerIP, ip.String())
+	}
+}
+
+// mountAllowed 挂载路径的地址列表，全局的列表在连接时检查
+func (s *Server) mountAllowed(path string, ip net.IP) bool {
+	return s.mountFilters[path].allowed(ip)
+}
+
+// sessionList session可能持有自己的锁访问server，不能在持有server的锁时访问session
+func (s *Server) sessionList() []*RtspServerSession {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ret := make([]*RtspServerSession, 0, len(s.sessions))
+	for rss := range s.sessions {
+		ret = append(ret, rss)
+	}
+	return ret
+}
+
+// Sessions 所有连接的状态，按连接的远端地址排序
+func (s *Server) Sessions() []SessionInfo {
+	sessions := s.sessionList()
+	ret := make([]SessionInfo, 0, len(sessions))
+	for _, rss := range sessions {
+		ret = append(ret, rss.Info())
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].RemoteAddr < ret[j].RemoteAddr })
+	return ret
+}
+
+// KickSession 断开session，释放其占用的资源
+func (s *Server) KickSession(id string) error {
+	for _, rss := range s.sessionList() {
+		if id != "" && rss.Info().ID == id {
+			rss.kick()
+			return nil
+		}
+	}
+	return fmt.Errorf("session %s not found", id)
+}
+
+// Streams 所有挂载路径的状态，按路径排序
+func (s *Server) Streams() []StreamInfo {
+	s.mu.RLock()
+	streams := make([]*Stream, 0, len(s.streams))
+	for _, st := range s.streams {
+		streams = append(streams, st)
+	}
+	s.mu.RUnlock()
+
+	ret := make([]StreamInfo, 0, len(streams))
+	for _, st := range streams {
+		ret = append(ret, st.Info())
+	}
+
+	sessions := s.Sessions()
+	for i := range ret {
+		for _, info := range sessions {
+			if info.Path != ret[i].Path {
+				continue
+			}
+			switch info.State {
+			case State2String[PLAYING]:
+				ret[i].Viewers++
+			case State2String[RECORDING]:
+				ret[i].Publisher = info.ID
+			}
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Path < ret[j].Path })
+	return ret
+}
+
+// findStream 按最长前缀匹配挂载路径，返回stream及剩余部分(track control)
+func (s *Server) findStream(path string) (*Stream, string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rest := ""
+	for {
+		if st, ok := s.streams[path]; ok {
+			return st, rest, true
+		}
+
+		index := strings.LastIndex(path, "/")
+		if index <= 0 {
+			return nil, "", false
+		}
+		if rest == "" {
+			rest = path[index+1:]
+		} else {
+			rest = path[index+1:] + "/" + rest
+		}
+		path = path[:index]
+	}
+}
+
+// parseRequestPath rtsp://host:port/live/cam1 -> /live/cam1
+func parseRequestPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	return normalizePath(u.Path), nil
+}
+
+func normalizePath(path string) string {
+	return "/" + strings.Trim(path, "/")
 }
@@ -0,0 +1,160 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/client"
+	"github.com/Lcmasdf/drs/pkg/rtcp"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestShutdown(t *testing.T) {
+	Convey("test shutdown sends bye, releases sessions and finishes recordings", t, func() {
+		dir := t.TempDir()
+		srv := &Server{Config: &Config{
+			Record: RecordConfig{Path: filepath.Join(dir, "{path}", "{start}.mp4")},
+			Mounts: []*MountConfig{{Path: "/live", Source: writeTestH264(t), FrameRate: 100, Record: true}},
+		}}
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		served := make(chan error, 1)
+		go func() {
+			served <- srv.Serve(listener)
+		}()
+		url := "rtsp://" + listener.Addr().String() + "/live"
+
+		play := func(transport string) (*client.Client, chan *rtcp.Goodbye) {
+			bye := make(chan *rtcp.Goodbye, 1)
+			c, err := client.Dial(url, client.Options{
+				Transport: transport,
+				OnRTCP: func(track int, data []byte) {
+					pkts, _ := rtcp.Unmarshal(data)
+					for _, pkt := range pkts {
+						if p, ok := pkt.(*rtcp.Goodbye); ok {
+							select {
+							case bye <- p:
+							default:
+							}
+						}
+					}
+				},
+			})
+			So(err, ShouldBeNil)
+			_, err = c.Describe()
+			So(err, ShouldBeNil)
+			So(c.SetupAll(), ShouldBeNil)
+			_, err = c.Play(nil)
+			So(err, ShouldBeNil)
+			<-c.Packets()
+			return c, bye
+		}
+		tcp, tcpBye := play(client.TransportTCP)
+		defer tcp.Close()
+		udp, udpBye := play(client.TransportUDP)
+		defer udp.Close()
+		So(srv.Sessions(), ShouldHaveLength, 2)
+		So(srv.ports.Used(), ShouldBeGreaterThan, 0)
+		So(srv.IsRecording("/live"), ShouldBeTrue)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		So(srv.Shutdown(ctx), ShouldBeNil)
+		So(<-served, ShouldEqual, ErrServerClosed)
+
+		for _, bye := range []chan *rtcp.Goodbye{tcpBye, udpBye} {
+			select {
+			case p := <-bye:
+				So(p.Sources, ShouldHaveLength, 1)
+				So(p.Reason, ShouldEqual, "server shutdown")
+			case <-time.After(5 * time.Second):
+				So("timeout", ShouldBeEmpty)
+			}
+		}
+		select {
+		case <-tcp.Done():
+		case <-time.After(5 * time.Second):
+			So("timeout", ShouldBeEmpty)
+		}
+
+		So(srv.Sessions(), ShouldBeEmpty)
+		So(srv.ports.Used(), ShouldEqual, 0)
+		So(srv.IsRecording("/live"), ShouldBeFalse)
+
+		files, err := filepath.Glob(filepath.Join(dir, "live", "*.mp4"))
+		So(err, ShouldBeNil)
+		So(files, ShouldHaveLength, 1)
+		data, err := ioutil.ReadFile(files[0])
+		So(err, ShouldBeNil)
+		So(bytes.Contains(data, []byte("moof")), ShouldBeTrue)
+
+		_, err = client.Dial(url, client.Options{})
+		So(err, ShouldNotBeNil)
+		So(srv.Serve(listener), ShouldEqual, ErrServerClosed)
+	})
+
+	Convey("test listen and serve stops accepting when context is done", t, func() {
+		srv := &Server{Config: &Config{Listen: "127.0.0.1:0"}}
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() {
+			served <- srv.ListenAndServe(ctx)
+		}()
+		cancel()
+		select {
+		case err := <-served:
+			So(err, ShouldEqual, ErrServerClosed)
+		case <-time.After(5 * time.Second):
+			So("timeout", ShouldBeEmpty)
+		}
+		So(srv.Shutdown(context.Background()), ShouldBeNil)
+	})
+
+	Convey("test shutdown stops preload retries", t, func() {
+		// 连接后立即关闭的远端，预连接一直失败
+		upstream, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer upstream.Close()
+		attempts := make(chan struct{}, 16)
+		go func() {
+			for {
+				conn, err := upstream.Accept()
+				if err != nil {
+					return
+				}
+				conn.Close()
+				select {
+				case attempts <- struct{}{}:
+				default:
+				}
+			}
+		}()
+
+		srv := &Server{Config: &Config{Mounts: []*MountConfig{
+			{Path: "/cam", Source: "rtsp://" + upstream.Addr().String() + "/cam", PullTransport: "tcp", PullAlways: true},
+		}}}
+		serveTest(srv)
+		<-attempts
+
+		So(srv.Shutdown(context.Background()), ShouldBeNil)
+		// 重试等待被中断，preload的goroutine退出
+		deadline := time.Now().Add(500 * time.Millisecond)
+		for preloading() && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(preloading(), ShouldBeFalse)
+	})
+}
+
+// preloading 是否还有Server.preload的goroutine
+func preloading() bool {
+	buf := make([]byte, 1<<20)
+	return strings.Contains(string(buf[:runtime.Stack(buf, true)]), "(*Server).preload")
+}
@@ -1,177 +1,1611 @@
 package pkg
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"math/rand"
 	"net"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Lcmasdf/drs/pkg/rtcp"
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/rtsp"
 	"github.com/Lcmasdf/drs/pkg/sdp"
+	"github.com/Lcmasdf/drs/pkg/source"
+	"github.com/Lcmasdf/drs/pkg/srtp"
 )
 
-//rtsp 连接 C->S
+// rtsp 连接 C->S
 type RtspServerSession struct {
 	// tcp 连接
-	conn net.Conn
+	conn   net.Conn
+	reader *textproto.Reader
+	// rtsps连接
+	secure bool
+	// 远端地址，用于连接限制
+	ip net.IP
+	// 请求速率限制，只在session goroutine中使用
+	limiter *tokenBucket
+	// 最后一次收到请求或数据的时间(UnixNano)，超时后由checkTimeout断开
+	lastActive atomic.Int64
+	timedOut   atomic.Bool
+	// 握手成功后通知connect，之后断开时通知disconnect
+	connected bool
 
-	sm *ServerStatusMachine
+	srv *Server
+	sm  *ServerStatusMachine
 
 	sessionId string
+	// 创建session时的RTSP版本，之后的请求需使用相同的版本
+	version string
+	// RTSP 2.0 Pipelined-Requests，SETUP返回Session之前的请求通过它关联session
+	pipelined string
+	// PLAY的url，PLAY_NOTIFY使用
+	playURI   string
+	notifySeq int64
 
 	seq int64
 
-	sdp sdp.SDP
+	// 带session ID、远端地址及当前请求CSeq的logger，每个请求更新
+	logger atomic.Pointer[slog.Logger]
 
-	transport *TransportItem
+	stream *Stream
+	// 点播暂停后再次PLAY需要resume
+	started bool
+	// 第一次PLAY时开始定时发送RTCP SR
+	reporting bool
+
+	// 推流: ANNOUNCE的sdp，SETUP时用于查找track
+	announced *sdp.SDPImpl
+	publisher *source.Publish
+	// RECORD之后，断开时通知publish_stop
+	publishing bool
+
+	// 组播的track由group发送
+	group *multicastGroup
+
+	// DESCRIBE下发的SRTP密钥，path -> 每个track的key
+	srtpKeys map[string][]*srtp.Key
+
+	mu     sync.Mutex
+	tracks map[int]*sessionTrack
+	// 管理接口使用的状态，每个请求处理之后更新
+	status sessionStatus
+
+	// response和interleaved数据共用tcp连接
+	writeMu sync.Mutex
+	// 待发送的interleaved数据及PLAY_NOTIFY，writePacket不能阻塞
+	interleaved chan []byte
+	done        chan struct{}
+}
+
+// sessionStatus session goroutine中的状态，供其他goroutine读取，由mu保护
+type sessionStatus struct {
+	id        string
+	version   string
+	uri       string
+	state     state
+	path      string
+	userAgent string
 }
 
-func NewRtspServerSession(conn net.Conn) *RtspServerSession {
+// sessionTrack 一个SETUP过的track
+type sessionTrack struct {
+	transport *rtsp.TransportItem
+	ssrc      uint32
+	// SETUP的url，用于RTP-Info
+	uri string
+	// 挂载路径，用于统计发送的数据
+	path      string
+	stats     senderStats
+	sentBytes uint64
+
+	serverPort int
+	rtpConn    *net.UDPConn
+	rtcpConn   *net.UDPConn
+	rtpAddr    *net.UDPAddr
+	rtcpAddr   *net.UDPAddr
+
+	// RTP/AVP/TCP 时RTP使用的channel，UDP时为-1
+	channel int
+	// mode=record，从客户端接收数据
+	record bool
+	// 由stream的组播group发送
+	multicast bool
+	// RTP/SAVP，播放时加密，推流时解密
+	srtp *srtp.Context
+
+	// RTP/AVPF，处理viewer的NACK和PLI/FIR
+	feedback bool
+	rtxPT    uint8
+	rtxSSRC  uint32
+	rtxSeq   uint16
+	sent     *retransmitBuffer
+	// 推流端的SSRC，请求关键帧时使用，原子操作
+	remoteSSRC uint32
+}
+
+func NewRtspServerSession(srv *Server, conn net.Conn) *RtspServerSession {
 	sm := &ServerStatusMachine{}
 	// sm.Init()
 
-	//for test
-	mockSDP := &sdp.SDPImpl{}
-	mockSDP.Parse(sdp.MockSDP)
-
-	return &RtspServerSession{
-		conn: conn,
-		sm:   sm,
-		sdp:  mockSDP,
+	_, secure := conn.(*tls.Conn)
+	ret := &RtspServerSession{
+		conn:        conn,
+		secure:      secure,
+		reader:      textproto.NewReader(bufio.NewReader(conn)),
+		srv:         srv,
+		sm:          sm,
+		tracks:      make(map[int]*sessionTrack),
+		srtpKeys:    make(map[string][]*srtp.Key),
+		interleaved: make(chan []byte, 1024),
+		done:        make(chan struct{}),
 	}
+	ret.ip = remoteIP(conn)
+	ret.limiter = newTokenBucket(srv.Config.Limits.RequestRate, srv.Config.Limits.RequestBurst)
+	ret.active()
+	ret.setLogger(0)
+	return ret
 }
 
 func (rss *RtspServerSession) Init() {
 	rss.sm.OptionsHandler = rss.OptionsHandler
 	rss.sm.DescribeHandler = rss.DescribeHandler
 	rss.sm.SetupInitHandler = rss.SetupInitHandler
+	rss.sm.SetupReadyHandler = rss.SetupInitHandler
+	rss.sm.SetupPlayingHandler = rss.SetupInitHandler
+	rss.sm.PlayReadyHandler = rss.PlayHandler
+	rss.sm.PlayPlayingHandler = rss.PlayHandler
+	rss.sm.PausePlayingHandler = rss.PauseHandler
+	rss.sm.TeardownInitHandler = rss.TeardownHandler
+	rss.sm.TeardownReadyHandler = rss.TeardownHandler
+	rss.sm.TeardownPlayingHandler = rss.TeardownHandler
+	rss.sm.AnnounceHandler = rss.AnnounceHandler
+	rss.sm.RecordReadyHandler = rss.RecordHandler
+	rss.sm.SetupRecordingHandler = rss.SetupInitHandler
+	rss.sm.PauseRecordingHandler = rss.PauseHandler
+	rss.sm.TeardownRecordingHandler = rss.TeardownHandler
 	rss.sm.Init()
 }
 
 func (rss *RtspServerSession) Run() {
+	defer rss.close()
+
+	// 要求客户端证书时握手失败计为认证失败
+	if conn, ok := rss.conn.(*tls.Conn); ok {
+		if err := conn.Handshake(); err != nil {
+			if rss.srv.Config.TLS.ClientCA != "" {
+				rss.srv.metrics.authFailure()
+			}
+			rss.log().Warn("tls handshake failed", "err", err)
+			return
+		}
+	}
+	rss.connected = true
+	rss.event(EventConnect)
+	go rss.writeInterleaved()
+	go rss.checkTimeout()
+
 	for {
-		req := &Request{}
-		err := req.GenRequest(rss.conn)
+		// 推流的RTP，客户端的RTCP忽略
+		if b, err := rss.reader.R.Peek(1); err == nil && b[0] == '$' {
+			if err := rss.readInterleaved(); err != nil {
+				rss.log().Info("read interleaved failed", "err", err)
+				break
+			}
+			rss.active()
+			continue
+		}
+
+		// 客户端对PLAY_NOTIFY的response
+		if b, err := rss.reader.R.Peek(5); err == nil && string(b) == "RTSP/" {
+			resp := &rtsp.Response{}
+			if err := resp.Parse(*rss.reader); err != nil {
+				rss.log().Info("read response failed", "err", err)
+				break
+			}
+			continue
+		}
+
+		req := &rtsp.Request{}
+		err := req.Parse(*rss.reader)
 		if err != nil {
-			fmt.Println("gen request failed", err.Error())
+			rss.log().Info("read request failed", "err", err)
 			break
 		}
-		fmt.Println(req)
+		rss.active()
+		rss.setLogger(req.Seq)
+		log := rss.log()
+		if log.Enabled(context.Background(), slog.LevelDebug) {
+			log.Debug("request", "method", req.M, "message", req.Gen())
+		}
 
-		resp := rss.sm.Request(req)
-		fmt.Println(resp)
+		resp := rss.checkRequest(req)
+		if resp == nil {
+			resp = rss.sm.Request(req)
+		}
+		if resp == nil {
+			resp = rss.unsupportedHandler(req)
+		}
+		if p, ok := req.GetMessage("Pipelined-Requests"); ok && req.Version == rtsp.Version20 {
+			resp.AddMessage("Pipelined-Requests", p)
+		}
+		rss.srv.metrics.request(req.M, resp.StatusCode)
+		rss.updateStatus(req)
 		data := resp.Gen()
 
+		// SETUP之后带上分配的session ID
+		rss.setLogger(req.Seq)
+		log = rss.log()
+		log.Debug("response", "status", resp.StatusCode, "message", data)
+
+		rss.writeMu.Lock()
 		_, err = rss.conn.Write([]byte(data))
+		rss.writeMu.Unlock()
 		if err != nil {
-			fmt.Println("write line failed ", err.Error())
+			log.Warn("write response failed", "err", err)
 		}
 	}
 }
 
-func (rss *RtspServerSession) OptionsHandler(r *Request) *Response {
-	rss.seq = r.Seq
+// close 连接断开，释放session占用的资源
+func (rss *RtspServerSession) close() {
+	if rss.timedOut.Load() {
+		rss.event(EventTimeout)
+	}
+	if rss.sessionId != "" {
+		rss.srv.releaseSession(rss.ip)
+	}
+	rss.teardown()
+	if rss.connected {
+		rss.event(EventDisconnect)
+	}
+	close(rss.done)
+	rss.conn.Close()
+	rss.srv.removeSession(rss)
+}
+
+// event 在session goroutine中调用
+func (rss *RtspServerSession) event(typ string) {
+	e := &Event{
+		Type:       typ,
+		SessionID:  rss.sessionId,
+		RemoteAddr: rss.conn.RemoteAddr().String(),
+	}
+	if rss.stream != nil {
+		e.Path = rss.stream.Path
+	}
+	rss.srv.hooks.fire(e)
+}
+
+func (rss *RtspServerSession) active() {
+	rss.lastActive.Store(time.Now().UnixNano())
+}
+
+// checkTimeout 建立session后超过Config.SessionTimeout没有收到请求、数据或RTCP时关闭连接，由Run释放资源；
+// 组播的RTCP不经过session，组播session不检查
+func (rss *RtspServerSession) checkTimeout() {
+	timeout := time.Duration(rss.srv.Config.SessionTimeout) * time.Second
+	if timeout <= 0 {
+		return
+	}
+	// 检查间隔最小1s
+	interval := timeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-rss.done:
+			return
+		}
+
+		rss.mu.Lock()
+		established := rss.status.id != ""
+		for _, t := range rss.tracks {
+			if t.multicast {
+				established = false
+			}
+		}
+		rss.mu.Unlock()
+		if established && time.Since(time.Unix(0, rss.lastActive.Load())) > timeout {
+			rss.log().Info("session timeout")
+			rss.timedOut.Store(true)
+			rss.conn.Close()
+			return
+		}
+	}
+}
+
+// sessionHeader response的Session头，带上timeout，客户端据此发送keepalive
+func (rss *RtspServerSession) sessionHeader() string {
+	session, _ := rtsp.GenSession(&rtsp.Session{
+		SessionId: rss.sessionId,
+		Timeout:   uint64(rss.srv.Config.SessionTimeout),
+	})
+	return string(session)
+}
+
+// setLogger 在session goroutine中调用
+func (rss *RtspServerSession) setLogger(cseq int64) {
+	rss.logger.Store(rss.srv.Logger.With("session", rss.sessionId, "remote", rss.conn.RemoteAddr().String(), "cseq", cseq))
+}
+
+func (rss *RtspServerSession) log() *slog.Logger {
+	return rss.logger.Load()
+}
+
+// updateStatus 在session goroutine中调用
+func (rss *RtspServerSession) updateStatus(r *rtsp.Request) {
+	rss.mu.Lock()
+	defer rss.mu.Unlock()
+
+	rss.status.id = rss.sessionId
+	rss.status.version = rss.version
+	rss.status.uri = rss.playURI
+	if rss.status.uri == "" {
+		rss.status.uri = r.URI
+	}
+	rss.status.state = rss.sm.st
+	rss.status.path = ""
+	if rss.stream != nil {
+		rss.status.path = rss.stream.Path
+	}
+	if ua, ok := r.GetMessage("User-Agent"); ok {
+		rss.status.userAgent = ua
+	}
+}
+
+// Info 管理接口返回的session状态
+func (rss *RtspServerSession) Info() SessionInfo {
+	rss.mu.Lock()
+	defer rss.mu.Unlock()
+
+	ret := SessionInfo{
+		ID:         rss.status.id,
+		RemoteAddr: rss.conn.RemoteAddr().String(),
+		State:      State2String[rss.status.state],
+		Path:       rss.status.path,
+		UserAgent:  rss.status.userAgent,
+		Transports: make([]string, 0, len(rss.tracks)),
+	}
+	order := make([]int, 0, len(rss.tracks))
+	for i := range rss.tracks {
+		order = append(order, i)
+	}
+	sort.Ints(order)
+	for _, i := range order {
+		t := rss.tracks[i]
+		if transport, err := rtsp.GenTransportItem(t.transport); err == nil {
+			ret.Transports = append(ret.Transports, string(transport))
+		}
+		ret.BytesSent += t.sentBytes
+	}
+	return ret
+}
+
+// kick 管理接口踢出session，RTSP 2.0先由服务端发送TEARDOWN，连接关闭后由Run释放资源
+func (rss *RtspServerSession) kick() {
+	rss.mu.Lock()
+	status := rss.status
+	rss.mu.Unlock()
+
+	if status.version == rtsp.Version20 && status.id != "" {
+		req := rtsp.NewRequest("TEARDOWN", status.uri)
+		req.Version = rtsp.Version20
+		req.AddMessage("CSeq", strconv.FormatInt(atomic.AddInt64(&rss.notifySeq, 1), 10))
+		req.AddMessage("Session", status.id)
+		req.AddMessage("Connection", "close")
+
+		rss.writeMu.Lock()
+		rss.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		rss.conn.Write([]byte(req.Gen()))
+		rss.writeMu.Unlock()
+	}
+	rss.conn.Close()
+}
+
+// shutdown 服务关闭，向播放的track发送RTCP BYE后同kick
+func (rss *RtspServerSession) shutdown() {
+	rss.goodbye("server shutdown")
+	rss.kick()
+}
+
+// goodbye interleaved的BYE直接写入连接，避免连接关闭时还在发送队列中
+func (rss *RtspServerSession) goodbye(reason string) {
+	frames := make([][]byte, 0)
+	rss.mu.Lock()
+	for _, t := range rss.tracks {
+		if t.record || t.multicast {
+			continue
+		}
+		data := (&rtcp.Goodbye{Sources: []uint32{t.ssrc}, Reason: reason}).Marshal()
+		if t.srtp != nil {
+			var err error
+			if data, err = t.srtp.EncryptRTCP(data); err != nil {
+				continue
+			}
+		}
+		if t.channel < 0 {
+			t.rtcpConn.WriteToUDP(data, t.rtcpAddr)
+			continue
+		}
+		frames = append(frames, interleavedFrame(t.channel+1, data))
+	}
+	rss.mu.Unlock()
+
+	if len(frames) == 0 {
+		return
+	}
+	rss.writeMu.Lock()
+	defer rss.writeMu.Unlock()
+	rss.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	for _, frame := range frames {
+		if _, err := rss.conn.Write(frame); err != nil {
+			return
+		}
+	}
+}
+
+// readInterleaved 读取一个 $ channel length data
+func (rss *RtspServerSession) readInterleaved() error {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(rss.reader.R, header); err != nil {
+		return err
+	}
+	data := make([]byte, binary.BigEndian.Uint16(header[2:]))
+	if _, err := io.ReadFull(rss.reader.R, data); err != nil {
+		return err
+	}
+
+	channel := int(header[1])
+	rss.mu.Lock()
+	track, rtcpTrack := -1, -1
+	var st *sessionTrack
+	for i, t := range rss.tracks {
+		if t.record && t.channel == channel {
+			track, st = i, t
+		}
+		if t.feedback && t.channel+1 == channel {
+			rtcpTrack, st = i, t
+		}
+	}
+	rss.mu.Unlock()
+	if track >= 0 && rss.publisher != nil {
+		rss.publishPacket(rss.publisher, track, st, data)
+	}
+	if rtcpTrack >= 0 {
+		rss.handleRTCP(rss.stream, rtcpTrack, st, data)
+	}
+	return nil
+}
+
+// readRecord 接收UDP推流，conn关闭后退出
+func (rss *RtspServerSession) readRecord(publisher *source.Publish, track int, st *sessionTrack) {
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := st.rtpConn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		rss.active()
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		rss.publishPacket(publisher, track, st, data)
+	}
+}
+
+// readRTCP 接收UDP viewer的RTCP，任何包都计为活动，AVPF时处理反馈，conn关闭后退出
+func (rss *RtspServerSession) readRTCP(stream *Stream, track int, st *sessionTrack) {
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := st.rtcpConn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		rss.active()
+		if !st.feedback {
+			continue
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		rss.handleRTCP(stream, track, st, data)
+	}
+}
+
+// publishPacket SRTP时先解密
+func (rss *RtspServerSession) publishPacket(publisher *source.Publish, track int, st *sessionTrack, data []byte) {
+	if st.srtp != nil {
+		var err error
+		if data, err = st.srtp.DecryptRTP(data); err != nil {
+			rss.log().Warn("drop srtp packet", "track", track, "err", err)
+			return
+		}
+	}
+
+	pkt := &rtp.Packet{}
+	if err := pkt.Unmarshal(data); err != nil {
+		rss.log().Warn("invalid rtp packet", "track", track, "err", err)
+		return
+	}
+	atomic.StoreUint32(&st.remoteSSRC, pkt.SSRC)
+	publisher.WritePacket(track, pkt)
+}
 
-	ret := &Response{
-		StatusLine: StatusLine{
+// handleRTCP NACK从重传缓存中发送RTX，PLI/FIR转发给stream的source
+func (rss *RtspServerSession) handleRTCP(stream *Stream, track int, st *sessionTrack, data []byte) {
+	if st.srtp != nil {
+		var err error
+		if data, err = st.srtp.DecryptRTCP(data); err != nil {
+			rss.log().Warn("drop srtcp packet", "track", track, "err", err)
+			return
+		}
+	}
+
+	pkts, err := rtcp.Unmarshal(data)
+	if err != nil {
+		rss.log().Warn("invalid rtcp packet", "track", track, "err", err)
+		return
+	}
+	for _, p := range pkts {
+		switch p := p.(type) {
+		case *rtcp.NACK:
+			rss.retransmit(st, p.Lost)
+		case *rtcp.PLI, *rtcp.FIR:
+			stream.RequestKeyFrame(track)
+		}
+	}
+}
+
+// retransmit 已经不在缓存中的包忽略
+func (rss *RtspServerSession) retransmit(st *sessionTrack, lost []uint16) {
+	rss.mu.Lock()
+	defer rss.mu.Unlock()
+
+	for _, seq := range lost {
+		p := st.sent.get(seq)
+		if p == nil {
+			continue
+		}
+		rss.sendRTP(st, rtp.NewRTX(p, st.rtxPT, st.rtxSSRC, st.rtxSeq))
+		st.rtxSeq++
+	}
+}
+
+// requestKeyFrame 向推流端发送PLI，由stream在viewer请求关键帧时调用
+func (rss *RtspServerSession) requestKeyFrame(track int) {
+	rss.mu.Lock()
+	defer rss.mu.Unlock()
+
+	t, ok := rss.tracks[track]
+	if !ok || !t.record {
+		return
+	}
+	pli := &rtcp.PLI{SenderSSRC: t.ssrc, MediaSSRC: atomic.LoadUint32(&t.remoteSSRC)}
+	rss.sendRTCP(t, pli.Marshal())
+}
+
+func (rss *RtspServerSession) writeInterleaved() {
+	for {
+		select {
+		case data := <-rss.interleaved:
+			rss.writeMu.Lock()
+			_, err := rss.conn.Write(data)
+			rss.writeMu.Unlock()
+			if err != nil {
+				return
+			}
+		case <-rss.done:
+			return
+		}
+	}
+}
+
+func (rss *RtspServerSession) teardown() {
+	if rss.publisher != nil {
+		rss.stream.Unpublish(rss.publisher)
+		rss.publisher = nil
+		rss.announced = nil
+	}
+	if rss.publishing {
+		rss.publishing = false
+		rss.event(EventPublishStop)
+	}
+	if rss.stream != nil {
+		rss.stream.RemoveReader(rss)
+		if rss.stream.private {
+			rss.stream.Close()
+		}
+	}
+	if rss.group != nil {
+		rss.group.removeViewer(rss)
+		rss.group = nil
+	}
+	rss.started = false
+
+	rss.mu.Lock()
+	defer rss.mu.Unlock()
+	for i, t := range rss.tracks {
+		t.close(rss.srv.ports)
+		delete(rss.tracks, i)
+	}
+}
+
+func genResponse(r *rtsp.Request, statusCode, reasonPhrase string) *rtsp.Response {
+	ret := &rtsp.Response{
+		StatusLine: rtsp.StatusLine{
 			RTSPVersion:  r.Version,
-			StatusCode:   "200",
-			ReasonPhrase: "OK",
+			StatusCode:   statusCode,
+			ReasonPhrase: reasonPhrase,
 		},
 	}
+	ret.AddMessage("CSeq", fmt.Sprintf("%d", r.Seq))
+	return ret
+}
+
+func (rss *RtspServerSession) unsupportedHandler(r *rtsp.Request) *rtsp.Response {
+	rss.seq = r.Seq
+
+	if _, ok := Method2method[r.M]; ok {
+		return genResponse(r, "455", "Method Not Valid in This State")
+	}
+	return genResponse(r, "501", "Not Implemented")
+}
+
+// checkRequest 版本协商，RTSP 2.0删除了ANNOUNCE和RECORD
+func (rss *RtspServerSession) checkRequest(r *rtsp.Request) *rtsp.Response {
+	if !rss.limiter.allow(time.Now()) {
+		return rss.reject(r, rejectRateLimit)
+	}
+	if r.Version != rtsp.Version10 && r.Version != rtsp.Version20 {
+		ret := genResponse(r, "505", "RTSP Version Not Supported")
+		ret.RTSPVersion = rtsp.Version20
+		return ret
+	}
+	if rss.version != "" && r.Version != rss.version {
+		return genResponse(r, "505", "RTSP Version Not Supported")
+	}
+	if r.Version == rtsp.Version20 && (r.M == "ANNOUNCE" || r.M == "RECORD") {
+		return genResponse(r, "501", "Not Implemented")
+	}
+
+	// 只支持play.basic
+	if require, ok := r.GetMessage("Require"); ok {
+		unsupported := make([]string, 0)
+		for _, tag := range strings.Split(require, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" && tag != "play.basic" {
+				unsupported = append(unsupported, tag)
+			}
+		}
+		if len(unsupported) > 0 {
+			ret := genResponse(r, "551", "Option Not Supported")
+			ret.AddMessage("Unsupported", strings.Join(unsupported, ", "))
+			return ret
+		}
+	}
+	return nil
+}
 
-	ret.AddMessage("Public", strings.Join(methods, ","))
-	ret.AddMessage("CSeq", fmt.Sprintf("%d", rss.seq))
+// reject 超过限制或地址不允许，返回503
+func (rss *RtspServerSession) reject(r *rtsp.Request, reason string) *rtsp.Response {
+	rss.srv.metrics.reject(reason)
+	rss.log().Info("request rejected", "reason", reason)
+	ret := genResponse(r, "503", "Service Unavailable")
+	if reason == rejectRateLimit {
+		ret.AddMessage("Retry-After", "1")
+	}
 	return ret
 }
 
-func (rss *RtspServerSession) DescribeHandler(r *Request) *Response {
+// authorize 没有配置Authorizer时返回nil，拒绝时返回401或403
+func (rss *RtspServerSession) authorize(r *rtsp.Request, action, path string) *rtsp.Response {
+	if rss.srv.auth == nil {
+		return nil
+	}
+
+	req := &AuthRequest{
+		Action:   action,
+		Path:     path,
+		IP:       rss.ip.String(),
+		Protocol: "rtsp",
+	}
+	if rss.secure {
+		req.Protocol = "rtsps"
+	}
+	if u, err := url.Parse(r.URI); err == nil {
+		req.Query = u.RawQuery
+	}
+	req.User, req.Password = basicAuth(r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(rss.srv.Config.Auth.Timeout)*time.Second)
+	defer cancel()
+	err := rss.srv.auth.Authorize(ctx, req)
+	if err == nil {
+		return nil
+	}
+	rss.log().Info("authorization failed", "action", action, "path", path, "user", req.User, "err", err)
+	if errors.Is(err, ErrUnauthorized) {
+		ret := genResponse(r, "401", "Unauthorized")
+		ret.AddMessage("WWW-Authenticate", `Basic realm="drs"`)
+		return ret
+	}
+	return genResponse(r, "403", "Forbidden")
+}
+
+// checkSession 校验请求中的Session头
+func (rss *RtspServerSession) checkSession(r *rtsp.Request) *rtsp.Response {
+	session, ok := r.GetMessage("Session")
+	if !ok {
+		// RTSP 2.0 收到SETUP的response之前通过Pipelined-Requests关联session
+		if p, ok := r.GetMessage("Pipelined-Requests"); ok && rss.sessionId != "" && p == rss.pipelined {
+			return nil
+		}
+		return genResponse(r, "454", "Session Not Found")
+	}
+
+	s, err := rtsp.ParseSession([]byte(session))
+	if err != nil || s.SessionId != rss.sessionId {
+		return genResponse(r, "454", "Session Not Found")
+	}
+	return nil
+}
+
+func (rss *RtspServerSession) OptionsHandler(r *rtsp.Request) *rtsp.Response {
 	rss.seq = r.Seq
 
-	ret := &Response{
-		StatusLine: StatusLine{
-			RTSPVersion:  r.Version,
-			StatusCode:   "200",
-			ReasonPhrase: "OK",
-		},
+	ret := genResponse(r, "200", "OK")
+	if r.Version != rtsp.Version20 {
+		ret.AddMessage("Public", strings.Join(methods, ","))
+		return ret
+	}
+
+	public := make([]string, 0, len(methods))
+	for _, m := range methods {
+		if m != "ANNOUNCE" && m != "RECORD" {
+			public = append(public, m)
+		}
+	}
+	ret.AddMessage("Public", strings.Join(public, ","))
+	ret.AddMessage("Supported", "play.basic")
+	return ret
+}
+
+func (rss *RtspServerSession) DescribeHandler(r *rtsp.Request) *rtsp.Response {
+	rss.seq = r.Seq
+
+	path, err := parseRequestPath(r.URI)
+	if err != nil {
+		return genResponse(r, "400", "Bad Request")
+	}
+
+	stream, rest, ok := rss.srv.findStream(path)
+	if !ok || rest != "" {
+		return genResponse(r, "404", "Not Found")
+	}
+	if !rss.srv.mountAllowed(stream.Path, rss.ip) {
+		return rss.reject(r, rejectMountDeny)
+	}
+	if resp := rss.authorize(r, ActionRead, stream.Path); resp != nil {
+		return resp
+	}
+
+	s, err := stream.Describe()
+	if err != nil {
+		rss.log().Warn("describe failed", "path", path, "err", err)
+		return genResponse(r, "503", "Service Unavailable")
+	}
+	if stream.mount.SRTP {
+		if s, err = rss.describeSRTP(stream, s); err != nil {
+			return genResponse(r, "500", err.Error())
+		}
+	} else if group := rss.srv.multicastGroup(stream.Path); group != nil && !stream.VOD() && !stream.mount.AVPF {
+		s = group.describe(s)
 	}
-	ret.AddMessage("CSeq", fmt.Sprintf("%d", rss.seq))
+	if stream.mount.AVPF {
+		if s, err = describeAVPF(s); err != nil {
+			return genResponse(r, "500", err.Error())
+		}
+	}
+
+	ret := genResponse(r, "200", "OK")
 	ret.AddMessage("Date", time.Now().Format(time.RFC1123))
+	ret.AddMessage("Content-Base", strings.TrimSuffix(r.URI, "/")+"/")
 	ret.AddMessage("Content-Type", "application/sdp")
-	ret.AddBody(rss.sdp.Gen())
+	ret.AddBody(s.Gen())
 
 	return ret
 }
 
-func (rss *RtspServerSession) SetupInitHandler(r *Request) *Response {
+func (rss *RtspServerSession) SetupInitHandler(r *rtsp.Request) *rtsp.Response {
 	rss.seq = r.Seq
-	ret := &Response{
-		StatusLine: StatusLine{
-			RTSPVersion:  r.Version,
-			StatusCode:   "200",
-			ReasonPhrase: "OK",
-		},
+
+	if rss.sessionId != "" {
+		if resp := rss.checkSession(r); resp != nil {
+			return resp
+		}
+	}
+
+	path, err := parseRequestPath(r.URI)
+	if err != nil {
+		return genResponse(r, "400", "Bad Request")
+	}
+
+	stream, control, ok := rss.srv.findStream(path)
+	if !ok {
+		return genResponse(r, "404", "Not Found")
+	}
+	if rss.stream != nil && rss.stream.Path != stream.Path {
+		return genResponse(r, "459", "Aggregate Operation Not Allowed")
+	}
+	if !rss.srv.mountAllowed(stream.Path, rss.ip) {
+		return rss.reject(r, rejectMountDeny)
+	}
+	action := ActionRead
+	if rss.announced != nil {
+		action = ActionPublish
+	}
+	if resp := rss.authorize(r, action, stream.Path); resp != nil {
+		return resp
+	}
+	// 新的session计入每个IP的session数，SETUP失败时释放
+	if rss.sessionId == "" {
+		if !rss.srv.acquireSession(rss.ip) {
+			return rss.reject(r, rejectMaxSessionsPerIP)
+		}
+		defer func() {
+			if rss.sessionId == "" {
+				rss.srv.releaseSession(rss.ip)
+			}
+		}()
+	}
+
+	// 推流的track在ANNOUNCE的sdp中查找
+	record := rss.announced != nil
+	var track int
+	if record {
+		track, err = trackByControl(rss.announced, control)
+	} else {
+		track, err = stream.TrackByControl(control)
+	}
+	if err != nil {
+		return genResponse(r, "404", "Not Found")
+	}
+
+	if rss.stream != nil {
+		stream = rss.stream
 	}
-	ret.AddMessage("CSeq", fmt.Sprintf("%d", rss.seq))
+	// 点播每个session单独读取文件，transport校验通过之后再Fork
+	fork := !stream.private && stream.VOD()
 
 	transport, ok := r.GetMessage("Transport")
 	if !ok {
-		ret.StatusCode = "300"
-		ret.ReasonPhrase = "transport not found"
+		ret := genResponse(r, "300", "transport not found")
 		return ret
 	}
 
 	//parse transport
-	t, err := parseTransport([]byte(transport))
+	t, err := rtsp.ParseTransport([]byte(transport))
 	if err != nil {
-		ret.StatusCode = "500"
-		ret.ReasonPhrase = err.Error()
-		return ret
+		return genResponse(r, "500", err.Error())
 	}
 
-	//TODO: transport select
-	// for test, use index 0 transport item
-	rss.transport = t.Items[0]
+	// 开启SRTP的挂载路径只允许RTP/SAVP，推流时由ANNOUNCE的sdp决定
+	profile := "AVP"
+	if (record && srtp.IsSAVP(rss.announced.Ms[track])) || (!record && stream.mount.SRTP) {
+		profile = "SAVP"
+	}
+	if record && stream.mount.SRTP && profile != "SAVP" {
+		return genResponse(r, "461", "Unsupported Transport")
+	}
+	// 推流端使用AVPF时同样接受，播放时AVPF的track也接受不支持反馈的客户端
+	var feedback *avpfTrack
+	if record {
+		if mm, err := rss.announced.Ms[track].GetM(); err == nil && strings.HasSuffix(mm.Proto, "F") {
+			profile += "F"
+		}
+	} else if stream.mount.AVPF {
+		s, err := stream.Describe()
+		if err != nil {
+			return genResponse(r, "503", "Service Unavailable")
+		}
+		feedback = avpfTracks(s)[track]
+	}
 
-	// TODO: get pair of udp ports
-	// use 30001-30002
-	rss.transport.ServerPort1 = 30001
-	rss.transport.ServerPort2 = 30002
+	// 组播只用于播放直播
+	var group *multicastGroup
+	if !record && !stream.private && !fork && profile == "AVP" && !stream.mount.AVPF {
+		group = rss.srv.multicastGroup(stream.Path)
+	}
+
+	// transport select
+	var item *rtsp.TransportItem
+	for _, v := range t.Items {
+		if v.Protocol != "RTP" || (v.Profile != profile && (feedback == nil || v.Profile != profile+"F")) {
+			continue
+		}
+		// rtsps上不允许明文UDP
+		if rss.secure && rss.srv.Config.TLS.RequireSecureTransport && v.Profile != "SAVP" && v.LowerTransport != "TCP" {
+			continue
+		}
+		if (v.LowerTransport == "UDP" || v.LowerTransport == "TCP") && v.Cast == "unicast" {
+			item = v
+			break
+		}
+		if v.LowerTransport == "UDP" && v.Cast == "multicast" && group != nil {
+			item = v
+			break
+		}
+	}
+	if item == nil {
+		return genResponse(r, "461", "Unsupported Transport")
+	}
+	if transportMode(item) == "record" && !record {
+		return genResponse(r, "455", "Method Not Valid in This State")
+	}
+	// RTSP 2.0 客户端端口由dest_addr指定
+	if r.Version == rtsp.Version20 && item.LowerTransport == "UDP" && item.Cast == "unicast" {
+		if item.ClientPort1, item.ClientPort2, err = destPorts(item.DestAddr); err != nil {
+			return genResponse(r, "461", "Unsupported Transport")
+		}
+	}
+	if fork {
+		stream = stream.Fork()
+	}
 
 	// gen ssrc
-	rss.transport.Ssrc = genSsrc()
+	item.Ssrc = genSsrc()
+	ssrc, _ := strconv.ParseUint(item.Ssrc, 16, 32)
 
-	// gen session
-	rss.sessionId = genRandomSessionId()
-	session, err := genSession(&Session{
-		SessionId: rss.sessionId,
-		Timeout:   60,
-	})
-	if err != nil {
-		ret.StatusCode = "500"
-		ret.ReasonPhrase = err.Error()
-		return ret
+	st := &sessionTrack{
+		transport: item,
+		ssrc:      uint32(ssrc),
+		uri:       r.URI,
+		path:      stream.Path,
+		channel:   -1,
+		record:    record,
+	}
+	if feedback != nil && strings.HasSuffix(item.Profile, "F") {
+		st.feedback = true
+		st.rtxPT = uint8(feedback.rtx)
+		st.rtxSSRC = rand.Uint32()
+		st.sent = newRetransmitBuffer()
+	}
+	if profile == "SAVP" {
+		key, err := rss.srtpKey(stream.Path, track)
+		if err != nil {
+			rss.log().Warn("setup failed", "path", stream.Path, "err", err)
+			return genResponse(r, "461", "Unsupported Transport")
+		}
+		if st.srtp, err = srtp.NewContext(key); err != nil {
+			return genResponse(r, "500", err.Error())
+		}
+	}
+	if item.Cast == "multicast" {
+		// 地址和端口由服务端决定
+		group.setup(track, item)
+		st.ssrc = group.ssrc(track)
+		st.multicast = true
+		rss.group = group
+	} else if item.LowerTransport == "TCP" {
+		// 客户端没有指定channel时按track分配
+		if !item.Interleaved {
+			item.Interleaved = true
+			item.Interleaved1, item.Interleaved2 = 2*track, 2*track+1
+		}
+		st.channel = item.Interleaved1
+	} else {
+		// get pair of udp ports
+		port, rtpConn, rtcpConn, err := rss.srv.ports.Alloc()
+		if err != nil {
+			return genResponse(r, "503", "Service Unavailable")
+		}
+		item.ServerPort1 = port
+		item.ServerPort2 = port + 1
+
+		remote := rss.conn.RemoteAddr().(*net.TCPAddr)
+		st.serverPort = port
+		st.rtpConn = rtpConn
+		st.rtcpConn = rtcpConn
+		st.rtpAddr = &net.UDPAddr{IP: remote.IP, Port: item.ClientPort1}
+		st.rtcpAddr = &net.UDPAddr{IP: remote.IP, Port: item.ClientPort2}
+		if record {
+			go rss.readRecord(rss.publisher, track, st)
+		} else {
+			go rss.readRTCP(stream, track, st)
+		}
+	}
+
+	rss.mu.Lock()
+	if old, ok := rss.tracks[track]; ok {
+		old.close(rss.srv.ports)
 	}
-	ret.AddMessage("Session", string(session))
+	rss.tracks[track] = st
+	rss.mu.Unlock()
+	rss.stream = stream
 
-	transResp := &Transport{
-		Items: []*TransportItem{
-			rss.transport,
+	// gen session
+	if rss.sessionId == "" {
+		rss.sessionId = genRandomSessionId()
+		rss.version = r.Version
+		rss.pipelined, _ = r.GetMessage("Pipelined-Requests")
+		rss.event(EventSessionCreate)
+	}
+	if r.Version == rtsp.Version20 {
+		rss.transport20(item)
+	}
+	transResp := &rtsp.Transport{
+		Items: []*rtsp.TransportItem{
+			item,
 		},
 	}
 
-	transRespByte, err := genTransport(transResp)
+	transRespByte, err := rtsp.GenTransport(transResp)
 	if err != nil {
-		ret.StatusCode = "500"
-		ret.ReasonPhrase = err.Error()
-		return ret
+		return genResponse(r, "500", err.Error())
 	}
 
+	ret := genResponse(r, "200", "OK")
+	ret.AddMessage("Session", rss.sessionHeader())
 	ret.AddMessage("Transport", string(transRespByte))
+	if r.Version == rtsp.Version20 {
+		ret.AddMessage("Accept-Ranges", "npt")
+		ret.AddMessage("Media-Properties", stream.MediaProperties())
+	}
+
+	return ret
+}
+
+func (rss *RtspServerSession) PlayHandler(r *rtsp.Request) *rtsp.Response {
+	rss.seq = r.Seq
+
+	if resp := rss.checkSession(r); resp != nil {
+		return resp
+	}
+	if rss.publisher != nil {
+		return genResponse(r, "455", "Method Not Valid in This State")
+	}
+
+	scale, speed, err := parseScale(r)
+	if err != nil {
+		return genResponse(r, "400", "Bad Request")
+	}
+
+	var start time.Duration
+	seeked, clockRange := false, false
+	if rss.stream.private {
+		if v, ok := r.GetMessage("Range"); ok {
+			rng, err := rtsp.ParseRange([]byte(v))
+			if err != nil {
+				return genResponse(r, "457", "Invalid Range")
+			}
+			npt := rng.Start
+			if rng.Clock {
+				// 绝对时间转换为npt
+				base := rss.stream.StartTime()
+				if base.IsZero() {
+					return genResponse(r, "457", "Invalid Range")
+				}
+				clockRange = true
+				if npt = rng.StartTime.Sub(base); npt < 0 {
+					npt = 0
+				}
+			}
+			if !rng.Now {
+				if start, err = rss.stream.Seek(npt); err != nil {
+					rss.log().Warn("seek failed", "path", rss.stream.Path, "err", err)
+					return genResponse(r, "457", "Invalid Range")
+				}
+				seeked = true
+			}
+		}
+		if scale, speed, err = rss.stream.SetScale(scale, speed); err != nil {
+			rss.log().Warn("scale failed", "path", rss.stream.Path, "err", err)
+			return genResponse(r, "456", "Header Field Not Valid for Resource")
+		}
+	} else {
+		// 直播只能使用1，Range只用于决定response的格式
+		scale, speed = 1, 1
+		if v, ok := r.GetMessage("Range"); ok {
+			rng, err := rtsp.ParseRange([]byte(v))
+			clockRange = err == nil && rng.Clock
+		}
+	}
+
+	// 开始发送之前确定第一个包
+	rtpInfo := rss.rtpInfo(r.Version)
+	if rss.stream.private && rss.started {
+		rss.stream.Resume()
+	}
+
+	rss.playURI = r.URI
+	if rss.group != nil {
+		if err := rss.group.addViewer(rss); err != nil {
+			rss.log().Warn("play failed", "path", rss.stream.Path, "err", err)
+			return genResponse(r, "503", "Service Unavailable")
+		}
+	}
+	if rss.hasUnicast() {
+		if err := rss.stream.AddReader(rss); err != nil {
+			rss.log().Warn("play failed", "path", rss.stream.Path, "err", err)
+			return genResponse(r, "503", "Service Unavailable")
+		}
+	}
+	rss.started = true
+	if !rss.reporting {
+		rss.reporting = true
+		go rss.sendReports(rss.stream)
+	}
+
+	ret := genResponse(r, "200", "OK")
+	ret.AddMessage("Session", rss.sessionHeader())
+	if clockRange {
+		ret.AddMessage("Range", rss.clockRange(start))
+	} else {
+		ret.AddMessage("Range", rtsp.GenRange(start, rss.stream.Duration()))
+	}
+	if rtpInfo != "" {
+		ret.AddMessage("RTP-Info", rtpInfo)
+	}
+	if _, ok := r.GetMessage("Scale"); ok {
+		ret.AddMessage("Scale", strconv.FormatFloat(scale, 'g', -1, 64))
+	}
+	if _, ok := r.GetMessage("Speed"); ok {
+		v := strconv.FormatFloat(speed, 'g', -1, 64)
+		if r.Version == rtsp.Version20 {
+			v += "-" + v
+		}
+		ret.AddMessage("Speed", v)
+	}
+	rss.event(EventPlay)
+	if r.Version == rtsp.Version20 {
+		ret.AddMessage("Media-Properties", rss.stream.MediaProperties())
+		// 总是从之前最近的关键帧开始
+		if seeked {
+			ret.AddMessage("Seek-Style", "RAP")
+		}
+	}
+	return ret
+}
+
+func (rss *RtspServerSession) AnnounceHandler(r *rtsp.Request) *rtsp.Response {
+	rss.seq = r.Seq
+
+	path, err := parseRequestPath(r.URI)
+	if err != nil {
+		return genResponse(r, "400", "Bad Request")
+	}
+
+	stream, rest, ok := rss.srv.findStream(path)
+	if !ok || rest != "" {
+		return genResponse(r, "404", "Not Found")
+	}
+	if !rss.srv.mountAllowed(stream.Path, rss.ip) {
+		return rss.reject(r, rejectMountDeny)
+	}
+	if resp := rss.authorize(r, ActionPublish, stream.Path); resp != nil {
+		return resp
+	}
+	if !stream.Publishable() {
+		return genResponse(r, "405", "Method Not Allowed")
+	}
+	if rss.stream != nil {
+		return genResponse(r, "455", "Method Not Valid in This State")
+	}
+
+	if ct, _ := r.GetMessage("Content-Type"); ct != "application/sdp" {
+		return genResponse(r, "415", "Unsupported Media Type")
+	}
+	// 一份用于SETUP查找track，一份改写后作为stream的sdp
+	announced, published := &sdp.SDPImpl{}, &sdp.SDPImpl{}
+	if err := announced.Parse(r.Body()); err != nil || announced.S == nil || len(announced.Ms) == 0 {
+		return genResponse(r, "400", "Bad Request")
+	}
+	published.Parse(r.Body())
+
+	rss.announced = announced
+	rss.publisher = source.NewPublish(published)
+	rss.publisher.OnKeyFrameRequest = rss.requestKeyFrame
+	rss.publisher.Logger = rss.log()
+	rss.stream = stream
+	return genResponse(r, "200", "OK")
+}
+
+func (rss *RtspServerSession) RecordHandler(r *rtsp.Request) *rtsp.Response {
+	rss.seq = r.Seq
+
+	if resp := rss.checkSession(r); resp != nil {
+		return resp
+	}
+	if rss.publisher == nil {
+		return genResponse(r, "455", "Method Not Valid in This State")
+	}
+
+	if err := rss.stream.Publish(rss.publisher); err != nil {
+		rss.log().Warn("record failed", "path", rss.stream.Path, "err", err)
+		return genResponse(r, "503", "Service Unavailable")
+	}
+	if !rss.publishing {
+		rss.publishing = true
+		rss.event(EventPublishStart)
+	}
+
+	ret := genResponse(r, "200", "OK")
+	ret.AddMessage("Session", rss.sessionHeader())
+	return ret
+}
+
+func (rss *RtspServerSession) PauseHandler(r *rtsp.Request) *rtsp.Response {
+	rss.seq = r.Seq
+
+	if resp := rss.checkSession(r); resp != nil {
+		return resp
+	}
+
+	// 点播暂停后从暂停处继续，直播直接停止发送
+	if rss.stream.private {
+		rss.stream.Pause()
+	} else {
+		rss.stream.RemoveReader(rss)
+		if rss.group != nil {
+			rss.group.removeViewer(rss)
+		}
+	}
+	rss.event(EventPause)
 
+	ret := genResponse(r, "200", "OK")
+	ret.AddMessage("Session", rss.sessionHeader())
 	return ret
 }
 
+func (rss *RtspServerSession) TeardownHandler(r *rtsp.Request) *rtsp.Response {
+	rss.seq = r.Seq
+
+	if resp := rss.checkSession(r); resp != nil {
+		return resp
+	}
+
+	if rss.sessionId != "" {
+		rss.srv.releaseSession(rss.ip)
+	}
+	rss.teardown()
+	rss.event(EventTeardown)
+	rss.stream = nil
+	rss.sessionId = ""
+	rss.version = ""
+	rss.pipelined = ""
+
+	return genResponse(r, "200", "OK")
+}
+
+func (rss *RtspServerSession) writePacket(track int, pkt *rtp.Packet) {
+	rss.mu.Lock()
+	defer rss.mu.Unlock()
+
+	t, ok := rss.tracks[track]
+	if !ok || t.multicast {
+		return
+	}
+
+	p := pkt.Clone()
+	p.SSRC = t.ssrc
+	if t.sent != nil {
+		t.sent.push(p)
+	}
+	t.stats.sent(p)
+	rss.sendRTP(t, p)
+}
+
+// sendReports 定时为每个播放的track发送RTCP SR，组播的track由group发送，session结束后退出
+func (rss *RtspServerSession) sendReports(stream *Stream) {
+	ticker := time.NewTicker(senderReportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-rss.done:
+			return
+		}
+
+		rss.mu.Lock()
+		tracks := make([]int, 0, len(rss.tracks))
+		for i, t := range rss.tracks {
+			if !t.record && !t.multicast {
+				tracks = append(tracks, i)
+			}
+		}
+		rss.mu.Unlock()
+
+		// stream分发数据时持有stream的锁再获取session的锁，这里不能同时持有
+		now := time.Now()
+		rtptimes := make(map[int]uint32)
+		for _, i := range tracks {
+			if rtptime, ok := stream.RTPTime(i, now); ok {
+				rtptimes[i] = rtptime
+			}
+		}
+
+		rss.mu.Lock()
+		for i, rtptime := range rtptimes {
+			if t, ok := rss.tracks[i]; ok {
+				rss.sendRTCP(t, t.stats.report(t.ssrc, rtptime, now).Marshal())
+			}
+		}
+		rss.mu.Unlock()
+	}
+}
+
+// clockRange 点播为文件中的绝对时间，直播为当前时间
+func (rss *RtspServerSession) clockRange(start time.Duration) string {
+	if !rss.stream.private {
+		return rtsp.GenClockRange(time.Now(), time.Time{})
+	}
+
+	base := rss.stream.StartTime()
+	var end time.Time
+	if duration := rss.stream.Duration(); duration > 0 {
+		end = base.Add(duration)
+	}
+	return rtsp.GenClockRange(base.Add(start), end)
+}
+
+// rtpInfo 每个track下一个包的序号及播放位置对应的RTP时间戳，需在开始发送之前调用
+func (rss *RtspServerSession) rtpInfo(version string) string {
+	rss.mu.Lock()
+	tracks := make(map[int]*sessionTrack)
+	order := make([]int, 0, len(rss.tracks))
+	for i, t := range rss.tracks {
+		tracks[i] = t
+		order = append(order, i)
+	}
+	rss.mu.Unlock()
+	sort.Ints(order)
+
+	infos := make([]*rtsp.RTPInfo, 0, len(tracks))
+	for _, i := range order {
+		t := tracks[i]
+		seq, rtptime, ok := rss.stream.RTPInfo(i)
+		if !ok {
+			continue
+		}
+		infos = append(infos, &rtsp.RTPInfo{
+			URL:     t.uri,
+			Ssrc:    fmt.Sprintf("%08X", t.ssrc),
+			Seq:     seq,
+			RTPTime: rtptime,
+		})
+	}
+	if len(infos) == 0 {
+		return ""
+	}
+	return rtsp.GenRTPInfo(infos, version)
+}
+
+// sendRTP 需持有锁
+func (rss *RtspServerSession) sendRTP(t *sessionTrack, p *rtp.Packet) {
+	data := p.Marshal()
+	if t.srtp != nil {
+		var err error
+		if data, err = t.srtp.EncryptRTP(data); err != nil {
+			return
+		}
+	}
+	t.sentBytes += uint64(len(data))
+	rss.srv.metrics.sent(t.path, len(data))
+	if t.channel < 0 {
+		t.rtpConn.WriteToUDP(data, t.rtpAddr)
+		return
+	}
+	rss.writeFrame(t.channel, data)
+}
+
+// sendRTCP 需持有锁
+func (rss *RtspServerSession) sendRTCP(t *sessionTrack, data []byte) {
+	if t.srtp != nil {
+		var err error
+		if data, err = t.srtp.EncryptRTCP(data); err != nil {
+			return
+		}
+	}
+	if t.channel < 0 {
+		t.rtcpConn.WriteToUDP(data, t.rtcpAddr)
+		return
+	}
+	rss.writeFrame(t.channel+1, data)
+}
+
+// writeFrame 加上interleaved头后放入发送队列，不能阻塞
+func (rss *RtspServerSession) writeFrame(channel int, data []byte) {
+	select {
+	case rss.interleaved <- interleavedFrame(channel, data):
+	default:
+		rss.log().Warn("drop interleaved packet", "channel", channel)
+	}
+}
+
+// interleavedFrame $ channel length data
+func interleavedFrame(channel int, data []byte) []byte {
+	frame := make([]byte, 4, 4+len(data))
+	frame[0] = '$'
+	frame[1] = byte(channel)
+	binary.BigEndian.PutUint16(frame[2:], uint16(len(data)))
+	return append(frame, data...)
+}
+
+// describeSRTP 为每个track生成密钥，sdp改为RTP/SAVP并加上a=crypto
+func (rss *RtspServerSession) describeSRTP(stream *Stream, s *sdp.SDPImpl) (*sdp.SDPImpl, error) {
+	ret := &sdp.SDPImpl{}
+	if err := ret.Parse(s.Gen()); err != nil {
+		return nil, err
+	}
+
+	keys := make([]*srtp.Key, len(ret.Ms))
+	for i, m := range ret.Ms {
+		key, err := srtp.GenerateKey(stream.mount.SRTPSuite)
+		if err != nil {
+			return nil, err
+		}
+		if err := srtp.SetMediaKey(m, key); err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	rss.srtpKeys[stream.Path] = keys
+	return ret, nil
+}
+
+// srtpKey 推流使用ANNOUNCE中的密钥，播放使用DESCRIBE下发的密钥
+func (rss *RtspServerSession) srtpKey(path string, track int) (*srtp.Key, error) {
+	if rss.announced != nil {
+		return srtp.MediaKey(rss.announced.Ms[track])
+	}
+
+	keys := rss.srtpKeys[path]
+	if track >= len(keys) {
+		return nil, fmt.Errorf("no srtp key for track %d, describe first", track)
+	}
+	return keys[track], nil
+}
+
+// notify RTSP 2.0的session发送PLAY_NOTIFY
+func (rss *RtspServerSession) notify(reason, mediaProperties string) {
+	if rss.version != rtsp.Version20 {
+		return
+	}
+
+	req := rtsp.NewRequest("PLAY_NOTIFY", rss.playURI)
+	req.Version = rtsp.Version20
+	req.AddMessage("CSeq", strconv.FormatInt(atomic.AddInt64(&rss.notifySeq, 1), 10))
+	req.AddMessage("Notify-Reason", reason)
+	req.AddMessage("Session", rss.sessionId)
+	if reason == notifyMediaPropertiesUpdate {
+		req.AddMessage("Media-Properties", mediaProperties)
+	}
+	select {
+	case rss.interleaved <- []byte(req.Gen()):
+	default:
+		rss.log().Warn("drop play notify", "reason", reason)
+	}
+}
+
+// transport20 RTSP 2.0使用dest_addr/src_addr代替端口参数
+func (rss *RtspServerSession) transport20(item *rtsp.TransportItem) {
+	hostPort := func(ip string, port int) string {
+		return net.JoinHostPort(ip, strconv.Itoa(port))
+	}
+
+	if item.Cast == "multicast" {
+		item.DestAddr = []string{hostPort(item.Destination, item.Port1), hostPort(item.Destination, item.Port2)}
+		item.Destination = ""
+		item.Port1, item.Port2 = 0, 0
+		return
+	}
+	if item.LowerTransport != "UDP" {
+		return
+	}
+
+	local := rss.conn.LocalAddr().(*net.TCPAddr).IP.String()
+	remote := rss.conn.RemoteAddr().(*net.TCPAddr).IP.String()
+	item.SrcAddr = []string{hostPort(local, item.ServerPort1), hostPort(local, item.ServerPort2)}
+	item.DestAddr = []string{hostPort(remote, item.ClientPort1), hostPort(remote, item.ClientPort2)}
+	item.ClientPort1, item.ClientPort2 = 0, 0
+	item.ServerPort1, item.ServerPort2 = 0, 0
+}
+
+// hasUnicast 是否有需要session自己发送的track
+func (rss *RtspServerSession) hasUnicast() bool {
+	rss.mu.Lock()
+	defer rss.mu.Unlock()
+
+	for _, t := range rss.tracks {
+		if !t.multicast {
+			return true
+		}
+	}
+	return false
+}
+
+// close 释放UDP端口
+func (t *sessionTrack) close(ports *PortAllocator) {
+	if t.rtpConn == nil {
+		return
+	}
+	t.rtpConn.Close()
+	t.rtcpConn.Close()
+	ports.Release(t.serverPort)
+}
+
+// parseScale 解析Scale和Speed，RTSP 2.0的Speed为范围，使用上限
+func parseScale(r *rtsp.Request) (float64, float64, error) {
+	scale, speed := 1.0, 1.0
+	var err error
+	if v, ok := r.GetMessage("Scale"); ok {
+		if scale, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+			return 0, 0, err
+		}
+	}
+	if v, ok := r.GetMessage("Speed"); ok {
+		v = strings.TrimSpace(v)
+		if i := strings.LastIndex(v, "-"); i > 0 {
+			v = v[i+1:]
+		}
+		if speed, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+			return 0, 0, err
+		}
+	}
+	return scale, speed, nil
+}
+
+// destPorts 从dest_addr取RTP和RTCP端口，地址总是使用客户端的地址，避免向第三方发送数据
+func destPorts(addrs []string) (int, int, error) {
+	if len(addrs) == 0 {
+		return 0, 0, fmt.Errorf("no dest_addr")
+	}
+
+	ports := make([]int, 0, 2)
+	for _, addr := range addrs {
+		_, p, err := net.SplitHostPort(addr)
+		if err != nil {
+			return 0, 0, err
+		}
+		port, err := strconv.Atoi(p)
+		if err != nil || port <= 0 || port > 65535 {
+			return 0, 0, fmt.Errorf("invalid dest_addr %s", addr)
+		}
+		ports = append(ports, port)
+	}
+	if len(ports) == 1 {
+		ports = append(ports, ports[0]+1)
+	}
+	return ports[0], ports[1], nil
+}
+
+// transportMode mode="RECORD" -> record，默认为play
+func transportMode(item *rtsp.TransportItem) string {
+	mode, ok := item.Parameter["mode"]
+	if !ok {
+		return "play"
+	}
+	return strings.ToLower(strings.Trim(string(mode), `"`))
+}
+
 func genRandomSessionId() string {
 	rand.Seed(time.Now().UnixNano())
 	return fmt.Sprintf("%d", rand.Int63())
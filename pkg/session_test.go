@@ -1,9 +1,22 @@
 package pkg
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/Lcmasdf/drs/pkg/client"
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/rtsp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+	"github.com/Lcmasdf/drs/pkg/srtp"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -18,3 +31,353 @@ func TestGenSsrc(t *testing.T) {
 		})
 	})
 }
+
+func TestSRTP(t *testing.T) {
+	file := writeTestH264(t)
+
+	readPacket := func(c *client.Client) {
+		select {
+		case p := <-c.Packets():
+			So(p.Packet.PayloadType, ShouldEqual, 96)
+		case <-time.After(5 * time.Second):
+			So("timeout", ShouldBeEmpty)
+		}
+	}
+
+	for _, transport := range []string{client.TransportUDP, client.TransportTCP} {
+		Convey("test play srtp mount over "+transport, t, func() {
+			srv := &Server{Config: &Config{
+				Mounts: []*MountConfig{{Path: "/live", Source: file, FrameRate: 100, SRTP: true}},
+			}}
+			url := serveTest(srv)
+
+			c, err := client.Dial(url+"/live", client.Options{Transport: transport})
+			So(err, ShouldBeNil)
+			defer c.Close()
+			s, err := c.Describe()
+			So(err, ShouldBeNil)
+			So(string(s.Gen()), ShouldContainSubstring, "m=video 0 RTP/SAVP 96")
+			So(string(s.Gen()), ShouldContainSubstring, "a=crypto:1 AES_CM_128_HMAC_SHA1_80 inline:")
+			So(c.SetupAll(), ShouldBeNil)
+			_, err = c.Play(nil)
+			So(err, ShouldBeNil)
+			readPacket(c)
+		})
+	}
+
+	Convey("test record to srtp mount", t, func() {
+		srv := &Server{Config: &Config{
+			Mounts: []*MountConfig{{Path: "/ingest", SRTP: true, SRTPSuite: srtp.SuiteSHA1_32}},
+		}}
+		url := serveTest(srv)
+
+		announced := sdp.NewSDP("test")
+		m := sdp.NewMedia("video", 96)
+		m.AddAttribute("rtpmap:96 H264/90000")
+		m.AddAttribute("control:trackID=0")
+		announced.AddMedia(m)
+
+		publish := func(savp bool) (*client.Client, error) {
+			if savp {
+				key, _ := srtp.GenerateKey(srtp.SuiteSHA1_80)
+				So(srtp.SetMediaKey(m, key), ShouldBeNil)
+			} else {
+				So(srtp.ClearMediaKey(m), ShouldBeNil)
+			}
+			c, err := client.Dial(url+"/ingest", client.Options{})
+			So(err, ShouldBeNil)
+			So(c.Announce(announced), ShouldBeNil)
+			if err := c.SetupAll(); err != nil {
+				return c, err
+			}
+			_, err = c.Record()
+			return c, err
+		}
+
+		Convey("plain rtp is rejected", func() {
+			c, err := publish(false)
+			defer c.Close()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "461")
+		})
+
+		Convey("viewers receive the published stream with their own keys", func() {
+			pub, err := publish(true)
+			So(err, ShouldBeNil)
+			defer pub.Close()
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				for seq := uint16(0); ; seq++ {
+					select {
+					case <-done:
+						return
+					case <-time.After(10 * time.Millisecond):
+					}
+					pub.WritePacket(0, &rtp.Packet{PayloadType: 96, SequenceNumber: seq, Payload: []byte{0x41, 0x9a, 0x01}})
+				}
+			}()
+
+			c, err := client.Dial(url+"/ingest", client.Options{Transport: client.TransportTCP})
+			So(err, ShouldBeNil)
+			defer c.Close()
+			s, err := c.Describe()
+			So(err, ShouldBeNil)
+			So(string(s.Gen()), ShouldContainSubstring, "a=crypto:1 AES_CM_128_HMAC_SHA1_32 inline:")
+			So(string(s.Gen()), ShouldNotContainSubstring, string(m.Item['a'][len(m.Item['a'])-1]))
+			So(c.SetupAll(), ShouldBeNil)
+			_, err = c.Play(nil)
+			So(err, ShouldBeNil)
+			readPacket(c)
+		})
+	})
+}
+
+// rawConn 直接发送请求，跳过interleaved数据读取response
+type rawConn struct {
+	net.Conn
+	reader *textproto.Reader
+}
+
+func dialRaw(url string) *rawConn {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "rtsp://"))
+	So(err, ShouldBeNil)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &rawConn{Conn: conn, reader: textproto.NewReader(bufio.NewReader(conn))}
+}
+
+func (c *rawConn) response() *rtsp.Response {
+	for {
+		b, err := c.reader.R.Peek(4)
+		So(err, ShouldBeNil)
+		if b[0] != '$' {
+			break
+		}
+		_, err = io.CopyN(io.Discard, c.reader.R, 4+int64(b[2])<<8+int64(b[3]))
+		So(err, ShouldBeNil)
+	}
+	resp := &rtsp.Response{}
+	So(resp.Parse(*c.reader), ShouldBeNil)
+	return resp
+}
+
+func TestRTSP20(t *testing.T) {
+	file := writeTestH264(t)
+
+	Convey("test rtsp 2.0", t, func() {
+		srv := &Server{Config: &Config{
+			Mounts: []*MountConfig{
+				{Path: "/live", Source: file, FrameRate: 100},
+				{Path: "/ingest"},
+			},
+		}}
+		url := serveTest(srv)
+
+		Convey("options without 1.0 only methods", func() {
+			c, err := client.Dial(url+"/live", client.Options{Version: rtsp.Version20})
+			So(err, ShouldBeNil)
+			defer c.Close()
+			resp, err := c.Options()
+			So(err, ShouldBeNil)
+			So(resp.RTSPVersion, ShouldEqual, rtsp.Version20)
+			public, _ := resp.GetMessage("Public")
+			So(public, ShouldContainSubstring, "PLAY")
+			So(public, ShouldNotContainSubstring, "ANNOUNCE")
+			supported, _ := resp.GetMessage("Supported")
+			So(supported, ShouldEqual, "play.basic")
+		})
+
+		Convey("unknown version and options are rejected", func() {
+			c := dialRaw(url)
+			defer c.Close()
+			fmt.Fprintf(c, "OPTIONS %s/live RTSP/3.0\r\nCSeq: 1\r\n\r\n", url)
+			So(c.response().StatusCode, ShouldEqual, "505")
+
+			fmt.Fprintf(c, "OPTIONS %s/live RTSP/2.0\r\nCSeq: 2\r\nRequire: play.scale, play.basic\r\n\r\n", url)
+			resp := c.response()
+			So(resp.StatusCode, ShouldEqual, "551")
+			unsupported, _ := resp.GetMessage("Unsupported")
+			So(unsupported, ShouldEqual, "play.scale")
+		})
+
+		Convey("announce is not implemented", func() {
+			c, err := client.Dial(url+"/ingest", client.Options{Version: rtsp.Version20})
+			So(err, ShouldBeNil)
+			defer c.Close()
+			announced := sdp.NewSDP("test")
+			announced.AddMedia(sdp.NewMedia("video", 96))
+			err = c.Announce(announced)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "501")
+		})
+
+		Convey("play over udp with dest_addr", func() {
+			c, err := client.Dial(url+"/live", client.Options{Version: rtsp.Version20})
+			So(err, ShouldBeNil)
+			defer c.Close()
+			_, err = c.Describe()
+			So(err, ShouldBeNil)
+			So(c.SetupAll(), ShouldBeNil)
+			resp, err := c.Play(nil)
+			So(err, ShouldBeNil)
+			properties, _ := resp.GetMessage("Media-Properties")
+			So(properties, ShouldContainSubstring, "Time-Progressing")
+
+			select {
+			case p := <-c.Packets():
+				So(p.Packet.PayloadType, ShouldEqual, 96)
+			case <-time.After(5 * time.Second):
+				So("timeout", ShouldBeEmpty)
+			}
+		})
+
+		Convey("pipelined setup and play", func() {
+			c := dialRaw(url)
+			defer c.Close()
+			fmt.Fprintf(c, "SETUP %s/live/trackID=0 RTSP/2.0\r\nCSeq: 1\r\nPipelined-Requests: 7\r\n"+
+				"Transport: RTP/AVP/TCP;unicast;interleaved=0-1\r\n\r\n", url)
+			fmt.Fprintf(c, "PLAY %s/live RTSP/2.0\r\nCSeq: 2\r\nPipelined-Requests: 7\r\n\r\n", url)
+
+			setup := c.response()
+			So(setup.StatusCode, ShouldEqual, "200")
+			pipelined, _ := setup.GetMessage("Pipelined-Requests")
+			So(pipelined, ShouldEqual, "7")
+			ranges, _ := setup.GetMessage("Accept-Ranges")
+			So(ranges, ShouldEqual, "npt")
+			_, ok := setup.GetMessage("Media-Properties")
+			So(ok, ShouldBeTrue)
+			So(c.response().StatusCode, ShouldEqual, "200")
+
+			// 之后的请求需使用相同的版本
+			fmt.Fprintf(c, "OPTIONS %s/live RTSP/1.0\r\nCSeq: 3\r\n\r\n", url)
+			So(c.response().StatusCode, ShouldEqual, "505")
+		})
+
+		Convey("play notify when the publisher changes", func() {
+			announced := sdp.NewSDP("test")
+			m := sdp.NewMedia("video", 96)
+			m.AddAttribute("rtpmap:96 H264/90000")
+			m.AddAttribute("control:trackID=0")
+			announced.AddMedia(m)
+			publish := func() *client.Client {
+				c, err := client.Dial(url+"/ingest", client.Options{Transport: client.TransportTCP})
+				So(err, ShouldBeNil)
+				So(c.Announce(announced), ShouldBeNil)
+				So(c.SetupAll(), ShouldBeNil)
+				_, err = c.Record()
+				So(err, ShouldBeNil)
+				return c
+			}
+			pub := publish()
+
+			notifies := make(chan string, 4)
+			c, err := client.Dial(url+"/ingest", client.Options{
+				Version:   rtsp.Version20,
+				Transport: client.TransportTCP,
+				OnPlayNotify: func(req *rtsp.Request) {
+					reason, _ := req.GetMessage("Notify-Reason")
+					notifies <- reason
+				},
+			})
+			So(err, ShouldBeNil)
+			defer c.Close()
+			_, err = c.Describe()
+			So(err, ShouldBeNil)
+			So(c.SetupAll(), ShouldBeNil)
+			_, err = c.Play(nil)
+			So(err, ShouldBeNil)
+
+			wait := func() string {
+				select {
+				case reason := <-notifies:
+					return reason
+				case <-time.After(5 * time.Second):
+					return "timeout"
+				}
+			}
+			pub.Close()
+			So(wait(), ShouldEqual, "end-of-stream")
+			pub = publish()
+			defer pub.Close()
+			So(wait(), ShouldEqual, "media-properties-update")
+
+			// PLAY_NOTIFY的response不影响之后的请求
+			_, err = c.Options()
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+func TestScale(t *testing.T) {
+	file := writeTestH264(t)
+
+	Convey("test scale on live mount", t, func() {
+		srv := &Server{Config: &Config{
+			Mounts: []*MountConfig{{Path: "/live", Source: file, FrameRate: 100}},
+		}}
+		url := serveTest(srv)
+
+		c := dialRaw(url)
+		defer c.Close()
+		fmt.Fprintf(c, "SETUP %s/live/trackID=0 RTSP/1.0\r\nCSeq: 1\r\nTransport: RTP/AVP/TCP;unicast;interleaved=0-1\r\n\r\n", url)
+		setup := c.response()
+		So(setup.StatusCode, ShouldEqual, "200")
+		session, _ := setup.GetMessage("Session")
+
+		fmt.Fprintf(c, "PLAY %s/live RTSP/1.0\r\nCSeq: 2\r\nSession: %s\r\nScale: abc\r\n\r\n", url, session)
+		So(c.response().StatusCode, ShouldEqual, "400")
+
+		// 直播只能使用1
+		fmt.Fprintf(c, "PLAY %s/live RTSP/1.0\r\nCSeq: 3\r\nSession: %s\r\nScale: 4\r\nSpeed: 2\r\n\r\n", url, session)
+		resp := c.response()
+		So(resp.StatusCode, ShouldEqual, "200")
+		scale, _ := resp.GetMessage("Scale")
+		So(scale, ShouldEqual, "1")
+		speed, _ := resp.GetMessage("Speed")
+		So(speed, ShouldEqual, "1")
+	})
+}
+
+// openFiles 当前进程打开path的文件描述符数量
+func openFiles(path string) int {
+	path, _ = filepath.EvalSymlinks(path)
+	entries, _ := os.ReadDir("/proc/self/fd")
+	count := 0
+	for _, e := range entries {
+		if target, err := os.Readlink(filepath.Join("/proc/self/fd", e.Name())); err == nil && target == path {
+			count++
+		}
+	}
+	return count
+}
+
+func TestSetupTransport(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("no /proc/self/fd")
+	}
+	file := writeTestFLV(t)
+
+	Convey("test setup with unsupported transport does not open the vod file", t, func() {
+		srv := &Server{Config: &Config{
+			Mounts: []*MountConfig{{Path: "/vod", Source: file, AVPF: true}},
+		}}
+		url := serveTest(srv)
+
+		c := dialRaw(url)
+		defer c.Close()
+		for i := 1; i <= 3; i++ {
+			fmt.Fprintf(c, "SETUP %s/vod/trackID=0 RTSP/1.0\r\nCSeq: %d\r\nTransport: RTP/AVPF/UDP;multicast\r\n\r\n", url, i)
+			So(c.response().StatusCode, ShouldEqual, "461")
+		}
+		So(openFiles(file), ShouldEqual, 0)
+
+		fmt.Fprintf(c, "SETUP %s/vod/trackID=0 RTSP/1.0\r\nCSeq: 4\r\nTransport: RTP/AVPF/TCP;unicast;interleaved=0-1\r\n\r\n", url)
+		setup := c.response()
+		So(setup.StatusCode, ShouldEqual, "200")
+		session, _ := setup.GetMessage("Session")
+		fmt.Fprintf(c, "PLAY %s/vod RTSP/1.0\r\nCSeq: 5\r\nSession: %s\r\n\r\n", url, session)
+		So(c.response().StatusCode, ShouldEqual, "200")
+		// 只有PLAY的session打开了文件
+		So(openFiles(file), ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,85 @@
+package pkg
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+	"github.com/Lcmasdf/drs/pkg/source"
+)
+
+// Source 媒体源，track为sdp中media的序号
+type Source interface {
+	SDP() *sdp.SDPImpl
+	// ReadPacket 阻塞读取下一个RTP包，由source自己控制发送节奏
+	ReadPacket() (track int, pkt *rtp.Packet, err error)
+	Close() error
+}
+
+// Seeker 支持Range的点播source，每个session单独打开
+type Seeker interface {
+	// Seek 跳转到npt之前最近的关键帧，返回实际位置
+	Seek(npt time.Duration) (time.Duration, error)
+	Duration() time.Duration
+}
+
+// Scaler 点播source支持Scale/Speed，返回实际使用的值
+type Scaler interface {
+	SetScale(scale, speed float64) (float64, float64, error)
+}
+
+// KeyFrameRequester 可以向推流端或上游请求关键帧的source
+type KeyFrameRequester interface {
+	RequestKeyFrame(track int)
+}
+
+// RTPInfoer 点播source下一个发送的包的序号及当前播放位置对应的RTP时间戳，用于RTP-Info
+type RTPInfoer interface {
+	RTPInfo(track int) (seq uint16, rtptime uint32, ok bool)
+}
+
+// Dater 点播source中npt 0对应的绝对时间，用于Range: clock=
+type Dater interface {
+	StartTime() time.Time
+}
+
+// Pauser 暂停后从暂停处继续发送
+type Pauser interface {
+	Pause()
+	Resume()
+}
+
+// newSource log用于拉流的重连等日志
+func newSource(mc *MountConfig, log *slog.Logger) (Source, error) {
+	if lower := strings.ToLower(mc.Source); strings.HasPrefix(lower, "rtsp://") || strings.HasPrefix(lower, "rtsps://") {
+		return source.NewRTSP(mc.Source, mc.PullTransport, log)
+	}
+
+	switch strings.ToLower(filepath.Ext(mc.Source)) {
+	case ".h264", ".264", ".avc", ".h265", ".265", ".hevc":
+		return source.NewAnnexB(mc.Source, mc.FrameRate)
+	case ".wav":
+		return source.NewWAV(mc.Source, mc.PTime)
+	case ".mkv", ".mka", ".webm":
+		return source.NewMKV(mc.Source)
+	case ".flv":
+		return source.NewFLV(mc.Source)
+	default:
+		return nil, fmt.Errorf("unsupported source %s", mc.Source)
+	}
+}
+
+// sourceType 管理接口显示的source类型：publish、rtsp或文件扩展名
+func sourceType(mc *MountConfig) string {
+	if mc.Source == "" {
+		return "publish"
+	}
+	if lower := strings.ToLower(mc.Source); strings.HasPrefix(lower, "rtsp://") || strings.HasPrefix(lower, "rtsps://") {
+		return "rtsp"
+	}
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(mc.Source)), ".")
+}
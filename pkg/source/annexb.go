@@ -0,0 +1,198 @@
+package source
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/codec"
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+)
+
+const (
+	defaultFrameRate = 25
+	videoClockRate   = 90000
+)
+
+// AnnexB 读取 .h264/.h265 裸流文件，按帧率循环播放
+type AnnexB struct {
+	codec     string
+	frameRate float64
+
+	aus [][][]byte
+	vps []byte
+	sps []byte
+	pps []byte
+
+	sdp        *sdp.SDPImpl
+	packetizer *rtp.Packetizer
+	pacer      *pacer
+
+	// 下一个要发送的AU
+	index int
+	// 已发送帧数，循环时不清零，保证时间戳连续
+	frames  uint64
+	baseTS  uint32
+	pending []*rtp.Packet
+}
+
+// NewAnnexB frameRate 为0时使用SPS VUI中的帧率，VUI没有timing信息时使用25fps
+func NewAnnexB(path string, frameRate float64) (*AnnexB, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &AnnexB{
+		frameRate: frameRate,
+		pacer:     newPacer(),
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".h264", ".264", ".avc":
+		ret.codec = "H264"
+	case ".h265", ".265", ".hevc":
+		ret.codec = "H265"
+	default:
+		return nil, fmt.Errorf("unknown annexb file type %s", path)
+	}
+
+	if err := ret.load(codec.SplitAnnexB(data)); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return ret, nil
+}
+
+func (s *AnnexB) load(nalus [][]byte) error {
+	var vuiFrameRate float64
+
+	if s.codec == "H264" {
+		for _, nalu := range nalus {
+			switch codec.H264NaluType(nalu) {
+			case codec.H264NaluSPS:
+				if s.sps == nil {
+					s.sps = nalu
+				}
+			case codec.H264NaluPPS:
+				if s.pps == nil {
+					s.pps = nalu
+				}
+			}
+		}
+		if s.sps == nil || s.pps == nil {
+			return fmt.Errorf("sps/pps not found")
+		}
+
+		sps, err := codec.ParseH264SPS(s.sps)
+		if err != nil {
+			return err
+		}
+		vuiFrameRate = sps.FrameRate()
+		s.aus = codec.H264GroupAccessUnits(nalus)
+		s.packetizer = rtp.NewPacketizer(dynamicPayloadType, videoClockRate, &rtp.H264Payloader{})
+	} else {
+		for _, nalu := range nalus {
+			if len(nalu) < 2 {
+				continue
+			}
+			switch codec.H265NaluType(nalu) {
+			case codec.H265NaluVPS:
+				if s.vps == nil {
+					s.vps = nalu
+				}
+			case codec.H265NaluSPS:
+				if s.sps == nil {
+					s.sps = nalu
+				}
+			case codec.H265NaluPPS:
+				if s.pps == nil {
+					s.pps = nalu
+				}
+			}
+		}
+		if s.vps == nil || s.sps == nil || s.pps == nil {
+			return fmt.Errorf("vps/sps/pps not found")
+		}
+
+		sps, err := codec.ParseH265SPS(s.sps)
+		if err != nil {
+			return err
+		}
+		vuiFrameRate = sps.FrameRate()
+		s.aus = codec.H265GroupAccessUnits(nalus)
+		s.packetizer = rtp.NewPacketizer(dynamicPayloadType, videoClockRate, &rtp.H265Payloader{})
+	}
+
+	if len(s.aus) == 0 {
+		return fmt.Errorf("no access unit found")
+	}
+
+	if s.frameRate <= 0 {
+		s.frameRate = vuiFrameRate
+	}
+	if s.frameRate <= 0 {
+		s.frameRate = defaultFrameRate
+	}
+
+	s.baseTS = s.packetizer.SSRC ^ uint32(time.Now().UnixNano())
+	s.sdp = s.genSDP()
+	return nil
+}
+
+func (s *AnnexB) genSDP() *sdp.SDPImpl {
+	ret := sdp.NewSDP("Media Server")
+
+	m := sdp.NewMedia("video", dynamicPayloadType)
+	m.AddAttribute("control:trackID=0")
+	m.AddAttribute("framerate:%.6f", s.frameRate)
+	m.AddAttribute("rtpmap:%d %s/%d", dynamicPayloadType, s.codec, videoClockRate)
+
+	enc := base64.StdEncoding.EncodeToString
+	if s.codec == "H264" {
+		sps, _ := codec.ParseH264SPS(s.sps)
+		m.AddAttribute("fmtp:%d packetization-mode=1;profile-level-id=%s;sprop-parameter-sets=%s,%s",
+			dynamicPayloadType, sps.ProfileLevelID(), enc(s.sps), enc(s.pps))
+	} else {
+		m.AddAttribute("fmtp:%d sprop-vps=%s;sprop-sps=%s;sprop-pps=%s",
+			dynamicPayloadType, enc(s.vps), enc(s.sps), enc(s.pps))
+	}
+	ret.AddMedia(m)
+
+	return ret
+}
+
+func (s *AnnexB) SDP() *sdp.SDPImpl {
+	return s.sdp
+}
+
+func (s *AnnexB) FrameRate() float64 {
+	return s.frameRate
+}
+
+func (s *AnnexB) ReadPacket() (int, *rtp.Packet, error) {
+	for len(s.pending) == 0 {
+		offset := time.Duration(float64(s.frames) / s.frameRate * float64(time.Second))
+		if err := s.pacer.wait(offset); err != nil {
+			return 0, nil, err
+		}
+
+		ts := s.baseTS + uint32(uint64(float64(s.frames)*videoClockRate/s.frameRate))
+		s.pending = s.packetizer.Packetize(s.aus[s.index], ts)
+
+		s.frames++
+		s.index = (s.index + 1) % len(s.aus)
+	}
+
+	pkt := s.pending[0]
+	s.pending = s.pending[1:]
+	return 0, pkt, nil
+}
+
+func (s *AnnexB) Close() error {
+	s.pacer.close()
+	return nil
+}
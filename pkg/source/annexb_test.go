@@ -0,0 +1,74 @@
+package source
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/Lcmasdf/drs/pkg/codec"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func writeAnnexB(t *testing.T, name string) string {
+	sps, _ := hex.DecodeString("6764002aac2c6a81e0089f966e0202020400")
+	pps, _ := hex.DecodeString("68ee3cb0")
+	nalus := [][]byte{
+		sps, pps,
+		append([]byte{0x65, 0x88}, bytes.Repeat([]byte{0x11}, 3000)...),
+		{0x41, 0x9a, 0x01},
+		{0x41, 0x9a, 0x02},
+	}
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, codec.JoinAnnexB(nalus), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAnnexB(t *testing.T) {
+	Convey("test annexb file source", t, func() {
+		s, err := NewAnnexB(writeAnnexB(t, "test.h264"), 100)
+		So(err, ShouldBeNil)
+		defer s.Close()
+
+		So(s.FrameRate(), ShouldEqual, 100)
+		sdp := string(s.SDP().Gen())
+		So(sdp, ShouldContainSubstring, "a=rtpmap:96 H264/90000")
+		So(sdp, ShouldContainSubstring, "sprop-parameter-sets=Z2QAKqwsaoHgCJ+WbgICAgQ=,aO48sA==")
+		So(sdp, ShouldContainSubstring, "profile-level-id=64002A")
+
+		Convey("timestamps are continuous across loops", func() {
+			frames := make([]uint32, 0)
+			for len(frames) < 5 {
+				_, pkt, err := s.ReadPacket()
+				So(err, ShouldBeNil)
+				if pkt.Marker {
+					frames = append(frames, pkt.Timestamp)
+				}
+			}
+			for i := 1; i < len(frames); i++ {
+				So(frames[i]-frames[i-1], ShouldEqual, 900)
+			}
+		})
+
+		Convey("read after close", func() {
+			s.Close()
+			_, _, err := s.ReadPacket()
+			So(err, ShouldEqual, ErrClosed)
+		})
+	})
+
+	Convey("frame rate falls back to default without vui timing", t, func() {
+		s, err := NewAnnexB(writeAnnexB(t, "test.264"), 0)
+		So(err, ShouldBeNil)
+		So(s.FrameRate(), ShouldEqual, defaultFrameRate)
+	})
+
+	Convey("unknown file type", t, func() {
+		_, err := NewAnnexB(writeAnnexB(t, "test.bin"), 0)
+		So(err, ShouldNotBeNil)
+	})
+}
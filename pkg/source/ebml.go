@@ -0,0 +1,219 @@
+package source
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// 未知长度的element(直播写入的Segment/Cluster)
+const ebmlUnknownSize = -1
+
+// ebmlReader 顺序读取EBML element，支持seek
+type ebmlReader struct {
+	r   io.ReadSeeker
+	pos int64
+}
+
+type ebmlElement struct {
+	ID uint32
+	// element(ID) 在文件中的起始位置
+	Start int64
+	// data 部分的长度，未知长度为ebmlUnknownSize
+	Size int64
+	// data 部分在文件中的起始位置
+	Offset int64
+}
+
+func (e *ebmlElement) end() int64 {
+	return e.Offset + e.Size
+}
+
+func (r *ebmlReader) readByte() (byte, error) {
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return 0, err
+	}
+	r.pos++
+	return b[0], nil
+}
+
+// readVint 读取变长整数，keepMarker为true时保留长度标记位(element ID)
+func (r *ebmlReader) readVint(keepMarker bool) (uint64, int, error) {
+	first, err := r.readByte()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	length := 1
+	mask := byte(0x80)
+	for length <= 8 && first&mask == 0 {
+		length++
+		mask >>= 1
+	}
+	if length > 8 {
+		return 0, 0, fmt.Errorf("invalid ebml vint")
+	}
+
+	v := uint64(first)
+	if !keepMarker {
+		v = uint64(first & (mask - 1))
+	}
+	for i := 1; i < length; i++ {
+		b, err := r.readByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		v = v<<8 | uint64(b)
+	}
+	return v, length, nil
+}
+
+func (r *ebmlReader) next() (*ebmlElement, error) {
+	start := r.pos
+	id, _, err := r.readVint(true)
+	if err != nil {
+		return nil, err
+	}
+
+	size, length, err := r.readVint(false)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &ebmlElement{
+		ID:     uint32(id),
+		Start:  start,
+		Size:   int64(size),
+		Offset: r.pos,
+	}
+	// 全1表示未知长度
+	if size == 1<<(7*uint(length))-1 {
+		ret.Size = ebmlUnknownSize
+	}
+	return ret, nil
+}
+
+func (r *ebmlReader) seek(pos int64) error {
+	if _, err := r.r.Seek(pos, io.SeekStart); err != nil {
+		return err
+	}
+	r.pos = pos
+	return nil
+}
+
+func (r *ebmlReader) skip(e *ebmlElement) error {
+	if e.Size == ebmlUnknownSize {
+		return fmt.Errorf("can not skip unknown size element %x", e.ID)
+	}
+	return r.seek(e.end())
+}
+
+func (r *ebmlReader) readData(e *ebmlElement) ([]byte, error) {
+	if e.Size == ebmlUnknownSize || e.Size > 64<<20 {
+		return nil, fmt.Errorf("invalid element size %d", e.Size)
+	}
+
+	ret := make([]byte, e.Size)
+	if _, err := io.ReadFull(r.r, ret); err != nil {
+		return nil, err
+	}
+	r.pos += e.Size
+	return ret, nil
+}
+
+func (r *ebmlReader) readUint(e *ebmlElement) (uint64, error) {
+	data, err := r.readData(e)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) > 8 {
+		return 0, fmt.Errorf("invalid uint element %x", e.ID)
+	}
+
+	var ret uint64
+	for _, b := range data {
+		ret = ret<<8 | uint64(b)
+	}
+	return ret, nil
+}
+
+func (r *ebmlReader) readFloat(e *ebmlElement) (float64, error) {
+	data, err := r.readData(e)
+	if err != nil {
+		return 0, err
+	}
+
+	switch len(data) {
+	case 0:
+		return 0, nil
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), nil
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), nil
+	default:
+		return 0, fmt.Errorf("invalid float element %x", e.ID)
+	}
+}
+
+func (r *ebmlReader) readString(e *ebmlElement) (string, error) {
+	data, err := r.readData(e)
+	if err != nil {
+		return "", err
+	}
+
+	// 去掉结尾的0
+	n := len(data)
+	for n > 0 && data[n-1] == 0 {
+		n--
+	}
+	return string(data[:n]), nil
+}
+
+// children 遍历master element的子element，f返回后若没有读取data会自动跳过
+func (r *ebmlReader) children(parent *ebmlElement, f func(e *ebmlElement) error) error {
+	for parent.Size == ebmlUnknownSize || r.pos < parent.end() {
+		e, err := r.next()
+		if err != nil {
+			if parent.Size == ebmlUnknownSize && err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if err := f(e); err != nil {
+			return err
+		}
+
+		if e.Size != ebmlUnknownSize && r.pos != e.end() {
+			if err := r.seek(e.end()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseVintBytes 解析内存中的变长整数(block中的track number和EBML lacing)
+func parseVintBytes(b []byte) (uint64, int, error) {
+	if len(b) == 0 {
+		return 0, 0, fmt.Errorf("invalid ebml vint")
+	}
+
+	length := 1
+	mask := byte(0x80)
+	for length <= 8 && b[0]&mask == 0 {
+		length++
+		mask >>= 1
+	}
+	if length > 8 || len(b) < length {
+		return 0, 0, fmt.Errorf("invalid ebml vint")
+	}
+
+	v := uint64(b[0] & (mask - 1))
+	for i := 1; i < length; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, length, nil
+}
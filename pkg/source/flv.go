@@ -0,0 +1,482 @@
+package source
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/codec"
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+)
+
+// FLV tag type
+const (
+	flvTagAudio  = 8
+	flvTagVideo  = 9
+	flvTagScript = 18
+)
+
+const (
+	flvHeaderSize    = 9
+	flvTagHeaderSize = 11
+
+	flvCodecAVC   = 7
+	flvSoundAAC   = 10
+	flvKeyFrame   = 1
+	flvSeqHeader  = 0
+	flvPacketData = 1
+)
+
+type flvTag struct {
+	typ byte
+	// 单位ms
+	timestamp uint32
+	// tag header 在文件中的位置
+	position int64
+	// data 部分在文件中的位置
+	offset int64
+	size   int
+}
+
+func (t *flvTag) next() int64 {
+	// data 后面是4字节的PreviousTagSize
+	return t.offset + int64(t.size) + 4
+}
+
+type flvSyncPoint struct {
+	time     time.Duration
+	position int64
+}
+
+// FLV 读取FLV文件中的H264/AAC，打开时扫描所有tag建立关键帧索引用于seek
+type FLV struct {
+	file *os.File
+
+	duration time.Duration
+	firstTag int64
+
+	video *track
+	audio *track
+	// 视频关键帧，只有音频时为每个音频帧
+	syncPoints []flvSyncPoint
+
+	sdp   *sdp.SDPImpl
+	pacer *pacer
+
+	mu      sync.Mutex
+	pos     int64
+	pending []*trackPacket
+	trick   trickPlay
+	// 倒放时下一个发送的syncPoints序号
+	reverseIndex int
+}
+
+func NewFLV(path string) (*FLV, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &FLV{
+		file:  f,
+		pacer: newPacer(),
+		trick: newTrickPlay(),
+	}
+	if err := ret.parseHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return ret, nil
+}
+
+func (s *FLV) parseHeader() error {
+	header := make([]byte, flvHeaderSize)
+	if _, err := s.file.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("not a flv file")
+	}
+	if string(header[:3]) != "FLV" {
+		return fmt.Errorf("not a flv file")
+	}
+	// 跳过 PreviousTagSize0
+	s.firstTag = int64(binary.BigEndian.Uint32(header[5:])) + 4
+
+	var avcConfig, aacConfig []byte
+	videoSync := make([]flvSyncPoint, 0)
+	audioSync := make([]flvSyncPoint, 0)
+	var last uint32
+
+	for pos := s.firstTag; ; {
+		tag, err := s.readTagHeader(pos)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		pos = tag.next()
+
+		if tag.timestamp > last {
+			last = tag.timestamp
+		}
+
+		switch tag.typ {
+		case flvTagVideo:
+			if tag.size < 5 {
+				continue
+			}
+			b := make([]byte, 2)
+			if _, err := s.file.ReadAt(b, tag.offset); err != nil {
+				return err
+			}
+			if b[0]&0x0f != flvCodecAVC {
+				continue
+			}
+			if b[1] == flvSeqHeader && avcConfig == nil {
+				if avcConfig, err = s.readTagData(tag, 5); err != nil {
+					return err
+				}
+			}
+			if b[1] == flvPacketData && b[0]>>4 == flvKeyFrame {
+				videoSync = append(videoSync, flvSyncPoint{
+					time:     time.Duration(tag.timestamp) * time.Millisecond,
+					position: tag.position,
+				})
+			}
+		case flvTagAudio:
+			if tag.size < 2 {
+				continue
+			}
+			b := make([]byte, 2)
+			if _, err := s.file.ReadAt(b, tag.offset); err != nil {
+				return err
+			}
+			if b[0]>>4 != flvSoundAAC {
+				continue
+			}
+			if b[1] == flvSeqHeader && aacConfig == nil {
+				if aacConfig, err = s.readTagData(tag, 2); err != nil {
+					return err
+				}
+			}
+			if b[1] == flvPacketData {
+				audioSync = append(audioSync, flvSyncPoint{
+					time:     time.Duration(tag.timestamp) * time.Millisecond,
+					position: tag.position,
+				})
+			}
+		}
+	}
+
+	index := 0
+	if avcConfig != nil {
+		conf, err := codec.ParseAVCDecoderConfig(avcConfig)
+		if err != nil {
+			return err
+		}
+		s.video = newH264Track(index, conf)
+		s.syncPoints = videoSync
+		index++
+	}
+	if aacConfig != nil {
+		t, err := newAACTrack(index, aacConfig, 0, 0)
+		if err != nil {
+			return err
+		}
+		s.audio = t
+		if s.video == nil {
+			s.syncPoints = audioSync
+		}
+	}
+	if s.video == nil && s.audio == nil {
+		return fmt.Errorf("no supported track")
+	}
+
+	s.duration = time.Duration(last) * time.Millisecond
+	s.pos = s.firstTag
+	s.sdp = s.genSDP()
+	return nil
+}
+
+// readTagHeader 读取pos处的tag header，文件结束返回io.EOF
+func (s *FLV) readTagHeader(pos int64) (*flvTag, error) {
+	b := make([]byte, flvTagHeaderSize)
+	n, err := s.file.ReadAt(b, pos)
+	if n == 0 && err == io.EOF {
+		return nil, io.EOF
+	}
+	if n < flvTagHeaderSize {
+		// 文件尾部不完整的tag忽略
+		return nil, io.EOF
+	}
+
+	if b[0]&0x20 != 0 {
+		return nil, fmt.Errorf("encrypted flv tag is not supported")
+	}
+	ret := &flvTag{
+		typ:       b[0] & 0x1f,
+		size:      int(b[1])<<16 | int(b[2])<<8 | int(b[3]),
+		timestamp: uint32(b[7])<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6]),
+		position:  pos,
+		offset:    pos + flvTagHeaderSize,
+	}
+	return ret, nil
+}
+
+// readTagData 读取tag data，跳过前skip字节的tag header
+func (s *FLV) readTagData(tag *flvTag, skip int) ([]byte, error) {
+	if tag.size < skip {
+		return nil, fmt.Errorf("invalid flv tag size %d", tag.size)
+	}
+
+	ret := make([]byte, tag.size-skip)
+	if _, err := s.file.ReadAt(ret, tag.offset+int64(skip)); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (s *FLV) genSDP() *sdp.SDPImpl {
+	ret := sdp.NewSDP("Media Server")
+	if s.duration > 0 {
+		ret.S.SetItem('a', []byte(fmt.Sprintf("range:npt=0-%.3f", s.duration.Seconds())))
+	}
+
+	if s.video != nil {
+		ret.AddMedia(s.video.genMedia())
+	}
+	if s.audio != nil {
+		ret.AddMedia(s.audio.genMedia())
+	}
+	return ret
+}
+
+func (s *FLV) SDP() *sdp.SDPImpl {
+	return s.sdp
+}
+
+func (s *FLV) Duration() time.Duration {
+	return s.duration
+}
+
+// StartTime npt 0对应的绝对时间
+func (s *FLV) StartTime() time.Time {
+	return fileStartTime(s.file, s.duration)
+}
+
+// RTPInfo 下一个发送的包的序号及当前播放位置对应的RTP时间戳
+func (s *FLV) RTPInfo(index int) (uint16, uint32, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range []*track{s.video, s.audio} {
+		if t != nil && t.index == index {
+			seq, rtptime := rtpInfo(t, s.pending, &s.trick)
+			return seq, rtptime, true
+		}
+	}
+	return 0, 0, false
+}
+
+// readTag 读取下一个音视频tag并打包，需持有锁
+func (s *FLV) readTag() error {
+	if s.trick.reverse() {
+		return s.readReverse()
+	}
+
+	for {
+		tag, err := s.readTagHeader(s.pos)
+		if err != nil {
+			return err
+		}
+		s.pos = tag.next()
+
+		switch tag.typ {
+		case flvTagVideo:
+			if s.video == nil {
+				continue
+			}
+			return s.parseVideo(tag)
+		case flvTagAudio:
+			if s.audio == nil {
+				continue
+			}
+			return s.parseAudio(tag)
+		}
+	}
+}
+
+// readReverse 倒放时读取前一个关键帧，需持有锁
+func (s *FLV) readReverse() error {
+	if s.reverseIndex < 0 {
+		return io.EOF
+	}
+	p := s.syncPoints[s.reverseIndex]
+	s.reverseIndex--
+
+	tag, err := s.readTagHeader(p.position)
+	if err != nil {
+		return err
+	}
+	return s.parseVideo(tag)
+}
+
+func (s *FLV) parseVideo(tag *flvTag) error {
+	data, err := s.readTagData(tag, 0)
+	if err != nil {
+		return err
+	}
+	if len(data) < 5 || data[0]&0x0f != flvCodecAVC || data[1] != flvPacketData {
+		return nil
+	}
+
+	// CompositionTime SI24，pts = dts + cts
+	cts := int32(uint32(data[2])<<16|uint32(data[3])<<8|uint32(data[4])) << 8 >> 8
+	dts := time.Duration(tag.timestamp) * time.Millisecond
+	pts := dts + time.Duration(cts)*time.Millisecond
+	if pts < 0 {
+		pts = 0
+	}
+
+	return s.push(s.video, data[5:], pts, dts, data[0]>>4 == flvKeyFrame)
+}
+
+func (s *FLV) parseAudio(tag *flvTag) error {
+	data, err := s.readTagData(tag, 0)
+	if err != nil {
+		return err
+	}
+	if len(data) < 2 || data[0]>>4 != flvSoundAAC || data[1] != flvPacketData {
+		return nil
+	}
+
+	ts := time.Duration(tag.timestamp) * time.Millisecond
+	return s.push(s.audio, data[2:], ts, ts, true)
+}
+
+func (s *FLV) push(t *track, frame []byte, pts, dts time.Duration, key bool) error {
+	if s.trick.skip(t, key) {
+		return nil
+	}
+
+	pkts, err := t.packetize(frame, pts)
+	if err != nil {
+		return err
+	}
+	for _, pkt := range pkts {
+		s.pending = append(s.pending, &trackPacket{
+			track: t.index,
+			t:     t,
+			pkt:   pkt,
+			pts:   pts,
+			dts:   dts,
+		})
+	}
+	return nil
+}
+
+func (s *FLV) ReadPacket() (int, *rtp.Packet, error) {
+	for {
+		s.mu.Lock()
+		for len(s.pending) == 0 {
+			if err := s.readTag(); err != nil {
+				s.mu.Unlock()
+				return 0, nil, err
+			}
+		}
+		p := s.pending[0]
+		offset := s.trick.offset(p.dts)
+		s.mu.Unlock()
+
+		err := s.pacer.wait(offset)
+		if err == errPacerReset {
+			continue
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+
+		s.mu.Lock()
+		// 等待期间发生了seek
+		if len(s.pending) == 0 || s.pending[0] != p {
+			s.mu.Unlock()
+			continue
+		}
+		s.pending = s.pending[1:]
+		s.trick.sent(p)
+		s.mu.Unlock()
+
+		return p.track, p.pkt, nil
+	}
+}
+
+// Seek 跳转到npt之前最近的关键帧，返回实际的播放位置
+func (s *FLV) Seek(npt time.Duration) (time.Duration, error) {
+	if len(s.syncPoints) == 0 {
+		return 0, fmt.Errorf("no key frame")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ret := s.seek(npt)
+	s.trick.seek(ret)
+	return ret, nil
+}
+
+// seek 需持有锁
+func (s *FLV) seek(npt time.Duration) time.Duration {
+	index := sort.Search(len(s.syncPoints), func(i int) bool {
+		return s.syncPoints[i].time > npt
+	}) - 1
+	if index < 0 {
+		index = 0
+	}
+	p := s.syncPoints[index]
+
+	s.pos = p.position
+	s.reverseIndex = index
+	s.pending = nil
+	s.pacer.reset()
+	return p.time
+}
+
+// SetScale 从当前位置之前最近的关键帧开始使用新的Scale/Speed，返回实际使用的值
+func (s *FLV) SetScale(scale, speed float64) (float64, float64, error) {
+	scale, speed, err := checkScale(scale, speed, s.video != nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if scale == s.trick.scale && speed == s.trick.speed {
+		return scale, speed, nil
+	}
+	if len(s.syncPoints) == 0 {
+		return 0, 0, fmt.Errorf("no key frame")
+	}
+	s.trick.set(scale, speed, s.seek(s.trick.position))
+	return scale, speed, nil
+}
+
+func (s *FLV) Pause() {
+	s.pacer.pause()
+}
+
+func (s *FLV) Resume() {
+	s.pacer.resume()
+}
+
+func (s *FLV) Close() error {
+	s.pacer.close()
+	return s.file.Close()
+}
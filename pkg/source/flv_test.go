@@ -0,0 +1,114 @@
+package source
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func flvTagBytes(typ byte, timestamp uint32, data []byte) []byte {
+	b := make([]byte, flvTagHeaderSize, flvTagHeaderSize+len(data)+4)
+	b[0] = typ
+	b[1], b[2], b[3] = byte(len(data)>>16), byte(len(data)>>8), byte(len(data))
+	b[4], b[5], b[6], b[7] = byte(timestamp>>16), byte(timestamp>>8), byte(timestamp), byte(timestamp>>24)
+	b = append(b, data...)
+
+	prev := make([]byte, 4)
+	binary.BigEndian.PutUint32(prev, uint32(len(b)))
+	return append(b, prev...)
+}
+
+func flvVideo(frameType, packetType byte, cts int32, data []byte) []byte {
+	b := []byte{frameType<<4 | flvCodecAVC, packetType, byte(cts >> 16), byte(cts >> 8), byte(cts)}
+	return append(b, data...)
+}
+
+func writeFLV(t *testing.T) string {
+	sps := []byte{0x67, 0x42, 0xc0, 0x1e, 0xab}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	avcC := []byte{0x01, 0x42, 0xc0, 0x1e, 0xff, 0xe1, 0x00, byte(len(sps))}
+	avcC = append(avcC, sps...)
+	avcC = append(avcC, 0x01, 0x00, byte(len(pps)))
+	avcC = append(avcC, pps...)
+
+	data := []byte{'F', 'L', 'V', 1, 0x05, 0, 0, 0, 9, 0, 0, 0, 0}
+	data = append(data, flvTagBytes(flvTagScript, 0, []byte{0x02, 0x00, 0x00})...)
+	data = append(data, flvTagBytes(flvTagVideo, 0, flvVideo(flvKeyFrame, flvSeqHeader, 0, avcC))...)
+	// AAC LC 44100 双声道
+	data = append(data, flvTagBytes(flvTagAudio, 0, []byte{0xaf, flvSeqHeader, 0x12, 0x10})...)
+	data = append(data, flvTagBytes(flvTagVideo, 0, flvVideo(flvKeyFrame, flvPacketData, 80, []byte{0, 0, 0, 2, 0x65, 0x01}))...)
+	data = append(data, flvTagBytes(flvTagAudio, 10, []byte{0xaf, flvPacketData, 0x21, 0x22})...)
+	data = append(data, flvTagBytes(flvTagVideo, 40, flvVideo(2, flvPacketData, -40, []byte{0, 0, 0, 2, 0x41, 0x02}))...)
+	data = append(data, flvTagBytes(flvTagVideo, 1000, flvVideo(flvKeyFrame, flvPacketData, 0, []byte{0, 0, 0, 2, 0x65, 0x03}))...)
+	data = append(data, flvTagBytes(flvTagAudio, 1500, []byte{0xaf, flvPacketData, 0x23})...)
+
+	path := filepath.Join(t.TempDir(), "test.flv")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFLV(t *testing.T) {
+	Convey("test flv sdp and packets", t, func() {
+		s, err := NewFLV(writeFLV(t))
+		So(err, ShouldBeNil)
+		defer s.Close()
+
+		So(s.Duration(), ShouldEqual, 1500*time.Millisecond)
+		sdp := string(s.SDP().Gen())
+		So(sdp, ShouldContainSubstring, "a=range:npt=0-1.500")
+		So(sdp, ShouldContainSubstring, "a=rtpmap:96 H264/90000")
+		So(sdp, ShouldContainSubstring, "profile-level-id=42C01E")
+		So(sdp, ShouldContainSubstring, "a=rtpmap:97 MPEG4-GENERIC/44100/2")
+		So(sdp, ShouldContainSubstring, "config=1210")
+
+		track, v1, err := s.ReadPacket()
+		So(err, ShouldBeNil)
+		So(track, ShouldEqual, 0)
+		So(v1.Payload, ShouldResemble, []byte{0x65, 0x01})
+
+		track, a1, err := s.ReadPacket()
+		So(err, ShouldBeNil)
+		So(track, ShouldEqual, 1)
+		So(a1.Payload, ShouldResemble, []byte{0x00, 0x10, 0x00, 0x10, 0x21, 0x22})
+
+		// 第二帧 pts 为 0，第一帧 pts 为 80ms
+		_, v2, err := s.ReadPacket()
+		So(err, ShouldBeNil)
+		So(v1.Timestamp-v2.Timestamp, ShouldEqual, 80*90)
+	})
+
+	Convey("test flv seek", t, func() {
+		s, err := NewFLV(writeFLV(t))
+		So(err, ShouldBeNil)
+		defer s.Close()
+
+		pos, err := s.Seek(1200 * time.Millisecond)
+		So(err, ShouldBeNil)
+		So(pos, ShouldEqual, time.Second)
+		seq, rtptime, ok := s.RTPInfo(0)
+		So(ok, ShouldBeTrue)
+
+		start := time.Now()
+		track, pkt, err := s.ReadPacket()
+		So(err, ShouldBeNil)
+		So(track, ShouldEqual, 0)
+		So(pkt.Payload, ShouldResemble, []byte{0x65, 0x03})
+		So(time.Since(start), ShouldBeLessThan, 300*time.Millisecond)
+		// 关键帧的pts即为seek的位置
+		So(pkt.SequenceNumber, ShouldEqual, seq)
+		So(pkt.Timestamp, ShouldEqual, rtptime)
+	})
+
+	Convey("test invalid flv", t, func() {
+		path := filepath.Join(t.TempDir(), "bad.flv")
+		So(ioutil.WriteFile(path, []byte("FLX\x01"), 0644), ShouldBeNil)
+		_, err := NewFLV(path)
+		So(err, ShouldNotBeNil)
+	})
+}
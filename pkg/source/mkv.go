@@ -0,0 +1,762 @@
+package source
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/codec"
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+)
+
+// Matroska element ID
+const (
+	mkvIDEBML               = 0x1a45dfa3
+	mkvIDDocType            = 0x4282
+	mkvIDSegment            = 0x18538067
+	mkvIDSeekHead           = 0x114d9b74
+	mkvIDSeek               = 0x4dbb
+	mkvIDSeekID             = 0x53ab
+	mkvIDSeekPosition       = 0x53ac
+	mkvIDInfo               = 0x1549a966
+	mkvIDTimecodeScale      = 0x2ad7b1
+	mkvIDDuration           = 0x4489
+	mkvIDDateUTC            = 0x4461
+	mkvIDTracks             = 0x1654ae6b
+	mkvIDTrackEntry         = 0xae
+	mkvIDTrackNumber        = 0xd7
+	mkvIDCodecID            = 0x86
+	mkvIDCodecPrivate       = 0x63a2
+	mkvIDDefaultDuration    = 0x23e383
+	mkvIDAudio              = 0xe1
+	mkvIDSamplingFrequency  = 0xb5
+	mkvIDChannels           = 0x9f
+	mkvIDCues               = 0x1c53bb6b
+	mkvIDCuePoint           = 0xbb
+	mkvIDCueTime            = 0xb3
+	mkvIDCueTrackPositions  = 0xb7
+	mkvIDCueClusterPosition = 0xf1
+	mkvIDCluster            = 0x1f43b675
+	mkvIDTimecode           = 0xe7
+	mkvIDSimpleBlock        = 0xa3
+	mkvIDBlockGroup         = 0xa0
+	mkvIDBlock              = 0xa1
+)
+
+const defaultTimecodeScale = 1000000
+
+// DateUTC的起点
+var mkvEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var errMKVStop = fmt.Errorf("stop")
+
+type mkvTrackEntry struct {
+	number          uint64
+	codecID         string
+	codecPrivate    []byte
+	defaultDuration uint64
+	sampleRate      float64
+	channels        int
+}
+
+type mkvCue struct {
+	time     time.Duration
+	position int64
+}
+
+// MKV 读取 Matroska/WebM 文件，支持通过Cues seek
+type MKV struct {
+	file *os.File
+	r    *ebmlReader
+
+	timecodeScale uint64
+	duration      time.Duration
+	// Info中的DateUTC，没有时为零值
+	date          time.Time
+	segmentOffset int64
+	firstCluster  int64
+	cuesPosition  int64
+
+	// track number -> track
+	tracks     map[uint64]*track
+	trackOrder []*track
+	entries    map[uint64]*mkvTrackEntry
+	cues       []mkvCue
+
+	sdp   *sdp.SDPImpl
+	pacer *pacer
+
+	mu sync.Mutex
+	// 当前cluster的时间戳，单位为timecodeScale
+	clusterTime uint64
+	pending     []*trackPacket
+	trick       trickPlay
+	// 倒放时下一个读取的cues序号
+	reverseIndex int
+}
+
+func NewMKV(path string) (*MKV, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &MKV{
+		file:          f,
+		r:             &ebmlReader{r: f},
+		timecodeScale: defaultTimecodeScale,
+		firstCluster:  -1,
+		cuesPosition:  -1,
+		tracks:        make(map[uint64]*track),
+		entries:       make(map[uint64]*mkvTrackEntry),
+		pacer:         newPacer(),
+		trick:         newTrickPlay(),
+	}
+	if err := ret.parseHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return ret, nil
+}
+
+func (s *MKV) parseHeader() error {
+	e, err := s.r.next()
+	if err != nil {
+		return err
+	}
+	if e.ID != mkvIDEBML {
+		return fmt.Errorf("not a matroska file")
+	}
+	docType := ""
+	err = s.r.children(e, func(c *ebmlElement) error {
+		if c.ID == mkvIDDocType {
+			docType, err = s.r.readString(c)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if docType != "matroska" && docType != "webm" {
+		return fmt.Errorf("unsupported doc type %s", docType)
+	}
+
+	segment, err := s.r.next()
+	if err != nil {
+		return err
+	}
+	if segment.ID != mkvIDSegment {
+		return fmt.Errorf("segment not found")
+	}
+	s.segmentOffset = segment.Offset
+
+	// 读取到第一个Cluster为止
+	err = s.r.children(segment, func(c *ebmlElement) error {
+		switch c.ID {
+		case mkvIDSeekHead:
+			return s.parseSeekHead(c)
+		case mkvIDInfo:
+			return s.parseInfo(c)
+		case mkvIDTracks:
+			return s.parseTracks(c)
+		case mkvIDCues:
+			return s.parseCues(c)
+		case mkvIDCluster:
+			s.firstCluster = c.Start
+			return errMKVStop
+		}
+		return nil
+	})
+	if err != nil && err != errMKVStop {
+		return err
+	}
+	if s.firstCluster < 0 {
+		return fmt.Errorf("cluster not found")
+	}
+
+	// Cues 通常在文件尾部，通过SeekHead找到
+	if s.cues == nil && s.cuesPosition >= 0 {
+		if err := s.r.seek(s.cuesPosition); err == nil {
+			if c, err := s.r.next(); err == nil && c.ID == mkvIDCues {
+				if err := s.parseCues(c); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// 没有SeekHead时跳过各个Cluster查找Cues
+	if s.cues == nil && s.cuesPosition < 0 && segment.Size != ebmlUnknownSize {
+		if err := s.r.seek(s.firstCluster); err != nil {
+			return err
+		}
+		err = s.r.children(segment, func(c *ebmlElement) error {
+			if c.ID == mkvIDCues {
+				return s.parseCues(c)
+			}
+			if c.Size == ebmlUnknownSize {
+				return errMKVStop
+			}
+			return nil
+		})
+		if err != nil && err != errMKVStop {
+			return err
+		}
+	}
+
+	if len(s.trackOrder) == 0 {
+		return fmt.Errorf("no supported track")
+	}
+
+	s.sdp = s.genSDP()
+	return s.r.seek(s.firstCluster)
+}
+
+func (s *MKV) parseSeekHead(e *ebmlElement) error {
+	return s.r.children(e, func(c *ebmlElement) error {
+		if c.ID != mkvIDSeek {
+			return nil
+		}
+
+		var id []byte
+		position := int64(-1)
+		err := s.r.children(c, func(cc *ebmlElement) error {
+			var err error
+			switch cc.ID {
+			case mkvIDSeekID:
+				id, err = s.r.readData(cc)
+			case mkvIDSeekPosition:
+				var v uint64
+				v, err = s.r.readUint(cc)
+				position = int64(v)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(id) == 4 && binary.BigEndian.Uint32(id) == mkvIDCues && position >= 0 {
+			s.cuesPosition = s.segmentOffset + position
+		}
+		return nil
+	})
+}
+
+func (s *MKV) parseInfo(e *ebmlElement) error {
+	var duration float64
+	err := s.r.children(e, func(c *ebmlElement) error {
+		var err error
+		switch c.ID {
+		case mkvIDTimecodeScale:
+			s.timecodeScale, err = s.r.readUint(c)
+		case mkvIDDuration:
+			duration, err = s.r.readFloat(c)
+		case mkvIDDateUTC:
+			// 2001-01-01开始的纳秒数，有符号
+			var v uint64
+			if v, err = s.r.readUint(c); err == nil {
+				s.date = mkvEpoch.Add(time.Duration(int64(v)))
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.timecodeScale == 0 {
+		s.timecodeScale = defaultTimecodeScale
+	}
+	s.duration = time.Duration(duration * float64(s.timecodeScale))
+	return nil
+}
+
+func (s *MKV) parseTracks(e *ebmlElement) error {
+	return s.r.children(e, func(c *ebmlElement) error {
+		if c.ID != mkvIDTrackEntry {
+			return nil
+		}
+
+		entry := &mkvTrackEntry{}
+		err := s.r.children(c, func(cc *ebmlElement) error {
+			var err error
+			switch cc.ID {
+			case mkvIDTrackNumber:
+				entry.number, err = s.r.readUint(cc)
+			case mkvIDCodecID:
+				entry.codecID, err = s.r.readString(cc)
+			case mkvIDCodecPrivate:
+				entry.codecPrivate, err = s.r.readData(cc)
+			case mkvIDDefaultDuration:
+				entry.defaultDuration, err = s.r.readUint(cc)
+			case mkvIDAudio:
+				err = s.r.children(cc, func(a *ebmlElement) error {
+					var err error
+					switch a.ID {
+					case mkvIDSamplingFrequency:
+						entry.sampleRate, err = s.r.readFloat(a)
+					case mkvIDChannels:
+						var v uint64
+						v, err = s.r.readUint(a)
+						entry.channels = int(v)
+					}
+					return err
+				})
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		t, err := s.newTrack(entry)
+		if err != nil {
+			return fmt.Errorf("track %d: %s", entry.number, err.Error())
+		}
+		if t == nil {
+			// 不支持的codec忽略
+			return nil
+		}
+		s.entries[entry.number] = entry
+		s.tracks[entry.number] = t
+		s.trackOrder = append(s.trackOrder, t)
+		return nil
+	})
+}
+
+func (s *MKV) newTrack(entry *mkvTrackEntry) (*track, error) {
+	index := len(s.trackOrder)
+
+	switch entry.codecID {
+	case "V_MPEG4/ISO/AVC":
+		conf, err := codec.ParseAVCDecoderConfig(entry.codecPrivate)
+		if err != nil {
+			return nil, err
+		}
+		return newH264Track(index, conf), nil
+	case "V_MPEGH/ISO/HEVC":
+		conf, err := codec.ParseHEVCDecoderConfig(entry.codecPrivate)
+		if err != nil {
+			return nil, err
+		}
+		return newH265Track(index, conf), nil
+	case "V_VP8":
+		return newTrack(index, "video", "VP8", videoClockRate, &rtp.VP8Payloader{}), nil
+	case "V_VP9":
+		return newTrack(index, "video", "VP9", videoClockRate, &rtp.VP9Payloader{}), nil
+	case "A_OPUS":
+		return newOpusTrack(index, entry.channels), nil
+	case "A_AAC":
+		return newAACTrack(index, entry.codecPrivate, int(entry.sampleRate), entry.channels)
+	default:
+		return nil, nil
+	}
+}
+
+func (s *MKV) parseCues(e *ebmlElement) error {
+	cues := make([]mkvCue, 0)
+	err := s.r.children(e, func(c *ebmlElement) error {
+		if c.ID != mkvIDCuePoint {
+			return nil
+		}
+
+		var cueTime uint64
+		position := int64(-1)
+		err := s.r.children(c, func(cc *ebmlElement) error {
+			switch cc.ID {
+			case mkvIDCueTime:
+				var err error
+				cueTime, err = s.r.readUint(cc)
+				return err
+			case mkvIDCueTrackPositions:
+				return s.r.children(cc, func(p *ebmlElement) error {
+					if p.ID != mkvIDCueClusterPosition || position >= 0 {
+						return nil
+					}
+					v, err := s.r.readUint(p)
+					position = int64(v)
+					return err
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if position >= 0 {
+			cues = append(cues, mkvCue{
+				time:     time.Duration(cueTime * s.timecodeScale),
+				position: s.segmentOffset + position,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(cues, func(i, j int) bool {
+		return cues[i].time < cues[j].time
+	})
+	s.cues = cues
+	return nil
+}
+
+func (s *MKV) genSDP() *sdp.SDPImpl {
+	ret := sdp.NewSDP("Media Server")
+	if s.duration > 0 {
+		ret.S.SetItem('a', []byte(fmt.Sprintf("range:npt=0-%.3f", s.duration.Seconds())))
+	}
+
+	for _, t := range s.trackOrder {
+		ret.AddMedia(t.genMedia())
+	}
+	return ret
+}
+
+func (s *MKV) SDP() *sdp.SDPImpl {
+	return s.sdp
+}
+
+func (s *MKV) Duration() time.Duration {
+	return s.duration
+}
+
+// StartTime npt 0对应的绝对时间，优先使用DateUTC
+func (s *MKV) StartTime() time.Time {
+	if !s.date.IsZero() {
+		return s.date
+	}
+	return fileStartTime(s.file, s.duration)
+}
+
+// RTPInfo 下一个发送的包的序号及当前播放位置对应的RTP时间戳
+func (s *MKV) RTPInfo(index int) (uint16, uint32, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.trackOrder {
+		if t.index == index {
+			seq, rtptime := rtpInfo(t, s.pending, &s.trick)
+			return seq, rtptime, true
+		}
+	}
+	return 0, 0, false
+}
+
+// readBlock 读取下一个block并打包，需持有锁
+func (s *MKV) readBlock() error {
+	for {
+		e, err := s.r.next()
+		if err != nil {
+			return err
+		}
+
+		switch e.ID {
+		case mkvIDSegment, mkvIDCluster, mkvIDBlockGroup:
+			// 进入子element
+			continue
+		case mkvIDTimecode:
+			if s.clusterTime, err = s.r.readUint(e); err != nil {
+				return err
+			}
+		case mkvIDSimpleBlock, mkvIDBlock:
+			data, err := s.r.readData(e)
+			if err != nil {
+				return err
+			}
+			return s.parseBlock(data, e.ID == mkvIDSimpleBlock)
+		default:
+			if err := s.r.skip(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readReverse 倒放时读取前一个cluster的第一个视频关键帧，需持有锁
+func (s *MKV) readReverse() error {
+	for len(s.pending) == 0 {
+		index := s.reverseIndex
+		if index < 0 {
+			return io.EOF
+		}
+		// 多个cue可能指向同一个cluster
+		position := s.cues[index].position
+		for index > 0 && s.cues[index-1].position == position {
+			index--
+		}
+		s.reverseIndex = index - 1
+
+		end := int64(-1)
+		for i := index + 1; i < len(s.cues); i++ {
+			if s.cues[i].position > position {
+				end = s.cues[i].position
+				break
+			}
+		}
+		if err := s.r.seek(position); err != nil {
+			return err
+		}
+		for len(s.pending) == 0 && (end < 0 || s.r.pos < end) {
+			if err := s.readBlock(); err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseBlock BlockGroup中的Block不知道是否为关键帧，只有SimpleBlock可以只发送关键帧
+func (s *MKV) parseBlock(data []byte, simple bool) error {
+	number, n, err := parseVintBytes(data)
+	if err != nil {
+		return err
+	}
+	if len(data) < n+3 {
+		return fmt.Errorf("invalid block")
+	}
+
+	t, ok := s.tracks[number]
+	if !ok {
+		return nil
+	}
+
+	relative := int64(int16(binary.BigEndian.Uint16(data[n:])))
+	flags := data[n+2]
+	frames, err := mkvSplitLacing(data[n+3:], (flags>>1)&0x03)
+	if err != nil {
+		return err
+	}
+
+	key := simple && flags&0x80 != 0
+	if s.trick.skip(t, key) {
+		return nil
+	}
+
+	timecode := int64(s.clusterTime) + relative
+	if timecode < 0 {
+		timecode = 0
+	}
+	pts := time.Duration(uint64(timecode) * s.timecodeScale)
+
+	for i, frame := range frames {
+		framePTS := pts + time.Duration(uint64(i)*s.entries[number].defaultDuration)
+		pkts, err := t.packetize(frame, framePTS)
+		if err != nil {
+			return err
+		}
+		for _, pkt := range pkts {
+			s.pending = append(s.pending, &trackPacket{
+				track: t.index,
+				t:     t,
+				pkt:   pkt,
+				pts:   framePTS,
+				dts:   framePTS,
+			})
+		}
+	}
+	return nil
+}
+
+// mkvSplitLacing lacing: 0 无, 1 Xiph, 2 fixed-size, 3 EBML
+func mkvSplitLacing(data []byte, lacing byte) ([][]byte, error) {
+	if lacing == 0 {
+		return [][]byte{data}, nil
+	}
+
+	if len(data) < 1 {
+		return nil, fmt.Errorf("invalid lacing")
+	}
+	count := int(data[0]) + 1
+	data = data[1:]
+
+	sizes := make([]int, count)
+	switch lacing {
+	case 1:
+		for i := 0; i < count-1; i++ {
+			for {
+				if len(data) == 0 {
+					return nil, fmt.Errorf("invalid xiph lacing")
+				}
+				b := data[0]
+				data = data[1:]
+				sizes[i] += int(b)
+				if b != 0xff {
+					break
+				}
+			}
+		}
+	case 2:
+		if len(data)%count != 0 {
+			return nil, fmt.Errorf("invalid fixed-size lacing")
+		}
+		for i := range sizes {
+			sizes[i] = len(data) / count
+		}
+	case 3:
+		first, n, err := parseVintBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[n:]
+		sizes[0] = int(first)
+		for i := 1; i < count-1; i++ {
+			v, n, err := parseVintBytes(data)
+			if err != nil {
+				return nil, err
+			}
+			data = data[n:]
+			// 有符号差值
+			diff := int64(v) - (1<<(7*uint(n)-1) - 1)
+			sizes[i] = sizes[i-1] + int(diff)
+		}
+	}
+
+	if lacing != 2 {
+		total := 0
+		for i := 0; i < count-1; i++ {
+			if sizes[i] < 0 {
+				return nil, fmt.Errorf("invalid lacing size")
+			}
+			total += sizes[i]
+		}
+		if total > len(data) {
+			return nil, fmt.Errorf("invalid lacing size")
+		}
+		sizes[count-1] = len(data) - total
+	}
+
+	ret := make([][]byte, 0, count)
+	for _, size := range sizes {
+		ret = append(ret, data[:size])
+		data = data[size:]
+	}
+	return ret, nil
+}
+
+func (s *MKV) ReadPacket() (int, *rtp.Packet, error) {
+	for {
+		s.mu.Lock()
+		for len(s.pending) == 0 {
+			read := s.readBlock
+			if s.trick.reverse() {
+				read = s.readReverse
+			}
+			if err := read(); err != nil {
+				s.mu.Unlock()
+				return 0, nil, err
+			}
+		}
+		p := s.pending[0]
+		offset := s.trick.offset(p.dts)
+		s.mu.Unlock()
+
+		err := s.pacer.wait(offset)
+		if err == errPacerReset {
+			continue
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+
+		s.mu.Lock()
+		// 等待期间发生了seek
+		if len(s.pending) == 0 || s.pending[0] != p {
+			s.mu.Unlock()
+			continue
+		}
+		s.pending = s.pending[1:]
+		s.trick.sent(p)
+		s.mu.Unlock()
+
+		return p.track, p.pkt, nil
+	}
+}
+
+// Seek 跳转到npt之前最近的cue，返回实际的播放位置
+func (s *MKV) Seek(npt time.Duration) (time.Duration, error) {
+	if len(s.cues) == 0 {
+		return 0, fmt.Errorf("no cues")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ret, err := s.seek(npt)
+	if err != nil {
+		return 0, err
+	}
+	s.trick.seek(ret)
+	return ret, nil
+}
+
+// seek 需持有锁
+func (s *MKV) seek(npt time.Duration) (time.Duration, error) {
+	index := sort.Search(len(s.cues), func(i int) bool {
+		return s.cues[i].time > npt
+	}) - 1
+	if index < 0 {
+		index = 0
+	}
+	cue := s.cues[index]
+
+	if err := s.r.seek(cue.position); err != nil {
+		return 0, err
+	}
+	s.reverseIndex = index
+	s.pending = nil
+	s.pacer.reset()
+	return cue.time, nil
+}
+
+// SetScale 从当前位置之前最近的cue开始使用新的Scale/Speed，返回实际使用的值
+func (s *MKV) SetScale(scale, speed float64) (float64, float64, error) {
+	video := false
+	for _, t := range s.trackOrder {
+		video = video || t.media == "video"
+	}
+	scale, speed, err := checkScale(scale, speed, video)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if scale == s.trick.scale && speed == s.trick.speed {
+		return scale, speed, nil
+	}
+	if len(s.cues) == 0 {
+		return 0, 0, fmt.Errorf("no cues")
+	}
+	at, err := s.seek(s.trick.position)
+	if err != nil {
+		return 0, 0, err
+	}
+	s.trick.set(scale, speed, at)
+	return scale, speed, nil
+}
+
+func (s *MKV) Pause() {
+	s.pacer.pause()
+}
+
+func (s *MKV) Resume() {
+	s.pacer.resume()
+}
+
+func (s *MKV) Close() error {
+	s.pacer.close()
+	return s.file.Close()
+}
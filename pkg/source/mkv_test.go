@@ -0,0 +1,189 @@
+package source
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func mkvElement(id uint32, body ...[]byte) []byte {
+	ret := make([]byte, 0)
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> uint(shift)); b != 0 || len(ret) > 0 {
+			ret = append(ret, b)
+		}
+	}
+
+	size := 0
+	for _, b := range body {
+		size += len(b)
+	}
+	// 固定8字节长度
+	sizeBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(sizeBytes, uint64(size))
+	sizeBytes[0] = 0x01
+	ret = append(ret, sizeBytes...)
+
+	for _, b := range body {
+		ret = append(ret, b...)
+	}
+	return ret
+}
+
+func mkvUint(id uint32, v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return mkvElement(id, b)
+}
+
+func mkvFloat(id uint32, v float64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, math.Float64bits(v))
+	return mkvElement(id, b)
+}
+
+func mkvSimpleBlock(track byte, relative int16, lacing byte, data []byte) []byte {
+	b := []byte{0x80 | track, 0, 0, 0x80 | lacing<<1}
+	binary.BigEndian.PutUint16(b[1:], uint16(relative))
+	return mkvElement(mkvIDSimpleBlock, b, data)
+}
+
+var mkvTestDate = time.Date(2026, 10, 19, 7, 0, 0, 0, time.UTC)
+
+func writeMKV(t *testing.T) string {
+	sps := []byte{0x67, 0x64, 0x00, 0x1f, 0xac}
+	pps := []byte{0x68, 0xee, 0x3c, 0x80}
+	avcC := []byte{0x01, 0x64, 0x00, 0x1f, 0xff, 0xe1, 0x00, byte(len(sps))}
+	avcC = append(avcC, sps...)
+	avcC = append(avcC, 0x01, 0x00, byte(len(pps)))
+	avcC = append(avcC, pps...)
+
+	header := mkvElement(mkvIDEBML, mkvElement(mkvIDDocType, []byte("webm")))
+	info := mkvElement(mkvIDInfo, mkvUint(mkvIDTimecodeScale, 1000000), mkvFloat(mkvIDDuration, 2000),
+		mkvUint(mkvIDDateUTC, uint64(mkvTestDate.Sub(mkvEpoch))))
+	tracks := mkvElement(mkvIDTracks,
+		mkvElement(mkvIDTrackEntry,
+			mkvUint(mkvIDTrackNumber, 1),
+			mkvElement(mkvIDCodecID, []byte("V_MPEG4/ISO/AVC")),
+			mkvElement(mkvIDCodecPrivate, avcC)),
+		mkvElement(mkvIDTrackEntry,
+			mkvUint(mkvIDTrackNumber, 2),
+			mkvElement(mkvIDCodecID, []byte("A_OPUS")),
+			mkvUint(mkvIDDefaultDuration, uint64(20*time.Millisecond)),
+			mkvElement(mkvIDAudio, mkvFloat(mkvIDSamplingFrequency, 48000), mkvUint(mkvIDChannels, 2))),
+		mkvElement(mkvIDTrackEntry,
+			mkvUint(mkvIDTrackNumber, 3),
+			mkvElement(mkvIDCodecID, []byte("S_TEXT/UTF8"))))
+
+	// 两个长度前缀的NALU
+	frame := []byte{0, 0, 0, 2, 0x65, 0x01, 0, 0, 0, 3, 0x06, 0x02, 0x03}
+	// xiph lacing: 2帧，第一帧长度3
+	laced := []byte{0x01, 0x03, 0xa1, 0xa2, 0xa3, 0xb1, 0xb2}
+
+	cluster1 := mkvElement(mkvIDCluster,
+		mkvUint(mkvIDTimecode, 0),
+		mkvSimpleBlock(1, 0, 0, frame),
+		mkvSimpleBlock(2, 0, 1, laced),
+		mkvSimpleBlock(3, 0, 0, []byte("hello")))
+	cluster2 := mkvElement(mkvIDCluster,
+		mkvUint(mkvIDTimecode, 1000),
+		mkvSimpleBlock(1, 0, 0, []byte{0, 0, 0, 2, 0x65, 0x04}))
+
+	body := append(info, tracks...)
+	pos1 := len(body)
+	body = append(body, cluster1...)
+	pos2 := len(body)
+	body = append(body, cluster2...)
+	body = append(body, mkvElement(mkvIDCues,
+		mkvElement(mkvIDCuePoint,
+			mkvUint(mkvIDCueTime, 0),
+			mkvElement(mkvIDCueTrackPositions, mkvUint(mkvIDCueClusterPosition, uint64(pos1)))),
+		mkvElement(mkvIDCuePoint,
+			mkvUint(mkvIDCueTime, 1000),
+			mkvElement(mkvIDCueTrackPositions, mkvUint(mkvIDCueClusterPosition, uint64(pos2)))))...)
+
+	data := append(header, mkvElement(mkvIDSegment, body)...)
+	path := filepath.Join(t.TempDir(), "test.webm")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMKV(t *testing.T) {
+	Convey("test mkv sdp and packets", t, func() {
+		s, err := NewMKV(writeMKV(t))
+		So(err, ShouldBeNil)
+		defer s.Close()
+
+		So(s.Duration(), ShouldEqual, 2*time.Second)
+		sdp := string(s.SDP().Gen())
+		So(sdp, ShouldContainSubstring, "a=range:npt=0-2.000")
+		So(sdp, ShouldContainSubstring, "a=rtpmap:96 H264/90000")
+		So(sdp, ShouldContainSubstring, "profile-level-id=64001F")
+		So(sdp, ShouldContainSubstring, "sprop-parameter-sets=Z2QAH6w=,aO48gA==")
+		So(sdp, ShouldContainSubstring, "a=rtpmap:97 opus/48000/2")
+		So(sdp, ShouldContainSubstring, "sprop-stereo=1")
+		So(sdp, ShouldNotContainSubstring, "m=text")
+
+		track, pkt, err := s.ReadPacket()
+		So(err, ShouldBeNil)
+		So(track, ShouldEqual, 0)
+		So(pkt.Payload, ShouldResemble, []byte{0x65, 0x01})
+		So(pkt.Marker, ShouldBeFalse)
+		_, pkt, err = s.ReadPacket()
+		So(err, ShouldBeNil)
+		So(pkt.Payload, ShouldResemble, []byte{0x06, 0x02, 0x03})
+		So(pkt.Marker, ShouldBeTrue)
+
+		track, a1, err := s.ReadPacket()
+		So(err, ShouldBeNil)
+		So(track, ShouldEqual, 1)
+		So(a1.Payload, ShouldResemble, []byte{0xa1, 0xa2, 0xa3})
+		track, a2, err := s.ReadPacket()
+		So(err, ShouldBeNil)
+		So(track, ShouldEqual, 1)
+		So(a2.Payload, ShouldResemble, []byte{0xb1, 0xb2})
+		So(a2.Timestamp-a1.Timestamp, ShouldEqual, 960)
+	})
+
+	Convey("test mkv seek", t, func() {
+		s, err := NewMKV(writeMKV(t))
+		So(err, ShouldBeNil)
+		defer s.Close()
+
+		pos, err := s.Seek(1500 * time.Millisecond)
+		So(err, ShouldBeNil)
+		So(pos, ShouldEqual, time.Second)
+		So(s.StartTime().Equal(mkvTestDate), ShouldBeTrue)
+		seq, rtptime, ok := s.RTPInfo(0)
+		So(ok, ShouldBeTrue)
+
+		start := time.Now()
+		track, pkt, err := s.ReadPacket()
+		So(err, ShouldBeNil)
+		So(track, ShouldEqual, 0)
+		So(pkt.Payload, ShouldResemble, []byte{0x65, 0x04})
+		So(time.Since(start), ShouldBeLessThan, 500*time.Millisecond)
+		So(pkt.SequenceNumber, ShouldEqual, seq)
+		So(pkt.Timestamp, ShouldEqual, rtptime)
+	})
+
+	Convey("test mkv lacing", t, func() {
+		frames, err := mkvSplitLacing([]byte{0x02, 0x81, 0xbf, 1, 2, 3, 4, 5}, 3)
+		So(err, ShouldBeNil)
+		So(frames, ShouldResemble, [][]byte{{1}, {2}, {3, 4, 5}})
+
+		frames, err = mkvSplitLacing([]byte{0x01, 1, 2, 3, 4}, 2)
+		So(err, ShouldBeNil)
+		So(frames, ShouldResemble, [][]byte{{1, 2}, {3, 4}})
+
+		_, err = mkvSplitLacing([]byte{0x01, 0xff}, 1)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,84 @@
+package source
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+)
+
+// Publish 客户端通过ANNOUNCE/RECORD推流，数据由session写入
+type Publish struct {
+	sdp *sdp.SDPImpl
+	// viewer请求关键帧时调用，由推流的session在Publish之前设置，不能阻塞
+	OnKeyFrameRequest func(track int)
+	// 为nil时使用slog.Default()，由推流的session设置
+	Logger *slog.Logger
+
+	packets   chan trackPacket
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewPublish s为ANNOUNCE的sdp，control会被改写为trackID=<序号>
+func NewPublish(s *sdp.SDPImpl) *Publish {
+	return &Publish{
+		sdp:     rewriteSDP(s),
+		packets: make(chan trackPacket, 1024),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (p *Publish) SDP() *sdp.SDPImpl {
+	return p.sdp
+}
+
+// WritePacket 不阻塞，来不及读取时丢弃
+func (p *Publish) WritePacket(track int, pkt *rtp.Packet) {
+	if track < 0 || track >= len(p.sdp.Ms) {
+		return
+	}
+
+	select {
+	case p.packets <- trackPacket{track: track, pkt: pkt}:
+	case <-p.closed:
+	default:
+		p.logger().Warn("publish drop packet", "track", track)
+	}
+}
+
+func (p *Publish) logger() *slog.Logger {
+	if p.Logger == nil {
+		return slog.Default()
+	}
+	return p.Logger
+}
+
+func (p *Publish) ReadPacket() (int, *rtp.Packet, error) {
+	select {
+	case <-p.closed:
+		return 0, nil, ErrClosed
+	default:
+	}
+
+	select {
+	case pkt := <-p.packets:
+		return pkt.track, pkt.pkt, nil
+	case <-p.closed:
+		return 0, nil, ErrClosed
+	}
+}
+
+func (p *Publish) RequestKeyFrame(track int) {
+	if p.OnKeyFrameRequest != nil {
+		p.OnKeyFrameRequest(track)
+	}
+}
+
+func (p *Publish) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
+	return nil
+}
@@ -0,0 +1,38 @@
+package source
+
+import (
+	"testing"
+
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPublish(t *testing.T) {
+	Convey("test publish source", t, func() {
+		s := &sdp.SDPImpl{}
+		So(s.Parse([]byte("v=0\r\no=- 1 1 IN IP4 192.168.1.2\r\ns=push\r\nt=0 0\r\n"+
+			"m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\na=control:streamid=0\r\n"+
+			"m=audio 0 RTP/AVP 97\r\na=rtpmap:97 opus/48000/2\r\na=control:streamid=1\r\n")), ShouldBeNil)
+
+		p := NewPublish(s)
+		desc := string(p.SDP().Gen())
+		So(desc, ShouldContainSubstring, "a=control:trackID=1\n")
+		So(desc, ShouldNotContainSubstring, "streamid")
+
+		p.WritePacket(1, &rtp.Packet{SequenceNumber: 7})
+		// 不存在的track被忽略
+		p.WritePacket(2, &rtp.Packet{SequenceNumber: 8})
+
+		track, pkt, err := p.ReadPacket()
+		So(err, ShouldBeNil)
+		So(track, ShouldEqual, 1)
+		So(pkt.SequenceNumber, ShouldEqual, 7)
+
+		p.WritePacket(0, &rtp.Packet{})
+		p.Close()
+		_, _, err = p.ReadPacket()
+		So(err, ShouldEqual, ErrClosed)
+		p.WritePacket(0, &rtp.Packet{})
+	})
+}
@@ -0,0 +1,295 @@
+package source
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/client"
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+	"github.com/Lcmasdf/drs/pkg/srtp"
+)
+
+// 重连的退避时间
+var (
+	rtspRetryMin = time.Second
+	rtspRetryMax = 30 * time.Second
+)
+
+// rtspTrack 重连后改写seq和timestamp，使reader看到的是连续的流
+type rtspTrack struct {
+	clockRate uint32
+
+	started  bool
+	rebase   bool
+	lastSeq  uint16
+	lastTs   uint32
+	lastTime time.Time
+
+	seqOffset uint16
+	tsOffset  uint32
+}
+
+// RTSP 从远端rtsp服务拉流，断开后按指数退避重连，重连期间ReadPacket阻塞
+type RTSP struct {
+	url  string
+	opts client.Options
+	sdp  *sdp.SDPImpl
+	log  *slog.Logger
+
+	mu     sync.Mutex
+	client *client.Client
+	tracks []*rtspTrack
+
+	packets   chan trackPacket
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewRTSP 连接并开始播放，sdp取自第一次连接，log为nil时使用slog.Default()
+func NewRTSP(url, transport string, log *slog.Logger) (*RTSP, error) {
+	if log == nil {
+		log = slog.Default()
+	}
+	ret := &RTSP{
+		url:     url,
+		log:     log.With("upstream", url),
+		packets: make(chan trackPacket, 1024),
+		closed:  make(chan struct{}),
+	}
+	ret.opts = client.Options{
+		Transport: transport,
+		OnPacket:  ret.onPacket,
+		Logger:    ret.log,
+	}
+
+	c, s, err := ret.connect()
+	if err != nil {
+		return nil, err
+	}
+	// PLAY之后已经开始回调onPacket
+	ret.mu.Lock()
+	ret.client = c
+	for _, m := range s.Ms {
+		ret.tracks = append(ret.tracks, &rtspTrack{clockRate: mediaClockRate(m)})
+	}
+	ret.mu.Unlock()
+	ret.sdp = rewriteSDP(s)
+
+	go ret.run(c)
+	return ret, nil
+}
+
+func (s *RTSP) SDP() *sdp.SDPImpl {
+	return s.sdp
+}
+
+func (s *RTSP) ReadPacket() (int, *rtp.Packet, error) {
+	// 关闭后不再返回缓存的包
+	select {
+	case <-s.closed:
+		return 0, nil, ErrClosed
+	default:
+	}
+
+	select {
+	case p := <-s.packets:
+		return p.track, p.pkt, nil
+	case <-s.closed:
+		return 0, nil, ErrClosed
+	}
+}
+
+// RequestKeyFrame 向上游发送PLI，重连期间忽略
+func (s *RTSP) RequestKeyFrame(track int) {
+	s.mu.Lock()
+	c := s.client
+	s.mu.Unlock()
+	if c == nil {
+		return
+	}
+	if err := c.RequestKeyFrame(track); err != nil {
+		s.log.Warn("request key frame failed", "err", err)
+	}
+}
+
+func (s *RTSP) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+
+	s.mu.Lock()
+	c := s.client
+	s.client = nil
+	s.mu.Unlock()
+	if c != nil {
+		c.Close()
+	}
+	return nil
+}
+
+func (s *RTSP) connect() (*client.Client, *sdp.SDPImpl, error) {
+	c, err := client.Dial(s.url, s.opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	desc, err := c.Describe()
+	// 没有会话描述时无法改写sdp
+	if err == nil && desc.S == nil {
+		err = fmt.Errorf("upstream sdp has no session description")
+	}
+	if err == nil {
+		err = c.SetupAll()
+	}
+	if err == nil {
+		_, err = c.Play(nil)
+	}
+	if err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	return c, desc, nil
+}
+
+// run 连接断开后重连，直到source被关闭
+func (s *RTSP) run(c *client.Client) {
+	retry := rtspRetryMin
+	for {
+		select {
+		case <-c.Done():
+			s.log.Warn("upstream disconnected", "err", c.Err())
+		case <-s.closed:
+			return
+		}
+		c.Close()
+
+		// 新连接PLAY之后就开始回调，需在连接前设置
+		s.mu.Lock()
+		for _, t := range s.tracks {
+			t.rebase = true
+		}
+		s.mu.Unlock()
+
+		for {
+			select {
+			case <-time.After(retry):
+			case <-s.closed:
+				return
+			}
+
+			var desc *sdp.SDPImpl
+			var err error
+			c, desc, err = s.connect()
+			if err == nil && len(desc.Ms) != len(s.tracks) {
+				c.Close()
+				err = fmt.Errorf("track count changed from %d to %d", len(s.tracks), len(desc.Ms))
+			}
+			if err != nil {
+				s.log.Warn("reconnect failed", "err", err)
+				if retry *= 2; retry > rtspRetryMax {
+					retry = rtspRetryMax
+				}
+				continue
+			}
+			break
+		}
+
+		s.mu.Lock()
+		select {
+		case <-s.closed:
+			s.mu.Unlock()
+			c.Close()
+			return
+		default:
+		}
+		s.client = c
+		s.mu.Unlock()
+
+		s.log.Info("upstream reconnected")
+		retry = rtspRetryMin
+	}
+}
+
+func (s *RTSP) onPacket(track int, pkt *rtp.Packet) {
+	s.mu.Lock()
+	if track >= len(s.tracks) {
+		s.mu.Unlock()
+		return
+	}
+	s.tracks[track].rewrite(pkt)
+	s.mu.Unlock()
+
+	select {
+	case s.packets <- trackPacket{track: track, pkt: pkt}:
+	case <-s.closed:
+	default:
+		s.log.Warn("drop packet", "track", track)
+	}
+}
+
+// rewrite 重连后第一个包接在上一个包之后，timestamp按经过的时间递增
+func (t *rtspTrack) rewrite(pkt *rtp.Packet) {
+	now := time.Now()
+	if t.rebase && t.started {
+		elapsed := uint32(now.Sub(t.lastTime).Seconds() * float64(t.clockRate))
+		t.seqOffset = t.lastSeq + 1 - pkt.SequenceNumber
+		t.tsOffset = t.lastTs + elapsed - pkt.Timestamp
+	}
+	t.rebase = false
+
+	pkt.SequenceNumber += t.seqOffset
+	pkt.Timestamp += t.tsOffset
+
+	t.started = true
+	t.lastSeq = pkt.SequenceNumber
+	t.lastTs = pkt.Timestamp
+	t.lastTime = now
+}
+
+// rewriteSDP control改为trackID=<序号>，源地址和连接地址改为0.0.0.0
+// 收到的数据已经解密，RTP/SAVP改为RTP/AVP并删除密钥
+func rewriteSDP(s *sdp.SDPImpl) *sdp.SDPImpl {
+	// o=<username> <sess-id> <sess-version> IN IP4 <address>
+	if o, ok := s.S.Item['o']; ok {
+		parts := strings.Split(string(o[0]), " ")
+		if len(parts) == 6 {
+			parts[3], parts[4], parts[5] = "IN", "IP4", "0.0.0.0"
+			s.S.Item['o'] = [][]byte{[]byte(strings.Join(parts, " "))}
+		}
+	}
+
+	rewrite := func(item map[byte][][]byte, control string) {
+		if _, ok := item['c']; ok {
+			item['c'] = [][]byte{[]byte("IN IP4 0.0.0.0")}
+		}
+		attrs := make([][]byte, 0, len(item['a'])+1)
+		for _, a := range item['a'] {
+			if !strings.HasPrefix(string(a), "control:") {
+				attrs = append(attrs, a)
+			}
+		}
+		item['a'] = append(attrs, []byte("control:"+control))
+	}
+
+	rewrite(s.S.Item, "*")
+	for i, m := range s.Ms {
+		rewrite(m.Item, fmt.Sprintf("trackID=%d", i))
+		if srtp.IsSAVP(m) {
+			srtp.ClearMediaKey(m)
+		}
+	}
+	return s
+}
+
+// mediaClockRate 取第一个rtpmap的clock rate，没有rtpmap(静态payload type)时使用90000
+func mediaClockRate(m *sdp.Media) uint32 {
+	rtpmaps, err := m.GetRtpmaps()
+	if err != nil || len(rtpmaps) == 0 || rtpmaps[0].ClockRate <= 0 {
+		return 90000
+	}
+	return uint32(rtpmaps[0].ClockRate)
+}
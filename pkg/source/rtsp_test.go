@@ -0,0 +1,146 @@
+package source
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/rtsp"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeUpstream 每个连接PLAY后通过interleaved发送5个包然后断开
+type fakeUpstream struct {
+	listener net.Listener
+	conns    int32
+	// 不为空时代替默认的sdp
+	sdp string
+}
+
+func newFakeUpstream(t *testing.T, sdp string) *fakeUpstream {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := &fakeUpstream{listener: listener, sdp: sdp}
+	go ret.serve()
+	return ret
+}
+
+func (u *fakeUpstream) url() string {
+	return "rtsp://" + u.listener.Addr().String() + "/cam"
+}
+
+func (u *fakeUpstream) serve() {
+	for {
+		conn, err := u.listener.Accept()
+		if err != nil {
+			return
+		}
+		go u.handle(conn, int(atomic.AddInt32(&u.conns, 1)))
+	}
+}
+
+func (u *fakeUpstream) handle(conn net.Conn, index int) {
+	defer conn.Close()
+
+	reader := textproto.NewReader(bufio.NewReader(conn))
+	for {
+		req := &rtsp.Request{}
+		if err := req.Parse(*reader); err != nil {
+			return
+		}
+
+		switch req.M {
+		case "DESCRIBE":
+			body := "v=0\r\no=- 1 1 IN IP4 10.0.0.1\r\ns=cam\r\nc=IN IP4 10.0.0.1\r\nt=0 0\r\na=control:" + u.url() + "\r\n" +
+				"m=video 0 RTP/AVP 96\r\nc=IN IP4 10.0.0.1\r\na=rtpmap:96 H264/90000\r\na=control:" + u.url() + "/video\r\n"
+			if u.sdp != "" {
+				body = u.sdp
+			}
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %d\r\nContent-Base: %s/\r\nContent-Length: %d\r\n\r\n%s", req.Seq, u.url(), len(body), body)
+		case "SETUP":
+			transport, _ := req.GetMessage("Transport")
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %d\r\nSession: 1234;timeout=60\r\nTransport: %s\r\n\r\n", req.Seq, transport)
+		case "PLAY":
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %d\r\nSession: 1234\r\n\r\n", req.Seq)
+			for i := 0; i < 5; i++ {
+				pkt := &rtp.Packet{
+					PayloadType:    96,
+					SequenceNumber: uint16(1000*index + i),
+					Timestamp:      uint32(7000000*index + 3000*i),
+					Payload:        []byte{0x41, byte(i)},
+				}
+				data := pkt.Marshal()
+				frame := []byte{'$', 0, 0, 0}
+				binary.BigEndian.PutUint16(frame[2:], uint16(len(data)))
+				conn.Write(append(frame, data...))
+				time.Sleep(10 * time.Millisecond)
+			}
+			return
+		default:
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %d\r\n\r\n", req.Seq)
+		}
+	}
+}
+
+func TestRTSP(t *testing.T) {
+	rtspRetryMin = 10 * time.Millisecond
+
+	Convey("test rtsp source reconnects and keeps packets continuous", t, func() {
+		upstream := newFakeUpstream(t, "")
+		defer upstream.listener.Close()
+
+		s, err := NewRTSP(upstream.url(), "tcp", nil)
+		So(err, ShouldBeNil)
+		defer s.Close()
+
+		desc := string(s.SDP().Gen())
+		So(desc, ShouldNotContainSubstring, "10.0.0.1")
+		So(desc, ShouldNotContainSubstring, upstream.url())
+		So(desc, ShouldContainSubstring, "a=control:*\n")
+		So(desc, ShouldContainSubstring, "a=control:trackID=0\n")
+		So(strings.Count(desc, "c=IN IP4 0.0.0.0"), ShouldEqual, 2)
+
+		pkts := make([]*rtp.Packet, 0)
+		for len(pkts) < 12 {
+			track, pkt, err := s.ReadPacket()
+			So(err, ShouldBeNil)
+			So(track, ShouldEqual, 0)
+			pkts = append(pkts, pkt)
+		}
+		So(atomic.LoadInt32(&upstream.conns), ShouldBeGreaterThanOrEqualTo, 3)
+		for i := 1; i < len(pkts); i++ {
+			So(pkts[i].SequenceNumber, ShouldEqual, pkts[i-1].SequenceNumber+1)
+			So(pkts[i].Timestamp-pkts[i-1].Timestamp, ShouldBeLessThan, 90000)
+			So(pkts[i].Timestamp, ShouldNotEqual, pkts[i-1].Timestamp)
+		}
+
+		s.Close()
+		_, _, err = s.ReadPacket()
+		So(err, ShouldEqual, ErrClosed)
+	})
+
+	Convey("test rtsp source connect failed", t, func() {
+		_, err := NewRTSP("rtsp://127.0.0.1:1/cam", "tcp", nil)
+		So(err, ShouldNotBeNil)
+
+		// 没有会话描述或格式错误的sdp
+		for _, body := range []string{
+			"m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\n",
+			"o=- 1 1 IN IP4 10.0.0.1\r\ns=cam\r\n",
+		} {
+			upstream := newFakeUpstream(t, body)
+			_, err = NewRTSP(upstream.url(), "tcp", nil)
+			upstream.listener.Close()
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
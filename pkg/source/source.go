@@ -0,0 +1,144 @@
+package source
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrClosed = fmt.Errorf("source closed")
+
+// errPacerReset 等待期间发生了seek，调用方需要重新计算要发送的数据
+var errPacerReset = fmt.Errorf("pacer reset")
+
+// 动态payload type起始值
+const dynamicPayloadType = 96
+
+// fileStartTime 文件中没有记录时间时，认为录制在文件最后修改时结束
+func fileStartTime(f *os.File, duration time.Duration) time.Time {
+	info, err := f.Stat()
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime().Add(-duration)
+}
+
+// pacer 按墙上时钟控制发送节奏
+type pacer struct {
+	mu sync.Mutex
+	// offset为0的时刻，为零值时在下一次wait时确定
+	start time.Time
+	// 每次reset加1
+	generation int
+	// 暂停的时刻，非零值表示暂停中
+	pausedAt time.Time
+	// 状态改变时关闭并重建，唤醒wait
+	changed chan struct{}
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPacer() *pacer {
+	return &pacer{
+		changed: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+}
+
+// notify 需持有锁
+func (p *pacer) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// wait 等待到 start+offset，暂停期间一直等待，source被关闭返回ErrClosed
+func (p *pacer) wait(offset time.Duration) error {
+	p.mu.Lock()
+	generation := p.generation
+	p.mu.Unlock()
+
+	for {
+		p.mu.Lock()
+		if p.generation != generation {
+			p.mu.Unlock()
+			return errPacerReset
+		}
+		paused := !p.pausedAt.IsZero()
+		if !paused && p.start.IsZero() {
+			p.start = time.Now().Add(-offset)
+		}
+		deadline := p.start.Add(offset)
+		changed := p.changed
+		p.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !paused {
+			d := time.Until(deadline)
+			if d <= 0 {
+				select {
+				case <-p.closed:
+					return ErrClosed
+				default:
+					return nil
+				}
+			}
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+
+		select {
+		case <-p.closed:
+			return ErrClosed
+		case <-changed:
+			if timer != nil {
+				timer.Stop()
+			}
+		case <-timeout:
+			return nil
+		}
+	}
+}
+
+// reset 重新确定offset与墙上时钟的对应关系，用于seek
+func (p *pacer) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.start = time.Time{}
+	p.generation++
+	p.notify()
+}
+
+func (p *pacer) pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pausedAt.IsZero() {
+		p.pausedAt = time.Now()
+		p.notify()
+	}
+}
+
+// resume 从暂停处继续，start顺延暂停的时长
+func (p *pacer) resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pausedAt.IsZero() {
+		return
+	}
+	if !p.start.IsZero() {
+		p.start = p.start.Add(time.Since(p.pausedAt))
+	}
+	p.pausedAt = time.Time{}
+	p.notify()
+}
+
+func (p *pacer) close() {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
+}
@@ -0,0 +1,159 @@
+package source
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/codec"
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+)
+
+// track 容器格式(mkv/flv)中一路音视频对应的RTP输出
+type track struct {
+	index       int
+	media       string
+	encoding    string
+	clockRate   int
+	channels    int
+	payloadType int
+	fmtp        string
+
+	// H264/H265 帧为长度前缀的NALU，0表示不需要切分
+	nalLengthSize int
+
+	packetizer *rtp.Packetizer
+	baseTS     uint32
+}
+
+// trackPacket 打包后等待发送的RTP包，pts/dts为相对文件开始的时间
+type trackPacket struct {
+	track int
+	t     *track
+	pkt   *rtp.Packet
+	pts   time.Duration
+	dts   time.Duration
+}
+
+func newTrack(index int, media, encoding string, clockRate int, payloader rtp.Payloader) *track {
+	pt := dynamicPayloadType + index
+	ret := &track{
+		index:       index,
+		media:       media,
+		encoding:    encoding,
+		clockRate:   clockRate,
+		payloadType: pt,
+		packetizer:  rtp.NewPacketizer(uint8(pt), clockRate, payloader),
+	}
+	ret.baseTS = ret.packetizer.SSRC ^ uint32(time.Now().UnixNano())
+	return ret
+}
+
+func newH264Track(index int, conf *codec.AVCDecoderConfig) *track {
+	ret := newTrack(index, "video", "H264", videoClockRate, &rtp.H264Payloader{})
+	ret.nalLengthSize = conf.LengthSize
+
+	sps := conf.SPS[0]
+	ret.fmtp = fmt.Sprintf("packetization-mode=1;profile-level-id=%02X%02X%02X;sprop-parameter-sets=%s,%s",
+		sps[1], sps[2], sps[3], joinBase64(conf.SPS), joinBase64(conf.PPS))
+	return ret
+}
+
+func newH265Track(index int, conf *codec.HEVCDecoderConfig) *track {
+	ret := newTrack(index, "video", "H265", videoClockRate, &rtp.H265Payloader{})
+	ret.nalLengthSize = conf.LengthSize
+	ret.fmtp = fmt.Sprintf("sprop-vps=%s;sprop-sps=%s;sprop-pps=%s",
+		joinBase64(conf.VPS), joinBase64(conf.SPS), joinBase64(conf.PPS))
+	return ret
+}
+
+func newAACTrack(index int, config []byte, sampleRate, channels int) (*track, error) {
+	conf, err := codec.ParseAACConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if sampleRate == 0 {
+		sampleRate = conf.SampleRate
+	}
+	if channels == 0 {
+		channels = conf.Channels
+	}
+
+	ret := newTrack(index, "audio", "MPEG4-GENERIC", sampleRate, &rtp.AACPayloader{})
+	ret.channels = channels
+	ret.fmtp = fmt.Sprintf("streamtype=5;profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=%s",
+		hex.EncodeToString(config))
+	return ret, nil
+}
+
+// RFC7587 opus 的 rtpmap 固定为 opus/48000/2
+func newOpusTrack(index int, channels int) *track {
+	ret := newTrack(index, "audio", "opus", 48000, &rtp.RawPayloader{})
+	ret.channels = 2
+	if channels == 2 {
+		ret.fmtp = "sprop-stereo=1"
+	}
+	return ret
+}
+
+func (t *track) genMedia() *sdp.Media {
+	m := sdp.NewMedia(t.media, t.payloadType)
+	m.AddAttribute("control:trackID=%d", t.index)
+	if t.channels > 1 {
+		m.AddAttribute("rtpmap:%d %s/%d/%d", t.payloadType, t.encoding, t.clockRate, t.channels)
+	} else {
+		m.AddAttribute("rtpmap:%d %s/%d", t.payloadType, t.encoding, t.clockRate)
+	}
+	if t.fmtp != "" {
+		m.AddAttribute("fmtp:%d %s", t.payloadType, t.fmtp)
+	}
+	return m
+}
+
+// packetize pts为相对文件开始的时间
+func (t *track) packetize(frame []byte, pts time.Duration) ([]*rtp.Packet, error) {
+	units := [][]byte{frame}
+	if t.nalLengthSize > 0 {
+		var err error
+		if units, err = codec.SplitAVCC(frame, t.nalLengthSize); err != nil {
+			return nil, err
+		}
+	}
+
+	ret := t.packetizer.Packetize(units, t.timestamp(pts))
+	// 音频不使用marker
+	if t.media == "audio" {
+		for _, pkt := range ret {
+			pkt.Marker = false
+		}
+	}
+	return ret, nil
+}
+
+// timestamp pts对应的RTP时间戳
+func (t *track) timestamp(pts time.Duration) uint32 {
+	return t.baseTS + uint32(int64(pts)*int64(t.clockRate)/int64(time.Second))
+}
+
+// rtpInfo 下一个发送的包的序号及当前播放位置对应的RTP时间戳，用于RTP-Info，需持有锁
+func rtpInfo(t *track, pending []*trackPacket, tp *trickPlay) (uint16, uint32) {
+	seq := t.packetizer.Seq()
+	for _, p := range pending {
+		if p.t == t {
+			seq = p.pkt.SequenceNumber
+			break
+		}
+	}
+	return seq, t.timestamp(tp.rtpTime(tp.position))
+}
+
+func joinBase64(nalus [][]byte) string {
+	ret := make([]string, 0, len(nalus))
+	for _, nalu := range nalus {
+		ret = append(ret, base64.StdEncoding.EncodeToString(nalu))
+	}
+	return strings.Join(ret, ",")
+}
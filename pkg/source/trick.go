@@ -0,0 +1,99 @@
+package source
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Scale/Speed的范围
+const (
+	MaxScale = 16.0
+	MaxSpeed = 8.0
+	// 超过该倍速或倒放时只发送关键帧
+	keyFrameScale = 2.0
+)
+
+// trickPlay 媒体时间到发送时间及RTP时间的映射，用于Scale/Speed
+// Scale改变RTP时间戳，客户端按正常速度渲染即为快进/慢放；Speed只改变发送速度
+type trickPlay struct {
+	scale float64
+	speed float64
+	// 映射的起点：媒体时间media对应RTP时间rtp，发送时间为0
+	media time.Duration
+	rtp   time.Duration
+	// 最后发送的媒体时间，改变Scale时从这里开始
+	position time.Duration
+}
+
+func newTrickPlay() trickPlay {
+	return trickPlay{scale: 1, speed: 1}
+}
+
+// checkScale 校验并限制Scale/Speed的范围，没有视频时不支持Scale
+func checkScale(scale, speed float64, video bool) (float64, float64, error) {
+	if scale == 0 || math.IsNaN(scale) || math.IsInf(scale, 0) {
+		return 0, 0, fmt.Errorf("invalid scale %v", scale)
+	}
+	if speed <= 0 || math.IsNaN(speed) || math.IsInf(speed, 0) {
+		return 0, 0, fmt.Errorf("invalid speed %v", speed)
+	}
+
+	if !video {
+		scale = 1
+	}
+	scale = math.Max(-MaxScale, math.Min(MaxScale, scale))
+	speed = math.Min(MaxSpeed, speed)
+	return scale, speed, nil
+}
+
+func (tp *trickPlay) reverse() bool {
+	return tp.scale < 0
+}
+
+// keyFramesOnly 倒放或高倍速时只发送视频关键帧
+func (tp *trickPlay) keyFramesOnly() bool {
+	return tp.scale < 0 || tp.scale > keyFrameScale
+}
+
+// skip 是否丢弃该帧，Scale不为1时不发送音频
+func (tp *trickPlay) skip(t *track, key bool) bool {
+	if tp.scale == 1 {
+		return false
+	}
+	if t.media != "video" {
+		return true
+	}
+	return tp.keyFramesOnly() && !key
+}
+
+// seek 跳转后RTP时间与媒体时间重新对齐
+func (tp *trickPlay) seek(npt time.Duration) {
+	tp.media = npt
+	tp.rtp = npt
+	tp.position = npt
+}
+
+// set 从媒体时间at开始使用新的Scale/Speed，RTP时间从最后发送的位置继续
+func (tp *trickPlay) set(scale, speed float64, at time.Duration) {
+	tp.rtp = tp.rtpTime(tp.position)
+	tp.media = at
+	tp.position = at
+	tp.scale = scale
+	tp.speed = speed
+}
+
+// offset 发送时间，用于pacer
+func (tp *trickPlay) offset(dts time.Duration) time.Duration {
+	return time.Duration(float64(dts-tp.media) / tp.scale / tp.speed)
+}
+
+func (tp *trickPlay) rtpTime(pts time.Duration) time.Duration {
+	return tp.rtp + time.Duration(float64(pts-tp.media)/tp.scale)
+}
+
+// sent 发送前调用，更新位置及RTP时间戳
+func (tp *trickPlay) sent(p *trackPacket) {
+	tp.position = p.dts
+	p.pkt.Timestamp = p.t.timestamp(tp.rtpTime(p.pts))
+}
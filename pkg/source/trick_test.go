@@ -0,0 +1,117 @@
+package source
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type packetReader interface {
+	ReadPacket() (int, *rtp.Packet, error)
+}
+
+// readAll 读取到文件结束
+func readAll(s packetReader) []*rtp.Packet {
+	ret := make([]*rtp.Packet, 0)
+	for {
+		_, pkt, err := s.ReadPacket()
+		if err == io.EOF {
+			return ret
+		}
+		So(err, ShouldBeNil)
+		ret = append(ret, pkt)
+	}
+}
+
+func TestTrickPlay(t *testing.T) {
+	Convey("test scale and speed limits", t, func() {
+		_, _, err := checkScale(0, 1, true)
+		So(err, ShouldNotBeNil)
+		_, _, err = checkScale(1, -1, true)
+		So(err, ShouldNotBeNil)
+
+		scale, speed, err := checkScale(-100, 100, true)
+		So(err, ShouldBeNil)
+		So(scale, ShouldEqual, -MaxScale)
+		So(speed, ShouldEqual, MaxSpeed)
+
+		// 只有音频时不支持Scale
+		scale, _, err = checkScale(4, 1, false)
+		So(err, ShouldBeNil)
+		So(scale, ShouldEqual, 1)
+	})
+
+	Convey("test flv fast forward sends key frames only", t, func() {
+		s, err := NewFLV(writeFLV(t))
+		So(err, ShouldBeNil)
+		defer s.Close()
+
+		scale, speed, err := s.SetScale(4, 1)
+		So(err, ShouldBeNil)
+		So(scale, ShouldEqual, 4)
+		So(speed, ShouldEqual, 1)
+
+		start := time.Now()
+		pkts := readAll(s)
+		So(time.Since(start), ShouldBeBetween, 200*time.Millisecond, 500*time.Millisecond)
+		So(len(pkts), ShouldEqual, 2)
+		So(pkts[0].Payload, ShouldResemble, []byte{0x65, 0x01})
+		So(pkts[1].Payload, ShouldResemble, []byte{0x65, 0x03})
+		// pts 80ms -> 1000ms，RTP时间缩小4倍
+		So(pkts[1].Timestamp-pkts[0].Timestamp, ShouldEqual, 230*90)
+	})
+
+	Convey("test flv reverse", t, func() {
+		s, err := NewFLV(writeFLV(t))
+		So(err, ShouldBeNil)
+		defer s.Close()
+
+		_, err = s.Seek(1200 * time.Millisecond)
+		So(err, ShouldBeNil)
+		_, _, err = s.SetScale(-2, 1)
+		So(err, ShouldBeNil)
+
+		pkts := readAll(s)
+		So(len(pkts), ShouldEqual, 2)
+		So(pkts[0].Payload, ShouldResemble, []byte{0x65, 0x03})
+		So(pkts[1].Payload, ShouldResemble, []byte{0x65, 0x01})
+		// RTP时间戳保持递增
+		So(pkts[1].Timestamp-pkts[0].Timestamp, ShouldEqual, 460*90)
+	})
+
+	Convey("test flv speed keeps timestamps", t, func() {
+		s, err := NewFLV(writeFLV(t))
+		So(err, ShouldBeNil)
+		defer s.Close()
+
+		_, _, err = s.SetScale(1, 4)
+		So(err, ShouldBeNil)
+
+		start := time.Now()
+		pkts := readAll(s)
+		So(time.Since(start), ShouldBeBetween, 300*time.Millisecond, 600*time.Millisecond)
+		So(len(pkts), ShouldEqual, 5)
+		So(pkts[3].Timestamp-pkts[0].Timestamp, ShouldEqual, 920*90)
+	})
+
+	Convey("test mkv reverse", t, func() {
+		s, err := NewMKV(writeMKV(t))
+		So(err, ShouldBeNil)
+		defer s.Close()
+
+		_, err = s.Seek(1500 * time.Millisecond)
+		So(err, ShouldBeNil)
+		_, _, err = s.SetScale(-4, 1)
+		So(err, ShouldBeNil)
+
+		pkts := readAll(s)
+		So(len(pkts), ShouldEqual, 3)
+		So(pkts[0].Payload, ShouldResemble, []byte{0x65, 0x04})
+		So(pkts[1].Payload, ShouldResemble, []byte{0x65, 0x01})
+		So(pkts[2].Payload, ShouldResemble, []byte{0x06, 0x02, 0x03})
+		So(pkts[1].Timestamp-pkts[0].Timestamp, ShouldEqual, 250*90)
+	})
+}
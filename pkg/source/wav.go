@@ -0,0 +1,285 @@
+package source
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+)
+
+// WAVE format tag
+const (
+	wavFormatPCM        = 1
+	wavFormatALaw       = 6
+	wavFormatMuLaw      = 7
+	wavFormatExtensible = 0xfffe
+)
+
+const defaultPTime = 20
+
+// WAV 读取 RIFF/WAVE 文件，按ptime打包循环播放
+type WAV struct {
+	file *os.File
+
+	encoding      string
+	payloadType   int
+	sampleRate    int
+	channels      int
+	bitsPerSample int
+	ptime         int
+
+	dataOffset int64
+	dataSize   int64
+	// 当前在data chunk中的位置
+	pos int64
+
+	sdp        *sdp.SDPImpl
+	packetizer *rtp.Packetizer
+	pacer      *pacer
+
+	// 已发送的采样数，循环时不清零
+	samples uint64
+	baseTS  uint32
+}
+
+// NewWAV ptime 为每个RTP包的时长(ms)，0使用默认20ms
+func NewWAV(path string, ptime int) (*WAV, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &WAV{
+		file:  f,
+		ptime: ptime,
+		pacer: newPacer(),
+	}
+	if err := ret.parseHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	if err := ret.init(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return ret, nil
+}
+
+func (s *WAV) parseHeader() error {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(s.file, header); err != nil {
+		return err
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return fmt.Errorf("not a RIFF/WAVE file")
+	}
+
+	var format int
+	offset := int64(12)
+	for {
+		chunk := make([]byte, 8)
+		if _, err := io.ReadFull(s.file, chunk); err != nil {
+			return fmt.Errorf("data chunk not found")
+		}
+		id := string(chunk[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		offset += 8
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return fmt.Errorf("invalid fmt chunk")
+			}
+			body := make([]byte, size)
+			if _, err := io.ReadFull(s.file, body); err != nil {
+				return err
+			}
+			format = int(binary.LittleEndian.Uint16(body[0:2]))
+			s.channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			s.sampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			s.bitsPerSample = int(binary.LittleEndian.Uint16(body[14:16]))
+			if format == wavFormatExtensible {
+				if size < 40 {
+					return fmt.Errorf("invalid extensible fmt chunk")
+				}
+				// SubFormat GUID 的前两个字节为format tag
+				format = int(binary.LittleEndian.Uint16(body[24:26]))
+			}
+		case "data":
+			if format == 0 {
+				return fmt.Errorf("fmt chunk not found before data")
+			}
+			s.dataOffset = offset
+			s.dataSize = size
+
+			// 文件被截断时以实际大小为准
+			if fi, err := s.file.Stat(); err == nil && offset+size > fi.Size() {
+				s.dataSize = fi.Size() - offset
+			}
+			return s.setEncoding(format)
+		default:
+			if _, err := s.file.Seek(size, io.SeekCurrent); err != nil {
+				return err
+			}
+		}
+
+		// chunk 按2字节对齐
+		if size%2 == 1 {
+			if _, err := s.file.Seek(1, io.SeekCurrent); err != nil {
+				return err
+			}
+			size++
+		}
+		offset += size
+	}
+}
+
+func (s *WAV) setEncoding(format int) error {
+	if s.channels == 0 || s.sampleRate == 0 {
+		return fmt.Errorf("invalid channels %d or sample rate %d", s.channels, s.sampleRate)
+	}
+
+	switch {
+	case format == wavFormatMuLaw && s.bitsPerSample == 8:
+		s.encoding = "PCMU"
+	case format == wavFormatALaw && s.bitsPerSample == 8:
+		s.encoding = "PCMA"
+	case format == wavFormatPCM && s.bitsPerSample == 8:
+		s.encoding = "L8"
+	case format == wavFormatPCM && s.bitsPerSample == 16:
+		s.encoding = "L16"
+	default:
+		return fmt.Errorf("unsupported wav format %d with %d bits", format, s.bitsPerSample)
+	}
+
+	// RFC3551 静态payload type
+	s.payloadType = dynamicPayloadType
+	switch {
+	case s.encoding == "PCMU" && s.sampleRate == 8000 && s.channels == 1:
+		s.payloadType = 0
+	case s.encoding == "PCMA" && s.sampleRate == 8000 && s.channels == 1:
+		s.payloadType = 8
+	case s.encoding == "L16" && s.sampleRate == 44100 && s.channels == 2:
+		s.payloadType = 10
+	case s.encoding == "L16" && s.sampleRate == 44100 && s.channels == 1:
+		s.payloadType = 11
+	}
+	return nil
+}
+
+func (s *WAV) frameSize() int {
+	return s.channels * s.bitsPerSample / 8
+}
+
+func (s *WAV) init() error {
+	if s.dataSize < int64(s.frameSize()) {
+		return fmt.Errorf("empty data chunk")
+	}
+
+	if s.ptime <= 0 {
+		s.ptime = defaultPTime
+	}
+	// 一个包不能超过MTU
+	maxPTime := rtp.DefaultMTU / s.frameSize() * 1000 / s.sampleRate
+	if maxPTime == 0 {
+		return fmt.Errorf("sample rate %d too high", s.sampleRate)
+	}
+	if s.ptime > maxPTime {
+		s.ptime = maxPTime
+	}
+
+	if _, err := s.file.Seek(s.dataOffset, io.SeekStart); err != nil {
+		return err
+	}
+
+	s.packetizer = rtp.NewPacketizer(uint8(s.payloadType), s.sampleRate, &rtp.RawPayloader{})
+	s.baseTS = s.packetizer.SSRC ^ uint32(time.Now().UnixNano())
+	s.sdp = s.genSDP()
+	return nil
+}
+
+func (s *WAV) genSDP() *sdp.SDPImpl {
+	ret := sdp.NewSDP("Media Server")
+
+	m := sdp.NewMedia("audio", s.payloadType)
+	m.AddAttribute("control:trackID=0")
+	if s.channels > 1 {
+		m.AddAttribute("rtpmap:%d %s/%d/%d", s.payloadType, s.encoding, s.sampleRate, s.channels)
+	} else {
+		m.AddAttribute("rtpmap:%d %s/%d", s.payloadType, s.encoding, s.sampleRate)
+	}
+	m.AddAttribute("ptime:%d", s.ptime)
+	ret.AddMedia(m)
+
+	return ret
+}
+
+func (s *WAV) SDP() *sdp.SDPImpl {
+	return s.sdp
+}
+
+func (s *WAV) PTime() int {
+	return s.ptime
+}
+
+// readSamples 读取n个采样，到文件尾时从头循环
+func (s *WAV) readSamples(n int) ([]byte, error) {
+	ret := make([]byte, n*s.frameSize())
+	read := 0
+	for read < len(ret) {
+		if s.pos+int64(s.frameSize()) > s.dataSize {
+			if _, err := s.file.Seek(s.dataOffset, io.SeekStart); err != nil {
+				return nil, err
+			}
+			s.pos = 0
+		}
+
+		want := int64(len(ret) - read)
+		if left := s.dataSize - s.pos; want > left {
+			want = left - left%int64(s.frameSize())
+		}
+		c, err := io.ReadFull(s.file, ret[read:read+int(want)])
+		if err != nil {
+			return nil, err
+		}
+		read += c
+		s.pos += int64(c)
+	}
+	return ret, nil
+}
+
+func (s *WAV) ReadPacket() (int, *rtp.Packet, error) {
+	offset := time.Duration(float64(s.samples) / float64(s.sampleRate) * float64(time.Second))
+	if err := s.pacer.wait(offset); err != nil {
+		return 0, nil, err
+	}
+
+	n := s.sampleRate * s.ptime / 1000
+	data, err := s.readSamples(n)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// L16 使用网络字节序
+	if s.encoding == "L16" {
+		for i := 0; i+1 < len(data); i += 2 {
+			data[i], data[i+1] = data[i+1], data[i]
+		}
+	}
+
+	pkt := s.packetizer.Packetize([][]byte{data}, s.baseTS+uint32(s.samples))[0]
+	// 连续的音频只有第一个包设置marker
+	pkt.Marker = s.samples == 0
+
+	s.samples += uint64(n)
+	return 0, pkt, nil
+}
+
+func (s *WAV) Close() error {
+	s.pacer.close()
+	return s.file.Close()
+}
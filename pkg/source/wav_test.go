@@ -0,0 +1,100 @@
+package source
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func writeWAV(t *testing.T, format, channels, sampleRate, bits int, data []byte) string {
+	fmtChunk := make([]byte, 16)
+	binary.LittleEndian.PutUint16(fmtChunk[0:], uint16(format))
+	binary.LittleEndian.PutUint16(fmtChunk[2:], uint16(channels))
+	binary.LittleEndian.PutUint32(fmtChunk[4:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(fmtChunk[8:], uint32(sampleRate*channels*bits/8))
+	binary.LittleEndian.PutUint16(fmtChunk[12:], uint16(channels*bits/8))
+	binary.LittleEndian.PutUint16(fmtChunk[14:], uint16(bits))
+
+	chunk := func(id string, body []byte) []byte {
+		ret := append([]byte(id), 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(ret[4:], uint32(len(body)))
+		ret = append(ret, body...)
+		if len(body)%2 == 1 {
+			ret = append(ret, 0)
+		}
+		return ret
+	}
+
+	body := []byte("WAVE")
+	body = append(body, chunk("fmt ", fmtChunk)...)
+	body = append(body, chunk("LIST", []byte("odd"))...)
+	body = append(body, chunk("data", data)...)
+
+	path := filepath.Join(t.TempDir(), "test.wav")
+	if err := ioutil.WriteFile(path, chunk("RIFF", body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWAV(t *testing.T) {
+	Convey("test mu-law wav", t, func() {
+		data := make([]byte, 8000)
+		for i := range data {
+			data[i] = byte(i)
+		}
+		s, err := NewWAV(writeWAV(t, wavFormatMuLaw, 1, 8000, 8, data), 0)
+		So(err, ShouldBeNil)
+		defer s.Close()
+
+		sdp := string(s.SDP().Gen())
+		So(sdp, ShouldContainSubstring, "m=audio 0 RTP/AVP 0")
+		So(sdp, ShouldContainSubstring, "a=rtpmap:0 PCMU/8000")
+		So(sdp, ShouldContainSubstring, "a=ptime:20")
+
+		_, pkt, err := s.ReadPacket()
+		So(err, ShouldBeNil)
+		So(pkt.PayloadType, ShouldEqual, 0)
+		So(pkt.Marker, ShouldBeTrue)
+		So(len(pkt.Payload), ShouldEqual, 160)
+		So(pkt.Payload[1], ShouldEqual, 1)
+
+		_, pkt2, err := s.ReadPacket()
+		So(err, ShouldBeNil)
+		So(pkt2.Marker, ShouldBeFalse)
+		So(pkt2.Timestamp-pkt.Timestamp, ShouldEqual, 160)
+		So(pkt2.Payload[0], ShouldEqual, 160)
+	})
+
+	Convey("test L16 stereo wav loops and uses network byte order", t, func() {
+		data := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+		s, err := NewWAV(writeWAV(t, wavFormatPCM, 2, 48000, 16, data), 5)
+		So(err, ShouldBeNil)
+		defer s.Close()
+
+		sdp := string(s.SDP().Gen())
+		So(sdp, ShouldContainSubstring, "a=rtpmap:96 L16/48000/2")
+		So(sdp, ShouldContainSubstring, "a=ptime:5")
+
+		_, pkt, err := s.ReadPacket()
+		So(err, ShouldBeNil)
+		So(len(pkt.Payload), ShouldEqual, 240*4)
+		So(pkt.Payload[:10], ShouldResemble, []byte{0x02, 0x01, 0x04, 0x03, 0x06, 0x05, 0x08, 0x07, 0x02, 0x01})
+	})
+
+	Convey("ptime is limited by mtu", t, func() {
+		s, err := NewWAV(writeWAV(t, wavFormatPCM, 2, 44100, 16, make([]byte, 400)), 20)
+		So(err, ShouldBeNil)
+		defer s.Close()
+		So(s.PTime(), ShouldEqual, 7)
+		So(string(s.SDP().Gen()), ShouldContainSubstring, "a=rtpmap:10 L16/44100/2")
+	})
+
+	Convey("unsupported format", t, func() {
+		_, err := NewWAV(writeWAV(t, 3, 1, 8000, 32, make([]byte, 400)), 20)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,119 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/client"
+	"github.com/Lcmasdf/drs/pkg/codec"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// serveTest 在随机端口启动server，返回rtsp://地址
+func serveTest(srv *Server) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+	So(srv.init(), ShouldBeNil)
+	go srv.Serve(listener)
+	return "rtsp://" + listener.Addr().String()
+}
+
+func writeTestH264(t *testing.T) string {
+	sps, _ := hex.DecodeString("6764002aac2c6a81e0089f966e0202020400")
+	pps, _ := hex.DecodeString("68ee3cb0")
+	nalus := [][]byte{
+		sps, pps,
+		append([]byte{0x65, 0x88}, bytes.Repeat([]byte{0x11}, 3000)...),
+		{0x41, 0x9a, 0x01},
+	}
+	path := filepath.Join(t.TempDir(), "test.h264")
+	if err := ioutil.WriteFile(path, codec.JoinAnnexB(nalus), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func upstreamReaders(srv *Server) int {
+	stream, _, _ := srv.findStream("/live")
+	stream.mu.RLock()
+	defer stream.mu.RUnlock()
+	return len(stream.readers)
+}
+
+func TestPullMount(t *testing.T) {
+	file := writeTestH264(t)
+
+	Convey("test pull mount shares one upstream connection", t, func() {
+		upstream := &Server{Config: &Config{
+			Mounts: []*MountConfig{{Path: "/live", Source: file, FrameRate: 100}},
+		}}
+		upstreamURL := serveTest(upstream)
+
+		relay := &Server{Config: &Config{
+			Mounts: []*MountConfig{{Path: "/cam", Source: upstreamURL + "/live", PullTransport: "tcp"}},
+		}}
+		relayURL := serveTest(relay)
+
+		play := func() *client.Client {
+			c, err := client.Dial(relayURL+"/cam", client.Options{Transport: client.TransportTCP})
+			So(err, ShouldBeNil)
+			s, err := c.Describe()
+			So(err, ShouldBeNil)
+			So(string(s.Gen()), ShouldContainSubstring, "a=control:trackID=0")
+			So(c.SetupAll(), ShouldBeNil)
+			_, err = c.Play(nil)
+			So(err, ShouldBeNil)
+
+			select {
+			case p := <-c.Packets():
+				So(p.Packet.PayloadType, ShouldEqual, 96)
+			case <-time.After(5 * time.Second):
+				So("timeout", ShouldBeEmpty)
+			}
+			return c
+		}
+
+		c1 := play()
+		c2 := play()
+		So(upstreamReaders(upstream), ShouldEqual, 1)
+
+		c1.Close()
+		So(upstreamReaders(upstream), ShouldEqual, 1)
+		c2.Close()
+
+		// 最后一个客户端离开后断开远端
+		deadline := time.Now().Add(5 * time.Second)
+		for upstreamReaders(upstream) != 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(upstreamReaders(upstream), ShouldEqual, 0)
+	})
+
+	Convey("test pull mount connects at startup with pull_always", t, func() {
+		upstream := &Server{Config: &Config{
+			Mounts: []*MountConfig{{Path: "/live", Source: file, FrameRate: 100}},
+		}}
+		upstreamURL := serveTest(upstream)
+
+		relay := &Server{Config: &Config{
+			Mounts: []*MountConfig{{Path: "/cam", Source: upstreamURL + "/live", PullAlways: true}},
+		}}
+		serveTest(relay)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for upstreamReaders(upstream) != 1 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(upstreamReaders(upstream), ShouldEqual, 1)
+
+		stream, _, _ := relay.findStream("/cam")
+		stream.mu.RLock()
+		So(stream.running, ShouldBeTrue)
+		stream.mu.RUnlock()
+	})
+}
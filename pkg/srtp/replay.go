@@ -0,0 +1,45 @@
+package srtp
+
+// replayWindowSize RFC3711建议至少64
+const replayWindowSize = 64
+
+// replayWindow 记录最大index及其之前64个index是否收到过
+type replayWindow struct {
+	started bool
+	max     uint64
+	mask    uint64
+}
+
+func (w *replayWindow) check(index uint64) bool {
+	if !w.started || index > w.max {
+		return true
+	}
+	diff := w.max - index
+	if diff >= replayWindowSize {
+		return false
+	}
+	return w.mask&(1<<diff) == 0
+}
+
+// accept 认证通过后调用
+func (w *replayWindow) accept(index uint64) {
+	if !w.started {
+		w.started = true
+		w.max = index
+		w.mask = 1
+		return
+	}
+
+	if index > w.max {
+		shift := index - w.max
+		if shift >= replayWindowSize {
+			w.mask = 0
+		} else {
+			w.mask <<= shift
+		}
+		w.mask |= 1
+		w.max = index
+		return
+	}
+	w.mask |= 1 << (w.max - index)
+}
@@ -0,0 +1,130 @@
+package srtp
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/Lcmasdf/drs/pkg/sdp"
+)
+
+const (
+	SuiteSHA1_80 = "AES_CM_128_HMAC_SHA1_80"
+	SuiteSHA1_32 = "AES_CM_128_HMAC_SHA1_32"
+)
+
+// suites crypto suite -> SRTP tag长度
+var suites = map[string]int{
+	SuiteSHA1_80: 10,
+	SuiteSHA1_32: 4,
+}
+
+// Key SDES(RFC4568)交换的master key和master salt
+type Key struct {
+	Suite  string
+	Master []byte
+	Salt   []byte
+}
+
+func GenerateKey(suite string) (*Key, error) {
+	if _, ok := suites[suite]; !ok {
+		return nil, fmt.Errorf("unsupported crypto suite %s", suite)
+	}
+
+	b := make([]byte, keyLength+saltLength)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return &Key{
+		Suite:  suite,
+		Master: b[:keyLength],
+		Salt:   b[keyLength:],
+	}, nil
+}
+
+// ParseKeyParams inline:<base64(key||salt)>[|lifetime][|MKI:length]，不支持MKI
+func ParseKeyParams(suite, keyParams string) (*Key, error) {
+	if _, ok := suites[suite]; !ok {
+		return nil, fmt.Errorf("unsupported crypto suite %s", suite)
+	}
+
+	// 多个key时只使用第一个
+	keyParams = strings.Split(keyParams, ";")[0]
+	if !strings.HasPrefix(keyParams, "inline:") {
+		return nil, fmt.Errorf("invalid key params %s", keyParams)
+	}
+	parts := strings.Split(strings.TrimPrefix(keyParams, "inline:"), "|")
+	for _, p := range parts[1:] {
+		if strings.Contains(p, ":") {
+			return nil, fmt.Errorf("mki is not supported")
+		}
+	}
+
+	b, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil || len(b) != keyLength+saltLength {
+		return nil, fmt.Errorf("invalid key params %s", keyParams)
+	}
+	return &Key{
+		Suite:  suite,
+		Master: b[:keyLength],
+		Salt:   b[keyLength:],
+	}, nil
+}
+
+func (k *Key) KeyParams() string {
+	return "inline:" + base64.StdEncoding.EncodeToString(append(append([]byte{}, k.Master...), k.Salt...))
+}
+
+// MediaKey 取media中第一个支持的a=crypto，没有时返回错误
+func MediaKey(m *sdp.Media) (*Key, error) {
+	cryptos, err := m.GetCryptos()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range cryptos {
+		// 不支持UNENCRYPTED_SRTP等session参数
+		if len(c.SessionParams) > 0 {
+			continue
+		}
+		if key, err := ParseKeyParams(c.Suite, c.KeyParams); err == nil {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("no supported crypto attribute")
+}
+
+// SetMediaKey m行改为RTP/SAVP，a=crypto替换为key
+func SetMediaKey(m *sdp.Media, key *Key) error {
+	if err := m.SetProto("RTP/SAVP"); err != nil {
+		return err
+	}
+	removeCrypto(m)
+	m.AddAttribute("crypto:1 %s %s", key.Suite, key.KeyParams())
+	return nil
+}
+
+// ClearMediaKey m行改为RTP/AVP并删除a=crypto
+func ClearMediaKey(m *sdp.Media) error {
+	if err := m.SetProto("RTP/AVP"); err != nil {
+		return err
+	}
+	removeCrypto(m)
+	return nil
+}
+
+// IsSAVP m行的传输协议是否为RTP/SAVP或RTP/SAVPF
+func IsSAVP(m *sdp.Media) bool {
+	mm, err := m.GetM()
+	return err == nil && (mm.Proto == "RTP/SAVP" || mm.Proto == "RTP/SAVPF")
+}
+
+func removeCrypto(m *sdp.Media) {
+	attrs := make([][]byte, 0, len(m.Item['a']))
+	for _, a := range m.Item['a'] {
+		if !strings.HasPrefix(string(a), "crypto:") {
+			attrs = append(attrs, a)
+		}
+	}
+	m.Item['a'] = attrs
+}
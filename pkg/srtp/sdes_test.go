@@ -0,0 +1,51 @@
+package srtp
+
+import (
+	"testing"
+
+	"github.com/Lcmasdf/drs/pkg/sdp"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKeyParams(t *testing.T) {
+	Convey("test sdes key params", t, func() {
+		key, err := GenerateKey(SuiteSHA1_80)
+		So(err, ShouldBeNil)
+		So(key.Master, ShouldHaveLength, keyLength)
+		So(key.Salt, ShouldHaveLength, saltLength)
+
+		parsed, err := ParseKeyParams(SuiteSHA1_80, key.KeyParams()+"|2^20")
+		So(err, ShouldBeNil)
+		So(parsed, ShouldResemble, key)
+
+		_, err = ParseKeyParams(SuiteSHA1_80, key.KeyParams()+"|2^20|1:4")
+		So(err, ShouldNotBeNil)
+		_, err = ParseKeyParams("F8_128_HMAC_SHA1_80", key.KeyParams())
+		So(err, ShouldNotBeNil)
+		_, err = ParseKeyParams(SuiteSHA1_80, "inline:AAAA")
+		So(err, ShouldNotBeNil)
+		_, err = GenerateKey("F8_128_HMAC_SHA1_80")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("test sdes media attribute", t, func() {
+		m := sdp.NewMedia("video", 96)
+		_, err := MediaKey(m)
+		So(err, ShouldNotBeNil)
+		So(IsSAVP(m), ShouldBeFalse)
+
+		key, _ := GenerateKey(SuiteSHA1_32)
+		So(SetMediaKey(m, key), ShouldBeNil)
+		So(string(m.Gen()), ShouldContainSubstring, "m=video 0 RTP/SAVP 96")
+		So(string(m.Gen()), ShouldContainSubstring, "a=crypto:1 AES_CM_128_HMAC_SHA1_32 "+key.KeyParams())
+		So(IsSAVP(m), ShouldBeTrue)
+
+		parsed, err := MediaKey(m)
+		So(err, ShouldBeNil)
+		So(parsed, ShouldResemble, key)
+
+		So(ClearMediaKey(m), ShouldBeNil)
+		So(string(m.Gen()), ShouldNotContainSubstring, "crypto")
+		So(IsSAVP(m), ShouldBeFalse)
+	})
+}
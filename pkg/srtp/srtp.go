@@ -0,0 +1,336 @@
+package srtp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"sync"
+)
+
+// RFC3711 密钥派生的label
+const (
+	labelRTPEncryption  = 0x00
+	labelRTPAuth        = 0x01
+	labelRTPSalt        = 0x02
+	labelRTCPEncryption = 0x03
+	labelRTCPAuth       = 0x04
+	labelRTCPSalt       = 0x05
+)
+
+const (
+	keyLength     = 16
+	saltLength    = 14
+	authKeyLength = 20
+	// RFC4568 两个suite的SRTCP都使用80位的tag
+	rtcpTagLength = 10
+	// SRTCP的E标志和index
+	rtcpIndexLength = 4
+)
+
+// sessionKeys 由master key派生的会话密钥
+type sessionKeys struct {
+	block cipher.Block
+	salt  []byte
+	mac   hash.Hash
+}
+
+type rtpState struct {
+	started bool
+	roc     uint32
+	// s_l，收到或发出的最大序号
+	lastSeq uint16
+	replay  replayWindow
+}
+
+type rtcpState struct {
+	// 发送端下一个SRTCP index
+	index  uint32
+	replay replayWindow
+}
+
+// Context 一个方向的SRTP/SRTCP加解密，按ssrc维护rollover counter和重放窗口
+type Context struct {
+	tagLength int
+
+	mu    sync.Mutex
+	rtp   *sessionKeys
+	rtcp  *sessionKeys
+	rtps  map[uint32]*rtpState
+	rtcps map[uint32]*rtcpState
+}
+
+func NewContext(k *Key) (*Context, error) {
+	tagLength, ok := suites[k.Suite]
+	if !ok {
+		return nil, fmt.Errorf("unsupported crypto suite %s", k.Suite)
+	}
+	if len(k.Master) != keyLength || len(k.Salt) != saltLength {
+		return nil, fmt.Errorf("invalid master key length %d/%d", len(k.Master), len(k.Salt))
+	}
+
+	rtp, err := newSessionKeys(k.Master, k.Salt, labelRTPEncryption, labelRTPAuth, labelRTPSalt)
+	if err != nil {
+		return nil, err
+	}
+	rtcp, err := newSessionKeys(k.Master, k.Salt, labelRTCPEncryption, labelRTCPAuth, labelRTCPSalt)
+	if err != nil {
+		return nil, err
+	}
+	return &Context{
+		tagLength: tagLength,
+		rtp:       rtp,
+		rtcp:      rtcp,
+		rtps:      make(map[uint32]*rtpState),
+		rtcps:     make(map[uint32]*rtcpState),
+	}, nil
+}
+
+func newSessionKeys(master, salt []byte, encLabel, authLabel, saltLabel byte) (*sessionKeys, error) {
+	enc, err := deriveKey(master, salt, encLabel, keyLength)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := deriveKey(master, salt, authLabel, authKeyLength)
+	if err != nil {
+		return nil, err
+	}
+	sessionSalt, err := deriveKey(master, salt, saltLabel, saltLength)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(enc)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionKeys{
+		block: block,
+		salt:  sessionSalt,
+		mac:   hmac.New(sha1.New, auth),
+	}, nil
+}
+
+// deriveKey RFC3711 4.3，key_derivation_rate为0
+func deriveKey(master, salt []byte, label byte, n int) ([]byte, error) {
+	block, err := aes.NewCipher(master)
+	if err != nil {
+		return nil, err
+	}
+
+	// x = (label || r) XOR master_salt，r为0，再左移16位作为IV
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, salt)
+	iv[7] ^= label
+
+	ret := make([]byte, n)
+	cipher.NewCTR(block, iv).XORKeyStream(ret, ret)
+	return ret, nil
+}
+
+// xor AES-CM，IV = (salt * 2^16) XOR (SSRC * 2^64) XOR (index * 2^16)
+func (k *sessionKeys) xor(ssrc uint32, index uint64, data []byte) {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, k.salt)
+	for i := 0; i < 4; i++ {
+		iv[4+i] ^= byte(ssrc >> uint(24-8*i))
+	}
+	for i := 0; i < 6; i++ {
+		iv[8+i] ^= byte(index >> uint(40-8*i))
+	}
+	cipher.NewCTR(k.block, iv).XORKeyStream(data, data)
+}
+
+// tag HMAC-SHA1，需持有Context的锁
+func (k *sessionKeys) tag(data []byte, roc []byte) []byte {
+	k.mac.Reset()
+	k.mac.Write(data)
+	k.mac.Write(roc)
+	return k.mac.Sum(nil)
+}
+
+// EncryptRTP 返回新的切片，b不会被修改
+func (c *Context) EncryptRTP(b []byte) ([]byte, error) {
+	n, err := rtpHeaderLength(b)
+	if err != nil {
+		return nil, err
+	}
+	seq := binary.BigEndian.Uint16(b[2:])
+	ssrc := binary.BigEndian.Uint32(b[8:])
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.rtpState(ssrc)
+	roc := s.guessROC(seq)
+	s.update(roc, seq)
+
+	ret := make([]byte, len(b), len(b)+c.tagLength)
+	copy(ret, b)
+	c.rtp.xor(ssrc, uint64(roc)<<16|uint64(seq), ret[n:])
+	tag := c.rtp.tag(ret, uint32Bytes(roc))
+	return append(ret, tag[:c.tagLength]...), nil
+}
+
+// DecryptRTP 校验tag和重放窗口，返回新的切片
+func (c *Context) DecryptRTP(b []byte) ([]byte, error) {
+	n, err := rtpHeaderLength(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < n+c.tagLength {
+		return nil, fmt.Errorf("srtp packet too short: %d", len(b))
+	}
+	seq := binary.BigEndian.Uint16(b[2:])
+	ssrc := binary.BigEndian.Uint32(b[8:])
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.rtpState(ssrc)
+	roc := s.guessROC(seq)
+	index := uint64(roc)<<16 | uint64(seq)
+	if !s.replay.check(index) {
+		return nil, fmt.Errorf("srtp packet %d replayed", index)
+	}
+
+	end := len(b) - c.tagLength
+	tag := c.rtp.tag(b[:end], uint32Bytes(roc))
+	if !hmac.Equal(tag[:c.tagLength], b[end:]) {
+		return nil, fmt.Errorf("srtp authentication failed")
+	}
+
+	ret := make([]byte, end)
+	copy(ret, b[:end])
+	c.rtp.xor(ssrc, index, ret[n:])
+
+	s.update(roc, seq)
+	s.replay.accept(index)
+	return ret, nil
+}
+
+// EncryptRTCP 加密第一个包头之后的部分，追加E标志、SRTCP index和tag
+func (c *Context) EncryptRTCP(b []byte) ([]byte, error) {
+	if len(b) < 8 {
+		return nil, fmt.Errorf("rtcp packet too short: %d", len(b))
+	}
+	ssrc := binary.BigEndian.Uint32(b[4:])
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.rtcpState(ssrc)
+	index := s.index
+	s.index = (s.index + 1) & 0x7fffffff
+
+	ret := make([]byte, len(b), len(b)+rtcpIndexLength+rtcpTagLength)
+	copy(ret, b)
+	c.rtcp.xor(ssrc, uint64(index), ret[8:])
+	ret = append(ret, uint32Bytes(1<<31|index)...)
+	tag := c.rtcp.tag(ret, nil)
+	return append(ret, tag[:rtcpTagLength]...), nil
+}
+
+func (c *Context) DecryptRTCP(b []byte) ([]byte, error) {
+	if len(b) < 8+rtcpIndexLength+rtcpTagLength {
+		return nil, fmt.Errorf("srtcp packet too short: %d", len(b))
+	}
+	ssrc := binary.BigEndian.Uint32(b[4:])
+	end := len(b) - rtcpTagLength
+	eIndex := binary.BigEndian.Uint32(b[end-rtcpIndexLength:])
+	index := eIndex & 0x7fffffff
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.rtcpState(ssrc)
+	if !s.replay.check(uint64(index)) {
+		return nil, fmt.Errorf("srtcp packet %d replayed", index)
+	}
+	tag := c.rtcp.tag(b[:end], nil)
+	if !hmac.Equal(tag[:rtcpTagLength], b[end:]) {
+		return nil, fmt.Errorf("srtcp authentication failed")
+	}
+
+	ret := make([]byte, end-rtcpIndexLength)
+	copy(ret, b)
+	if eIndex>>31 == 1 {
+		c.rtcp.xor(ssrc, uint64(index), ret[8:])
+	}
+	s.replay.accept(uint64(index))
+	return ret, nil
+}
+
+func (c *Context) rtpState(ssrc uint32) *rtpState {
+	s, ok := c.rtps[ssrc]
+	if !ok {
+		s = &rtpState{}
+		c.rtps[ssrc] = s
+	}
+	return s
+}
+
+func (c *Context) rtcpState(ssrc uint32) *rtcpState {
+	s, ok := c.rtcps[ssrc]
+	if !ok {
+		s = &rtcpState{}
+		c.rtcps[ssrc] = s
+	}
+	return s
+}
+
+// guessROC RFC3711 Appendix A，根据s_l估计包的rollover counter
+func (s *rtpState) guessROC(seq uint16) uint32 {
+	if !s.started {
+		return s.roc
+	}
+	if s.lastSeq < 0x8000 {
+		if int(seq)-int(s.lastSeq) > 0x8000 && s.roc > 0 {
+			return s.roc - 1
+		}
+		return s.roc
+	}
+	if int(s.lastSeq)-0x8000 > int(seq) {
+		return s.roc + 1
+	}
+	return s.roc
+}
+
+func (s *rtpState) update(roc uint32, seq uint16) {
+	if !s.started || roc == s.roc+1 || (roc == s.roc && seq > s.lastSeq) {
+		s.started = true
+		s.roc = roc
+		s.lastSeq = seq
+	}
+}
+
+// rtpHeaderLength 包括CSRC和扩展头，之后为加密的部分
+func rtpHeaderLength(b []byte) (int, error) {
+	if len(b) < 12 {
+		return 0, fmt.Errorf("rtp packet too short: %d", len(b))
+	}
+	if b[0]>>6 != 2 {
+		return 0, fmt.Errorf("invalid rtp version: %d", b[0]>>6)
+	}
+
+	n := 12 + 4*int(b[0]&0x0f)
+	if b[0]&0x10 != 0 {
+		if len(b) < n+4 {
+			return 0, fmt.Errorf("rtp packet too short: %d", len(b))
+		}
+		n += 4 + 4*int(binary.BigEndian.Uint16(b[n+2:]))
+	}
+	if len(b) < n {
+		return 0, fmt.Errorf("rtp packet too short: %d", len(b))
+	}
+	return n, nil
+}
+
+func uint32Bytes(v uint32) []byte {
+	ret := make([]byte, 4)
+	binary.BigEndian.PutUint32(ret, v)
+	return ret
+}
@@ -0,0 +1,160 @@
+package srtp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func testKey(suite string) *Key {
+	return &Key{
+		Suite:  suite,
+		Master: mustHex("E1F97A0D3E018BE0D64FA32C06DE4139"),
+		Salt:   mustHex("0EC675AD498AFEEBB6960B3AABE6"),
+	}
+}
+
+func testRTP(seq uint16) []byte {
+	pkt := &rtp.Packet{
+		PayloadType:    96,
+		SequenceNumber: seq,
+		Timestamp:      3000,
+		SSRC:           0xcafebabe,
+		Payload:        bytes.Repeat([]byte{0xab}, 100),
+	}
+	return pkt.Marshal()
+}
+
+func TestKeyDerivation(t *testing.T) {
+	Convey("test key derivation (RFC3711 B.3)", t, func() {
+		k := testKey(SuiteSHA1_80)
+		enc, err := deriveKey(k.Master, k.Salt, labelRTPEncryption, keyLength)
+		So(err, ShouldBeNil)
+		So(enc, ShouldResemble, mustHex("C61E7A93744F39EE10734AFE3FF7A087"))
+		salt, err := deriveKey(k.Master, k.Salt, labelRTPSalt, saltLength)
+		So(err, ShouldBeNil)
+		So(salt, ShouldResemble, mustHex("30CBBC08863D8C85D49DB34A9AE1"))
+		auth, err := deriveKey(k.Master, k.Salt, labelRTPAuth, authKeyLength)
+		So(err, ShouldBeNil)
+		So(auth, ShouldResemble, mustHex("CEBE321F6FF7716B6FD4AB49AF256A156D38BAA4"))
+	})
+
+	Convey("test AES-CM keystream (RFC3711 B.2)", t, func() {
+		block, err := aes.NewCipher(mustHex("2B7E151628AED2A6ABF7158809CF4F3C"))
+		So(err, ShouldBeNil)
+		k := &sessionKeys{block: block, salt: mustHex("F0F1F2F3F4F5F6F7F8F9FAFBFCFD")}
+		data := make([]byte, 48)
+		k.xor(0, 0, data)
+		So(data, ShouldResemble, mustHex("E03EAD0935C95E80E166B16DD92B4EB4D23513162B02D0F72A43A2FE4A5F97AB41E95B3BB0A2E8DD477901E4FCA894C0"))
+	})
+}
+
+func TestRTP(t *testing.T) {
+	for _, suite := range []string{SuiteSHA1_80, SuiteSHA1_32} {
+		Convey("test srtp "+suite, t, func() {
+			tx, err := NewContext(testKey(suite))
+			So(err, ShouldBeNil)
+			rx, err := NewContext(testKey(suite))
+			So(err, ShouldBeNil)
+
+			plain := testRTP(1)
+			enc, err := tx.EncryptRTP(plain)
+			So(err, ShouldBeNil)
+			So(len(enc), ShouldEqual, len(plain)+suites[suite])
+			So(enc[:12], ShouldResemble, plain[:12])
+			So(enc[12:len(plain)], ShouldNotResemble, plain[12:])
+
+			dec, err := rx.DecryptRTP(enc)
+			So(err, ShouldBeNil)
+			So(dec, ShouldResemble, plain)
+
+			Convey("replayed packet is rejected", func() {
+				_, err := rx.DecryptRTP(enc)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("modified packet is rejected", func() {
+				enc, err := tx.EncryptRTP(testRTP(2))
+				So(err, ShouldBeNil)
+				enc[20] ^= 1
+				_, err = rx.DecryptRTP(enc)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("packet older than the replay window is rejected", func() {
+				old, err := tx.EncryptRTP(testRTP(2))
+				So(err, ShouldBeNil)
+				newer, err := tx.EncryptRTP(testRTP(100))
+				So(err, ShouldBeNil)
+				_, err = rx.DecryptRTP(newer)
+				So(err, ShouldBeNil)
+				_, err = rx.DecryptRTP(old)
+				So(err, ShouldNotBeNil)
+			})
+		})
+	}
+
+	Convey("test srtp rollover counter", t, func() {
+		tx, _ := NewContext(testKey(SuiteSHA1_80))
+		rx, _ := NewContext(testKey(SuiteSHA1_80))
+
+		// 回绕前后的包乱序到达
+		var packets [][]byte
+		for _, seq := range []uint16{65533, 65534, 65535, 0, 1, 2} {
+			enc, err := tx.EncryptRTP(testRTP(seq))
+			So(err, ShouldBeNil)
+			packets = append(packets, enc)
+		}
+		for _, i := range []int{0, 1, 3, 2, 4, 5} {
+			dec, err := rx.DecryptRTP(packets[i])
+			So(err, ShouldBeNil)
+			So(dec, ShouldResemble, testRTP([]uint16{65533, 65534, 65535, 0, 1, 2}[i]))
+		}
+		So(tx.rtps[0xcafebabe].roc, ShouldEqual, 1)
+		So(rx.rtps[0xcafebabe].roc, ShouldEqual, 1)
+
+		// 回绕之后才加入的接收端roc为0，tag校验失败
+		other, _ := NewContext(testKey(SuiteSHA1_80))
+		_, err := other.DecryptRTP(packets[5])
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestRTCP(t *testing.T) {
+	Convey("test srtcp", t, func() {
+		tx, _ := NewContext(testKey(SuiteSHA1_32))
+		rx, _ := NewContext(testKey(SuiteSHA1_32))
+
+		// SR
+		plain := append(mustHex("80c80006cafebabe"), bytes.Repeat([]byte{0x01}, 20)...)
+		enc, err := tx.EncryptRTCP(plain)
+		So(err, ShouldBeNil)
+		So(len(enc), ShouldEqual, len(plain)+rtcpIndexLength+rtcpTagLength)
+		So(enc[:8], ShouldResemble, plain[:8])
+
+		dec, err := rx.DecryptRTCP(enc)
+		So(err, ShouldBeNil)
+		So(dec, ShouldResemble, plain)
+
+		_, err = rx.DecryptRTCP(enc)
+		So(err, ShouldNotBeNil)
+
+		enc, err = tx.EncryptRTCP(plain)
+		So(err, ShouldBeNil)
+		enc[10] ^= 1
+		_, err = rx.DecryptRTCP(enc)
+		So(err, ShouldNotBeNil)
+	})
+}
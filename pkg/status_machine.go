@@ -1,5 +1,7 @@
 package pkg
 
+import "github.com/Lcmasdf/drs/pkg/rtsp"
+
 type state int
 
 //SM状态
@@ -7,8 +9,16 @@ const (
 	INIT state = iota
 	READY
 	PLAYING
+	RECORDING
 )
 
+var State2String = map[state]string{
+	INIT:      "INIT",
+	READY:     "READY",
+	PLAYING:   "PLAYING",
+	RECORDING: "RECORDING",
+}
+
 type method int
 
 const (
@@ -18,8 +28,12 @@ const (
 	TEARDOWN
 	PLAY
 	PAUSE
+	ANNOUNCE
+	RECORD
 )
 
+var methods []string = []string{"OPTIONS", "DESCRIBE", "SETUP", "TEARDOWN", "PLAY", "PAUSE", "ANNOUNCE", "RECORD"}
+
 var Method2String = map[method]string{
 	OPTIONS:  "OPTIONS",
 	DESCRIBE: "DESCRIBE",
@@ -27,18 +41,22 @@ var Method2String = map[method]string{
 	TEARDOWN: "TEARDOWN",
 	PLAY:     "PLAY",
 	PAUSE:    "PAUSE",
+	ANNOUNCE: "ANNOUNCE",
+	RECORD:   "RECORD",
 }
 
 var Method2method = map[string]method{
-	"OPTION":   OPTIONS,
+	"OPTIONS":  OPTIONS,
 	"DESCRIBE": DESCRIBE,
 	"SETUP":    SETUP,
 	"TEARDOWN": TEARDOWN,
 	"PLAY":     PLAY,
 	"PAUSE":    PAUSE,
+	"ANNOUNCE": ANNOUNCE,
+	"RECORD":   RECORD,
 }
 
-type TransitionFunc func(r *Request) *Response
+type TransitionFunc func(r *rtsp.Request) *rtsp.Response
 type MethodStateTupple struct {
 	Method method
 	State  state
@@ -52,26 +70,41 @@ type ServerStatusMachine struct {
 	TeardownInitHandler    TransitionFunc
 	SetupReadyHandler      TransitionFunc
 	PlayReadyHandler       TransitionFunc
+	TeardownReadyHandler   TransitionFunc
 	SetupPlayingHandler    TransitionFunc
+	PlayPlayingHandler     TransitionFunc
 	PausePlayingHandler    TransitionFunc
 	TeardownPlayingHandler TransitionFunc
 	OptionsHandler         TransitionFunc
 	DescribeHandler        TransitionFunc
+
+	// 推流
+	AnnounceHandler          TransitionFunc
+	RecordReadyHandler       TransitionFunc
+	SetupRecordingHandler    TransitionFunc
+	PauseRecordingHandler    TransitionFunc
+	TeardownRecordingHandler TransitionFunc
 }
 
 func (m *ServerStatusMachine) Init() {
 	m.transitionTable = map[MethodStateTupple]TransitionFunc{
-		{SETUP, INIT}:       m.SetupInit,
-		{TEARDOWN, INIT}:    m.TeardownInit,
-		{SETUP, READY}:      m.SetupReady,
-		{PLAY, READY}:       m.PlayReady,
-		{SETUP, PLAYING}:    m.SetupPlaying,
-		{PAUSE, PLAYING}:    m.PausePlaying,
-		{TEARDOWN, PLAYING}: m.TeardownPlaying,
+		{SETUP, INIT}:         m.SetupInit,
+		{TEARDOWN, INIT}:      m.TeardownInit,
+		{SETUP, READY}:        m.SetupReady,
+		{PLAY, READY}:         m.PlayReady,
+		{TEARDOWN, READY}:     m.TeardownReady,
+		{SETUP, PLAYING}:      m.SetupPlaying,
+		{PLAY, PLAYING}:       m.PlayPlaying,
+		{PAUSE, PLAYING}:      m.PausePlaying,
+		{TEARDOWN, PLAYING}:   m.TeardownPlaying,
+		{RECORD, READY}:       m.RecordReady,
+		{SETUP, RECORDING}:    m.SetupRecording,
+		{PAUSE, RECORDING}:    m.PauseRecording,
+		{TEARDOWN, RECORDING}: m.TeardownRecording,
 	}
 }
 
-func (m *ServerStatusMachine) Request(r *Request) *Response {
+func (m *ServerStatusMachine) Request(r *rtsp.Request) *rtsp.Response {
 	f, ok := m.transitionTable[MethodStateTupple{Method2method[r.M], m.st}]
 
 	if ok {
@@ -84,13 +117,15 @@ func (m *ServerStatusMachine) Request(r *Request) *Response {
 			return m.OptionsHandler(r)
 		case Method2String[DESCRIBE]:
 			return m.DescribeHandler(r)
+		case Method2String[ANNOUNCE]:
+			return m.AnnounceHandler(r)
 		default:
 			return nil
 		}
 	}
 }
 
-func (m *ServerStatusMachine) SetupInit(r *Request) *Response {
+func (m *ServerStatusMachine) SetupInit(r *rtsp.Request) *rtsp.Response {
 	resp := m.SetupInitHandler(r)
 	if statusCodeMatch3xx(resp.StatusCode) {
 		m.st = INIT
@@ -103,7 +138,7 @@ func (m *ServerStatusMachine) SetupInit(r *Request) *Response {
 	return resp
 }
 
-func (m *ServerStatusMachine) TeardownInit(r *Request) *Response {
+func (m *ServerStatusMachine) TeardownInit(r *rtsp.Request) *rtsp.Response {
 	resp := m.TeardownInitHandler(r)
 	if statusCodeMatch2xx(resp.StatusCode) {
 		m.st = INIT
@@ -114,7 +149,7 @@ func (m *ServerStatusMachine) TeardownInit(r *Request) *Response {
 	return resp
 }
 
-func (m *ServerStatusMachine) SetupReady(r *Request) *Response {
+func (m *ServerStatusMachine) SetupReady(r *rtsp.Request) *rtsp.Response {
 	resp := m.SetupReadyHandler(r)
 	if statusCodeMatch3xx(resp.StatusCode) {
 		m.st = INIT
@@ -126,7 +161,7 @@ func (m *ServerStatusMachine) SetupReady(r *Request) *Response {
 	return resp
 }
 
-func (m *ServerStatusMachine) PlayReady(r *Request) *Response {
+func (m *ServerStatusMachine) PlayReady(r *rtsp.Request) *rtsp.Response {
 	resp := m.PlayReadyHandler(r)
 	if statusCodeMatch3xx(resp.StatusCode) {
 		m.st = INIT
@@ -138,7 +173,17 @@ func (m *ServerStatusMachine) PlayReady(r *Request) *Response {
 	return resp
 }
 
-func (m *ServerStatusMachine) SetupPlaying(r *Request) *Response {
+func (m *ServerStatusMachine) TeardownReady(r *rtsp.Request) *rtsp.Response {
+	resp := m.TeardownReadyHandler(r)
+	if statusCodeMatch2xx(resp.StatusCode) {
+		m.st = INIT
+	} else {
+		// m.st no change
+	}
+	return resp
+}
+
+func (m *ServerStatusMachine) SetupPlaying(r *rtsp.Request) *rtsp.Response {
 	resp := m.SetupPlayingHandler(r)
 	if statusCodeMatch3xx(resp.StatusCode) {
 		m.st = INIT
@@ -150,7 +195,19 @@ func (m *ServerStatusMachine) SetupPlaying(r *Request) *Response {
 	return resp
 }
 
-func (m *ServerStatusMachine) PausePlaying(r *Request) *Response {
+func (m *ServerStatusMachine) PlayPlaying(r *rtsp.Request) *rtsp.Response {
+	resp := m.PlayPlayingHandler(r)
+	if statusCodeMatch3xx(resp.StatusCode) {
+		m.st = INIT
+	} else if statusCodeMatch4xx(resp.StatusCode) {
+		// m.st no change
+	} else if statusCodeMatch2xx(resp.StatusCode) {
+		m.st = PLAYING
+	}
+	return resp
+}
+
+func (m *ServerStatusMachine) PausePlaying(r *rtsp.Request) *rtsp.Response {
 	resp := m.PausePlayingHandler(r)
 	if statusCodeMatch3xx(resp.StatusCode) {
 		m.st = INIT
@@ -162,7 +219,7 @@ func (m *ServerStatusMachine) PausePlaying(r *Request) *Response {
 	return resp
 }
 
-func (m *ServerStatusMachine) TeardownPlaying(r *Request) *Response {
+func (m *ServerStatusMachine) TeardownPlaying(r *rtsp.Request) *rtsp.Response {
 	resp := m.TeardownPlayingHandler(r)
 	if statusCodeMatch2xx(resp.StatusCode) {
 		m.st = INIT
@@ -172,6 +229,52 @@ func (m *ServerStatusMachine) TeardownPlaying(r *Request) *Response {
 	return resp
 }
 
+func (m *ServerStatusMachine) RecordReady(r *rtsp.Request) *rtsp.Response {
+	resp := m.RecordReadyHandler(r)
+	if statusCodeMatch3xx(resp.StatusCode) {
+		m.st = INIT
+	} else if statusCodeMatch4xx(resp.StatusCode) {
+		// m.st no change
+	} else if statusCodeMatch2xx(resp.StatusCode) {
+		m.st = RECORDING
+	}
+	return resp
+}
+
+func (m *ServerStatusMachine) SetupRecording(r *rtsp.Request) *rtsp.Response {
+	resp := m.SetupRecordingHandler(r)
+	if statusCodeMatch3xx(resp.StatusCode) {
+		m.st = INIT
+	} else if statusCodeMatch4xx(resp.StatusCode) {
+		// m.st no change
+	} else if statusCodeMatch2xx(resp.StatusCode) {
+		m.st = RECORDING
+	}
+	return resp
+}
+
+func (m *ServerStatusMachine) PauseRecording(r *rtsp.Request) *rtsp.Response {
+	resp := m.PauseRecordingHandler(r)
+	if statusCodeMatch3xx(resp.StatusCode) {
+		m.st = INIT
+	} else if statusCodeMatch4xx(resp.StatusCode) {
+		// m.st no change
+	} else if statusCodeMatch2xx(resp.StatusCode) {
+		m.st = READY
+	}
+	return resp
+}
+
+func (m *ServerStatusMachine) TeardownRecording(r *rtsp.Request) *rtsp.Response {
+	resp := m.TeardownRecordingHandler(r)
+	if statusCodeMatch2xx(resp.StatusCode) {
+		m.st = INIT
+	} else {
+		// m.st no change
+	}
+	return resp
+}
+
 func statusCodeMatch2xx(statusCode string) bool {
 	return statusCode[0] == '2'
 }
@@ -0,0 +1,522 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/rtp"
+	"github.com/Lcmasdf/drs/pkg/sdp"
+	"github.com/Lcmasdf/drs/pkg/source"
+)
+
+// streamReader 从stream接收RTP包，writePacket不能阻塞
+type streamReader interface {
+	writePacket(track int, pkt *rtp.Packet)
+}
+
+// 同一track向source请求关键帧的最小间隔
+const keyFrameRequestInterval = 500 * time.Millisecond
+
+// RTSP 2.0 PLAY_NOTIFY的Notify-Reason
+const (
+	notifyEndOfStream           = "end-of-stream"
+	notifyMediaPropertiesUpdate = "media-properties-update"
+)
+
+// streamNotifier 可选，stream的状态变化时通知reader，不能阻塞
+type streamNotifier interface {
+	notify(reason, mediaProperties string)
+}
+
+// Stream 一个挂载路径对应一个stream，source的数据分发给所有reader
+// 点播source(Seeker)每个session通过Fork使用单独的stream
+type Stream struct {
+	Path string
+
+	mount *MountConfig
+	log   *slog.Logger
+	// 由Fork创建，只属于一个session
+	private bool
+	// 没有reader时也不关闭source
+	always bool
+
+	mu       sync.RWMutex
+	source   Source
+	sdp      *sdp.SDPImpl
+	vod      bool
+	scalable bool
+	duration time.Duration
+	running  bool
+	readers  map[streamReader]struct{}
+	// 每个track最后一次向source请求关键帧的时间
+	keyFrameRequests map[int]time.Time
+	// jitter buffer认为丢失的包数量
+	lost map[int]uint64
+	// source打开或推流开始时创建
+	clock *presentationClock
+	// 收到的数据量，Fork的stream共用
+	meter *bitrateMeter
+	// 由server设置，Fork的stream不通知
+	hooks *hooks
+}
+
+func newStream(mc *MountConfig, log *slog.Logger) *Stream {
+	return &Stream{
+		Path:             mc.Path,
+		mount:            mc,
+		log:              log.With("path", mc.Path),
+		always:           mc.PullAlways || mc.Source == "",
+		readers:          make(map[streamReader]struct{}),
+		keyFrameRequests: make(map[int]time.Time),
+		lost:             make(map[int]uint64),
+		meter:            &bitrateMeter{},
+	}
+}
+
+// open 需持有锁
+func (st *Stream) open() error {
+	if st.source != nil {
+		return nil
+	}
+	if st.mount.Source == "" {
+		return fmt.Errorf("stream %s has no publisher", st.Path)
+	}
+
+	src, err := newSource(st.mount, st.log)
+	if err != nil {
+		st.hooks.fire(&Event{Type: EventSourceFailed, Path: st.Path, Error: err.Error()})
+		return err
+	}
+	st.source = src
+	st.sdp = src.SDP()
+
+	var seeker Seeker
+	seeker, st.vod = src.(Seeker)
+	if st.vod {
+		st.duration = seeker.Duration()
+	}
+	_, st.scalable = src.(Scaler)
+
+	var start time.Time
+	if dater, ok := src.(Dater); ok {
+		start = dater.StartTime()
+	}
+	st.clock = newPresentationClock(st.sdp, start)
+	return nil
+}
+
+// start 需持有锁，source开始读取；直播、拉流和推流的source从停止变为运行时通知source_ready
+func (st *Stream) start(src Source) {
+	st.running = true
+	go st.run(src)
+	if !st.vod {
+		st.hooks.fire(&Event{Type: EventSourceReady, Path: st.Path})
+	}
+}
+
+// Describe 返回stream的sdp，source未打开时先打开
+func (st *Stream) Describe() (*sdp.SDPImpl, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if err := st.open(); err != nil {
+		return nil, err
+	}
+
+	// 点播的source由各session单独打开，这里只需要sdp
+	if st.vod && !st.private && len(st.readers) == 0 {
+		st.source.Close()
+		st.source = nil
+	}
+	return st.sdp, nil
+}
+
+// VOD 是否为点播，需先调用Describe
+func (st *Stream) VOD() bool {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	return st.vod
+}
+
+func (st *Stream) Duration() time.Duration {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	return st.duration
+}
+
+// Fork 创建只属于一个session的stream
+func (st *Stream) Fork() *Stream {
+	ret := newStream(st.mount, st.log)
+	ret.log = st.log
+	ret.private = true
+	ret.always = false
+	ret.meter = st.meter
+	return ret
+}
+
+// TrackByControl 根据sdp中a=control的值查找track
+func (st *Stream) TrackByControl(control string) (int, error) {
+	s, err := st.Describe()
+	if err != nil {
+		return 0, err
+	}
+	return trackByControl(s, control)
+}
+
+func trackByControl(s *sdp.SDPImpl, control string) (int, error) {
+	for i, m := range s.Ms {
+		controls, err := m.GetControl()
+		if err != nil {
+			return 0, err
+		}
+		for _, c := range controls {
+			// 推流的sdp中control可能是绝对url
+			if c.Value == control || strings.HasSuffix(control, "/"+c.Value) || (control != "" && strings.HasSuffix(c.Value, "/"+control)) {
+				return i, nil
+			}
+		}
+	}
+
+	// 只有一个track时允许不带control
+	if control == "" && len(s.Ms) == 1 {
+		return 0, nil
+	}
+	return 0, fmt.Errorf("track %s not found", control)
+}
+
+// Preload 打开source并开始读取，用于没有reader时也保持连接的stream
+func (st *Stream) Preload() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if err := st.open(); err != nil {
+		return err
+	}
+	if !st.running {
+		st.start(st.source)
+	}
+	return nil
+}
+
+func (st *Stream) AddReader(r streamReader) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if err := st.open(); err != nil {
+		return err
+	}
+
+	st.readers[r] = struct{}{}
+	if !st.running {
+		st.start(st.source)
+	}
+	return nil
+}
+
+// RemoveReader 最后一个reader离开时关闭source
+func (st *Stream) RemoveReader(r streamReader) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, ok := st.readers[r]; !ok {
+		return
+	}
+	delete(st.readers, r)
+
+	if len(st.readers) == 0 && st.source != nil && !st.private && !st.always {
+		st.source.Close()
+		st.source = nil
+		st.running = false
+	}
+}
+
+// Publishable 挂载时没有指定source，由客户端ANNOUNCE/RECORD推流
+func (st *Stream) Publishable() bool {
+	return st.mount.Source == ""
+}
+
+// Publish 推流客户端RECORD时调用，已经attach的reader直接开始收到数据
+func (st *Stream) Publish(src Source) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if !st.Publishable() {
+		return fmt.Errorf("stream %s is not publishable", st.Path)
+	}
+	if st.source == src {
+		return nil
+	}
+	if st.source != nil {
+		return fmt.Errorf("stream %s is already published", st.Path)
+	}
+
+	st.source = src
+	st.sdp = src.SDP()
+	st.clock = newPresentationClock(st.sdp, time.Time{})
+	st.start(src)
+	st.notify(notifyMediaPropertiesUpdate)
+	return nil
+}
+
+// Unpublish 推流结束，reader保持attach等待下一次推流
+func (st *Stream) Unpublish(src Source) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	src.Close()
+	if st.source == src {
+		st.source = nil
+		st.running = false
+		st.notify(notifyEndOfStream)
+	}
+}
+
+// Seek 点播跳转，返回实际位置
+func (st *Stream) Seek(npt time.Duration) (time.Duration, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if err := st.open(); err != nil {
+		return 0, err
+	}
+	seeker, ok := st.source.(Seeker)
+	if !ok {
+		return 0, fmt.Errorf("stream %s is not seekable", st.Path)
+	}
+	st.clock.reset()
+	return seeker.Seek(npt)
+}
+
+// RequestKeyFrame 转发viewer的PLI/FIR，多个viewer同时请求时合并
+func (st *Stream) RequestKeyFrame(track int) {
+	st.mu.Lock()
+	requester, ok := st.source.(KeyFrameRequester)
+	if !ok || time.Since(st.keyFrameRequests[track]) < keyFrameRequestInterval {
+		st.mu.Unlock()
+		return
+	}
+	st.keyFrameRequests[track] = time.Now()
+	st.mu.Unlock()
+
+	requester.RequestKeyFrame(track)
+}
+
+// SetScale 点播的Scale/Speed，返回实际使用的值，不支持时只能使用1
+func (st *Stream) SetScale(scale, speed float64) (float64, float64, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if err := st.open(); err != nil {
+		return 0, 0, err
+	}
+	scaler, ok := st.source.(Scaler)
+	if !ok {
+		return 1, 1, nil
+	}
+	st.clock.reset()
+	return scaler.SetScale(scale, speed)
+}
+
+func (st *Stream) Pause() {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	if p, ok := st.source.(Pauser); ok {
+		p.Pause()
+		st.clock.reset()
+	}
+}
+
+func (st *Stream) Resume() {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	if p, ok := st.source.(Pauser); ok {
+		p.Resume()
+	}
+}
+
+// RTPInfo 下一个发送的包的序号及当前播放位置对应的RTP时间戳，用于PLAY的RTP-Info
+// 点播由source提供，直播使用stream的时钟，还没有收到数据时返回false
+func (st *Stream) RTPInfo(track int) (uint16, uint32, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	if r, ok := st.source.(RTPInfoer); ok {
+		return r.RTPInfo(track)
+	}
+	if st.clock == nil {
+		return 0, 0, false
+	}
+	return st.clock.rtpInfo(track, time.Now())
+}
+
+// RTPTime 墙上时钟对应的RTP时间戳，用于RTCP SR
+func (st *Stream) RTPTime(track int, at time.Time) (uint32, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	if st.clock == nil {
+		return 0, false
+	}
+	return st.clock.rtpTime(track, at)
+}
+
+// StartTime npt 0对应的绝对时间，用于Range: clock=，source未打开时先打开，未知时为零值
+func (st *Stream) StartTime() time.Time {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if err := st.open(); err != nil {
+		return time.Time{}
+	}
+	return st.clock.startTime()
+}
+
+// Close 关闭source，用于Fork出的stream
+func (st *Stream) Close() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.source != nil {
+		st.source.Close()
+		st.source = nil
+		st.running = false
+	}
+}
+
+// notify 需持有锁
+func (st *Stream) notify(reason string) {
+	properties := st.mediaProperties()
+	for r := range st.readers {
+		if n, ok := r.(streamNotifier); ok {
+			n.notify(reason, properties)
+		}
+	}
+}
+
+// MediaProperties RTSP 2.0的Media-Properties
+func (st *Stream) MediaProperties() string {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	return st.mediaProperties()
+}
+
+// mediaProperties 需持有锁
+func (st *Stream) mediaProperties() string {
+	if st.vod && st.scalable {
+		return fmt.Sprintf("Random-Access, Immutable, Unlimited, Scales=\"%g:%g\"", -source.MaxScale, source.MaxScale)
+	}
+	if st.vod {
+		return "Random-Access, Immutable, Unlimited"
+	}
+	return "No-Seeking, Time-Progressing, Time-Duration=0.0"
+}
+
+// PacketsLost 使用jitter buffer时track丢失的包数量
+func (st *Stream) PacketsLost(track int) uint64 {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	return st.lost[track]
+}
+
+// Info 管理接口返回的stream状态，source还没有打开时没有track信息
+func (st *Stream) Info() StreamInfo {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	ret := StreamInfo{
+		Path:       st.Path,
+		SourceType: sourceType(st.mount),
+		Tracks:     make([]TrackInfo, 0),
+		Bitrate:    st.meter.rate(time.Now()),
+	}
+	if st.sdp == nil {
+		return ret
+	}
+	for _, m := range st.sdp.Ms {
+		info := TrackInfo{}
+		if media, err := m.GetM(); err == nil {
+			info.Media = media.Media
+		}
+		if rtpmaps, err := m.GetRtpmaps(); err == nil && len(rtpmaps) > 0 {
+			info.Codec = rtpmaps[0].EncodingName
+			info.ClockRate = rtpmaps[0].ClockRate
+		}
+		if controls, err := m.GetControl(); err == nil && len(controls) > 0 {
+			info.Control = controls[0].Value
+		}
+		ret.Tracks = append(ret.Tracks, info)
+	}
+	return ret
+}
+
+// readPacket 直播的source配置了jitter buffer时先排序，同时返回丢失的包数量
+func (st *Stream) readPacket(src Source) func() (int, *rtp.Packet, int, error) {
+	if _, vod := src.(Seeker); !vod && st.mount.JitterBuffer > 0 {
+		return newJitterStage(src, time.Duration(st.mount.JitterBuffer)*time.Millisecond).ReadPacket
+	}
+	return func() (int, *rtp.Packet, int, error) {
+		track, pkt, err := src.ReadPacket()
+		return track, pkt, 0, err
+	}
+}
+
+func (st *Stream) run(src Source) {
+	read := st.readPacket(src)
+	// 点播结束以外的错误
+	var failed error
+	for {
+		track, pkt, lost, err := read()
+		if err != nil {
+			st.log.Info("read packet failed", "err", err)
+			// 点播文件播放结束
+			if err == io.EOF {
+				st.mu.RLock()
+				st.notify(notifyEndOfStream)
+				st.mu.RUnlock()
+			} else {
+				failed = err
+			}
+			break
+		}
+		if lost > 0 {
+			st.log.Warn("packets lost", "track", track, "lost", lost)
+			st.mu.Lock()
+			st.lost[track] += uint64(lost)
+			st.mu.Unlock()
+		}
+
+		now := time.Now()
+		st.meter.add(len(pkt.Payload), now)
+
+		st.mu.RLock()
+		if st.clock != nil {
+			st.clock.update(track, pkt, now)
+		}
+		for r := range st.readers {
+			r.writePacket(track, pkt)
+		}
+		st.mu.RUnlock()
+	}
+
+	// source被Close或Unpublish时不是失败
+	st.mu.Lock()
+	if st.source == src {
+		src.Close()
+		st.source = nil
+		st.running = false
+		if failed != nil {
+			st.hooks.fire(&Event{Type: EventSourceFailed, Path: st.Path, Error: failed.Error()})
+		}
+	}
+	st.mu.Unlock()
+}
@@ -0,0 +1,112 @@
+package pkg
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader 握手时检查证书文件，修改后重新加载，加载失败时继续使用旧证书
+type certReloader struct {
+	certFile string
+	keyFile  string
+	log      *slog.Logger
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	size    int64
+}
+
+func newCertReloader(certFile, keyFile string, log *slog.Logger) (*certReloader, error) {
+	ret := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		log:      log,
+	}
+	if err := ret.reload(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// reload 需持有锁或在初始化时调用
+func (r *certReloader) reload() error {
+	modTime, size, err := r.stat()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	r.size = size
+	return nil
+}
+
+// stat 证书和私钥中较新的修改时间及总大小
+func (r *certReloader) stat() (time.Time, int64, error) {
+	var modTime time.Time
+	var size int64
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+		size += info.Size()
+	}
+	return modTime, size, nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if modTime, size, err := r.stat(); err == nil && (!modTime.Equal(r.modTime) || size != r.size) {
+		if err := r.reload(); err != nil {
+			r.log.Error("reload certificate failed", "cert", r.certFile, "err", err)
+		} else {
+			r.log.Info("certificate reloaded", "cert", r.certFile)
+		}
+	}
+	return r.cert, nil
+}
+
+// newTLSConfig 根据配置生成rtsps使用的tls.Config
+func newTLSConfig(c *TLSConfig, log *slog.Logger) (*tls.Config, error) {
+	if c.Cert == "" || c.Key == "" {
+		return nil, fmt.Errorf("tls cert and key are required")
+	}
+	reloader, err := newCertReloader(c.Cert, c.Key, log)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if c.ClientCA != "" {
+		data, err := ioutil.ReadFile(c.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in %s", c.ClientCA)
+		}
+		ret.ClientCAs = pool
+		ret.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return ret, nil
+}
@@ -0,0 +1,173 @@
+package pkg
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Lcmasdf/drs/pkg/client"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// writeTestCert 生成127.0.0.1的自签名证书，返回证书和私钥文件路径
+func writeTestCert(dir, name string, serial int64) (string, string, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	So(err, ShouldBeNil)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	So(err, ShouldBeNil)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	So(err, ShouldBeNil)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	So(ioutil.WriteFile(certFile, certPEM, 0644), ShouldBeNil)
+	So(ioutil.WriteFile(keyFile, keyPEM, 0600), ShouldBeNil)
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	So(err, ShouldBeNil)
+	return certFile, keyFile, cert
+}
+
+// serveTLSTest 在随机端口启动rtsps，返回rtsps://地址
+func serveTLSTest(srv *Server) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+	So(srv.init(), ShouldBeNil)
+	go srv.ServeTLS(listener)
+	return "rtsps://" + listener.Addr().String()
+}
+
+func certPool(files ...string) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		So(err, ShouldBeNil)
+		So(pool.AppendCertsFromPEM(data), ShouldBeTrue)
+	}
+	return pool
+}
+
+func TestRTSPS(t *testing.T) {
+	file := writeTestH264(t)
+
+	Convey("test rtsps", t, func() {
+		dir := t.TempDir()
+		certFile, keyFile, _ := writeTestCert(dir, "server", 1)
+
+		srv := &Server{Config: &Config{
+			TLS:    TLSConfig{Cert: certFile, Key: keyFile, RequireSecureTransport: true},
+			Mounts: []*MountConfig{{Path: "/live", Source: file, FrameRate: 100}},
+		}}
+		url := serveTLSTest(srv)
+		opts := client.Options{TLSConfig: &tls.Config{RootCAs: certPool(certFile)}}
+
+		Convey("tcp interleaved is allowed", func() {
+			opts.Transport = client.TransportTCP
+			c, err := client.Dial(url+"/live", opts)
+			So(err, ShouldBeNil)
+			defer c.Close()
+			_, err = c.Describe()
+			So(err, ShouldBeNil)
+			So(c.SetupAll(), ShouldBeNil)
+			_, err = c.Play(nil)
+			So(err, ShouldBeNil)
+
+			select {
+			case p := <-c.Packets():
+				So(p.Packet.PayloadType, ShouldEqual, 96)
+			case <-time.After(5 * time.Second):
+				So("timeout", ShouldBeEmpty)
+			}
+		})
+
+		Convey("plain udp is rejected", func() {
+			opts.Transport = client.TransportUDP
+			c, err := client.Dial(url+"/live", opts)
+			So(err, ShouldBeNil)
+			defer c.Close()
+			_, err = c.Describe()
+			So(err, ShouldBeNil)
+			err = c.SetupAll()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "461")
+		})
+
+		Convey("certificate is reloaded after the file changes", func() {
+			serial := func() int64 {
+				conn, err := tls.Dial("tcp", url[len("rtsps://"):], &tls.Config{InsecureSkipVerify: true})
+				So(err, ShouldBeNil)
+				defer conn.Close()
+				So(conn.Handshake(), ShouldBeNil)
+				return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+			}
+			So(serial(), ShouldEqual, 1)
+
+			writeTestCert(dir, "server", 2)
+			So(serial(), ShouldEqual, 2)
+		})
+	})
+
+	Convey("test rtsps with client certificate", t, func() {
+		dir := t.TempDir()
+		certFile, keyFile, _ := writeTestCert(dir, "server", 1)
+		clientCert, _, cert := writeTestCert(dir, "client", 3)
+
+		srv := &Server{Config: &Config{
+			TLS:    TLSConfig{Cert: certFile, Key: keyFile, ClientCA: clientCert},
+			Mounts: []*MountConfig{{Path: "/live", Source: file, FrameRate: 100}},
+		}}
+		url := serveTLSTest(srv)
+
+		describe := func(config *tls.Config) error {
+			c, err := client.Dial(url+"/live", client.Options{TLSConfig: config})
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			_, err = c.Describe()
+			return err
+		}
+
+		So(describe(&tls.Config{RootCAs: certPool(certFile)}), ShouldNotBeNil)
+		So(describe(&tls.Config{RootCAs: certPool(certFile), Certificates: []tls.Certificate{cert}}), ShouldBeNil)
+
+		// TLS 1.3客户端握手完成后服务端才校验证书
+		deadline := time.Now().Add(5 * time.Second)
+		for atomic.LoadUint64(&srv.metrics.authFailures) == 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(atomic.LoadUint64(&srv.metrics.authFailures), ShouldEqual, 1)
+	})
+
+	Convey("test rtsps without certificate", t, func() {
+		srv := &Server{}
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer listener.Close()
+		So(srv.ServeTLS(listener), ShouldNotBeNil)
+	})
+}